@@ -0,0 +1,205 @@
+// Package adiantum implements Adiantum, a length-preserving tweakable
+// wide-block cipher for disk encryption and other settings where the
+// ciphertext cannot be longer than the plaintext.
+//
+// Adiantum splits each message into a bulk part and a final 16-byte block.
+// The final block is hashed with the bulk and the tweak by an NH and Poly1305
+// hash, and encrypted with a single call to AES-256; the result is the nonce
+// with which XChaCha12 encrypts the bulk. The final block is then hashed again
+// with the encrypted bulk. Changing any bit of the plaintext changes the whole
+// ciphertext, and all of the keys are derived from one 256-bit key with
+// XChaCha12.
+//
+// Adiantum is not authenticated: decrypting a modified ciphertext produces
+// unpredictable plaintext rather than an error. The tweak, such as a sector
+// number, must differ between messages which should not be linkable.
+//
+// For more information, see https://eprint.iacr.org/2018/720.pdf
+package adiantum
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/poly1305"
+)
+
+const (
+	// KeySize is the length of Adiantum keys, in bytes.
+	KeySize = chacha20.KeySize
+	// MinSize is the length of the shortest message, in bytes.
+	MinSize = aes.BlockSize
+
+	nhKeySize     = 1072
+	nhMessageSize = 1024
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20.ErrInvalidKey
+	// ErrInvalidRounds is returned when the provided rounds is not 8, 12, or
+	// 20.
+	ErrInvalidRounds = chacha20.ErrInvalidRounds
+)
+
+// Cipher is an Adiantum cipher with a given key.
+type Cipher struct {
+	key    []byte
+	rounds uint8
+	block  cipher.Block
+	keyT   [poly1305.KeySize]byte // the second half, Poly1305's s, is zero
+	keyM   [poly1305.KeySize]byte
+	keyNH  [nhKeySize]byte
+}
+
+// New returns an Adiantum cipher with XChaCha12 and the given 256-bit key.
+func New(key []byte) (*Cipher, error) {
+	return NewWithRounds(key, 12)
+}
+
+// NewWithRounds returns an Adiantum cipher just like New but with XChaCha8
+// or XChaCha20 in place of XChaCha12, as the rounds number of 8, 12, or 20
+// can be specified.
+func NewWithRounds(key []byte, rounds uint8) (*Cipher, error) {
+	c := &Cipher{key: append([]byte{}, key...), rounds: rounds}
+
+	keys := make([]byte, 32+16+16+nhKeySize)
+	if err := c.xor(keys, nil); err != nil {
+		return nil, err
+	}
+
+	c.block, _ = aes.NewCipher(keys[:32])
+	copy(c.keyT[:16], keys[32:])
+	copy(c.keyM[:16], keys[48:])
+	copy(c.keyNH[:], keys[64:])
+
+	return c, nil
+}
+
+// Encrypt encrypts src with the given tweak into dst, which must be the same
+// length. Dst and src may be the same slice but must not otherwise overlap.
+// It panics if src is shorter than MinSize.
+func (c *Cipher) Encrypt(dst, src, tweak []byte) {
+	if len(src) < MinSize || len(dst) != len(src) {
+		panic("adiantum: invalid message length")
+	}
+	copy(dst, src)
+
+	bulk, final := dst[:len(dst)-MinSize], dst[len(dst)-MinSize:]
+
+	h := c.hash(tweak, bulk)
+	add(final, h[:])
+	c.block.Encrypt(final, final)
+	_ = c.xor(bulk, final)
+	h = c.hash(tweak, bulk)
+	sub(final, h[:])
+}
+
+// Decrypt decrypts src with the given tweak into dst, which must be the same
+// length. Dst and src may be the same slice but must not otherwise overlap.
+// It panics if src is shorter than MinSize.
+func (c *Cipher) Decrypt(dst, src, tweak []byte) {
+	if len(src) < MinSize || len(dst) != len(src) {
+		panic("adiantum: invalid message length")
+	}
+	copy(dst, src)
+
+	bulk, final := dst[:len(dst)-MinSize], dst[len(dst)-MinSize:]
+
+	h := c.hash(tweak, bulk)
+	add(final, h[:])
+	_ = c.xor(bulk, final)
+	c.block.Decrypt(final, final)
+	h = c.hash(tweak, bulk)
+	sub(final, h[:])
+}
+
+// xor encrypts b in place with XChaCha, using the given block followed by 1
+// as the nonce.
+func (c *Cipher) xor(b, block []byte) error {
+	nonce := make([]byte, chacha20.XNonceSize)
+	nonce[copy(nonce, block)] = 1
+
+	s, err := chacha20.NewXChaChaWithRounds(c.key, nonce, c.rounds)
+	if err != nil {
+		return err
+	}
+
+	s.XORKeyStream(b, b)
+	return nil
+}
+
+// hash is the sum, modulo 2^128, of Poly1305 of the message's length in bits
+// and the tweak, and Poly1305 of NH of the message.
+func (c *Cipher) hash(tweak, msg []byte) [poly1305.TagSize]byte {
+	header := make([]byte, 16, 16+len(tweak))
+	binary.LittleEndian.PutUint64(header, uint64(len(msg))*8)
+
+	var ht, hm [poly1305.TagSize]byte
+	poly1305.Sum(&ht, append(header, tweak...), &c.keyT)
+
+	mac := poly1305.New(&c.keyM)
+	var chunk [nhMessageSize]byte
+	var out [32]byte
+	for len(msg) > 0 {
+		n := copy(chunk[:], msg)
+		msg = msg[n:]
+
+		// NH operates on multiples of 16 bytes
+		padded := (n + 15) &^ 15
+		clear(chunk[n:padded])
+
+		nh(&out, chunk[:padded], c.keyNH[:])
+		mac.Write(out[:])
+	}
+	mac.Sum(hm[:0])
+
+	add(ht[:], hm[:])
+	return ht
+}
+
+// nh hashes a message of up to 1024 bytes with four passes of NH, each using
+// the key offset by another 16 bytes.
+func nh(out *[32]byte, msg, key []byte) {
+	var sums [4]uint64
+	for len(msg) > 0 {
+		var m [4]uint32
+		for i := range m {
+			m[i] = binary.LittleEndian.Uint32(msg[4*i:])
+		}
+
+		for p := range sums {
+			var k [4]uint32
+			for i := range k {
+				k[i] = binary.LittleEndian.Uint32(key[16*p+4*i:])
+			}
+
+			sums[p] += uint64(m[0]+k[0])*uint64(m[2]+k[2]) + uint64(m[1]+k[1])*uint64(m[3]+k[3])
+		}
+
+		msg, key = msg[16:], key[16:]
+	}
+
+	for i, s := range sums {
+		binary.LittleEndian.PutUint64(out[8*i:], s)
+	}
+}
+
+// add sets x to x + y modulo 2^128, both little-endian.
+func add(x, y []byte) {
+	lo, c := bits.Add64(binary.LittleEndian.Uint64(x), binary.LittleEndian.Uint64(y), 0)
+	hi, _ := bits.Add64(binary.LittleEndian.Uint64(x[8:]), binary.LittleEndian.Uint64(y[8:]), c)
+	binary.LittleEndian.PutUint64(x, lo)
+	binary.LittleEndian.PutUint64(x[8:], hi)
+}
+
+// sub sets x to x - y modulo 2^128, both little-endian.
+func sub(x, y []byte) {
+	lo, b := bits.Sub64(binary.LittleEndian.Uint64(x), binary.LittleEndian.Uint64(y), 0)
+	hi, _ := bits.Sub64(binary.LittleEndian.Uint64(x[8:]), binary.LittleEndian.Uint64(y[8:]), b)
+	binary.LittleEndian.PutUint64(x, lo)
+	binary.LittleEndian.PutUint64(x[8:], hi)
+}
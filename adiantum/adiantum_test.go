@@ -0,0 +1,129 @@
+package adiantum_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/codahale/chacha20/adiantum"
+)
+
+// The vectors in testdata/vectors.json are a selection of those published
+// with the Adiantum authors' reference implementation,
+// https://github.com/google/adiantum, for XChaCha12, XChaCha8 and XChaCha20,
+// as shipped with lukechampine.com/adiantum v1.1.1. They include a 4096-byte
+// sector with a 32-byte tweak for each number of rounds.
+type testVector struct {
+	Rounds      uint8  `json:"rounds"`
+	Description string `json:"description"`
+	Key         string `json:"key"`
+	Tweak       string `json:"tweak"`
+	Plaintext   string `json:"plaintext"`
+	Ciphertext  string `json:"ciphertext"`
+}
+
+func readVectors(t *testing.T) []testVector {
+	data, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors []testVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func TestVectors(t *testing.T) {
+	for i, vector := range readVectors(t) {
+		t.Logf("Running test vector %d: XChaCha%d, %d bytes", i, vector.Rounds, len(vector.Plaintext)/2)
+
+		key, _ := hex.DecodeString(vector.Key)
+		tweak, _ := hex.DecodeString(vector.Tweak)
+		plaintext, _ := hex.DecodeString(vector.Plaintext)
+
+		c, err := adiantum.NewWithRounds(key, vector.Rounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := make([]byte, len(plaintext))
+		c.Encrypt(ciphertext, plaintext, tweak)
+
+		if hex.EncodeToString(ciphertext) != vector.Ciphertext {
+			t.Errorf("Bad ciphertext: expected %s, was %x", vector.Ciphertext, ciphertext)
+		}
+
+		// in place
+		c.Decrypt(ciphertext, ciphertext, tweak)
+
+		if !bytes.Equal(ciphertext, plaintext) {
+			t.Errorf("Bad plaintext: expected %x, was %x", plaintext, ciphertext)
+		}
+	}
+}
+
+func TestTweak(t *testing.T) {
+	c, err := adiantum.New(make([]byte, adiantum.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sector := make([]byte, 4096)
+	a, b := make([]byte, len(sector)), make([]byte, len(sector))
+	c.Encrypt(a, sector, []byte{0})
+	c.Encrypt(b, sector, []byte{1})
+
+	// every block of the ciphertext should depend on the tweak
+	for i := 0; i < len(sector); i += 16 {
+		if bytes.Equal(a[i:i+16], b[i:i+16]) {
+			t.Errorf("Block %d did not change with the tweak", i/16)
+		}
+	}
+}
+
+func TestBadInputs(t *testing.T) {
+	if _, err := adiantum.New(make([]byte, 16)); err != adiantum.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+
+	if _, err := adiantum.NewWithRounds(make([]byte, adiantum.KeySize), 10); err != adiantum.ErrInvalidRounds {
+		t.Error("Should have rejected 10 rounds")
+	}
+
+	c, _ := adiantum.New(make([]byte, adiantum.KeySize))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Should have panicked on a short message")
+		}
+	}()
+	c.Encrypt(make([]byte, 15), make([]byte, 15), nil)
+}
+
+func Example() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := adiantum.New(key)
+	if err != nil {
+		panic(err)
+	}
+
+	// the sector number
+	tweak := []byte{0x2a, 0, 0, 0, 0, 0, 0, 0}
+
+	sector := []byte("hello I am a sector's worth of data")
+	c.Encrypt(sector, sector, tweak)
+	c.Decrypt(sector, sector, tweak)
+
+	fmt.Printf("%d bytes: %s\n", len(sector), sector)
+	// Output:
+	// 35 bytes: hello I am a sector's worth of data
+}
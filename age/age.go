@@ -0,0 +1,235 @@
+// Package age provides an implementation of the age v1 file encryption
+// format, with X25519 recipients and symmetric-key recipients.
+//
+// An age file starts with a textual header, which holds the 128-bit file key
+// wrapped once for each recipient in a stanza, and is authenticated with an
+// HMAC keyed by the file key. The header is followed by a 16-byte nonce and
+// the payload, which is encrypted with the STREAM construction: the plaintext
+// is split into 64 KiB chunks, each sealed with ChaCha20-Poly1305 under a key
+// derived from the file key and the nonce, and with a nonce made of an 88-bit
+// chunk counter and a flag marking the last chunk.
+//
+// X25519 recipients are age's public keys. SymmetricKey recipients share a
+// random 256-bit key with the sender, in place of age's scrypt passphrase
+// recipient, which is not provided. Their "symmetric" stanza is an extension
+// of this package, not part of the age specification: the age and rage tools
+// skip it, so they cannot decrypt a file whose only recipients are symmetric
+// keys. Stanzas of any other type are parsed, skipped when decrypting, and
+// can be produced and consumed by custom Recipient and Identity
+// implementations.
+//
+// For more information, see https://age-encryption.org/v1
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	fileKeySize     = 16
+	streamNonceSize = 16
+)
+
+var (
+	// ErrIncorrectIdentity is returned by Identity implementations when a
+	// stanza was not wrapped for them.
+	ErrIncorrectIdentity = errors.New("incorrect identity for recipient stanza")
+	// ErrNoIdentityMatch is returned by Decrypt when none of the identities
+	// can unwrap any of the header's stanzas.
+	ErrNoIdentityMatch = errors.New("no identity matched any of the recipients")
+	// ErrInvalidHeader is returned by Decrypt when the header is malformed.
+	ErrInvalidHeader = errors.New("invalid age header")
+	// ErrInvalidHeaderMAC is returned by Decrypt when a file key was
+	// unwrapped but the header's MAC does not match it.
+	ErrInvalidHeaderMAC = errors.New("bad header MAC")
+	// ErrInvalidPayload is returned when reading a payload which has been
+	// truncated or modified.
+	ErrInvalidPayload = errors.New("invalid age payload")
+)
+
+// A Stanza is a section of the age header which holds the file key wrapped
+// for one recipient. Its type and arguments must be non-empty strings of
+// printable ASCII characters other than space.
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// A Recipient wraps a file key for a recipient, producing one or more
+// stanzas.
+type Recipient interface {
+	Wrap(fileKey []byte) ([]*Stanza, error)
+}
+
+// An Identity unwraps a file key from the stanzas of a header. It returns
+// ErrIncorrectIdentity if none of the stanzas were wrapped for it; any other
+// error is treated as a malformed header.
+type Identity interface {
+	Unwrap(stanzas []*Stanza) ([]byte, error)
+}
+
+// Encrypt writes the header for a new file encrypted to the given recipients
+// to dst, and returns an io.WriteCloser which encrypts the plaintext written
+// to it. Close must be called to write the last chunk of the payload; it does
+// not close dst.
+func Encrypt(dst io.Writer, recipients ...Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients specified")
+	}
+
+	fileKey := make([]byte, fileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	h := new(header)
+	for i, r := range recipients {
+		stanzas, err := r.Wrap(fileKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap key for recipient #%d: %w", i, err)
+		}
+		h.stanzas = append(h.stanzas, stanzas...)
+	}
+
+	mac, err := headerMAC(fileKey, h)
+	if err != nil {
+		return nil, err
+	}
+	h.mac = mac
+
+	if err := h.marshal(dst); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, streamNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	if _, err := dst.Write(nonce); err != nil {
+		return nil, err
+	}
+
+	return newStreamWriter(streamKey(fileKey, nonce), dst), nil
+}
+
+// Decrypt reads and authenticates the header of an encrypted file from src,
+// unwraps the file key with the first of the identities which matches a
+// stanza, and returns an io.Reader which decrypts the payload.
+//
+// The payload is authenticated one 64 KiB chunk at a time, so plaintext may
+// be returned before a later chunk turns out to be truncated or modified, in
+// which case Read returns an error wrapping ErrInvalidPayload.
+func Decrypt(src io.Reader, identities ...Identity) (io.Reader, error) {
+	if len(identities) == 0 {
+		return nil, errors.New("no identities specified")
+	}
+
+	r := bufio.NewReader(src)
+	h, err := parseHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	for _, id := range identities {
+		fileKey, err = id.Unwrap(h.stanzas)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+		}
+		break
+	}
+
+	if fileKey == nil {
+		return nil, ErrNoIdentityMatch
+	}
+
+	mac, err := headerMAC(fileKey, h)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(mac, h.mac) {
+		return nil, ErrInvalidHeaderMAC
+	}
+
+	nonce := make([]byte, streamNonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("%w: failed to read nonce: %v", ErrInvalidHeader, err)
+	}
+
+	return newStreamReader(streamKey(fileKey, nonce), r), nil
+}
+
+// headerMAC returns the HMAC-SHA-256 of the header up to and including the
+// "---" of its closing line, keyed with a key derived from the file key.
+func headerMAC(fileKey []byte, h *header) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "header", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := h.marshalWithoutMAC(&buf); err != nil {
+		return nil, err
+	}
+
+	m := hmac.New(sha256.New, key)
+	m.Write(buf.Bytes())
+	return m.Sum(nil), nil
+}
+
+// streamKey derives the payload key from the file key and the payload nonce.
+func streamKey(fileKey, nonce []byte) []byte {
+	key, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		// HKDF only fails for outputs longer than 255 hash blocks.
+		panic(err)
+	}
+	return key
+}
+
+// aeadEncrypt seals a value with a single-use key, such as a wrapped file
+// key. Because the key is never reused, the nonce is all zeros.
+func aeadEncrypt(key, plaintext []byte) ([]byte, error) {
+	a, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return a.Seal(nil, nonce, plaintext, nil), nil
+}
+
+var errIncorrectCiphertextSize = errors.New("encrypted value has unexpected length")
+
+// aeadDecrypt opens a value sealed by aeadEncrypt, which must decrypt to
+// exactly size bytes. Rejecting other lengths prevents ciphertexts crafted to
+// open under more than one key.
+func aeadDecrypt(key []byte, size int, ciphertext []byte) ([]byte, error) {
+	a, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) != size+a.Overhead() {
+		return nil, errIncorrectCiphertextSize
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return a.Open(nil, nonce, ciphertext, nil)
+}
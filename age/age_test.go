@@ -0,0 +1,507 @@
+package age_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codahale/chacha20/age"
+)
+
+// The files in testdata/testkit are the X25519 vectors from the Community
+// Cryptography Test Vectors project (https://c2sp.org/CCTV/age), released
+// under the 0BSD license.
+type testkitVector struct {
+	expect     string
+	payload    string
+	identities []string
+	compressed bool
+	file       []byte
+}
+
+func parseTestkitVector(t *testing.T, path string) *testkitVector {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := new(testkitVector)
+	r := bufio.NewReader(bytes.NewReader(contents))
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: truncated vector header", path)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+
+		key, value, _ := strings.Cut(line, ": ")
+		switch key {
+		case "expect":
+			v.expect = value
+		case "payload":
+			v.payload = value
+		case "identity":
+			v.identities = append(v.identities, value)
+		case "compressed":
+			v.compressed = value == "zlib"
+		}
+	}
+
+	v.file, _ = io.ReadAll(r)
+	if v.compressed {
+		z, err := zlib.NewReader(bytes.NewReader(v.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v.file, err = io.ReadAll(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return v
+}
+
+func TestTestkit(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "testkit", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) == 0 {
+		t.Fatal("No test vectors found")
+	}
+
+	for _, path := range paths {
+		t.Logf("Running test vector %s", filepath.Base(path))
+
+		v := parseTestkitVector(t, path)
+
+		var identities []age.Identity
+		for _, s := range v.identities {
+			id, err := age.ParseX25519Identity(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			identities = append(identities, id)
+		}
+
+		r, err := age.Decrypt(bytes.NewReader(v.file), identities...)
+		var plaintext []byte
+		if err == nil {
+			plaintext, err = io.ReadAll(r)
+		}
+
+		var expected error
+		switch v.expect {
+		case "success":
+			expected = nil
+		case "header failure":
+			expected = age.ErrInvalidHeader
+		case "HMAC failure":
+			expected = age.ErrInvalidHeaderMAC
+		case "no match":
+			expected = age.ErrNoIdentityMatch
+		case "payload failure":
+			expected = age.ErrInvalidPayload
+		default:
+			t.Fatalf("Unknown expectation %q", v.expect)
+		}
+
+		if expected == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", path, err)
+				continue
+			}
+
+			sum := sha256.Sum256(plaintext)
+			if hex.EncodeToString(sum[:]) != v.payload {
+				t.Errorf("%s: Bad payload hash: expected %s, was %x", path, v.payload, sum)
+			}
+		} else if !errors.Is(err, expected) {
+			t.Errorf("%s: Should have failed with %q, was %v", path, expected, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	id, err := age.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := age.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lengths around the 64 KiB chunk boundary
+	for _, n := range []int{0, 1, 64*1024 - 1, 64 * 1024, 64*1024 + 1, 3 * 64 * 1024} {
+		t.Logf("Running length %d", n)
+
+		plaintext := make([]byte, n)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		w, err := age.Encrypt(buf, other.Recipient(), id.Recipient())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// write in odd-sized pieces to exercise the chunk buffering
+		for p := plaintext; len(p) > 0; {
+			k := 1000
+			if k > len(p) {
+				k = len(p)
+			}
+			if _, err := w.Write(p[:k]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[k:]
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := buf.Bytes()
+
+		r, err := age.Decrypt(bytes.NewReader(ciphertext), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("Bad plaintext for length %d", n)
+		}
+
+		r, err = age.Decrypt(bytes.NewReader(ciphertext[:len(ciphertext)-1]), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.ReadAll(r); !errors.Is(err, age.ErrInvalidPayload) {
+			t.Error("Should have rejected a truncated payload")
+		}
+	}
+}
+
+// eofReader returns data one byte at a time, with io.EOF along with the last
+// byte, and with an empty read before each byte.
+type eofReader struct {
+	b     []byte
+	empty bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	if r.empty = !r.empty; r.empty {
+		return 0, nil
+	}
+
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+
+	p[0] = r.b[0]
+	r.b = r.b[1:]
+	if len(r.b) == 0 {
+		return 1, io.EOF
+	}
+	return 1, nil
+}
+
+func TestTrailingData(t *testing.T) {
+	id, err := age.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(w, "hello I am a secret file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := age.Decrypt(&eofReader{b: buf.Bytes()}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plaintext, err := io.ReadAll(r); err != nil || string(plaintext) != "hello I am a secret file" {
+		t.Errorf("Should have read the file, was %q, %v", plaintext, err)
+	}
+
+	r, err = age.Decrypt(&eofReader{b: append(buf.Bytes(), 0)}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadAll(r); !errors.Is(err, age.ErrInvalidPayload) {
+		t.Errorf("Should have rejected trailing data, was %v", err)
+	}
+}
+
+// failWriter fails every write once fail is set.
+type failWriter struct {
+	fail bool
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errWrite
+	}
+	return len(p), nil
+}
+
+func TestWriteError(t *testing.T) {
+	id, err := age.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := new(failWriter)
+	w, err := age.Encrypt(dst, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.fail = true
+
+	// the first chunk is buffered, and fails to be written when the second
+	// begins
+	n, err := w.Write(make([]byte, 3*64*1024))
+	if err != errWrite || n != 64*1024 {
+		t.Errorf("Bad write: expected %d, %v, was %d, %v", 64*1024, errWrite, n, err)
+	}
+
+	if n, err := w.Write([]byte("more")); err != errWrite || n != 0 {
+		t.Errorf("Should have kept failing, was %d, %v", n, err)
+	}
+}
+
+func TestNoMatch(t *testing.T) {
+	id, _ := age.GenerateX25519Identity(rand.Reader)
+	other, _ := age.GenerateX25519Identity(rand.Reader)
+
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if _, err := age.Decrypt(buf, other); err != age.ErrNoIdentityMatch {
+		t.Errorf("Should have failed with no match, was %v", err)
+	}
+}
+
+// a stanza of this package's own "symmetric" type, for which there are no
+// published vectors: the body is the file key 404142...4f sealed with
+// libsodium's crypto_aead_chacha20poly1305_ietf_encrypt, with a zero nonce and
+// no additional data, under HKDF-SHA-256 of the key 000102...1f with the salt
+// 101112...1f and the info "github.com/codahale/chacha20/age/symmetric",
+// computed with Python's hmac module
+const (
+	testSymmetricSalt = "EBESExQVFhcYGRobHB0eHw"
+	testSymmetricBody = "ad1fd9947f79bc53595d972bce7cfe601de15b53fa7f4b27216e171892fb32b0"
+)
+
+func TestSymmetricUnwrap(t *testing.T) {
+	key := make([]byte, age.SymmetricKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	k, err := age.NewSymmetricKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := hex.DecodeString(testSymmetricBody)
+	stanzas := []*age.Stanza{
+		{Type: "X25519", Args: []string{"AAAA"}, Body: body},
+		{Type: "symmetric", Args: []string{testSymmetricSalt}, Body: body},
+	}
+
+	fileKey, err := k.Unwrap(stanzas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "404142434445464748494a4b4c4d4e4f"; hex.EncodeToString(fileKey) != expected {
+		t.Errorf("Bad file key: expected %s, was %x", expected, fileKey)
+	}
+
+	key[0] ^= 1
+	other, err := age.NewSymmetricKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := other.Unwrap(stanzas); err != age.ErrIncorrectIdentity {
+		t.Errorf("Should have failed with an incorrect identity, was %v", err)
+	}
+
+	stanzas[1].Body = body[:31]
+	if _, err := k.Unwrap(stanzas); err == nil || err == age.ErrIncorrectIdentity {
+		t.Errorf("Should have rejected a short body, was %v", err)
+	}
+
+	if _, err := age.NewSymmetricKey(key[:16]); err == nil {
+		t.Error("Should have rejected a 128-bit key")
+	}
+}
+
+func TestSymmetricRoundTrip(t *testing.T) {
+	k, err := age.GenerateSymmetricKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := age.GenerateSymmetricKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := age.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, k, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(w, "hello I am a secret file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, identity := range []age.Identity{k, id} {
+		r, err := age.Decrypt(bytes.NewReader(buf.Bytes()), other, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plaintext) != "hello I am a secret file" {
+			t.Errorf("Bad plaintext: %q", plaintext)
+		}
+	}
+
+	if _, err := age.Decrypt(bytes.NewReader(buf.Bytes()), other); err != age.ErrNoIdentityMatch {
+		t.Errorf("Should have failed with no match, was %v", err)
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	const (
+		identity  = "AGE-SECRET-KEY-1EGTZVFFV20835NWYV6270LXYVK2VKNX2MMDKWYKLMGR48UAWX40Q2P2LM0"
+		recipient = "age1xmwwc06ly3ee5rytxm9mflaz2u56jjj36s0mypdrwsvlul66mv4q47ryef"
+	)
+
+	id, err := age.ParseX25519Identity(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.String() != identity {
+		t.Errorf("Bad identity: expected %s, was %s", identity, id)
+	}
+
+	if id.Recipient().String() != recipient {
+		t.Errorf("Bad recipient: expected %s, was %s", recipient, id.Recipient())
+	}
+
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.String() != recipient {
+		t.Errorf("Bad recipient: expected %s, was %s", recipient, r)
+	}
+
+	if _, err := age.ParseX25519Recipient(recipient[:len(recipient)-1] + "q"); err == nil {
+		t.Error("Should have rejected a bad checksum")
+	}
+
+	if _, err := age.ParseX25519Identity(strings.ToLower(identity[:20]) + identity[20:]); err == nil {
+		t.Error("Should have rejected a mixed case identity")
+	}
+}
+
+func Example() {
+	id, err := age.ParseX25519Identity("AGE-SECRET-KEY-1EGTZVFFV20835NWYV6270LXYVK2VKNX2MMDKWYKLMGR48UAWX40Q2P2LM0")
+	if err != nil {
+		panic(err)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, id.Recipient())
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := io.WriteString(w, "hello I am a secret file"); err != nil {
+		panic(err)
+	}
+
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	r, err := age.Decrypt(buf, id)
+	if err != nil {
+		panic(err)
+	}
+
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", plaintext)
+	// Output:
+	// hello I am a secret file
+}
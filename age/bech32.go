@@ -0,0 +1,142 @@
+package age
+
+import (
+	"errors"
+	"strings"
+)
+
+// Recipients and identities are encoded with Bech32, from BIP 173, without
+// its 90-character length limit.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range bech32Generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	ret := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+}
+
+// convertBits regroups data from groups of fromBits to groups of toBits. When
+// not padding, leftover bits must be fewer than fromBits and all zero.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var ret []byte
+	var acc uint32
+	var bits uint
+	maxv := byte(1<<toBits - 1)
+	for _, v := range data {
+		if v>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits)&maxv)
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits))&maxv)
+		}
+	} else if bits >= fromBits || byte(acc<<(toBits-bits))&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return ret, nil
+}
+
+// bech32Encode encodes data with the given human-readable part, which must be
+// all lowercase or all uppercase. The case of the output matches the case of
+// the human-readable part.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	upper := strings.ToUpper(hrp) == hrp && strings.ToLower(hrp) != hrp
+	if !upper && strings.ToLower(hrp) != hrp {
+		return "", errors.New("mixed case human-readable part")
+	}
+	hrp = strings.ToLower(hrp)
+
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(checksum>>uint(5*(5-i)))&31)
+	}
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+
+	if upper {
+		return strings.ToUpper(b.String()), nil
+	}
+	return b.String(), nil
+}
+
+// bech32Decode decodes a Bech32 string, which must not be mixed case. The
+// human-readable part is returned in the case of the input.
+func bech32Decode(s string) (string, []byte, error) {
+	lower := strings.ToLower(s)
+	if lower != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+
+	pos := strings.LastIndexByte(lower, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+
+	hrp := lower[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errors.New("invalid character in human-readable part")
+		}
+	}
+
+	values := make([]byte, 0, len(lower)-pos-1)
+	for i := pos + 1; i < len(lower); i++ {
+		v := strings.IndexByte(bech32Charset, lower[i])
+		if v < 0 {
+			return "", nil, errors.New("invalid character in data part")
+		}
+		values = append(values, byte(v))
+	}
+
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s[:pos], data, nil
+}
@@ -0,0 +1,205 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	intro = "age-encryption.org/v1\n"
+
+	// stanza bodies are wrapped at 64 columns, which hold 48 bytes
+	columnsPerLine = 64
+	bytesPerLine   = columnsPerLine / 4 * 3
+)
+
+var (
+	stanzaPrefix = []byte("->")
+	footerPrefix = []byte("---")
+
+	b64 = base64.RawStdEncoding.Strict()
+)
+
+// header is the parsed form of an age header.
+type header struct {
+	stanzas []*Stanza
+	mac     []byte
+}
+
+// marshalWithoutMAC writes the header up to and including the "---" of its
+// closing line, which is the input to the header MAC.
+func (h *header) marshalWithoutMAC(w io.Writer) error {
+	if _, err := io.WriteString(w, intro); err != nil {
+		return err
+	}
+
+	for _, s := range h.stanzas {
+		if err := marshalStanza(w, s); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(footerPrefix)
+	return err
+}
+
+func (h *header) marshal(w io.Writer) error {
+	if err := h.marshalWithoutMAC(w); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, " %s\n", b64.EncodeToString(h.mac))
+	return err
+}
+
+func marshalStanza(w io.Writer, s *Stanza) error {
+	for _, a := range append([]string{s.Type}, s.Args...) {
+		if !isValidString(a) {
+			return fmt.Errorf("invalid stanza argument %q", a)
+		}
+	}
+
+	line := string(stanzaPrefix) + " " + strings.Join(append([]string{s.Type}, s.Args...), " ")
+	if _, err := io.WriteString(w, line+"\n"); err != nil {
+		return err
+	}
+
+	// The body is always terminated by a line shorter than 64 columns, which
+	// is empty if the body's length is a multiple of 48 bytes.
+	body := b64.EncodeToString(s.Body)
+	for len(body) >= columnsPerLine {
+		if _, err := io.WriteString(w, body[:columnsPerLine]+"\n"); err != nil {
+			return err
+		}
+		body = body[columnsPerLine:]
+	}
+
+	_, err := io.WriteString(w, body+"\n")
+	return err
+}
+
+// parseHeader reads a header from r, leaving r positioned at the payload
+// nonce. All parsing errors wrap ErrInvalidHeader.
+func parseHeader(r *bufio.Reader) (*header, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, headerError("failed to read intro: %v", err)
+	}
+
+	if line != intro {
+		return nil, headerError("unexpected intro: %q", line)
+	}
+
+	h := new(header)
+	for {
+		peek, err := r.Peek(len(footerPrefix))
+		if err != nil {
+			return nil, headerError("failed to read header: %v", err)
+		}
+
+		if bytes.Equal(peek, footerPrefix) {
+			break
+		}
+
+		s, err := parseStanza(r)
+		if err != nil {
+			return nil, err
+		}
+		h.stanzas = append(h.stanzas, s)
+	}
+
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return nil, headerError("failed to read closing line: %v", err)
+	}
+
+	prefix, args := splitArgs(line)
+	if prefix != string(footerPrefix) || len(args) != 1 {
+		return nil, headerError("malformed closing line: %q", line)
+	}
+
+	h.mac, err = decodeString(args[0])
+	if err != nil || len(h.mac) != 32 {
+		return nil, headerError("malformed closing line: %q", line)
+	}
+
+	return h, nil
+}
+
+func parseStanza(r *bufio.Reader) (*Stanza, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, headerError("failed to read stanza: %v", err)
+	}
+
+	prefix, args := splitArgs(line)
+	if prefix != string(stanzaPrefix) || len(args) < 1 {
+		return nil, headerError("malformed stanza: %q", line)
+	}
+
+	for _, a := range args {
+		if !isValidString(a) {
+			return nil, headerError("malformed stanza: %q", line)
+		}
+	}
+
+	s := &Stanza{Type: args[0], Args: args[1:]}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, headerError("failed to read stanza body: %v", err)
+		}
+
+		b, err := decodeString(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			return nil, headerError("malformed stanza body line %q: %v", line, err)
+		}
+
+		if len(b) > bytesPerLine {
+			return nil, headerError("malformed stanza body line %q: too long", line)
+		}
+
+		s.Body = append(s.Body, b...)
+		if len(b) < bytesPerLine {
+			return s, nil
+		}
+	}
+}
+
+// decodeString decodes unpadded, canonical base64. Unlike the encoding
+// package, it does not skip newlines, so each encoded value has exactly one
+// valid representation.
+func decodeString(s string) ([]byte, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, fmt.Errorf("unexpected newline character")
+	}
+	return b64.DecodeString(s)
+}
+
+func splitArgs(line string) (string, []string) {
+	parts := strings.Split(strings.TrimSuffix(line, "\n"), " ")
+	return parts[0], parts[1:]
+}
+
+// isValidString returns whether s is a non-empty string of printable ASCII
+// characters other than space.
+func isValidString(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+
+	for _, c := range s {
+		if c < 33 || c > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+func headerError(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidHeader}, a...)...)
+}
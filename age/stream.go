@@ -0,0 +1,210 @@
+package age
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	chunkSize          = 64 * 1024
+	encryptedChunkSize = chunkSize + chacha20poly1305.Overhead
+
+	lastChunkFlag = 0x01
+)
+
+// streamNonce is an 88-bit big-endian chunk counter followed by a byte which
+// is set to lastChunkFlag for the final chunk.
+type streamNonce [chacha20poly1305.NonceSize]byte
+
+func (n *streamNonce) increment() {
+	for i := len(n) - 2; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return
+		}
+	}
+
+	// 2^88 chunks is more than 16 yottabytes of plaintext.
+	panic("age: chunk counter wrapped around")
+}
+
+func (n *streamNonce) setLast() {
+	n[len(n)-1] = lastChunkFlag
+}
+
+func (n *streamNonce) isZero() bool {
+	return *n == streamNonce{}
+}
+
+type streamWriter struct {
+	a     cipher.AEAD
+	dst   io.Writer
+	nonce streamNonce
+	buf   []byte
+	err   error
+}
+
+func newStreamWriter(key []byte, dst io.Writer) *streamWriter {
+	// The key is always the right size.
+	a, _ := chacha20poly1305.New(key)
+	return &streamWriter{
+		a:   a,
+		dst: dst,
+		buf: make([]byte, 0, encryptedChunkSize),
+	}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	total := len(p)
+	for len(p) > 0 {
+		// A full chunk is only flushed once more data arrives, because until
+		// then it might be the last chunk.
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				w.err = err
+				return total - len(p), err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+	}
+
+	return total, nil
+}
+
+// Close encrypts and writes the last chunk, which is empty only if no
+// plaintext was written at all. It does not close the underlying writer.
+func (w *streamWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.flush(true); err != nil {
+		w.err = err
+		return err
+	}
+
+	w.err = errors.New("age: writer is already closed")
+	return nil
+}
+
+func (w *streamWriter) flush(last bool) error {
+	if last {
+		w.nonce.setLast()
+	}
+
+	ciphertext := w.a.Seal(w.buf[:0], w.nonce[:], w.buf, nil)
+	if _, err := w.dst.Write(ciphertext); err != nil {
+		return err
+	}
+
+	w.nonce.increment()
+	w.buf = w.buf[:0]
+	return nil
+}
+
+type streamReader struct {
+	a      cipher.AEAD
+	src    io.Reader
+	nonce  streamNonce
+	buf    []byte
+	unread []byte
+	err    error
+}
+
+func newStreamReader(key []byte, src io.Reader) *streamReader {
+	// The key is always the right size.
+	a, _ := chacha20poly1305.New(key)
+	return &streamReader{
+		a:   a,
+		src: src,
+		buf: make([]byte, encryptedChunkSize),
+	}
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if len(r.unread) > 0 {
+		n := copy(p, r.unread)
+		r.unread = r.unread[n:]
+		return n, nil
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	last, err := r.readChunk()
+	if err != nil {
+		r.err = fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		return 0, r.err
+	}
+
+	if last {
+		// The last chunk must be followed by the end of the file. ReadFull
+		// copes with readers which return no data and no error, or data
+		// along with io.EOF.
+		if _, err := io.ReadFull(r.src, make([]byte, 1)); err == nil {
+			r.err = fmt.Errorf("%w: trailing data after the last chunk", ErrInvalidPayload)
+		} else if err != io.EOF {
+			r.err = err
+		} else {
+			r.err = io.EOF
+		}
+	}
+
+	n := copy(p, r.unread)
+	r.unread = r.unread[n:]
+	return n, nil
+}
+
+// readChunk reads, authenticates and decrypts the next chunk into r.unread,
+// and returns whether it was the last chunk.
+func (r *streamReader) readChunk() (bool, error) {
+	in := r.buf
+	n, err := io.ReadFull(r.src, in)
+	switch {
+	case err == io.EOF:
+		return false, errors.New("missing last chunk")
+	case err == io.ErrUnexpectedEOF:
+		// Only a short chunk can be the last one, and it may only be empty
+		// if it is also the first.
+		if !r.nonce.isZero() && n == r.a.Overhead() {
+			return false, errors.New("last chunk is empty")
+		}
+		in = in[:n]
+		r.nonce.setLast()
+	case err != nil:
+		return false, err
+	}
+
+	last := r.nonce[len(r.nonce)-1] == lastChunkFlag
+	out, err := r.a.Open(in[:0], r.nonce[:], in, nil)
+	if err != nil && !last {
+		// A full chunk may still be the last one.
+		last = true
+		r.nonce.setLast()
+		out, err = r.a.Open(in[:0], r.nonce[:], in, nil)
+	}
+
+	if err != nil {
+		return false, errors.New("failed to authenticate chunk")
+	}
+
+	r.nonce.increment()
+	r.unread = out
+	return last, nil
+}
@@ -0,0 +1,131 @@
+package age
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	symmetricType     = "symmetric"
+	symmetricLabel    = "github.com/codahale/chacha20/age/symmetric"
+	symmetricSaltSize = 16
+
+	// SymmetricKeySize is the length of the keys of symmetric recipients, in
+	// bytes.
+	SymmetricKeySize = 32
+)
+
+// A SymmetricKey is a secret key shared by the sender and the recipients of a
+// file, which is both a Recipient and an Identity. Unlike age's scrypt
+// passphrase recipient, the key is used directly, so it must be uniformly
+// random, such as one from GenerateSymmetricKey.
+//
+// The file key is wrapped in a "symmetric" stanza, with a random 128-bit salt
+// as its argument and a body sealed with ChaCha20-Poly1305 under a key derived
+// from the symmetric key and the salt with HKDF-SHA-256. This stanza type is
+// an extension of this package, not part of the age specification, and other
+// age implementations skip it.
+type SymmetricKey struct {
+	key []byte
+}
+
+// NewSymmetricKey returns a SymmetricKey for the given 256-bit key.
+func NewSymmetricKey(key []byte) (*SymmetricKey, error) {
+	if len(key) != SymmetricKeySize {
+		return nil, errors.New("invalid symmetric key length (must be 256 bits)")
+	}
+	return &SymmetricKey{key: append([]byte(nil), key...)}, nil
+}
+
+// GenerateSymmetricKey generates a new SymmetricKey, using the given source
+// of randomness.
+func GenerateSymmetricKey(random io.Reader) (*SymmetricKey, error) {
+	key := make([]byte, SymmetricKeySize)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, err
+	}
+	return &SymmetricKey{key: key}, nil
+}
+
+// Wrap generates a salt and wraps the file key in a symmetric stanza.
+func (k *SymmetricKey) Wrap(fileKey []byte) ([]*Stanza, error) {
+	salt := make([]byte, symmetricSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key, err := k.wrappingKey(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := aeadEncrypt(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Stanza{{
+		Type: symmetricType,
+		Args: []string{b64.EncodeToString(salt)},
+		Body: body,
+	}}, nil
+}
+
+// Unwrap returns the file key from the first symmetric stanza which was
+// wrapped with the key.
+func (k *SymmetricKey) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		fileKey, err := k.unwrap(s)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+		return fileKey, err
+	}
+	return nil, ErrIncorrectIdentity
+}
+
+func (k *SymmetricKey) unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != symmetricType {
+		return nil, ErrIncorrectIdentity
+	}
+
+	if len(s.Args) != 1 {
+		return nil, errors.New("invalid symmetric stanza")
+	}
+
+	salt, err := decodeString(s.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid symmetric stanza: %v", err)
+	}
+
+	if len(salt) != symmetricSaltSize {
+		return nil, errors.New("invalid symmetric stanza: bad salt length")
+	}
+
+	key, err := k.wrappingKey(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := aeadDecrypt(key, fileKeySize, s.Body)
+	if err == errIncorrectCiphertextSize {
+		return nil, fmt.Errorf("invalid symmetric stanza: %v", err)
+	} else if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+
+	return fileKey, nil
+}
+
+// wrappingKey derives the key which wraps the file key from the symmetric key
+// and the stanza's salt. The salt makes each wrapping key single-use, as
+// aeadEncrypt requires.
+func (k *SymmetricKey) wrappingKey(salt []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, k.key, salt, symmetricLabel, chacha20poly1305.KeySize)
+}
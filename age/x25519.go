@@ -0,0 +1,212 @@
+package age
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	x25519Type  = "X25519"
+	x25519Label = "age-encryption.org/v1/X25519"
+
+	recipientHRP = "age"
+	identityHRP  = "AGE-SECRET-KEY-"
+)
+
+// An X25519Recipient is an age public key. The file key is wrapped with a key
+// derived from the X25519 shared secret between an ephemeral key and the
+// recipient's key.
+type X25519Recipient struct {
+	publicKey *ecdh.PublicKey
+}
+
+// NewX25519Recipient returns an X25519Recipient for the given X25519 public
+// key.
+func NewX25519Recipient(publicKey *ecdh.PublicKey) (*X25519Recipient, error) {
+	if publicKey.Curve() != ecdh.X25519() {
+		return nil, errors.New("not an X25519 public key")
+	}
+	return &X25519Recipient{publicKey: publicKey}, nil
+}
+
+// ParseX25519Recipient parses a Bech32 encoded public key with the "age1"
+// prefix.
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	hrp, k, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %v", s, err)
+	}
+
+	if hrp != recipientHRP {
+		return nil, fmt.Errorf("malformed recipient %q: invalid type %q", s, hrp)
+	}
+
+	publicKey, err := ecdh.X25519().NewPublicKey(k)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %v", s, err)
+	}
+
+	return &X25519Recipient{publicKey: publicKey}, nil
+}
+
+// Wrap generates an ephemeral key and wraps the file key in an X25519
+// stanza.
+func (r *X25519Recipient) Wrap(fileKey []byte) ([]*Stanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := ephemeral.ECDH(r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	share := ephemeral.PublicKey().Bytes()
+	key, err := x25519WrappingKey(shared, share, r.publicKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := aeadEncrypt(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Stanza{{
+		Type: x25519Type,
+		Args: []string{b64.EncodeToString(share)},
+		Body: body,
+	}}, nil
+}
+
+// String returns the Bech32 encoding of the recipient.
+func (r *X25519Recipient) String() string {
+	s, _ := bech32Encode(recipientHRP, r.publicKey.Bytes())
+	return s
+}
+
+// An X25519Identity is an age private key, which unwraps file keys from the
+// stanzas produced by its X25519Recipient.
+type X25519Identity struct {
+	privateKey *ecdh.PrivateKey
+}
+
+// NewX25519Identity returns an X25519Identity for the given X25519 private
+// key.
+func NewX25519Identity(privateKey *ecdh.PrivateKey) (*X25519Identity, error) {
+	if privateKey.Curve() != ecdh.X25519() {
+		return nil, errors.New("not an X25519 private key")
+	}
+	return &X25519Identity{privateKey: privateKey}, nil
+}
+
+// GenerateX25519Identity generates a new X25519Identity, using the given
+// source of randomness.
+func GenerateX25519Identity(random io.Reader) (*X25519Identity, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(random)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{privateKey: privateKey}, nil
+}
+
+// ParseX25519Identity parses a Bech32 encoded private key with the
+// "AGE-SECRET-KEY-1" prefix.
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	hrp, k, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed secret key: %v", err)
+	}
+
+	if hrp != identityHRP {
+		return nil, fmt.Errorf("malformed secret key: unknown type %q", hrp)
+	}
+
+	privateKey, err := ecdh.X25519().NewPrivateKey(k)
+	if err != nil {
+		return nil, fmt.Errorf("malformed secret key: %v", err)
+	}
+
+	return &X25519Identity{privateKey: privateKey}, nil
+}
+
+// Unwrap returns the file key from the first X25519 stanza which was wrapped
+// for the identity.
+func (i *X25519Identity) Unwrap(stanzas []*Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		fileKey, err := i.unwrap(s)
+		if errors.Is(err, ErrIncorrectIdentity) {
+			continue
+		}
+		return fileKey, err
+	}
+	return nil, ErrIncorrectIdentity
+}
+
+func (i *X25519Identity) unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != x25519Type {
+		return nil, ErrIncorrectIdentity
+	}
+
+	if len(s.Args) != 1 {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+
+	share, err := decodeString(s.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 stanza: %v", err)
+	}
+
+	publicKey, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 stanza: %v", err)
+	}
+
+	// ECDH rejects low-order shares, which produce an all-zero secret.
+	shared, err := i.privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 stanza: %v", err)
+	}
+
+	key, err := x25519WrappingKey(shared, share, i.privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := aeadDecrypt(key, fileKeySize, s.Body)
+	if err == errIncorrectCiphertextSize {
+		return nil, fmt.Errorf("invalid X25519 stanza: %v", err)
+	} else if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+
+	return fileKey, nil
+}
+
+// Recipient returns the X25519Recipient for the identity's public key.
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{publicKey: i.privateKey.PublicKey()}
+}
+
+// String returns the Bech32 encoding of the identity.
+func (i *X25519Identity) String() string {
+	s, _ := bech32Encode(identityHRP, i.privateKey.Bytes())
+	return s
+}
+
+// x25519WrappingKey derives the key which wraps the file key from the shared
+// secret, salted with the ephemeral share and the recipient's public key.
+func x25519WrappingKey(shared, share, publicKey []byte) ([]byte, error) {
+	salt := make([]byte, 0, len(share)+len(publicKey))
+	salt = append(salt, share...)
+	salt = append(salt, publicKey...)
+	return hkdf.Key(sha256.New, shared, salt, x25519Label, chacha20poly1305.KeySize)
+}
@@ -0,0 +1,128 @@
+package chacha20
+
+import "encoding/binary"
+
+// the number of blocks core4 transforms at once
+const lanes = 4
+
+// Job is one message for XORKeyStreamBatch.
+type Job struct {
+	Key   []byte // a 256-bit key
+	Nonce []byte // a 64-, 96- or 192-bit nonce
+	Dst   []byte // the output, at least as long as Src
+	Src   []byte // the input
+}
+
+// XORKeyStreamBatch XORs each job's Src with the keystream for its key and
+// nonce and writes the result to its Dst, with the same results as calling New,
+// NewIETF or NewXChaCha, depending on the length of the nonce, and
+// XORKeyStream for each job. It is faster for many small messages, because it
+// computes the keystream blocks of several messages, or of several parts of
+// one message, in parallel lanes.
+//
+// Every job is checked before any are encrypted, so if an error is returned,
+// no output has been written. It panics if any job's Dst is shorter than its
+// Src, or if an IETF ChaCha20 job is longer than its keystream. As with
+// XORKeyStream, Dst and Src may overlap entirely or not at all.
+func XORKeyStreamBatch(jobs []Job) error {
+	for _, job := range jobs {
+		if len(job.Key) != KeySize {
+			return ErrInvalidKey
+		}
+
+		switch len(job.Nonce) {
+		case NonceSize, XNonceSize:
+		case IETFNonceSize:
+			if uint64(len(job.Src)) > ietfKeystreamSize {
+				panic("chacha20: keystream exhausted")
+			}
+		default:
+			return ErrInvalidNonce
+		}
+
+		if len(job.Dst) < len(job.Src) {
+			panic("chacha20: output smaller than input")
+		}
+	}
+
+	var (
+		in, out [stateSize][lanes]uint32
+		dst     [lanes][]byte
+		src     [lanes][]byte
+		s       stream // the initial state of the current job
+		j       = 0    // the current job
+		block   = -1   // the next block of the current job, or -1 to start it
+	)
+
+	for {
+		// Fill the lanes with the next blocks of the remaining jobs.
+		n := 0
+		for n < lanes && j < len(jobs) {
+			job := &jobs[j]
+			if block < 0 {
+				s = stream{}
+				if len(job.Nonce) == XNonceSize {
+					s.initX(job.Key, job.Nonce, 20)
+				} else {
+					s.init(job.Key, job.Nonce, 20)
+				}
+				block = 0
+			}
+
+			start := block * blockSize
+			if start >= len(job.Src) {
+				j++
+				block = -1
+				continue
+			}
+
+			end := start + blockSize
+			if end > len(job.Src) {
+				end = len(job.Src)
+			}
+
+			for i, v := range s.state {
+				in[i][n] = v
+			}
+			in[12][n] = uint32(block)
+			if !s.ietf {
+				in[13][n] = uint32(uint64(block) >> 32)
+			}
+
+			dst[n], src[n] = job.Dst[start:end], job.Src[start:end]
+			block++
+			n++
+		}
+
+		if n == 0 {
+			return nil
+		}
+
+		core4(&in, &out, 20)
+
+		for l := 0; l < n; l++ {
+			xorLane(dst[l], src[l], &out, l)
+		}
+	}
+}
+
+// xorLane XORs src, which is at most one block long, with the keystream block
+// in lane l.
+func xorLane(dst, src []byte, ks *[stateSize][lanes]uint32, l int) {
+	if len(src) == blockSize {
+		for i := 0; i < stateSize; i++ {
+			binary.LittleEndian.PutUint32(dst[i*wordSize:],
+				binary.LittleEndian.Uint32(src[i*wordSize:])^ks[i][l])
+		}
+		return
+	}
+
+	var block [blockSize]byte
+	for i := 0; i < stateSize; i++ {
+		binary.LittleEndian.PutUint32(block[i*wordSize:], ks[i][l])
+	}
+
+	for i := range src {
+		dst[i] = src[i] ^ block[i]
+	}
+}
@@ -0,0 +1,98 @@
+package chacha20_test
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"math/rand"
+	"testing"
+
+	"github.com/codahale/chacha20"
+)
+
+func TestXORKeyStreamBatch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	var jobs []chacha20.Job
+	var expected [][]byte
+	for i, size := range []int{0, 1, 63, 64, 65, 100, 128, 250, 500, 1000, 4096, 3, 200, 511} {
+		for _, nonceSize := range []int{chacha20.NonceSize, chacha20.IETFNonceSize, chacha20.XNonceSize} {
+			key := make([]byte, chacha20.KeySize)
+			nonce := make([]byte, nonceSize)
+			src := make([]byte, size)
+			r.Read(key)
+			r.Read(nonce)
+			r.Read(src)
+
+			var s cipher.Stream
+			var err error
+			switch nonceSize {
+			case chacha20.NonceSize:
+				s, err = chacha20.New(key, nonce)
+			case chacha20.IETFNonceSize:
+				s, err = chacha20.NewIETF(key, nonce)
+			default:
+				s, err = chacha20.NewXChaCha(key, nonce)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := make([]byte, size)
+			s.XORKeyStream(out, src)
+			expected = append(expected, out)
+
+			// encrypt every other job in place
+			dst := make([]byte, size)
+			if i%2 == 0 {
+				dst = append([]byte(nil), src...)
+				src = dst
+			}
+			jobs = append(jobs, chacha20.Job{Key: key, Nonce: nonce, Dst: dst, Src: src})
+		}
+	}
+
+	if err := chacha20.XORKeyStreamBatch(jobs); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, job := range jobs {
+		if !bytes.Equal(expected[i], job.Dst) {
+			t.Errorf("Bad output for job %d: expected %x, was %x", i, expected[i], job.Dst)
+		}
+	}
+}
+
+func TestXORKeyStreamBatchInvalid(t *testing.T) {
+	good := chacha20.Job{
+		Key:   make([]byte, chacha20.KeySize),
+		Nonce: make([]byte, chacha20.NonceSize),
+		Dst:   make([]byte, 10),
+		Src:   make([]byte, 10),
+	}
+
+	badKey, badNonce := good, good
+	badKey.Key = badKey.Key[:16]
+	badNonce.Nonce = make([]byte, chacha20.HNonceSize)
+
+	if err := chacha20.XORKeyStreamBatch([]chacha20.Job{good, badKey}); err != chacha20.ErrInvalidKey {
+		t.Error("Should have rejected a 128-bit key")
+	}
+
+	if err := chacha20.XORKeyStreamBatch([]chacha20.Job{good, badNonce}); err != chacha20.ErrInvalidNonce {
+		t.Error("Should have rejected a 128-bit nonce")
+	}
+
+	if !bytes.Equal(make([]byte, 10), good.Dst) {
+		t.Error("Should not have written any output")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Should have panicked on a short output")
+		}
+	}()
+
+	short := good
+	short.Dst = short.Dst[:9]
+	chacha20.XORKeyStreamBatch([]chacha20.Job{short})
+}
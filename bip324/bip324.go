@@ -0,0 +1,177 @@
+// Package bip324 implements the packet encryption of Bitcoin's v2 P2P
+// transport protocol, from BIP324.
+//
+// After the handshake, each direction has two ciphers. FSChaCha20 encrypts
+// each packet's 3-byte little-endian contents length, so that it can be
+// decrypted before the rest of the packet has been read.
+// FSChaCha20Poly1305 encrypts and authenticates a header byte, whose high bit
+// marks packets to be ignored, followed by the contents. Both ciphers replace
+// their keys every 224 packets, so that a compromised key does not reveal
+// earlier traffic.
+//
+// The keys are derived from the shared secret of the ElligatorSwift-encoded
+// secp256k1 key exchange with HKDF-SHA256. The key exchange and the garbage
+// which surrounds it are left to the caller.
+//
+// For more information, see
+// https://github.com/bitcoin/bips/blob/master/bip-0324.mediawiki
+package bip324
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// SecretSize is the length of the ECDH shared secret, in bytes.
+	SecretSize = 32
+	// SessionIDSize is the length of the session ID, in bytes.
+	SessionIDSize = 32
+	// GarbageTerminatorSize is the length of a garbage terminator, in bytes.
+	GarbageTerminatorSize = 16
+	// LengthSize is the length of the encrypted contents length, in bytes.
+	LengthSize = 3
+	// HeaderSize is the length of the header which precedes the contents, in
+	// bytes.
+	HeaderSize = 1
+	// Overhead is the number of bytes a packet is longer than its contents.
+	Overhead = LengthSize + HeaderSize + chacha20poly1305.Overhead
+	// MaxContentsSize is the length of the longest contents, in bytes.
+	MaxContentsSize = 1<<24 - 1
+
+	// IgnoreBit is set in the header of packets which should be ignored.
+	IgnoreBit = 0x80
+)
+
+// MainnetMagic is the network magic of Bitcoin's main network.
+var MainnetMagic = [4]byte{0xf9, 0xbe, 0xb4, 0xd9}
+
+var (
+	// ErrInvalidKey is returned when the shared secret is not 256 bits long.
+	ErrInvalidKey = chacha20poly1305.ErrInvalidKey
+	// ErrTooLong is returned when the contents are longer than
+	// MaxContentsSize.
+	ErrTooLong = errors.New("contents too long")
+	// ErrInvalidPacket is returned when a packet's length does not match its
+	// decrypted length.
+	ErrInvalidPacket = errors.New("invalid packet")
+	// ErrOpen is returned when a packet cannot be authenticated, either
+	// because it was not encrypted with the session's keys, it was modified,
+	// or packets were lost or reordered.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// Cipher encrypts the packets one peer sends and decrypts the packets it
+// receives. Ciphers are not safe for concurrent use, but sending and
+// receiving do not share state.
+type Cipher struct {
+	// SessionID identifies the session, and may be compared out of band.
+	SessionID [SessionIDSize]byte
+	// SendGarbageTerminator follows the garbage this peer sends.
+	SendGarbageTerminator [GarbageTerminatorSize]byte
+	// RecvGarbageTerminator follows the garbage the other peer sends.
+	RecvGarbageTerminator [GarbageTerminatorSize]byte
+
+	sendL *FSChaCha20
+	sendP *FSChaCha20Poly1305
+	recvL *FSChaCha20
+	recvP *FSChaCha20Poly1305
+}
+
+// NewCipher derives the session's keys from the ECDH shared secret and the
+// network magic, for the initiating peer if initiator is true and for the
+// responding peer otherwise.
+func NewCipher(secret []byte, magic [4]byte, initiator bool) (*Cipher, error) {
+	if len(secret) != SecretSize {
+		return nil, ErrInvalidKey
+	}
+
+	prk, err := hkdf.Extract(sha256.New, secret, append([]byte("bitcoin_v2_shared_secret"), magic[:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	expand := func(info string) []byte {
+		k, _ := hkdf.Expand(sha256.New, prk, info, 32)
+		return k
+	}
+
+	initiatorL, initiatorP := expand("initiator_L"), expand("initiator_P")
+	responderL, responderP := expand("responder_L"), expand("responder_P")
+	terminators := expand("garbage_terminators")
+
+	c := new(Cipher)
+	copy(c.SessionID[:], expand("session_id"))
+
+	if !initiator {
+		initiatorL, responderL = responderL, initiatorL
+		initiatorP, responderP = responderP, initiatorP
+		terminators = append(terminators[GarbageTerminatorSize:], terminators[:GarbageTerminatorSize]...)
+	}
+	copy(c.SendGarbageTerminator[:], terminators)
+	copy(c.RecvGarbageTerminator[:], terminators[GarbageTerminatorSize:])
+
+	c.sendL, _ = NewFSChaCha20(initiatorL)
+	c.sendP, _ = NewFSChaCha20Poly1305(initiatorP)
+	c.recvL, _ = NewFSChaCha20(responderL)
+	c.recvP, _ = NewFSChaCha20Poly1305(responderP)
+
+	return c, nil
+}
+
+// Encrypt encrypts a packet with the given contents and additional data, and
+// appends it to dst. If ignore is true, the receiver will discard the
+// packet; such decoys can hide traffic patterns. The additional data is only
+// used for the first packet, to authenticate the garbage.
+func (c *Cipher) Encrypt(dst, contents, additionalData []byte, ignore bool) ([]byte, error) {
+	if len(contents) > MaxContentsSize {
+		return nil, ErrTooLong
+	}
+
+	var length [LengthSize]byte
+	length[0], length[1], length[2] = byte(len(contents)), byte(len(contents)>>8), byte(len(contents)>>16)
+	c.sendL.Crypt(length[:], length[:])
+
+	plaintext := make([]byte, HeaderSize, HeaderSize+len(contents))
+	if ignore {
+		plaintext[0] = IgnoreBit
+	}
+	plaintext = append(plaintext, contents...)
+
+	return c.sendP.Seal(append(dst, length[:]...), plaintext, additionalData), nil
+}
+
+// DecryptLength decrypts the first LengthSize bytes of a packet, and returns
+// the number of bytes which follow them. It must be called exactly once for
+// each received packet, before Decrypt.
+func (c *Cipher) DecryptLength(packet []byte) (int, error) {
+	if len(packet) < LengthSize {
+		return 0, ErrInvalidPacket
+	}
+
+	var length [LengthSize]byte
+	c.recvL.Crypt(length[:], packet[:LengthSize])
+
+	n := uint32(length[0]) | uint32(length[1])<<8 | uint32(length[2])<<16
+	return HeaderSize + int(n) + chacha20poly1305.Overhead, nil
+}
+
+// Decrypt authenticates and decrypts the rest of a packet, the bytes after
+// the length, and appends its contents to dst. It returns true if the packet
+// should be ignored.
+func (c *Cipher) Decrypt(dst, rest, additionalData []byte) ([]byte, bool, error) {
+	if len(rest) < HeaderSize+chacha20poly1305.Overhead {
+		return nil, false, ErrInvalidPacket
+	}
+
+	out, err := c.recvP.Open(dst, rest, additionalData)
+	if err != nil {
+		return nil, false, err
+	}
+
+	header := out[len(dst)]
+	return append(out[:len(dst)], out[len(dst)+HeaderSize:]...), header&IgnoreBit != 0, nil
+}
@@ -0,0 +1,273 @@
+package bip324_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/codahale/chacha20/bip324"
+)
+
+// testdata/packet_encoding_test_vectors.csv is the BIP's own file, copied
+// unchanged from bip-0324/ in https://github.com/bitcoin/bips at commit
+// 9783d61f1b9c. Its ElligatorSwift columns are ignored, since the key
+// exchange is left to the caller; the shared secret is taken from
+// mid_shared_secret.
+type testVector struct {
+	idx              int
+	initiating       bool
+	contents         []byte
+	aad              []byte
+	ignore           bool
+	secret           []byte
+	sendL, sendP     []byte
+	sessionID        string
+	sendTerminator   string
+	recvTerminator   string
+	ciphertext       string
+	ciphertextSuffix string
+}
+
+// readVectors reads the vectors from every CSV file in testdata.
+func readVectors(t *testing.T) []testVector {
+	names, err := filepath.Glob("testdata/*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors []testVector
+	for _, name := range names {
+		vectors = append(vectors, readVectorFile(t, name)...)
+	}
+	return vectors
+}
+
+func readVectorFile(t *testing.T, name string) []testVector {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	var vectors []testVector
+	for _, record := range records[1:] {
+		r := make(map[string]string)
+		for i, name := range records[0] {
+			r[name] = record[i]
+		}
+
+		hexBytes := func(name string) []byte {
+			b, err := hex.DecodeString(r[name])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+
+		idx, _ := strconv.Atoi(r["in_idx"])
+		multiply, _ := strconv.Atoi(r["in_multiply"])
+
+		v := testVector{
+			idx:              idx,
+			initiating:       r["in_initiating"] == "1",
+			contents:         bytes.Repeat(hexBytes("in_contents"), multiply),
+			aad:              hexBytes("in_aad"),
+			ignore:           r["in_ignore"] == "1",
+			secret:           hexBytes("mid_shared_secret"),
+			sessionID:        r["out_session_id"],
+			sendTerminator:   r["mid_send_garbage_terminator"],
+			recvTerminator:   r["mid_recv_garbage_terminator"],
+			ciphertext:       r["out_ciphertext"],
+			ciphertextSuffix: r["out_ciphertext_endswith"],
+		}
+
+		if v.initiating {
+			v.sendL, v.sendP = hexBytes("mid_initiator_l"), hexBytes("mid_initiator_p")
+		} else {
+			v.sendL, v.sendP = hexBytes("mid_responder_l"), hexBytes("mid_responder_p")
+		}
+
+		vectors = append(vectors, v)
+	}
+	return vectors
+}
+
+func checkCiphertext(t *testing.T, v testVector, packet []byte) {
+	encoded := hex.EncodeToString(packet)
+	if v.ciphertext != "" && encoded != v.ciphertext {
+		t.Errorf("Bad ciphertext: expected %s, was %s", v.ciphertext, encoded)
+	}
+
+	if v.ciphertextSuffix != "" && !strings.HasSuffix(encoded, v.ciphertextSuffix) {
+		t.Errorf("Bad ciphertext: expected a suffix of %s, was %s", v.ciphertextSuffix, encoded)
+	}
+}
+
+func TestFSCiphers(t *testing.T) {
+	for i, v := range readVectors(t) {
+		t.Logf("Running test vector %d", i)
+
+		l, err := bip324.NewFSChaCha20(v.sendL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := bip324.NewFSChaCha20Poly1305(v.sendP)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < v.idx; j++ {
+			l.Crypt(make([]byte, 3), make([]byte, 3))
+			p.Seal(nil, []byte{bip324.IgnoreBit}, nil)
+		}
+
+		packet := []byte{byte(len(v.contents)), byte(len(v.contents) >> 8), byte(len(v.contents) >> 16)}
+		l.Crypt(packet, packet)
+
+		header := byte(0)
+		if v.ignore {
+			header = bip324.IgnoreBit
+		}
+		packet = p.Seal(packet, append([]byte{header}, v.contents...), v.aad)
+
+		checkCiphertext(t, v, packet)
+	}
+}
+
+func TestCipher(t *testing.T) {
+	for i, v := range readVectors(t) {
+		t.Logf("Running test vector %d", i)
+
+		sender, err := bip324.NewCipher(v.secret, bip324.MainnetMagic, v.initiating)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		receiver, err := bip324.NewCipher(v.secret, bip324.MainnetMagic, !v.initiating)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(sender.SessionID[:]) != v.sessionID {
+			t.Errorf("Bad session ID: expected %s, was %x", v.sessionID, sender.SessionID)
+		}
+
+		if hex.EncodeToString(sender.SendGarbageTerminator[:]) != v.sendTerminator ||
+			hex.EncodeToString(sender.RecvGarbageTerminator[:]) != v.recvTerminator {
+			t.Errorf("Bad garbage terminators: %x, %x", sender.SendGarbageTerminator, sender.RecvGarbageTerminator)
+		}
+
+		if receiver.SendGarbageTerminator != sender.RecvGarbageTerminator {
+			t.Error("Bad garbage terminators: the receiver's should be swapped")
+		}
+
+		for j := 0; j <= v.idx; j++ {
+			contents, aad, ignore := []byte(nil), []byte(nil), true
+			if j == v.idx {
+				contents, aad, ignore = v.contents, v.aad, v.ignore
+			}
+
+			packet, err := sender.Encrypt(nil, contents, aad, ignore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if j == v.idx {
+				checkCiphertext(t, v, packet)
+			}
+
+			n, err := receiver.DecryptLength(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != len(packet)-bip324.LengthSize {
+				t.Fatalf("Bad length: expected %d, was %d", len(packet)-bip324.LengthSize, n)
+			}
+
+			decrypted, ignored, err := receiver.Decrypt(nil, packet[bip324.LengthSize:], aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decrypted, contents) || ignored != ignore {
+				t.Errorf("Bad packet %d: %x, %v", j, decrypted, ignored)
+			}
+		}
+	}
+}
+
+func TestModifiedPacket(t *testing.T) {
+	secret := make([]byte, bip324.SecretSize)
+
+	sender, _ := bip324.NewCipher(secret, bip324.MainnetMagic, true)
+	receiver, _ := bip324.NewCipher(secret, bip324.MainnetMagic, false)
+
+	packet, err := sender.Encrypt(nil, []byte("version"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet[len(packet)-1] ^= 1
+
+	if _, err := receiver.DecryptLength(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := receiver.Decrypt(nil, packet[bip324.LengthSize:], nil); err != bip324.ErrOpen {
+		t.Error("Should have rejected a modified packet")
+	}
+
+	if _, err := sender.Encrypt(nil, make([]byte, bip324.MaxContentsSize+1), nil, false); err != bip324.ErrTooLong {
+		t.Error("Should have rejected long contents")
+	}
+
+	if _, err := bip324.NewCipher(secret[1:], bip324.MainnetMagic, true); err != bip324.ErrInvalidKey {
+		t.Error("Should have rejected a short secret")
+	}
+}
+
+func Example() {
+	// the shared secret of the ElligatorSwift key exchange
+	secret := make([]byte, bip324.SecretSize)
+
+	initiator, err := bip324.NewCipher(secret, bip324.MainnetMagic, true)
+	if err != nil {
+		panic(err)
+	}
+
+	responder, err := bip324.NewCipher(secret, bip324.MainnetMagic, false)
+	if err != nil {
+		panic(err)
+	}
+
+	packet, err := initiator.Encrypt(nil, []byte("hello I am a v2 message"), nil, false)
+	if err != nil {
+		panic(err)
+	}
+
+	n, err := responder.DecryptLength(packet[:bip324.LengthSize])
+	if err != nil {
+		panic(err)
+	}
+
+	contents, _, err := responder.Decrypt(nil, packet[bip324.LengthSize:bip324.LengthSize+n], nil)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", contents)
+	// Output:
+	// hello I am a v2 message
+}
@@ -0,0 +1,116 @@
+package bip324
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+// RekeyInterval is the number of chunks or packets after which the
+// forward-secure ciphers replace their keys.
+const RekeyInterval = 224
+
+// nonce returns the 96-bit nonce made of a little-endian 32-bit and 64-bit
+// integer.
+func nonce(lo uint32, hi uint64) []byte {
+	n := make([]byte, chacha20.IETFNonceSize)
+	binary.LittleEndian.PutUint32(n, lo)
+	binary.LittleEndian.PutUint64(n[4:], hi)
+	return n
+}
+
+// FSChaCha20 is the forward-secure stream cipher which encrypts packet
+// lengths. Each chunk continues the keystream of the one before it, and
+// after every RekeyInterval chunks the next 32 bytes of keystream become the
+// new key, with the number of rekeyings as the nonce.
+type FSChaCha20 struct {
+	stream       cipher.Stream
+	chunkCounter uint32
+	rekeyCounter uint64
+}
+
+// NewFSChaCha20 returns an FSChaCha20 with the given 256-bit initial key.
+func NewFSChaCha20(key []byte) (*FSChaCha20, error) {
+	s, err := chacha20.NewIETF(key, nonce(0, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	return &FSChaCha20{stream: s}, nil
+}
+
+// Crypt encrypts or decrypts one chunk from src into dst, which may be the
+// same slice.
+func (f *FSChaCha20) Crypt(dst, src []byte) {
+	f.stream.XORKeyStream(dst, src)
+
+	f.chunkCounter++
+	if f.chunkCounter == RekeyInterval {
+		key := make([]byte, chacha20.KeySize)
+		f.stream.XORKeyStream(key, key)
+
+		f.chunkCounter = 0
+		f.rekeyCounter++
+		f.stream, _ = chacha20.NewIETF(key, nonce(0, f.rekeyCounter))
+	}
+}
+
+// FSChaCha20Poly1305 is the forward-secure AEAD which encrypts packet
+// contents. Each packet uses the next nonce, and after every RekeyInterval
+// packets the key is replaced with 32 bytes of keystream from the current
+// key, using a nonce no packet uses.
+type FSChaCha20Poly1305 struct {
+	key           []byte
+	aead          cipher.AEAD
+	packetCounter uint64
+}
+
+// NewFSChaCha20Poly1305 returns an FSChaCha20Poly1305 with the given 256-bit
+// initial key.
+func NewFSChaCha20Poly1305(key []byte) (*FSChaCha20Poly1305, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FSChaCha20Poly1305{key: append([]byte{}, key...), aead: aead}, nil
+}
+
+func (f *FSChaCha20Poly1305) nonce() []byte {
+	return nonce(uint32(f.packetCounter%RekeyInterval), f.packetCounter/RekeyInterval)
+}
+
+// Seal encrypts and authenticates plaintext and additional data as the next
+// packet, and appends the result to dst.
+func (f *FSChaCha20Poly1305) Seal(dst, plaintext, additionalData []byte) []byte {
+	out := f.aead.Seal(dst, f.nonce(), plaintext, additionalData)
+	f.next()
+	return out
+}
+
+// Open authenticates and decrypts ciphertext and additional data as the next
+// packet, and appends the plaintext to dst. A packet which cannot be opened
+// still uses its nonce.
+func (f *FSChaCha20Poly1305) Open(dst, ciphertext, additionalData []byte) ([]byte, error) {
+	out, err := f.aead.Open(dst, f.nonce(), ciphertext, additionalData)
+	f.next()
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return out, nil
+}
+
+// next moves to the next packet, rekeying after the last of each interval
+// with the start of what would be the ciphertext of 32 zero bytes.
+func (f *FSChaCha20Poly1305) next() {
+	f.packetCounter++
+	if f.packetCounter%RekeyInterval != 0 {
+		return
+	}
+
+	s, _ := chacha20.NewIETFWithCounter(f.key, nonce(0xffffffff, f.packetCounter/RekeyInterval-1), 1)
+	s.XORKeyStream(f.key, make([]byte, chacha20.KeySize))
+	f.aead, _ = chacha20poly1305.New(f.key)
+}
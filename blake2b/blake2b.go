@@ -0,0 +1,219 @@
+// Package blake2b provides a pure Go implementation of the BLAKE2b hash
+// function, as specified in RFC 7693.
+//
+// BLAKE2b is used by several of the constructions in this module which are
+// built on ChaCha20: libsodium's sealed boxes derive their nonces with it, and
+// PASETO uses it both as a nonce derivation function and as a MAC.
+//
+// For more information, see https://www.blake2.net
+package blake2b
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+const (
+	// BlockSize is the block size of BLAKE2b, in bytes.
+	BlockSize = 128
+	// Size is the maximum length of a BLAKE2b digest, in bytes.
+	Size = 64
+	// Size256 is the length of a BLAKE2b-256 digest, in bytes.
+	Size256 = 32
+	// MaxKeySize is the maximum length of a BLAKE2b key, in bytes.
+	MaxKeySize = 64
+)
+
+var (
+	// ErrInvalidSize is returned when the requested digest size is not
+	// between 1 and 64 bytes.
+	ErrInvalidSize = errors.New("invalid digest size (must be 1 to 64 bytes)")
+	// ErrInvalidKey is returned when the provided key is longer than 64
+	// bytes.
+	ErrInvalidKey = errors.New("invalid key length (must be at most 512 bits)")
+)
+
+// the BLAKE2b initialization vector, shared with SHA-512
+var iv = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+// the message word permutations for each round
+var sigma = [12][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+}
+
+// New returns a new hash.Hash computing a BLAKE2b digest of the given size,
+// which must be between 1 and 64 bytes. If key is not empty, the hash is a
+// keyed BLAKE2b MAC; the key must be at most 64 bytes long.
+func New(size int, key []byte) (hash.Hash, error) {
+	if size < 1 || size > Size {
+		return nil, ErrInvalidSize
+	}
+
+	if len(key) > MaxKeySize {
+		return nil, ErrInvalidKey
+	}
+
+	d := &digest{size: size, keyLen: len(key)}
+	copy(d.key[:], key)
+	d.Reset()
+
+	return d, nil
+}
+
+// Sum512 returns the 64-byte BLAKE2b digest of data.
+func Sum512(data []byte) [Size]byte {
+	var sum [Size]byte
+	d := &digest{size: Size}
+	d.Reset()
+	d.Write(data)
+	d.finish(sum[:])
+	return sum
+}
+
+// Sum256 returns the 32-byte BLAKE2b digest of data.
+func Sum256(data []byte) [Size256]byte {
+	var sum [Size256]byte
+	d := &digest{size: Size256}
+	d.Reset()
+	d.Write(data)
+	d.finish(sum[:])
+	return sum
+}
+
+type digest struct {
+	h      [8]uint64       // the chained state
+	t      [2]uint64       // the 128-bit count of bytes hashed so far
+	buf    [BlockSize]byte // buffered bytes of the current block
+	offset int             // the number of bytes buffered in buf
+	size   int             // the length of the digest, in bytes
+	key    [BlockSize]byte // the key, zero-padded to a full block
+	keyLen int             // the length of the key, in bytes
+}
+
+func (d *digest) Size() int {
+	return d.size
+}
+
+func (d *digest) BlockSize() int {
+	return BlockSize
+}
+
+func (d *digest) Reset() {
+	d.h = iv
+	d.h[0] ^= uint64(d.size) | uint64(d.keyLen)<<8 | 1<<16 | 1<<24
+	d.t = [2]uint64{}
+	d.offset = 0
+
+	// A keyed hash processes the padded key as the first block of input.
+	if d.keyLen > 0 {
+		d.buf = d.key
+		d.offset = BlockSize
+	}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	n := len(p)
+
+	// The final block has to be compressed with the finalization flag set,
+	// so a full buffer is only compressed once more input arrives.
+	for len(p) > 0 {
+		if d.offset == BlockSize {
+			d.compress(BlockSize, false)
+			d.offset = 0
+		}
+
+		k := copy(d.buf[d.offset:], p)
+		d.offset += k
+		p = p[k:]
+	}
+
+	return n, nil
+}
+
+func (d *digest) Sum(b []byte) []byte {
+	var sum [Size]byte
+	dd := *d
+	dd.finish(sum[:dd.size])
+	return append(b, sum[:dd.size]...)
+}
+
+// finish compresses the final, zero-padded block and writes the digest to
+// out. It leaves the digest in an unusable state.
+func (d *digest) finish(out []byte) {
+	for i := d.offset; i < BlockSize; i++ {
+		d.buf[i] = 0
+	}
+	d.compress(d.offset, true)
+
+	var sum [Size]byte
+	for i, v := range d.h {
+		binary.LittleEndian.PutUint64(sum[i*8:], v)
+	}
+	copy(out, sum[:])
+}
+
+// compress mixes the buffered block, of which n bytes are message bytes, into
+// the chained state.
+func (d *digest) compress(n int, final bool) {
+	d.t[0] += uint64(n)
+	if d.t[0] < uint64(n) {
+		d.t[1]++
+	}
+
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(d.buf[i*8:])
+	}
+
+	v := [16]uint64{
+		d.h[0], d.h[1], d.h[2], d.h[3], d.h[4], d.h[5], d.h[6], d.h[7],
+		iv[0], iv[1], iv[2], iv[3], iv[4] ^ d.t[0], iv[5] ^ d.t[1], iv[6], iv[7],
+	}
+	if final {
+		v[14] = ^v[14]
+	}
+
+	for r := range sigma {
+		s := &sigma[r]
+		g(&v, 0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(&v, 1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(&v, 2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(&v, 3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(&v, 0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(&v, 1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(&v, 2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(&v, 3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+
+	for i := range d.h {
+		d.h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+// g is the BLAKE2b mixing function.
+func g(v *[16]uint64, a, b, c, d int, x, y uint64) {
+	v[a] += v[b] + x
+	v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+	v[c] += v[d]
+	v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+	v[a] += v[b] + y
+	v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+	v[c] += v[d]
+	v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+}
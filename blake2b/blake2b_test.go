@@ -0,0 +1,128 @@
+package blake2b_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/codahale/chacha20/blake2b"
+)
+
+// stolen from https://tools.ietf.org/html/rfc7693#appendix-A and the BLAKE2
+// reference keyed answer tests
+type testVector struct {
+	key    string
+	msg    string
+	digest string
+}
+
+var testVectors = []testVector{
+	testVector{
+		"",
+		"",
+		"786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419" +
+			"d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce",
+	},
+	testVector{
+		"",
+		hex.EncodeToString([]byte("abc")),
+		"ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1" +
+			"7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+	},
+	testVector{
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+		"",
+		"10ebb67700b1868efb4417987acf4690ae9d972fb7a590c2f02871799aaa4786" +
+			"b5e996e8f0f4eb981fc214b005f42d2ff4233499391653df7aefcbc13fc51568",
+	},
+	testVector{
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+		"00",
+		"961f6dd1e4dd30f63901690c512e78e4b45e4742ed197c3c5e45c549fd25f2e4" +
+			"187b0bc9fe30492b16b0d0bc4ef9b0f34c7003fac09a5ef1532e69430234cebd",
+	},
+}
+
+func TestBLAKE2b(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		key, err := hex.DecodeString(vector.key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		msg, err := hex.DecodeString(vector.msg)
+		if err != nil {
+			t.Error(err)
+		}
+
+		expected, err := hex.DecodeString(vector.digest)
+		if err != nil {
+			t.Error(err)
+		}
+
+		h, err := blake2b.New(blake2b.Size, key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		h.Write(msg)
+		if digest := h.Sum(nil); !bytes.Equal(expected, digest) {
+			t.Errorf("Bad digest: expected %x, was %x", expected, digest)
+		}
+
+		if len(key) == 0 {
+			if digest := blake2b.Sum512(msg); !bytes.Equal(expected, digest[:]) {
+				t.Errorf("Bad digest: expected %x, was %x", expected, digest)
+			}
+		}
+	}
+}
+
+func TestLongInput(t *testing.T) {
+	// Hashing across many block boundaries, in uneven writes, must match a
+	// single write.
+	msg := make([]byte, 1000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	h, err := blake2b.New(blake2b.Size256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(msg); i += 37 {
+		end := i + 37
+		if end > len(msg) {
+			end = len(msg)
+		}
+		h.Write(msg[i:end])
+	}
+
+	expected := blake2b.Sum256(msg)
+	if digest := h.Sum(nil); !bytes.Equal(expected[:], digest) {
+		t.Errorf("Bad digest: expected %x, was %x", expected, digest)
+	}
+}
+
+func TestBadSize(t *testing.T) {
+	if _, err := blake2b.New(0, nil); err != blake2b.ErrInvalidSize {
+		t.Error("Should have rejected an invalid size")
+	}
+
+	if _, err := blake2b.New(blake2b.Size+1, nil); err != blake2b.ErrInvalidSize {
+		t.Error("Should have rejected an invalid size")
+	}
+}
+
+func TestBadKeySize(t *testing.T) {
+	key := make([]byte, blake2b.MaxKeySize+1)
+
+	if _, err := blake2b.New(blake2b.Size, key); err != blake2b.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+}
@@ -0,0 +1,217 @@
+// Package box provides NaCl and libsodium compatible public-key authenticated
+// encryption over X25519.
+//
+// Seal and Open implement NaCl's crypto_box: an X25519 shared secret is
+// hashed with HSalsa20 into a key for crypto_secretbox. SealXChaCha and
+// OpenXChaCha implement libsodium's crypto_box_curve25519xchacha20poly1305,
+// which hashes the shared secret with HChaCha20 instead and uses
+// crypto_secretbox_xchacha20poly1305.
+//
+// SealAnonymous and OpenAnonymous implement libsodium's sealed boxes
+// (crypto_box_seal), which encrypt to a public key with a fresh ephemeral key
+// pair so that the sender remains anonymous.
+//
+// Keys are the X25519 keys of the standard library's crypto/ecdh package.
+//
+// For more information, see http://nacl.cr.yp.to/box.html
+package box
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"io"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/blake2b"
+	"github.com/codahale/chacha20/internal/salsa"
+	"github.com/codahale/chacha20/secretbox"
+)
+
+const (
+	// NonceSize is the length of box nonces, in bytes.
+	NonceSize = secretbox.NonceSize
+	// Overhead is the number of bytes a box is longer than its message.
+	Overhead = secretbox.Overhead
+	// AnonymousOverhead is the number of bytes a sealed box is longer than
+	// its message: the ephemeral public key followed by the authenticator.
+	AnonymousOverhead = publicKeySize + Overhead
+
+	publicKeySize = 32
+)
+
+var (
+	// ErrInvalidKey is returned when a provided key is not an X25519 key, or
+	// when the key exchange produces the all-zero shared secret.
+	ErrInvalidKey = errors.New("invalid key (must be an X25519 key)")
+	// ErrInvalidNonce is returned when the provided nonce is not 192 bits
+	// long.
+	ErrInvalidNonce = secretbox.ErrInvalidNonce
+	// ErrOpen is returned when a box cannot be opened, either because it was
+	// not sealed for the given keys or because it was modified.
+	ErrOpen = secretbox.ErrOpen
+)
+
+// Precompute calculates the shared key between peersPublicKey and privateKey
+// for use with secretbox.Seal and secretbox.Open. This is NaCl's
+// crypto_box_beforenm, and saves repeating the key exchange when many
+// messages are exchanged between the same pair of keys.
+func Precompute(peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	shared, err := exchange(peersPublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var key, k [32]byte
+	var zero [16]byte
+	copy(k[:], shared)
+	salsa.HSalsa20(&key, &zero, &k)
+
+	return key[:], nil
+}
+
+// Seal encrypts and authenticates message for peersPublicKey and appends the
+// result to out, which must not overlap message. A nonce must never be used
+// twice for the same pair of keys.
+func Seal(out, message, nonce []byte, peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	key, err := Precompute(peersPublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretbox.Seal(out, message, nonce, key)
+}
+
+// Open authenticates and decrypts a box produced by Seal and appends the
+// message to out, which must not overlap box.
+func Open(out, box, nonce []byte, peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	key, err := Precompute(peersPublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretbox.Open(out, box, nonce, key)
+}
+
+// PrecomputeXChaCha calculates the shared key between peersPublicKey and
+// privateKey for use with secretbox.SealXChaCha and secretbox.OpenXChaCha.
+// This is libsodium's crypto_box_curve25519xchacha20poly1305_beforenm.
+func PrecomputeXChaCha(peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	shared, err := exchange(peersPublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return chacha20.HChaCha20(shared, make([]byte, chacha20.HNonceSize))
+}
+
+// SealXChaCha encrypts and authenticates message for peersPublicKey like Seal,
+// but with XChaCha20 in place of XSalsa20.
+func SealXChaCha(out, message, nonce []byte, peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	key, err := PrecomputeXChaCha(peersPublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretbox.SealXChaCha(out, message, nonce, key)
+}
+
+// OpenXChaCha authenticates and decrypts a box produced by SealXChaCha and
+// appends the message to out, which must not overlap box.
+func OpenXChaCha(out, box, nonce []byte, peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	key, err := PrecomputeXChaCha(peersPublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretbox.OpenXChaCha(out, box, nonce, key)
+}
+
+// SealAnonymous encrypts message for recipient with a fresh ephemeral key pair
+// read from rand, and appends the ephemeral public key followed by the box to
+// out, which must not overlap message. The nonce is derived from both public
+// keys, so none needs to be transmitted.
+func SealAnonymous(out, message []byte, recipient *ecdh.PublicKey, rand io.Reader) ([]byte, error) {
+	return sealAnonymous(out, message, recipient, rand, Seal)
+}
+
+// OpenAnonymous authenticates and decrypts a sealed box produced by
+// SealAnonymous and appends the message to out, which must not overlap box.
+func OpenAnonymous(out, box []byte, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	return openAnonymous(out, box, privateKey, Open)
+}
+
+// SealAnonymousXChaCha encrypts message for recipient like SealAnonymous, but
+// with XChaCha20 in place of XSalsa20. This is libsodium's
+// crypto_box_curve25519xchacha20poly1305_seal.
+func SealAnonymousXChaCha(out, message []byte, recipient *ecdh.PublicKey, rand io.Reader) ([]byte, error) {
+	return sealAnonymous(out, message, recipient, rand, SealXChaCha)
+}
+
+// OpenAnonymousXChaCha authenticates and decrypts a sealed box produced by
+// SealAnonymousXChaCha and appends the message to out, which must not overlap
+// box.
+func OpenAnonymousXChaCha(out, box []byte, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	return openAnonymous(out, box, privateKey, OpenXChaCha)
+}
+
+type sealFunc func(out, message, nonce []byte, peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error)
+
+func sealAnonymous(out, message []byte, recipient *ecdh.PublicKey, rand io.Reader, seal sealFunc) ([]byte, error) {
+	if recipient.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidKey
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	epk := ephemeral.PublicKey()
+	nonce := anonymousNonce(epk, recipient)
+
+	ret := append(out, epk.Bytes()...)
+	return seal(ret, message, nonce, recipient, ephemeral)
+}
+
+func openAnonymous(out, box []byte, privateKey *ecdh.PrivateKey, open sealFunc) ([]byte, error) {
+	if len(box) < AnonymousOverhead {
+		return nil, ErrOpen
+	}
+
+	if privateKey.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidKey
+	}
+
+	epk, err := ecdh.X25519().NewPublicKey(box[:publicKeySize])
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	nonce := anonymousNonce(epk, privateKey.PublicKey())
+	return open(out, box[publicKeySize:], nonce, epk, privateKey)
+}
+
+// anonymousNonce derives the nonce of a sealed box, which is the 24-byte
+// BLAKE2b hash of the ephemeral public key followed by the recipient's public
+// key.
+func anonymousNonce(ephemeral, recipient *ecdh.PublicKey) []byte {
+	h, _ := blake2b.New(NonceSize, nil)
+	h.Write(ephemeral.Bytes())
+	h.Write(recipient.Bytes())
+	return h.Sum(nil)
+}
+
+func exchange(peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	if peersPublicKey.Curve() != ecdh.X25519() || privateKey.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidKey
+	}
+
+	shared, err := privateKey.ECDH(peersPublicKey)
+	if err != nil {
+		// The only failure between two X25519 keys is a low-order public
+		// key producing the all-zero shared secret, which NaCl rejects.
+		return nil, ErrInvalidKey
+	}
+
+	return shared, nil
+}
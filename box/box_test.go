@@ -0,0 +1,191 @@
+package box_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/codahale/chacha20/box"
+)
+
+// stolen from NaCl's tests/box.c. The XChaCha20 box is the output of
+// libsodium's crypto_box_curve25519xchacha20poly1305_easy for the same
+// message, nonce and keys, and the sealed boxes are outputs of crypto_box_seal
+// and crypto_box_curve25519xchacha20poly1305_seal to Bob's public key, which
+// chose their ephemeral keys at random.
+const (
+	alicePrivateKey = "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"
+	bobPrivateKey   = "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"
+	testNonce       = "69696ee955b62b73cd62bda875fc73d68219e0036b7a0b37"
+	testMessage     = "be075fc53c81f2d5cf141316ebeb0c7b5228c52a4c62cbd44b66849b64244ffc" +
+		"e5ecbaaf33bd751a1ac728d45e6c61296cdc3c01233561f41db66cce314adb31" +
+		"0e3be8250c46f06dceea3a7fa1348057e2f6556ad6b1318a024a838f21af1fde" +
+		"048977eb48f59ffd4924ca1c60902e52f0a089bc76897040e082f93776384864" +
+		"5e0705"
+	testBox = "f3ffc7703f9400e52a7dfb4b3d3305d98e993b9f48681273c29650ba32fc76ce" +
+		"48332ea7164d96a4476fb8c531a1186ac0dfc17c98dce87b4da7f011ec48c972" +
+		"71d2c20f9b928fe2270d6fb863d51738b48eeee314a7cc8ab932164548e526ae" +
+		"90224368517acfeabd6bb3732bc0e9da99832b61ca01b6de56244a9e88d5f9b3" +
+		"7973f622a43d14a6599b1f654cb45a74e355a5"
+	testXChaChaBox = "0b4ff00742f3c1aa99a6321e3883b03cef2c6061b7bcec0cfd723055f61f1ccc" +
+		"a2946d5a04dbf83151f4894223ac9bc790c660e19dc64cc0d7f9c7689ad19099" +
+		"55e7a9a7bdac777d6a7967d000ce841fc0f8f31d6d8720b374bc28982764a7db" +
+		"eb4daa977aae1b7350fac79a177ece7541fed264461ea93e6d7ad9fc08c91e77" +
+		"4abe79036e7f79ddcc6aab3abf527631048ce1"
+
+	testAnonymousMessage = "hello I am a secret message"
+	testAnonymousBox     = "e54fdd98a78b69a4a55856c6584bbe2652db0517a64df6fde8de9ddf94d6b623" +
+		"67445cb455cf90fabd3dbfdb2bc16c6e2cd13c2cb1a3f20d24ea23ad5c03b487" +
+		"0b8b93d763b80ecfa00de8"
+	testAnonymousXChaChaBox = "458ee9296b154a062077b93ea8fbebf8ccc5b88cd35cb9c4bf87cfb2f343950a" +
+		"077b8ceb2a4a8157ca1d084c709ac157d29449927f0e733faaa997d64dc8206e" +
+		"5292dff56ec6dca4dd8210"
+)
+
+func privateKey(t *testing.T, s string) *ecdh.PrivateKey {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+type sealFunc func(out, message, nonce []byte, peersPublicKey *ecdh.PublicKey, privateKey *ecdh.PrivateKey) ([]byte, error)
+
+func testSealOpen(t *testing.T, seal, open sealFunc, expectedHex string) {
+	alice := privateKey(t, alicePrivateKey)
+	bob := privateKey(t, bobPrivateKey)
+	nonce, _ := hex.DecodeString(testNonce)
+	message, _ := hex.DecodeString(testMessage)
+	expected, _ := hex.DecodeString(expectedHex)
+
+	sealed, err := seal(nil, message, nonce, bob.PublicKey(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, sealed) {
+		t.Errorf("Bad box: expected %x, was %x", expected, sealed)
+	}
+
+	opened, err := open(nil, sealed, nonce, alice.PublicKey(), bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(message, opened) {
+		t.Errorf("Bad message: expected %x, was %x", message, opened)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := open(nil, sealed, nonce, alice.PublicKey(), bob); err != box.ErrOpen {
+		t.Error("Should have rejected a modified box")
+	}
+}
+
+func TestBox(t *testing.T) {
+	testSealOpen(t, box.Seal, box.Open, testBox)
+}
+
+func TestBoxXChaCha(t *testing.T) {
+	testSealOpen(t, box.SealXChaCha, box.OpenXChaCha, testXChaChaBox)
+}
+
+type openAnonymousFunc func(out, box []byte, privateKey *ecdh.PrivateKey) ([]byte, error)
+
+func testOpenAnonymous(t *testing.T, open openAnonymousFunc, sealedHex string) {
+	bob := privateKey(t, bobPrivateKey)
+	sealed, _ := hex.DecodeString(sealedHex)
+
+	message, err := open(nil, sealed, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(message) != testAnonymousMessage {
+		t.Errorf("Bad message: expected %q, was %q", testAnonymousMessage, message)
+	}
+
+	// Changing the ephemeral public key changes the nonce and the shared key.
+	sealed[0] ^= 1
+	if _, err := open(nil, sealed, bob); err != box.ErrOpen {
+		t.Error("Should have rejected a modified sealed box")
+	}
+
+	if _, err := open(nil, sealed[:box.AnonymousOverhead-1], bob); err != box.ErrOpen {
+		t.Error("Should have rejected a truncated sealed box")
+	}
+}
+
+func TestOpenAnonymous(t *testing.T) {
+	testOpenAnonymous(t, box.OpenAnonymous, testAnonymousBox)
+}
+
+func TestOpenAnonymousXChaCha(t *testing.T) {
+	testOpenAnonymous(t, box.OpenAnonymousXChaCha, testAnonymousXChaChaBox)
+}
+
+func TestSealAnonymous(t *testing.T) {
+	bob := privateKey(t, bobPrivateKey)
+	message := []byte(testAnonymousMessage)
+
+	sealed, err := box.SealAnonymous(nil, message, bob.PublicKey(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sealed) != len(message)+box.AnonymousOverhead {
+		t.Errorf("Bad sealed box length: %d", len(sealed))
+	}
+
+	opened, err := box.OpenAnonymous(nil, sealed, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(message, opened) {
+		t.Errorf("Bad message: expected %x, was %x", message, opened)
+	}
+
+	if _, err := box.OpenAnonymousXChaCha(nil, sealed, bob); err != box.ErrOpen {
+		t.Error("Should not have opened a sealed box with the wrong construction")
+	}
+}
+
+func TestPrecompute(t *testing.T) {
+	alice := privateKey(t, alicePrivateKey)
+	bob := privateKey(t, bobPrivateKey)
+
+	// NaCl's tests/box.c uses this shared key as its secretbox key.
+	expected, _ := hex.DecodeString("1b27556473e985d462cd51197a9a46c76009549eac6474f206c4ee0844f68389")
+
+	key, err := box.Precompute(bob.PublicKey(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, key) {
+		t.Errorf("Bad shared key: expected %x, was %x", expected, key)
+	}
+}
+
+func TestLowOrderPublicKey(t *testing.T) {
+	alice := privateKey(t, alicePrivateKey)
+	zero, err := ecdh.X25519().NewPublicKey(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, box.NonceSize)
+	if _, err := box.Seal(nil, nil, nonce, zero, alice); err != box.ErrInvalidKey {
+		t.Error("Should have rejected a low-order public key")
+	}
+}
@@ -0,0 +1,86 @@
+package branca
+
+import "errors"
+
+// Tokens are encoded in base62, treating the whole token as one big-endian
+// number. As in the base-x encoding used by other implementations, each
+// leading zero byte is encoded as a leading '0'.
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var errBase62 = errors.New("invalid base62")
+
+var base62Index [256]int8
+
+func init() {
+	for i := range base62Index {
+		base62Index[i] = -1
+	}
+	for i := 0; i < len(base62Alphabet); i++ {
+		base62Index[base62Alphabet[i]] = int8(i)
+	}
+}
+
+func base62Encode(data []byte) string {
+	zeros := 0
+	for zeros < len(data) && data[zeros] == 0 {
+		zeros++
+	}
+
+	// log(256)/log(62) < 1.35, so this is always long enough
+	digits := make([]byte, 0, len(data)*135/100+1)
+	for _, b := range data[zeros:] {
+		carry := int(b)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 62)
+			carry /= 62
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%62))
+			carry /= 62
+		}
+	}
+
+	out := make([]byte, zeros+len(digits))
+	for i := 0; i < zeros; i++ {
+		out[i] = base62Alphabet[0]
+	}
+	for i, d := range digits {
+		out[len(out)-1-i] = base62Alphabet[d]
+	}
+	return string(out)
+}
+
+func base62Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base62Alphabet[0] {
+		zeros++
+	}
+
+	// the bytes of the number, least significant first
+	var bytes []byte
+	for i := zeros; i < len(s); i++ {
+		d := base62Index[s[i]]
+		if d < 0 {
+			return nil, errBase62
+		}
+
+		carry := int(d)
+		for j := range bytes {
+			carry += int(bytes[j]) * 62
+			bytes[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			bytes = append(bytes, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	out := make([]byte, zeros+len(bytes))
+	for i, b := range bytes {
+		out[len(out)-1-i] = b
+	}
+	return out, nil
+}
@@ -0,0 +1,117 @@
+// Package branca implements Branca tokens, which are authenticated and
+// encrypted with XChaCha20-Poly1305 and a shared 256-bit key.
+//
+// A token is a version byte (0xBA), a 32-bit big-endian timestamp of when it
+// was created, a 192-bit random nonce, and the ciphertext and tag of the
+// payload, with the first three as additional data. The whole token is
+// encoded in base62. Because the timestamp is authenticated, a token can be
+// rejected once it is older than a time-to-live chosen by the recipient.
+//
+// For more information, see https://github.com/tuupola/branca-spec
+package branca
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// KeySize is the length of a Branca key, in bytes.
+	KeySize = chacha20poly1305.KeySize
+	// Version is the version byte of Branca tokens.
+	Version = 0xba
+	// MaxPayloadSize is the length of the longest payload which can be
+	// encoded, in bytes. Decoding base62 takes time quadratic in the length
+	// of the token, so longer tokens are rejected before they are decoded.
+	MaxPayloadSize = 4096
+
+	headerSize = 1 + 4 + chacha20poly1305.NonceSizeX
+
+	// the length of the longest token, using the same bound on the length of
+	// base62 as the encoder
+	maxTokenSize = (headerSize+MaxPayloadSize+chacha20poly1305.Overhead)*135/100 + 1
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20poly1305.ErrInvalidKey
+	// ErrInvalidTimestamp is returned when a time cannot be represented as a
+	// 32-bit Unix timestamp.
+	ErrInvalidTimestamp = errors.New("time out of range for a token timestamp")
+	// ErrPayloadTooLarge is returned when a payload is longer than
+	// MaxPayloadSize.
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrInvalidToken is returned when a token is not valid base62, is too
+	// short or too long, or has an unknown version.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrOpen is returned when a token cannot be authenticated, either
+	// because it was not encoded with the given key or because it was
+	// modified.
+	ErrOpen = errors.New("token authentication failed")
+	// ErrExpired is returned when a token is older than its time-to-live.
+	ErrExpired = errors.New("token expired")
+)
+
+// Encode encrypts and authenticates payload as a token created at the given
+// time, using 24 bytes read from rand as the nonce.
+func Encode(rand io.Reader, key, payload []byte, now time.Time) (string, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(payload) > MaxPayloadSize {
+		return "", ErrPayloadTooLarge
+	}
+
+	ts := now.Unix()
+	if ts < 0 || ts > math.MaxUint32 {
+		return "", ErrInvalidTimestamp
+	}
+
+	header := make([]byte, headerSize, headerSize+len(payload)+chacha20poly1305.Overhead)
+	header[0] = Version
+	binary.BigEndian.PutUint32(header[1:], uint32(ts))
+	if _, err := io.ReadFull(rand, header[5:]); err != nil {
+		return "", err
+	}
+
+	return base62Encode(aead.Seal(header, header[5:], payload, header)), nil
+}
+
+// Decode authenticates and decrypts a token, returning its payload and the
+// time it was created. If ttl is not zero, tokens created more than ttl
+// before now are rejected with ErrExpired.
+func Decode(key []byte, token string, ttl time.Duration, now time.Time) ([]byte, time.Time, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if len(token) > maxTokenSize {
+		return nil, time.Time{}, ErrInvalidToken
+	}
+
+	data, err := base62Decode(token)
+	if err != nil || len(data) < headerSize+chacha20poly1305.Overhead || data[0] != Version {
+		return nil, time.Time{}, ErrInvalidToken
+	}
+
+	header := data[:headerSize]
+	payload, err := aead.Open(nil, header[5:], data[headerSize:], header)
+	if err != nil {
+		return nil, time.Time{}, ErrOpen
+	}
+
+	created := time.Unix(int64(binary.BigEndian.Uint32(header[1:])), 0)
+	if ttl != 0 && now.Sub(created) > ttl {
+		return nil, time.Time{}, ErrExpired
+	}
+
+	return payload, created, nil
+}
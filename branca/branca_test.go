@@ -0,0 +1,195 @@
+package branca_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codahale/chacha20/branca"
+)
+
+const testKey = "supersecretkeyyoushouldnotcommit"
+
+type testVector struct {
+	nonce     string
+	timestamp int64
+	payload   string
+	token     string
+}
+
+var testVectors = []testVector{
+	// stolen from https://github.com/tuupola/branca-spec
+	testVector{
+		"0102030405060708090a0b0c0102030405060708090a0b0c",
+		123206400,
+		"48656c6c6f20776f726c6421",
+		"875GH233T7IYrxtgXxlQBYiFobZMQdHAT51vChKsAIYCFxZtL1evV54vYqLyZtQ0ekPHt8kJHQp0a",
+	},
+	testVector{
+		"beefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeef",
+		0,
+		"48656c6c6f20776f726c6421",
+		"870S4BYxgHw0KnP3W9fgVUHEhT5g86vJ17etaC5Kh5uIraWHCI1psNQGv298ZmjPwoYbjDQ9chy2z",
+	},
+	// sealed with libsodium's crypto_aead_xchacha20poly1305_ietf_encrypt,
+	// using the 29-byte header as the additional data and its last 24 bytes
+	// as the nonce, and encoded with a separate base62 encoder in Python
+	testVector{
+		"beefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeef",
+		4294967295,
+		"48656c6c6f20776f726c6421",
+		"89i7YCwu5tWAJNHUDdmIqhzOi5hVHOd4afjZcGMcVmM4enl4yeLiDyYv41eMkNmTX6IwYEFErCSqr",
+	},
+	testVector{
+		"beefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeef",
+		123206400,
+		"",
+		"4si6Rr2MAc3Ut4Earl745N2hoEU3OGWE2PWwpWmK44wZ1Mr77uqc5Go7G59MY",
+	},
+	testVector{
+		"beefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeefbeef",
+		123206400,
+		"0000000000000000",
+		"1jJDJOEjuwVb9Csz1Ypw1KBWSkr0YDpeBeJN6NzJWx1VgPLmcBhu2SbkpQ9JjZ3nfUf7Aytp",
+	},
+}
+
+func TestEncode(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		nonce, _ := hex.DecodeString(vector.nonce)
+		payload, _ := hex.DecodeString(vector.payload)
+
+		token, err := branca.Encode(bytes.NewReader(nonce), []byte(testKey), payload, time.Unix(vector.timestamp, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != vector.token {
+			t.Errorf("Bad token: expected %s, was %s", vector.token, token)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		payload, created, err := branca.Decode([]byte(testKey), vector.token, 0, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(payload) != vector.payload {
+			t.Errorf("Bad payload: expected %s, was %x", vector.payload, payload)
+		}
+
+		if created.Unix() != vector.timestamp {
+			t.Errorf("Bad timestamp: expected %d, was %d", vector.timestamp, created.Unix())
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	created := time.Unix(123206400, 0)
+	token := testVectors[0].token
+
+	if _, _, err := branca.Decode([]byte(testKey), token, time.Hour, created.Add(time.Hour)); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, err := branca.Decode([]byte(testKey), token, time.Hour, created.Add(time.Hour+time.Second)); err != branca.ErrExpired {
+		t.Error("Should have rejected an expired token")
+	}
+}
+
+func TestMaxPayloadSize(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xff}, branca.MaxPayloadSize)
+
+	token, err := branca.Encode(rand.Reader, []byte(testKey), payload, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, _, err := branca.Decode([]byte(testKey), token, 0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(payload, decoded) {
+		t.Errorf("Bad payload: expected %x, was %x", payload, decoded)
+	}
+}
+
+func TestBadTokens(t *testing.T) {
+	tokens := []struct {
+		token string
+		err   error
+	}{
+		// a wrong version
+		{"89mvl3RkwXjpEj5WMxK7GUDEHEeeeZtwjMG3PjYJ2t1xGhJ0Q6sU2Jy5JMFsdVMsZKHobgtByFOKn", branca.ErrInvalidToken},
+		// an invalid base62 character
+		{"875GH233T7IYrxtgXxlQBYiFobZMQdHAT51vChKsAIYCFxZtL1evV54vYqLyZtQ0ekPHt8kJHQp0_", branca.ErrInvalidToken},
+		// too short
+		{"875GH233T7IYrxtgXxlQBYiF", branca.ErrInvalidToken},
+		// the spec's first token with the low bit of the first byte of its
+		// timestamp, nonce, ciphertext and tag flipped in turn
+		{"874bfNM5lToygy7TcJJOBaBErgBksrAg7925MquVq2ZsQ1gtj2ChbDTqM5z7I2NQvORUyFxynFkY4", branca.ErrOpen},
+		{"875GH233KSGyFLzhHeV2XSj4jRoAVsw4lFDjFawW9N5TAxcq2qBKJraPsFWhTm5p10MPzZSencDmS", branca.ErrOpen},
+		{"875GH233T7IYrxtgXxlQBYiFobZMQdHAT51vChKs7A7Lsrax4gQEhf4LqnOtYvSSYG7pp8oY2YHJY", branca.ErrOpen},
+		{"875GH233T7IYrxtgXxlQBYiFobZMQdHAT51vChKsAIYCFxZtL1evV54vYqLyZtQ0ekPHt8kJHQp0b", branca.ErrOpen},
+	}
+
+	for i, v := range tokens {
+		t.Logf("Running token %d", i)
+
+		if _, _, err := branca.Decode([]byte(testKey), v.token, 0, time.Now()); err != v.err {
+			t.Errorf("Bad error: expected %v, was %v", v.err, err)
+		}
+	}
+
+	// too long to decode
+	if _, _, err := branca.Decode([]byte(testKey), strings.Repeat("z", 1<<20), 0, time.Now()); err != branca.ErrInvalidToken {
+		t.Errorf("Bad error: expected %v, was %v", branca.ErrInvalidToken, err)
+	}
+
+	if _, err := branca.Encode(rand.Reader, []byte(testKey), make([]byte, branca.MaxPayloadSize+1), time.Now()); err != branca.ErrPayloadTooLarge {
+		t.Error("Should have rejected a payload longer than MaxPayloadSize")
+	}
+
+	wrongKey := []byte("supersecretkeyyoushouldnotcommiT")
+	if _, _, err := branca.Decode(wrongKey, testVectors[0].token, 0, time.Now()); err != branca.ErrOpen {
+		t.Errorf("Bad error: expected %v, was %v", branca.ErrOpen, err)
+	}
+
+	if _, err := branca.Encode(rand.Reader, []byte("short"), nil, time.Now()); err != branca.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+
+	if _, err := branca.Encode(rand.Reader, []byte(testKey), nil, time.Unix(1<<32, 0)); err != branca.ErrInvalidTimestamp {
+		t.Error("Should have rejected a timestamp after 2106")
+	}
+}
+
+func Example() {
+	key := []byte("supersecretkeyyoushouldnotcommit")
+
+	token, err := branca.Encode(rand.Reader, key, []byte(`{"user_id":42}`), time.Now())
+	if err != nil {
+		panic(err)
+	}
+
+	payload, _, err := branca.Decode(key, token, time.Hour, time.Now())
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", payload)
+	// Output:
+	// {"user_id":42}
+}
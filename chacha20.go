@@ -27,8 +27,12 @@ const (
 	KeySize = 32
 	// NonceSize is the length of ChaCha20 nonces, in bytes.
 	NonceSize = 8
+	// IETFNonceSize is the length of IETF ChaCha20 nonces, in bytes.
+	IETFNonceSize = 12
 	// XNonceSize is the length of XChaCha20 nonces, in bytes.
 	XNonceSize = 24
+	// HNonceSize is the length of HChaCha20 nonces, in bytes.
+	HNonceSize = 16
 )
 
 var (
@@ -36,9 +40,15 @@ var (
 	ErrInvalidKey = errors.New("invalid key length (must be 256 bits)")
 	// ErrInvalidNonce is returned when the provided nonce is not 64 bits long.
 	ErrInvalidNonce = errors.New("invalid nonce length (must be 64 bits)")
+	// ErrInvalidIETFNonce is returned when the provided nonce is not 96 bits
+	// long.
+	ErrInvalidIETFNonce = errors.New("invalid nonce length (must be 96 bits)")
 	// ErrInvalidXNonce is returned when the provided nonce is not 192 bits
 	// long.
 	ErrInvalidXNonce = errors.New("invalid nonce length (must be 192 bits)")
+	// ErrInvalidHNonce is returned when the provided nonce is not 128 bits
+	// long.
+	ErrInvalidHNonce = errors.New("invalid nonce length (must be 128 bits)")
 	// ErrInvalidRounds is returned when the provided rounds is not
 	// 8, 12, or 20.
 	ErrInvalidRounds = errors.New("invalid rounds number (must be 8, 12, or 20)")
@@ -74,6 +84,36 @@ func NewWithRounds(key []byte, nonce []byte, rounds uint8) (cipher.Stream, error
 	return s, nil
 }
 
+// NewIETF creates and returns a new cipher.Stream using the IETF variant of
+// ChaCha20 from RFC 8439, which has a 96-bit nonce and a 32-bit block counter.
+// The key argument must be 256 bits long, and the nonce argument must be 96
+// bits long. The nonce must be randomly generated or used only once. This
+// Stream instance must not be used to encrypt more than 2^38 bytes (256 GiB),
+// and panics if it is.
+func NewIETF(key []byte, nonce []byte) (cipher.Stream, error) {
+	return NewIETFWithCounter(key, nonce, 0)
+}
+
+// NewIETFWithCounter creates and returns a new cipher.Stream just like
+// NewIETF but starting at the given block counter instead of zero. The
+// Stream panics if the counter wraps around.
+func NewIETFWithCounter(key []byte, nonce []byte, counter uint32) (cipher.Stream, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(nonce) != IETFNonceSize {
+		return nil, ErrInvalidIETFNonce
+	}
+
+	s := new(stream)
+	s.init(key, nonce, 20)
+	s.state[12] = counter
+	s.advance()
+
+	return s, nil
+}
+
 // NewXChaCha creates and returns a new cipher.Stream. The key argument must be
 // 256 bits long, and the nonce argument must be 192 bits long. The nonce must
 // be randomly generated or only used once. This Stream instance must not be
@@ -98,39 +138,62 @@ func NewXChaChaWithRounds(key []byte, nonce []byte, rounds uint8) (cipher.Stream
 	}
 
 	s := new(stream)
-	s.init(key, nonce, rounds)
-
-	// Call HChaCha to derive the subkey using the key and the first 16 bytes
-	// of the nonce, and re-initialize the state using the subkey and the
-	// remaining nonce.
-	blockArr := (*[stateSize]uint32)(unsafe.Pointer(&s.block))
-	core(&s.state, blockArr, s.rounds, true)
-	copy(s.state[4:8], blockArr[0:4])
-	copy(s.state[8:12], blockArr[12:16])
-	s.state[12] = 0
-	s.state[13] = 0
-	s.state[14] = binary.LittleEndian.Uint32(nonce[16:])
-	s.state[15] = binary.LittleEndian.Uint32(nonce[20:])
-
+	s.initX(key, nonce, rounds)
 	s.advance()
 
 	return s, nil
 }
 
+// HChaCha20 derives a 256-bit subkey from a 256-bit key and a 128-bit nonce.
+// It is the key derivation step of XChaCha20, and is also used on its own by
+// NaCl-style constructions to hash a Diffie-Hellman shared secret into a key.
+func HChaCha20(key []byte, nonce []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(nonce) != HNonceSize {
+		return nil, ErrInvalidHNonce
+	}
+
+	s := new(stream)
+	s.init(key, nonce, 20)
+
+	var out [stateSize]uint32
+	core(&s.state, &out, s.rounds, true)
+
+	subkey := make([]byte, KeySize)
+	for i, v := range out[0:4] {
+		binary.LittleEndian.PutUint32(subkey[i*wordSize:], v)
+	}
+	for i, v := range out[12:16] {
+		binary.LittleEndian.PutUint32(subkey[(i+4)*wordSize:], v)
+	}
+
+	return subkey, nil
+}
+
 type stream struct {
 	state  [stateSize]uint32 // the state as an array of 16 32-bit words
 	block  [blockSize]byte   // the keystream as an array of 64 bytes
 	offset int               // the offset of used bytes in block
-	rounds uint8
+	rounds uint8             // the number of rounds, 8, 12, or 20
+	ietf   bool              // whether the block counter is 32 bits instead of 64
+	done   bool              // whether a 32-bit block counter has wrapped around
 }
 
 func (s *stream) XORKeyStream(dst, src []byte) {
 	// Stride over the input in 64-byte blocks, minus the amount of keystream
 	// previously used. This will produce best results when processing blocks
-	// of a size evenly divisible by 64.
+	// of a size evenly divisible by 64. The next block is only generated when
+	// it is needed, so the last block of a 32-bit counter can be used.
 	i := 0
 	max := len(src)
 	for i < max {
+		if s.offset == blockSize {
+			s.advance()
+		}
+
 		gap := blockSize - s.offset
 
 		limit := i + gap
@@ -146,10 +209,6 @@ func (s *stream) XORKeyStream(dst, src []byte) {
 
 		i += gap
 		s.offset = o
-
-		if o == blockSize {
-			s.advance()
-		}
 	}
 }
 
@@ -176,7 +235,14 @@ func (s *stream) init(key []byte, nonce []byte, rounds uint8) {
 		s.state[13] = 0
 		s.state[14] = binary.LittleEndian.Uint32(nonce[0:])
 		s.state[15] = binary.LittleEndian.Uint32(nonce[4:])
-	case XNonceSize:
+	case IETFNonceSize:
+		// IETF ChaCha20 uses 12 byte nonces and a 4 byte counter.
+		s.state[12] = 0
+		s.state[13] = binary.LittleEndian.Uint32(nonce[0:])
+		s.state[14] = binary.LittleEndian.Uint32(nonce[4:])
+		s.state[15] = binary.LittleEndian.Uint32(nonce[8:])
+		s.ietf = true
+	case HNonceSize, XNonceSize:
 		// XChaCha20 derives the subkey via HChaCha initialized
 		// with the first 16 bytes of the nonce.
 		s.state[12] = binary.LittleEndian.Uint32(nonce[0:])
@@ -191,11 +257,31 @@ func (s *stream) init(key []byte, nonce []byte, rounds uint8) {
 	s.rounds = rounds
 }
 
+func (s *stream) initX(key []byte, nonce []byte, rounds uint8) {
+	s.init(key, nonce, rounds)
+
+	// Call HChaCha to derive the subkey using the key and the first 16 bytes
+	// of the nonce, and re-initialize the state using the subkey and the
+	// remaining nonce.
+	blockArr := (*[stateSize]uint32)(unsafe.Pointer(&s.block))
+	core(&s.state, blockArr, s.rounds, true)
+	copy(s.state[4:8], blockArr[0:4])
+	copy(s.state[8:12], blockArr[12:16])
+	s.state[12] = 0
+	s.state[13] = 0
+	s.state[14] = binary.LittleEndian.Uint32(nonce[16:])
+	s.state[15] = binary.LittleEndian.Uint32(nonce[20:])
+}
+
 // BUG(codahale): Totally untested on big-endian CPUs. Would very much
 // appreciate someone with an ARM device giving this a swing.
 
 // advances the keystream
 func (s *stream) advance() {
+	if s.done {
+		panic("chacha20: keystream exhausted")
+	}
+
 	core(&s.state, (*[stateSize]uint32)(unsafe.Pointer(&s.block)), s.rounds, false)
 
 	if bigEndian {
@@ -210,7 +296,13 @@ func (s *stream) advance() {
 	i := s.state[12] + 1
 	s.state[12] = i
 	if i == 0 {
-		s.state[13]++
+		if s.ietf {
+			// This is the last block, as the next would reuse the keystream
+			// of the first.
+			s.done = true
+		} else {
+			s.state[13]++
+		}
 	}
 }
 
@@ -364,6 +364,176 @@ func TestXChaCha20(t *testing.T) {
 	}
 }
 
+func TestIETF(t *testing.T) {
+	// stolen from https://tools.ietf.org/html/rfc8439#section-2.4.2
+	key, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Error(err)
+	}
+
+	nonce, err := hex.DecodeString("000000000000004a00000000")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected, err := hex.DecodeString(
+		"6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b" +
+			"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8" +
+			"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736" +
+			"5af90bbf74a35be6b40b8eedf2785e42874d")
+	if err != nil {
+		t.Error(err)
+	}
+
+	c, err := chacha20.NewIETF(key, nonce)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The RFC's example starts at block 1, so skip over block 0.
+	block := make([]byte, 64)
+	c.XORKeyStream(block, block)
+
+	src := []byte("Ladies and Gentlemen of the class of '99: If I could offer you " +
+		"only one tip for the future, sunscreen would be it.")
+	dst := make([]byte, len(src))
+	c.XORKeyStream(dst, src)
+
+	if !bytes.Equal(expected, dst) {
+		t.Errorf("Bad ciphertext: expected %x, was %x", expected, dst)
+	}
+}
+
+func TestIETFWithCounter(t *testing.T) {
+	// stolen from https://tools.ietf.org/html/rfc8439#section-2.4.2
+	key, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Error(err)
+	}
+
+	nonce, err := hex.DecodeString("000000000000004a00000000")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected, err := hex.DecodeString(
+		"6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b" +
+			"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8")
+	if err != nil {
+		t.Error(err)
+	}
+
+	c, err := chacha20.NewIETFWithCounter(key, nonce, 1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	src := []byte("Ladies and Gentlemen of the class of '99: If I could offer you o")
+	dst := make([]byte, len(src))
+	c.XORKeyStream(dst, src)
+
+	if !bytes.Equal(expected, dst) {
+		t.Errorf("Bad ciphertext: expected %x, was %x", expected, dst)
+	}
+}
+
+func TestIETFCounterExhaustion(t *testing.T) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.IETFNonceSize)
+
+	c, err := chacha20.NewIETFWithCounter(key, nonce, 0xffffffff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole of the last block is usable
+	block := make([]byte, 64)
+	c.XORKeyStream(block[:63], block[:63])
+	c.XORKeyStream(block[63:], block[63:])
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Should have panicked when the counter wrapped around")
+		}
+	}()
+	c.XORKeyStream(block[:1], block[:1])
+}
+
+func TestIETFLastBlock(t *testing.T) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.IETFNonceSize)
+
+	c, err := chacha20.NewIETFWithCounter(key, nonce, 0xfffffffe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// exactly the last two blocks, ending at the end of the keystream
+	blocks := make([]byte, 128)
+	c.XORKeyStream(blocks, blocks)
+
+	c, err = chacha20.NewIETFWithCounter(key, nonce, 0xffffffff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := make([]byte, 64)
+	c.XORKeyStream(last, last)
+
+	if !bytes.Equal(blocks[64:], last) {
+		t.Errorf("Bad last block: expected %x, was %x", blocks[64:], last)
+	}
+}
+
+func TestBadIETFNonceSize(t *testing.T) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.NonceSize)
+
+	_, err := chacha20.NewIETF(key, nonce)
+
+	if err != chacha20.ErrInvalidIETFNonce {
+		t.Error("Should have rejected an invalid nonce")
+	}
+}
+
+func TestHChaCha20(t *testing.T) {
+	// stolen from https://tools.ietf.org/html/draft-irtf-cfrg-xchacha-03#section-2.2.1
+	key, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Error(err)
+	}
+
+	nonce, err := hex.DecodeString("000000090000004a0000000031415927")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected, err := hex.DecodeString("82413b4227b27bfed30e42508a877d73a0f9e4d58a74a853c12ec41326d3ecdc")
+	if err != nil {
+		t.Error(err)
+	}
+
+	subkey, err := chacha20.HChaCha20(key, nonce)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(expected, subkey) {
+		t.Errorf("Bad subkey: expected %x, was %x", expected, subkey)
+	}
+}
+
+func TestBadHNonceSize(t *testing.T) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.NonceSize)
+
+	_, err := chacha20.HChaCha20(key, nonce)
+
+	if err != chacha20.ErrInvalidHNonce {
+		t.Error("Should have rejected an invalid nonce")
+	}
+}
+
 func TestBadKeySize(t *testing.T) {
 	key := make([]byte, 3)
 	nonce := make([]byte, chacha20.NonceSize)
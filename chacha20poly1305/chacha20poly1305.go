@@ -0,0 +1,219 @@
+// Package chacha20poly1305 provides the ChaCha20-Poly1305 authenticated
+// encryption construction from RFC 8439, and its XChaCha20-Poly1305 variant.
+//
+// ChaCha20-Poly1305 uses the IETF variant of ChaCha20 with a 96-bit nonce.
+// The first 32 bytes of block 0 of the keystream are used as a one-time
+// Poly1305 key, and the plaintext is encrypted starting at block 1. The
+// Poly1305 authenticator covers the additional data and the ciphertext, each
+// padded to a multiple of 16 bytes, followed by their lengths.
+//
+// XChaCha20-Poly1305, from draft-irtf-cfrg-xchacha, extends the nonce to 192
+// bits by using HChaCha20 to derive a subkey from the key and the first 128
+// bits of the nonce. Its nonces are long enough to be generated randomly.
+//
+// Sealer and Opener process a message in pieces, for messages too large to
+// hold in memory, and SealDetached and OpenDetached keep the authenticator
+// separate from the ciphertext. Both produce the same ciphertexts and
+// authenticators as Seal.
+//
+// For more information, see https://tools.ietf.org/html/rfc8439
+package chacha20poly1305
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/poly1305"
+)
+
+const (
+	// KeySize is the length of ChaCha20-Poly1305 keys, in bytes.
+	KeySize = chacha20.KeySize
+	// NonceSize is the length of ChaCha20-Poly1305 nonces, in bytes.
+	NonceSize = chacha20.IETFNonceSize
+	// NonceSizeX is the length of XChaCha20-Poly1305 nonces, in bytes.
+	NonceSizeX = chacha20.XNonceSize
+	// Overhead is the length of the Poly1305 authenticator appended to each
+	// ciphertext, in bytes.
+	Overhead = poly1305.TagSize
+
+	// the longest plaintext which can be encrypted under one nonce, limited
+	// by IETF ChaCha20's 32-bit block counter
+	maxPlaintextSize = (1<<32 - 1) * 64
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20.ErrInvalidKey
+	// ErrOpen is returned when a ciphertext cannot be opened, either because
+	// it was not sealed with the given key, nonce and additional data, or
+	// because it was modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// New creates and returns a new ChaCha20-Poly1305 cipher.AEAD. The key
+// argument must be 256 bits long. Nonces must be 96 bits long and must never
+// be used twice with the same key.
+func New(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	a := &aead{nonceSize: NonceSize}
+	copy(a.key[:], key)
+
+	return a, nil
+}
+
+// NewX creates and returns a new XChaCha20-Poly1305 cipher.AEAD. The key
+// argument must be 256 bits long. Nonces must be 192 bits long, which is long
+// enough for them to be randomly generated.
+func NewX(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	a := &aead{nonceSize: NonceSizeX}
+	copy(a.key[:], key)
+
+	return a, nil
+}
+
+type aead struct {
+	key       [KeySize]byte
+	nonceSize int
+}
+
+func (a *aead) NonceSize() int {
+	return a.nonceSize
+}
+
+func (a *aead) Overhead() int {
+	return Overhead
+}
+
+func (a *aead) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != a.nonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Seal")
+	}
+
+	if uint64(len(plaintext)) > maxPlaintextSize {
+		panic("chacha20poly1305: plaintext too large")
+	}
+
+	s, mac := a.init(nonce, additionalData)
+
+	ret, out := sliceForAppend(dst, len(plaintext)+Overhead)
+	ciphertext := out[:len(plaintext)]
+	s.XORKeyStream(ciphertext, plaintext)
+
+	finish(mac, additionalData, ciphertext, out[len(plaintext):])
+
+	return ret
+}
+
+func (a *aead) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != a.nonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Open")
+	}
+
+	if len(ciphertext) < Overhead {
+		return nil, ErrOpen
+	}
+
+	if uint64(len(ciphertext)) > maxPlaintextSize+Overhead {
+		return nil, ErrOpen
+	}
+
+	tag := ciphertext[len(ciphertext)-Overhead:]
+	ciphertext = ciphertext[:len(ciphertext)-Overhead]
+
+	s, mac := a.init(nonce, additionalData)
+
+	var expected [Overhead]byte
+	finish(mac, additionalData, ciphertext, expected[:])
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		return nil, ErrOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	s.XORKeyStream(out, ciphertext)
+
+	return ret, nil
+}
+
+// init returns the keystream for the nonce, positioned at block 1, and a MAC
+// keyed with block 0 which has absorbed the padded additional data.
+func (a *aead) init(nonce, additionalData []byte) (cipher.Stream, *poly1305.MAC) {
+	key := a.key[:]
+	if a.nonceSize == NonceSizeX {
+		// The subkey is derived from the first 16 bytes of the nonce, and the
+		// last 8 bytes are used as an IETF nonce with 4 leading zero bytes.
+		key, _ = chacha20.HChaCha20(key, nonce[:chacha20.HNonceSize])
+
+		var n [NonceSize]byte
+		copy(n[4:], nonce[chacha20.HNonceSize:])
+		nonce = n[:]
+	}
+
+	// The key and nonce are always the right sizes.
+	s, _ := chacha20.NewIETF(key, nonce)
+
+	var block [64]byte
+	s.XORKeyStream(block[:], block[:])
+
+	var polyKey [poly1305.KeySize]byte
+	copy(polyKey[:], block[:])
+
+	mac := poly1305.New(&polyKey)
+	mac.Write(additionalData)
+	pad16(mac, len(additionalData))
+
+	return s, mac
+}
+
+// finish absorbs the padded ciphertext and the lengths into the MAC and
+// writes the authenticator to out.
+func finish(mac *poly1305.MAC, additionalData, ciphertext, out []byte) {
+	mac.Write(ciphertext)
+	pad16(mac, len(ciphertext))
+
+	var lengths [16]byte
+	putLengths(lengths[:], uint64(len(additionalData)), uint64(len(ciphertext)))
+	mac.Write(lengths[:])
+
+	copy(out, mac.Sum(nil))
+}
+
+// putLengths encodes the lengths of the additional data and ciphertext as the
+// final block of the MAC's input.
+func putLengths(b []byte, adLen, ciphertextLen uint64) {
+	binary.LittleEndian.PutUint64(b[0:], adLen)
+	binary.LittleEndian.PutUint64(b[8:], ciphertextLen)
+}
+
+var zeros [16]byte
+
+// pad16 writes zeros to the MAC to pad n bytes of input to a multiple of 16.
+func pad16(mac *poly1305.MAC, n int) {
+	if n%16 != 0 {
+		mac.Write(zeros[:16-n%16])
+	}
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
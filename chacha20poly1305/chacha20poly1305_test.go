@@ -0,0 +1,183 @@
+package chacha20poly1305_test
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+// stolen from https://tools.ietf.org/html/rfc8439#section-2.8.2 and
+// https://tools.ietf.org/html/draft-irtf-cfrg-xchacha-03#appendix-A.3.1
+type testVector struct {
+	x          bool
+	key        string
+	nonce      string
+	ad         string
+	plaintext  string
+	ciphertext string
+}
+
+var sunscreen = hex.EncodeToString([]byte("Ladies and Gentlemen of the class of '99: " +
+	"If I could offer you only one tip for the future, sunscreen would be it."))
+
+var testVectors = []testVector{
+	testVector{
+		false,
+		"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		"070000004041424344454647",
+		"50515253c0c1c2c3c4c5c6c7",
+		sunscreen,
+		"d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+			"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+			"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+			"3ff4def08e4b7a9de576d26586cec64b6116" +
+			"1ae10b594f09e26a7e902ecbd0600691",
+	},
+	testVector{
+		true,
+		"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		"404142434445464748494a4b4c4d4e4f5051525354555657",
+		"50515253c0c1c2c3c4c5c6c7",
+		sunscreen,
+		"bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb" +
+			"731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b452" +
+			"2f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff9" +
+			"21f9664c97637da9768812f615c68b13b52e" +
+			"c0875924c1c7987947deafd8780acf49",
+	},
+}
+
+func TestAEAD(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		key, _ := hex.DecodeString(vector.key)
+		nonce, _ := hex.DecodeString(vector.nonce)
+		ad, _ := hex.DecodeString(vector.ad)
+		plaintext, _ := hex.DecodeString(vector.plaintext)
+		expected, _ := hex.DecodeString(vector.ciphertext)
+
+		var a cipher.AEAD
+		var err error
+		if vector.x {
+			a, err = chacha20poly1305.NewX(key)
+		} else {
+			a, err = chacha20poly1305.New(key)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := a.Seal(nil, nonce, plaintext, ad)
+		if !bytes.Equal(expected, ciphertext) {
+			t.Errorf("Bad ciphertext: expected %x, was %x", expected, ciphertext)
+		}
+
+		opened, err := a.Open(nil, nonce, ciphertext, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(plaintext, opened) {
+			t.Errorf("Bad plaintext: expected %x, was %x", plaintext, opened)
+		}
+
+		ciphertext[0] ^= 1
+		if _, err := a.Open(nil, nonce, ciphertext, ad); err != chacha20poly1305.ErrOpen {
+			t.Error("Should have rejected a modified ciphertext")
+		}
+		ciphertext[0] ^= 1
+
+		if _, err := a.Open(nil, nonce, ciphertext, ad[1:]); err != chacha20poly1305.ErrOpen {
+			t.Error("Should have rejected modified additional data")
+		}
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+
+	a, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello I am a secret message")
+	expected := a.Seal(nil, nonce, plaintext, nil)
+
+	buf := make([]byte, len(plaintext), len(plaintext)+a.Overhead())
+	copy(buf, plaintext)
+	ciphertext := a.Seal(buf[:0], nonce, buf, nil)
+	if !bytes.Equal(expected, ciphertext) {
+		t.Errorf("Bad ciphertext: expected %x, was %x", expected, ciphertext)
+	}
+
+	opened, err := a.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(plaintext, opened) {
+		t.Errorf("Bad plaintext: expected %x, was %x", plaintext, opened)
+	}
+}
+
+func TestShortCiphertext(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+
+	a, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Open(nil, nonce, make([]byte, a.Overhead()-1), nil); err != chacha20poly1305.ErrOpen {
+		t.Error("Should have rejected a short ciphertext")
+	}
+}
+
+func TestBadKeySize(t *testing.T) {
+	key := make([]byte, 3)
+
+	if _, err := chacha20poly1305.New(key); err != chacha20poly1305.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+
+	if _, err := chacha20poly1305.NewX(key); err != chacha20poly1305.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+}
+
+func ExampleNewX() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	a, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		panic(err)
+	}
+
+	// XChaCha20-Poly1305 nonces are long enough to be generated randomly.
+	nonce, err := hex.DecodeString("308c92676fa95973308c92676fa95973308c92676fa95973")
+	if err != nil {
+		panic(err)
+	}
+
+	ciphertext := a.Seal(nil, nonce, []byte("hello I am a secret message"), nil)
+
+	plaintext, err := a.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", plaintext)
+	// Output:
+	// hello I am a secret message
+}
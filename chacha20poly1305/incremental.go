@@ -0,0 +1,236 @@
+package chacha20poly1305
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/codahale/chacha20/poly1305"
+)
+
+// ErrInvalidNonce is returned when the provided nonce is neither 96 bits long,
+// for ChaCha20-Poly1305, nor 192 bits long, for XChaCha20-Poly1305.
+var ErrInvalidNonce = errors.New("invalid nonce length (must be 96 or 192 bits)")
+
+// newWithNonce returns the AEAD for a key and a nonce of either length.
+func newWithNonce(key, nonce []byte) (*aead, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(nonce) != NonceSize && len(nonce) != NonceSizeX {
+		return nil, ErrInvalidNonce
+	}
+
+	a := &aead{nonceSize: len(nonce)}
+	copy(a.key[:], key)
+
+	return a, nil
+}
+
+// SealDetached encrypts and authenticates plaintext and additional data like
+// Seal, but returns the Poly1305 authenticator separately from the
+// ciphertext, which is appended to dst. A 96-bit nonce selects
+// ChaCha20-Poly1305 and a 192-bit nonce selects XChaCha20-Poly1305.
+func SealDetached(dst, key, nonce, plaintext, additionalData []byte) (ciphertext, tag []byte, err error) {
+	s, err := NewSealer(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.AddAD(additionalData)
+
+	ret, out := sliceForAppend(dst, len(plaintext))
+	s.Encrypt(out, plaintext)
+
+	return ret, s.Finish(), nil
+}
+
+// OpenDetached authenticates and decrypts a ciphertext and its separate
+// Poly1305 authenticator like Open, appending the plaintext to dst. Nothing is
+// decrypted unless the authenticator is valid.
+func OpenDetached(dst, key, nonce, ciphertext, tag, additionalData []byte) ([]byte, error) {
+	a, err := newWithNonce(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tag) != Overhead || uint64(len(ciphertext)) > maxPlaintextSize {
+		return nil, ErrOpen
+	}
+
+	s, mac := a.init(nonce, additionalData)
+
+	var expected [Overhead]byte
+	finish(mac, additionalData, ciphertext, expected[:])
+	if subtle.ConstantTimeCompare(expected[:], tag) != 1 {
+		return nil, ErrOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	s.XORKeyStream(out, ciphertext)
+
+	return ret, nil
+}
+
+// incremental is the state shared by Sealer and Opener.
+type incremental struct {
+	s        cipher.Stream
+	mac      *poly1305.MAC
+	adLen    uint64
+	textLen  uint64
+	started  bool // whether the additional data is complete and padded
+	finished bool // whether the authenticator has been computed
+}
+
+func (c *incremental) init(key, nonce []byte) error {
+	a, err := newWithNonce(key, nonce)
+	if err != nil {
+		return err
+	}
+
+	c.s, c.mac = a.init(nonce, nil)
+	return nil
+}
+
+func (c *incremental) addAD(p []byte) {
+	if c.started || c.finished {
+		panic("chacha20poly1305: additional data added after text")
+	}
+
+	c.mac.Write(p)
+	c.adLen += uint64(len(p))
+}
+
+// text absorbs a piece of ciphertext into the MAC, padding the additional
+// data first if this is the first piece.
+func (c *incremental) text(ciphertext []byte) {
+	if c.finished {
+		panic("chacha20poly1305: text added after the authenticator")
+	}
+
+	if !c.started {
+		pad16(c.mac, int(c.adLen%16))
+		c.started = true
+	}
+
+	c.textLen += uint64(len(ciphertext))
+	if c.textLen > maxPlaintextSize {
+		panic("chacha20poly1305: plaintext too large")
+	}
+	c.mac.Write(ciphertext)
+}
+
+func (c *incremental) sum() []byte {
+	if c.finished {
+		panic("chacha20poly1305: authenticator already computed")
+	}
+
+	if !c.started {
+		pad16(c.mac, int(c.adLen%16))
+	}
+	pad16(c.mac, int(c.textLen%16))
+	c.finished = true
+
+	var lengths [16]byte
+	putLengths(lengths[:], c.adLen, c.textLen)
+	c.mac.Write(lengths[:])
+
+	return c.mac.Sum(nil)
+}
+
+// Sealer encrypts a message incrementally, for messages too large to hold in
+// memory: first the additional data is added, in any number of pieces, then
+// the plaintext is encrypted, in any number of pieces, and finally Finish
+// returns the authenticator for the whole message. The result is the same as
+// Seal's, with the authenticator detached from the ciphertext.
+type Sealer struct {
+	incremental
+}
+
+// NewSealer returns a Sealer for the given key and nonce. A 96-bit nonce
+// selects ChaCha20-Poly1305 and a 192-bit nonce selects XChaCha20-Poly1305.
+// As with Seal, a nonce must never be used twice with the same key.
+func NewSealer(key, nonce []byte) (*Sealer, error) {
+	s := new(Sealer)
+	if err := s.init(key, nonce); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddAD adds a piece of additional data. It panics if called after Encrypt or
+// Finish.
+func (s *Sealer) AddAD(additionalData []byte) {
+	s.addAD(additionalData)
+}
+
+// Encrypt encrypts a piece of plaintext into dst, which must be at least as
+// long as src and may overlap it entirely. It panics if called after Finish.
+func (s *Sealer) Encrypt(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("chacha20poly1305: output smaller than input")
+	}
+
+	dst = dst[:len(src)]
+	s.s.XORKeyStream(dst, src)
+	s.text(dst)
+}
+
+// Finish returns the 16-byte authenticator of the additional data and
+// ciphertext. It panics if called more than once.
+func (s *Sealer) Finish() []byte {
+	return s.sum()
+}
+
+// Opener decrypts a message incrementally, for messages too large to hold in
+// memory: first the additional data is added, in any number of pieces, then
+// the ciphertext is decrypted, in any number of pieces, and finally Verify
+// checks the authenticator for the whole message.
+//
+// The plaintext returned by Decrypt is unverified until Verify returns nil,
+// and may have been chosen by an attacker. It must not be used, acted on, or
+// released to anything else until then, and must be discarded if Verify
+// returns an error. Where that is not practical, split the message into
+// individually sealed chunks instead.
+type Opener struct {
+	incremental
+}
+
+// NewOpener returns an Opener for the given key and nonce. A 96-bit nonce
+// selects ChaCha20-Poly1305 and a 192-bit nonce selects XChaCha20-Poly1305.
+func NewOpener(key, nonce []byte) (*Opener, error) {
+	o := new(Opener)
+	if err := o.init(key, nonce); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// AddAD adds a piece of additional data. It panics if called after Decrypt or
+// Verify.
+func (o *Opener) AddAD(additionalData []byte) {
+	o.addAD(additionalData)
+}
+
+// Decrypt decrypts a piece of ciphertext into dst, which must be at least as
+// long as src and may overlap it entirely. The plaintext is unverified until
+// Verify returns nil. It panics if called after Verify.
+func (o *Opener) Decrypt(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("chacha20poly1305: output smaller than input")
+	}
+
+	o.text(src)
+	o.s.XORKeyStream(dst[:len(src)], src)
+}
+
+// Verify checks the 16-byte authenticator of the additional data and
+// ciphertext, returning ErrOpen if it is invalid. It panics if called more
+// than once.
+func (o *Opener) Verify(tag []byte) error {
+	expected := o.sum()
+	if subtle.ConstantTimeCompare(expected, tag) != 1 {
+		return ErrOpen
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package chacha20poly1305_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+// pieces splits b into pieces of the given size and a shorter remainder.
+func pieces(b []byte, size int) [][]byte {
+	var p [][]byte
+	for len(b) > size {
+		p = append(p, b[:size])
+		b = b[size:]
+	}
+	return append(p, b)
+}
+
+func TestIncremental(t *testing.T) {
+	for i, vector := range testVectors {
+		key, _ := hex.DecodeString(vector.key)
+		nonce, _ := hex.DecodeString(vector.nonce)
+		ad, _ := hex.DecodeString(vector.ad)
+		plaintext, _ := hex.DecodeString(vector.plaintext)
+		sealed, _ := hex.DecodeString(vector.ciphertext)
+		expected, tag := sealed[:len(plaintext)], sealed[len(plaintext):]
+
+		for _, size := range []int{1, 5, 16, 17, 64, 1000} {
+			t.Logf("Running test vector %d with %d-byte pieces", i, size)
+
+			s, err := chacha20poly1305.NewSealer(key, nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range pieces(ad, size) {
+				s.AddAD(p)
+			}
+
+			ciphertext := new(bytes.Buffer)
+			for _, p := range pieces(plaintext, size) {
+				out := make([]byte, len(p))
+				s.Encrypt(out, p)
+				ciphertext.Write(out)
+			}
+
+			if !bytes.Equal(expected, ciphertext.Bytes()) {
+				t.Errorf("Bad ciphertext: expected %x, was %x", expected, ciphertext.Bytes())
+			}
+
+			if actual := s.Finish(); !bytes.Equal(tag, actual) {
+				t.Errorf("Bad tag: expected %x, was %x", tag, actual)
+			}
+
+			o, err := chacha20poly1305.NewOpener(key, nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range pieces(ad, size) {
+				o.AddAD(p)
+			}
+
+			opened := new(bytes.Buffer)
+			for _, p := range pieces(expected, size) {
+				out := make([]byte, len(p))
+				o.Decrypt(out, p)
+				opened.Write(out)
+			}
+
+			if !bytes.Equal(plaintext, opened.Bytes()) {
+				t.Errorf("Bad plaintext: expected %x, was %x", plaintext, opened.Bytes())
+			}
+
+			if err := o.Verify(tag); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestIncrementalEmpty(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+
+	a, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ad := range [][]byte{nil, []byte("header")} {
+		expected := a.Seal(nil, nonce, nil, ad)
+
+		s, err := chacha20poly1305.NewSealer(key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.AddAD(ad)
+
+		if actual := s.Finish(); !bytes.Equal(expected, actual) {
+			t.Errorf("Bad tag: expected %x, was %x", expected, actual)
+		}
+	}
+}
+
+func TestOpenerBadTag(t *testing.T) {
+	vector := testVectors[0]
+	key, _ := hex.DecodeString(vector.key)
+	nonce, _ := hex.DecodeString(vector.nonce)
+	ad, _ := hex.DecodeString(vector.ad)
+	sealed, _ := hex.DecodeString(vector.ciphertext)
+	ciphertext, tag := sealed[:len(sealed)-16], sealed[len(sealed)-16:]
+
+	for _, c := range []struct {
+		name       string
+		ad         []byte
+		ciphertext []byte
+		tag        []byte
+	}{
+		{"a modified tag", ad, ciphertext, append([]byte{tag[0] ^ 1}, tag[1:]...)},
+		{"a short tag", ad, ciphertext, tag[:15]},
+		{"modified additional data", ad[1:], ciphertext, tag},
+		{"a truncated ciphertext", ad, ciphertext[:len(ciphertext)-1], tag},
+	} {
+		o, err := chacha20poly1305.NewOpener(key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.AddAD(c.ad)
+		o.Decrypt(make([]byte, len(c.ciphertext)), c.ciphertext)
+
+		if err := o.Verify(c.tag); err != chacha20poly1305.ErrOpen {
+			t.Errorf("Should have rejected %s", c.name)
+		}
+
+		if _, err := chacha20poly1305.OpenDetached(nil, key, nonce, c.ciphertext, c.tag, c.ad); err != chacha20poly1305.ErrOpen {
+			t.Errorf("Should have rejected %s", c.name)
+		}
+	}
+}
+
+func TestDetached(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		key, _ := hex.DecodeString(vector.key)
+		nonce, _ := hex.DecodeString(vector.nonce)
+		ad, _ := hex.DecodeString(vector.ad)
+		plaintext, _ := hex.DecodeString(vector.plaintext)
+		sealed, _ := hex.DecodeString(vector.ciphertext)
+
+		ciphertext, tag, err := chacha20poly1305.SealDetached([]byte("prefix"), key, nonce, plaintext, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := append([]byte("prefix"), sealed...); !bytes.Equal(expected, append(ciphertext, tag...)) {
+			t.Errorf("Bad ciphertext: expected %x, was %x", expected, append(ciphertext, tag...))
+		}
+
+		opened, err := chacha20poly1305.OpenDetached(nil, key, nonce, ciphertext[6:], tag, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(plaintext, opened) {
+			t.Errorf("Bad plaintext: expected %x, was %x", plaintext, opened)
+		}
+	}
+}
+
+func TestBadNonceSize(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, 16)
+
+	if _, err := chacha20poly1305.NewSealer(key, nonce); err != chacha20poly1305.ErrInvalidNonce {
+		t.Error("Should have rejected a 128-bit nonce")
+	}
+
+	if _, err := chacha20poly1305.NewOpener(key[:16], nonce[:12]); err != chacha20poly1305.ErrInvalidKey {
+		t.Error("Should have rejected a 128-bit key")
+	}
+
+	if _, _, err := chacha20poly1305.SealDetached(nil, key, nonce, nil, nil); err != chacha20poly1305.ErrInvalidNonce {
+		t.Error("Should have rejected a 128-bit nonce")
+	}
+}
+
+func TestSealerMisuse(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+
+	for _, c := range []struct {
+		name string
+		f    func(s *chacha20poly1305.Sealer)
+	}{
+		{"additional data after plaintext", func(s *chacha20poly1305.Sealer) {
+			s.Encrypt(make([]byte, 1), []byte{1})
+			s.AddAD([]byte{1})
+		}},
+		{"plaintext after the tag", func(s *chacha20poly1305.Sealer) {
+			s.Finish()
+			s.Encrypt(make([]byte, 1), []byte{1})
+		}},
+		{"a second tag", func(s *chacha20poly1305.Sealer) {
+			s.Finish()
+			s.Finish()
+		}},
+		{"a short output", func(s *chacha20poly1305.Sealer) {
+			s.Encrypt(make([]byte, 1), []byte{1, 2})
+		}},
+	} {
+		s, err := chacha20poly1305.NewSealer(key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Should have panicked on %s", c.name)
+				}
+			}()
+			c.f(s)
+		}()
+	}
+}
+
+func ExampleOpener() {
+	key := bytes.Repeat([]byte{0x42}, chacha20poly1305.KeySize)
+	nonce := bytes.Repeat([]byte{0x24}, chacha20poly1305.NonceSizeX)
+
+	s, err := chacha20poly1305.NewSealer(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+	s.AddAD([]byte("header"))
+
+	ciphertext := make([]byte, 12)
+	s.Encrypt(ciphertext[:7], []byte("hello, "))
+	s.Encrypt(ciphertext[7:], []byte("world"))
+	tag := s.Finish()
+
+	o, err := chacha20poly1305.NewOpener(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+	o.AddAD([]byte("header"))
+
+	// The plaintext must not be used until the tag is verified.
+	plaintext := make([]byte, len(ciphertext))
+	o.Decrypt(plaintext, ciphertext)
+	if err := o.Verify(tag); err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(plaintext))
+	// Output:
+	// hello, world
+}
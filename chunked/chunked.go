@@ -0,0 +1,286 @@
+// Package chunked implements an encrypted file format which can be read
+// from at any offset, with every read authenticated.
+//
+// A file begins with a 21-byte header: a version byte, the chunk size as a
+// big-endian 32-bit integer, and a random 16-byte file nonce. The plaintext
+// is split into chunks of exactly the chunk size, except for the last, which
+// may be shorter and is empty only if the file is. Each chunk is encrypted
+// with XChaCha20-Poly1305, using the file nonce followed by the chunk's
+// big-endian 64-bit index as its nonce, with the high bit of the index set
+// for the last chunk. The header is the additional data of every chunk.
+//
+// Because the file nonce is hashed with the key by XChaCha20's HChaCha20,
+// each file is encrypted under its own subkey. Reading any chunk reads only
+// that chunk, and a chunk which has been modified, moved to another position
+// or another file, or which is not the last chunk but ends the file, fails
+// to decrypt.
+package chunked
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// Version is the version of the format.
+	Version = 1
+	// KeySize is the length of a key, in bytes.
+	KeySize = chacha20poly1305.KeySize
+	// HeaderSize is the length of the file header, in bytes.
+	HeaderSize = 1 + 4 + fileNonceSize
+	// Overhead is the number of bytes each encrypted chunk is longer than its
+	// plaintext.
+	Overhead = chacha20poly1305.Overhead
+	// DefaultChunkSize is a chunk size which suits most files, in bytes.
+	DefaultChunkSize = 64 * 1024
+	// MaxChunkSize is the largest chunk size, in bytes.
+	MaxChunkSize = 16 * 1024 * 1024
+
+	fileNonceSize = 16
+	lastChunkFlag = 1 << 63
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20poly1305.ErrInvalidKey
+	// ErrInvalidChunkSize is returned when a chunk size is not between 1 and
+	// MaxChunkSize.
+	ErrInvalidChunkSize = errors.New("invalid chunk size")
+	// ErrInvalidHeader is returned when a file's header has an unknown
+	// version or an invalid chunk size.
+	ErrInvalidHeader = errors.New("invalid header")
+	// ErrInvalidFile is returned when a file's length is not that of a
+	// header and a whole number of chunks.
+	ErrInvalidFile = errors.New("invalid file length")
+	// ErrOpen is returned when a chunk cannot be authenticated, either because
+	// it was not encrypted with the given key, it was modified or moved, or
+	// the file was truncated.
+	ErrOpen = errors.New("message authentication failed")
+	// ErrInvalidOffset is returned when reading at a negative offset.
+	ErrInvalidOffset = errors.New("invalid offset")
+	// ErrClosed is returned when writing to a closed Writer.
+	ErrClosed = errors.New("writer is closed")
+)
+
+// nonce returns the nonce of a chunk.
+func nonce(header []byte, index uint64, last bool) []byte {
+	if last {
+		index |= lastChunkFlag
+	}
+
+	n := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(n, header[HeaderSize-fileNonceSize:HeaderSize])
+	binary.BigEndian.PutUint64(n[fileNonceSize:], index)
+	return n
+}
+
+// Writer encrypts a file as it is written.
+type Writer struct {
+	aead   cipher.AEAD
+	dst    io.Writer
+	header []byte
+	index  uint64
+	buf    []byte
+	size   int
+	err    error
+}
+
+// NewWriter writes the header of a new file with the given chunk size to
+// dst, using 16 bytes read from rand as the file nonce, and returns a Writer
+// which encrypts the file's contents. Close must be called to write the last
+// chunk.
+func NewWriter(dst io.Writer, key []byte, chunkSize int, rand io.Reader) (*Writer, error) {
+	if chunkSize < 1 || chunkSize > MaxChunkSize {
+		return nil, ErrInvalidChunkSize
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, HeaderSize)
+	header[0] = Version
+	binary.BigEndian.PutUint32(header[1:], uint32(chunkSize))
+	if _, err := io.ReadFull(rand, header[HeaderSize-fileNonceSize:]); err != nil {
+		return nil, err
+	}
+
+	if _, err := dst.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		aead:   aead,
+		dst:    dst,
+		header: header,
+		buf:    make([]byte, 0, chunkSize+Overhead),
+		size:   chunkSize,
+	}, nil
+}
+
+// Write encrypts and writes each chunk of p as it is filled.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	total := len(p)
+	for len(p) > 0 {
+		// A full chunk is only flushed once more data arrives, because until
+		// then it might be the last chunk.
+		if len(w.buf) == w.size {
+			if err := w.flush(false); err != nil {
+				w.err = err
+				return total - len(p), err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):w.size], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+	}
+
+	return total, nil
+}
+
+// Close encrypts and writes the last chunk. It does not close the underlying
+// writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.flush(true); err != nil {
+		w.err = err
+		return err
+	}
+
+	w.err = ErrClosed
+	return nil
+}
+
+func (w *Writer) flush(last bool) error {
+	ciphertext := w.aead.Seal(w.buf[:0], nonce(w.header, w.index, last), w.buf, w.header)
+	if _, err := w.dst.Write(ciphertext); err != nil {
+		return err
+	}
+
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Reader decrypts a file at any offset. Like any io.ReaderAt, it is safe for
+// concurrent use if the underlying io.ReaderAt is.
+type Reader struct {
+	aead      cipher.AEAD
+	src       io.ReaderAt
+	header    []byte
+	chunkSize int64
+	chunks    int64 // the number of chunks
+	fileSize  int64 // the length of the file
+	size      int64 // the length of the plaintext
+}
+
+// NewReader returns a Reader for the file of the given length read from src.
+// It authenticates the last chunk, so that a truncated file is rejected
+// before any of it is read.
+func NewReader(src io.ReaderAt, size int64, key []byte) (*Reader, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, HeaderSize)
+	if _, err := src.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			err = ErrInvalidFile
+		}
+		return nil, err
+	}
+
+	chunkSize := int64(binary.BigEndian.Uint32(header[1:]))
+	if header[0] != Version || chunkSize < 1 || chunkSize > MaxChunkSize {
+		return nil, ErrInvalidHeader
+	}
+
+	encrypted := chunkSize + Overhead
+	body := size - HeaderSize
+	chunks := (body + encrypted - 1) / encrypted
+	last := body - (chunks-1)*encrypted
+	if body < Overhead || last < Overhead {
+		return nil, ErrInvalidFile
+	}
+
+	r := &Reader{
+		aead:      aead,
+		src:       src,
+		header:    header,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		fileSize:  size,
+		size:      (chunks-1)*chunkSize + last - Overhead,
+	}
+
+	if _, err := r.chunk(nil, chunks-1); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Size returns the length of the plaintext, in bytes.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// ReadAt decrypts len(p) bytes of plaintext starting at off, reading and
+// authenticating only the chunks which contain them.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrInvalidOffset
+	}
+
+	n := 0
+	var buf []byte
+	for n < len(p) && off < r.size {
+		index, start := off/r.chunkSize, off%r.chunkSize
+
+		var err error
+		if buf, err = r.chunk(buf[:0], index); err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], buf[start:])
+		n += c
+		off += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunk reads, authenticates and decrypts a chunk, and appends its plaintext
+// to dst.
+func (r *Reader) chunk(dst []byte, index int64) ([]byte, error) {
+	offset := HeaderSize + index*(r.chunkSize+Overhead)
+	length := min(r.chunkSize+Overhead, r.fileSize-offset)
+
+	ciphertext := make([]byte, length)
+	if _, err := r.src.ReadAt(ciphertext, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	plaintext, err := r.aead.Open(dst, nonce(r.header, uint64(index), index == r.chunks-1), ciphertext, r.header)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
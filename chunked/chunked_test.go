@@ -0,0 +1,293 @@
+package chunked_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/codahale/chacha20/chunked"
+)
+
+// the header for 16-byte chunks and the file nonce 101112...1f, followed by
+// each chunk sealed with libsodium's crypto_aead_xchacha20poly1305_ietf_encrypt
+// under the key 000102...1f, with the file nonce and the chunk's index (with
+// the high bit set for the last) as the nonce and the header as the additional
+// data
+const (
+	testKey       = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testFileNonce = "101112131415161718191a1b1c1d1e1f"
+	testPlaintext = "The quick brown fox jumps over the lazy dog"
+	testFile      = "0100000010101112131415161718191a1b1c1d1e1fd2a3f38c5d9c7ed73fafd81fded4b8" +
+		"e5707ed236fdcf0ddf2659b1defcc6921f2e311f5e24c70613b2aeb8a75028e4ececd6ee" +
+		"f468738e0f8ca1e4243cecb8ae3e0d5d9202221e83f0d5ae4421e35619cae4ba8ef95a2d" +
+		"75cef6f0"
+)
+
+func TestWriter(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+	fileNonce, _ := hex.DecodeString(testFileNonce)
+
+	buf := new(bytes.Buffer)
+	w, err := chunked.NewWriter(buf, key, 16, bytes.NewReader(fileNonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writes which don't line up with chunks
+	for _, s := range []string{"The quick ", "brown fox jumps over the lazy", "", " dog"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(buf.Bytes()) != testFile {
+		t.Errorf("Bad file: expected %s, was %x", testFile, buf.Bytes())
+	}
+
+	if _, err := w.Write([]byte("more")); err != chunked.ErrClosed {
+		t.Error("Should have rejected a write after Close")
+	}
+}
+
+func TestReader(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+	file, _ := hex.DecodeString(testFile)
+
+	r, err := chunked.NewReader(bytes.NewReader(file), int64(len(file)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Size() != int64(len(testPlaintext)) {
+		t.Errorf("Bad size: expected %d, was %d", len(testPlaintext), r.Size())
+	}
+
+	for _, off := range []int{0, 1, 15, 16, 17, 30, 40} {
+		p := make([]byte, 5)
+		n, err := r.ReadAt(p, int64(off))
+
+		expected := testPlaintext[off:min(off+5, len(testPlaintext))]
+		if string(p[:n]) != expected {
+			t.Errorf("Bad read at %d: expected %q, was %q", off, expected, p[:n])
+		}
+
+		if (n < len(p)) != (err == io.EOF) {
+			t.Errorf("Bad error at %d: %v", off, err)
+		}
+	}
+
+	all, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(all) != testPlaintext {
+		t.Errorf("Bad plaintext: expected %q, was %q", testPlaintext, all)
+	}
+
+	if _, err := r.ReadAt(make([]byte, 1), -1); err != chunked.ErrInvalidOffset {
+		t.Error("Should have rejected a negative offset")
+	}
+}
+
+func TestTampering(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+	file, _ := hex.DecodeString(testFile)
+	encrypted := 16 + chunked.Overhead
+
+	open := func(file []byte) (*chunked.Reader, error) {
+		return chunked.NewReader(bytes.NewReader(file), int64(len(file)), key)
+	}
+
+	// truncated to whole chunks, so the last chunk is missing
+	if _, err := open(file[:chunked.HeaderSize+2*encrypted]); err != chunked.ErrOpen {
+		t.Error("Should have rejected a truncated file")
+	}
+
+	// truncated within a chunk's tag
+	if _, err := open(file[:chunked.HeaderSize+2*encrypted+10]); err != chunked.ErrInvalidFile {
+		t.Error("Should have rejected a partial chunk")
+	}
+
+	if _, err := open(file[:chunked.HeaderSize-1]); err != chunked.ErrInvalidFile {
+		t.Error("Should have rejected a partial header")
+	}
+
+	// the chunk size is authenticated
+	modified := append([]byte{}, file...)
+	modified[4] = 8
+	if _, err := open(modified); err != chunked.ErrOpen {
+		t.Error("Should have rejected a different chunk size")
+	}
+
+	modified[0], modified[4] = 2, 16
+	if _, err := open(modified); err != chunked.ErrInvalidHeader {
+		t.Error("Should have rejected an unknown version")
+	}
+
+	// only the modified chunk is rejected
+	modified = append([]byte{}, file...)
+	modified[chunked.HeaderSize] ^= 1
+	r, err := open(modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.ReadAt(make([]byte, 16), 16); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := r.ReadAt(make([]byte, 16), 0); err != chunked.ErrOpen {
+		t.Error("Should have rejected a modified chunk")
+	}
+
+	// the first two chunks, swapped
+	modified = append([]byte{}, file[:chunked.HeaderSize]...)
+	modified = append(modified, file[chunked.HeaderSize+encrypted:chunked.HeaderSize+2*encrypted]...)
+	modified = append(modified, file[chunked.HeaderSize:chunked.HeaderSize+encrypted]...)
+	modified = append(modified, file[chunked.HeaderSize+2*encrypted:]...)
+	if r, err = open(modified); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.ReadAt(make([]byte, 1), 0); err != chunked.ErrOpen {
+		t.Error("Should have rejected a reordered chunk")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := make([]byte, chunked.KeySize)
+
+	// empty, shorter than a chunk, exactly one chunk, and several
+	for _, size := range []int{0, 1, 100, 1000, 1001, 12345} {
+		t.Logf("Running %d bytes", size)
+
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 7)
+		}
+
+		buf := new(bytes.Buffer)
+		w, err := chunked.NewWriter(buf, key, 1000, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write(plaintext); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		chunks := max(1, (size+999)/1000)
+		if buf.Len() != chunked.HeaderSize+size+chunks*chunked.Overhead {
+			t.Errorf("Bad length: %d chunks in %d bytes", chunks, buf.Len())
+		}
+
+		r, err := chunked.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted := make([]byte, r.Size())
+		if _, err := r.ReadAt(decrypted, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Bad plaintext for %d bytes", size)
+		}
+	}
+}
+
+// failWriter fails every write once fail is set.
+type failWriter struct {
+	fail bool
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errWrite
+	}
+	return len(p), nil
+}
+
+func TestWriteError(t *testing.T) {
+	dst := new(failWriter)
+	w, err := chunked.NewWriter(dst, make([]byte, chunked.KeySize), 16, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.fail = true
+
+	// the first chunk is buffered, and fails to be written when the second
+	// begins
+	n, err := w.Write(make([]byte, 40))
+	if err != errWrite || n != 16 {
+		t.Errorf("Bad write: expected %d, %v, was %d, %v", 16, errWrite, n, err)
+	}
+
+	if n, err := w.Write([]byte("more")); err != errWrite || n != 0 {
+		t.Errorf("Should have kept failing, was %d, %v", n, err)
+	}
+}
+
+func TestBadInputs(t *testing.T) {
+	if _, err := chunked.NewWriter(io.Discard, make([]byte, 16), 1024, rand.Reader); err != chunked.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+
+	for _, size := range []int{0, chunked.MaxChunkSize + 1} {
+		if _, err := chunked.NewWriter(io.Discard, make([]byte, chunked.KeySize), size, rand.Reader); err != chunked.ErrInvalidChunkSize {
+			t.Errorf("Should have rejected a chunk size of %d", size)
+		}
+	}
+}
+
+func Example() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	file := new(bytes.Buffer)
+	w, err := chunked.NewWriter(file, key, 16, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := w.Write([]byte("page one........page two........page three")); err != nil {
+		panic(err)
+	}
+
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	r, err := chunked.NewReader(bytes.NewReader(file.Bytes()), int64(file.Len()), key)
+	if err != nil {
+		panic(err)
+	}
+
+	// only the second chunk is read and decrypted
+	page := make([]byte, 8)
+	if _, err := r.ReadAt(page, 16); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", page)
+	// Output:
+	// page two
+}
@@ -0,0 +1,10 @@
+//go:build amd64 && !purego
+
+package chacha20
+
+// core4 is the ChaCha20 core transform for four blocks at once, with each
+// word of the state holding one lane per block. It is implemented with SSE2,
+// which every amd64 CPU has.
+//
+//go:noescape
+func core4(input, output *[stateSize][lanes]uint32, rounds uint8)
@@ -0,0 +1,86 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// The ChaCha20 core transform for four blocks at once, in SSE2. Each XMM
+// register holds the same word of the state for all four lanes, so the
+// quarter rounds are the scalar ones applied to four lanes at a time. The
+// state is kept in output, and the quarter rounds are done two at a time in
+// X0-X7, with X8 and X9 for rotations.
+
+// ROTL rotates each lane of v left by n bits, using t as a temporary.
+#define ROTL(n, v, t) \
+	MOVO v, t; \
+	PSLLL $n, v; \
+	PSRLL $(32-n), t; \
+	PXOR t, v
+
+// ROT16 rotates each lane of v by 16 bits by swapping its 16-bit halves.
+#define ROT16(v) \
+	PSHUFLW $0xb1, v, v; \
+	PSHUFHW $0xb1, v, v
+
+// QR2 does the quarter rounds (A, B, C, D) and (E, F, G, H) interleaved.
+#define QR2(A, B, C, D, E, F, G, H) \
+	PADDL B, A; PADDL F, E; \
+	PXOR A, D; PXOR E, H; \
+	ROT16(D); ROT16(H); \
+	PADDL D, C; PADDL H, G; \
+	PXOR C, B; PXOR G, F; \
+	ROTL(12, B, X8); ROTL(12, F, X9); \
+	PADDL B, A; PADDL F, E; \
+	PXOR A, D; PXOR E, H; \
+	ROTL(8, D, X8); ROTL(8, H, X9); \
+	PADDL D, C; PADDL H, G; \
+	PXOR C, B; PXOR G, F; \
+	ROTL(7, B, X8); ROTL(7, F, X9)
+
+// PAIR does the quarter rounds on two sets of four words of the state.
+#define PAIR(a, b, c, d, e, f, g, h) \
+	MOVOU (a*16)(DI), X0; MOVOU (b*16)(DI), X1; \
+	MOVOU (c*16)(DI), X2; MOVOU (d*16)(DI), X3; \
+	MOVOU (e*16)(DI), X4; MOVOU (f*16)(DI), X5; \
+	MOVOU (g*16)(DI), X6; MOVOU (h*16)(DI), X7; \
+	QR2(X0, X1, X2, X3, X4, X5, X6, X7); \
+	MOVOU X0, (a*16)(DI); MOVOU X1, (b*16)(DI); \
+	MOVOU X2, (c*16)(DI); MOVOU X3, (d*16)(DI); \
+	MOVOU X4, (e*16)(DI); MOVOU X5, (f*16)(DI); \
+	MOVOU X6, (g*16)(DI); MOVOU X7, (h*16)(DI)
+
+// COPY copies word i of the state from input to output.
+#define COPY(i) \
+	MOVOU (i*16)(SI), X0; MOVOU X0, (i*16)(DI)
+
+// ADD adds word i of the input to word i of the output.
+#define ADD(i) \
+	MOVOU (i*16)(DI), X0; MOVOU (i*16)(SI), X1; \
+	PADDL X1, X0; MOVOU X0, (i*16)(DI)
+
+// func core4(input, output *[stateSize][lanes]uint32, rounds uint8)
+TEXT ·core4(SB), NOSPLIT, $0-17
+	MOVQ    input+0(FP), SI
+	MOVQ    output+8(FP), DI
+	MOVBQZX rounds+16(FP), CX
+
+	COPY(0); COPY(1); COPY(2); COPY(3)
+	COPY(4); COPY(5); COPY(6); COPY(7)
+	COPY(8); COPY(9); COPY(10); COPY(11)
+	COPY(12); COPY(13); COPY(14); COPY(15)
+
+loop:
+	// column rounds
+	PAIR(0, 4, 8, 12, 1, 5, 9, 13)
+	PAIR(2, 6, 10, 14, 3, 7, 11, 15)
+
+	// diagonal rounds
+	PAIR(0, 5, 10, 15, 1, 6, 11, 12)
+	PAIR(2, 7, 8, 13, 3, 4, 9, 14)
+
+	SUBQ $2, CX
+	JA   loop
+
+	ADD(0); ADD(1); ADD(2); ADD(3)
+	ADD(4); ADD(5); ADD(6); ADD(7)
+	ADD(8); ADD(9); ADD(10); ADD(11)
+	ADD(12); ADD(13); ADD(14); ADD(15)
+	RET
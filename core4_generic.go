@@ -0,0 +1,21 @@
+//go:build !amd64 || purego
+
+package chacha20
+
+// core4 is the ChaCha20 core transform for four blocks at once, with each
+// word of the state holding one lane per block. Without SIMD, it transforms
+// each lane in turn.
+func core4(input, output *[stateSize][lanes]uint32, rounds uint8) {
+	var in, out [stateSize]uint32
+	for l := 0; l < lanes; l++ {
+		for i := range in {
+			in[i] = input[i][l]
+		}
+
+		core(&in, &out, rounds, false)
+
+		for i, v := range out {
+			output[i][l] = v
+		}
+	}
+}
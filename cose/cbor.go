@@ -0,0 +1,305 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// This is a minimal CBOR (RFC 8949) codec for COSE structures. It handles
+// integers, byte and text strings, arrays, maps, tags, booleans and null, all
+// of definite length. Integers are decoded as int64, arrays as
+// []interface{}, and maps as map[interface{}]interface{}. Maps are encoded
+// with their keys sorted as in the core deterministic encoding.
+
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+
+	maxDepth = 16
+)
+
+var errCBOR = errors.New("invalid CBOR")
+
+// A cborTag is a tagged data item.
+type cborTag struct {
+	number  uint64
+	content interface{}
+}
+
+func appendHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, m|27), n)
+	}
+}
+
+func cborMarshal(v interface{}) ([]byte, error) {
+	return appendCBOR(nil, v)
+}
+
+func appendCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case int:
+		return appendCBOR(b, int64(v))
+	case int64:
+		if v < 0 {
+			return appendHead(b, majorNegative, uint64(-1-v)), nil
+		}
+		return appendHead(b, majorUnsigned, uint64(v)), nil
+	case []byte:
+		return append(appendHead(b, majorBytes, uint64(len(v))), v...), nil
+	case string:
+		return append(appendHead(b, majorText, uint64(len(v))), v...), nil
+	case bool:
+		if v {
+			return append(b, majorSimple<<5|simpleTrue), nil
+		}
+		return append(b, majorSimple<<5|simpleFalse), nil
+	case nil:
+		return append(b, majorSimple<<5|simpleNull), nil
+	case []interface{}:
+		b = appendHead(b, majorArray, uint64(len(v)))
+		for _, e := range v {
+			var err error
+			if b, err = appendCBOR(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case Header:
+		return appendCBOR(b, map[interface{}]interface{}(v))
+	case map[interface{}]interface{}:
+		// Each entry is encoded separately so they can be sorted by their
+		// encoded keys.
+		type entry struct{ key, encoded []byte }
+		entries := make([]entry, 0, len(v))
+		for k, e := range v {
+			switch k.(type) {
+			case int, int64, string:
+			default:
+				return nil, errCBOR
+			}
+
+			key, err := appendCBOR(nil, k)
+			if err != nil {
+				return nil, err
+			}
+
+			encoded, err := appendCBOR(key[:len(key):len(key)], e)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{key, encoded})
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].encoded, entries[j].encoded) < 0
+		})
+
+		// keys which differ only in Go type, such as 1 and int64(1), would be
+		// encoded as duplicates
+		for i := 1; i < len(entries); i++ {
+			if bytes.Equal(entries[i-1].key, entries[i].key) {
+				return nil, errCBOR
+			}
+		}
+
+		b = appendHead(b, majorMap, uint64(len(v)))
+		for _, e := range entries {
+			b = append(b, e.encoded...)
+		}
+		return b, nil
+	case cborTag:
+		return appendCBOR(appendHead(b, majorTag, v.number), v.content)
+	default:
+		return nil, errCBOR
+	}
+}
+
+// cborUnmarshal decodes exactly one data item.
+func cborUnmarshal(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.off != len(data) {
+		return nil, errCBOR
+	}
+
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// head reads the initial byte and argument of a data item.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, errCBOR
+	}
+
+	ib := d.data[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		n = 1 << (info - 24)
+	default:
+		// indefinite lengths and reserved values
+		return 0, 0, errCBOR
+	}
+
+	if len(d.data)-d.off < n {
+		return 0, 0, errCBOR
+	}
+
+	var arg uint64
+	for _, c := range d.data[d.off : d.off+n] {
+		arg = arg<<8 | uint64(c)
+	}
+	d.off += n
+
+	return major, arg, nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, errCBOR
+	}
+
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errCBOR
+	}
+
+	start := d.off
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUnsigned:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return int64(arg), nil
+	case majorNegative:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(arg), nil
+	case majorBytes:
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case majorText:
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case majorArray:
+		// each element takes at least one byte
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errCBOR
+		}
+
+		a := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case majorMap:
+		if arg > uint64(len(d.data)-d.off)/2 {
+			return nil, errCBOR
+		}
+
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errCBOR
+			}
+
+			if _, ok := m[k]; ok {
+				return nil, errCBOR
+			}
+
+			if m[k], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case majorTag:
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTag{number: arg, content: v}, nil
+	default:
+		// simple values must be encoded in the initial byte
+		if d.off-start != 1 {
+			return nil, errCBOR
+		}
+
+		switch arg {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		default:
+			// floats and other simple values
+			return nil, errCBOR
+		}
+	}
+}
@@ -0,0 +1,516 @@
+// Package cose provides COSE (RFC 9052) encrypted messages using the
+// ChaCha20/Poly1305 content encryption algorithm from RFC 9053, with a
+// minimal built-in CBOR codec.
+//
+// COSE_Encrypt0 messages are encrypted with a key which is known to the
+// recipient from context. COSE_Encrypt messages carry a list of recipients;
+// only recipients using the "direct" key distribution algorithm are
+// supported, in which case the recipient holds the content encryption key
+// itself.
+//
+// The content is encrypted with IETF ChaCha20-Poly1305 with a 256-bit key and
+// a 96-bit IV from the IV header parameter. The additional authenticated data
+// is the Enc_structure: a CBOR array of the context string, the encoded
+// protected header, and the externally supplied additional data.
+//
+// For more information, see https://tools.ietf.org/html/rfc9052 and
+// https://tools.ietf.org/html/rfc9053#section-4.3
+package cose
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+// Header parameter labels.
+const (
+	HeaderAlgorithm   int64 = 1
+	HeaderCritical    int64 = 2
+	HeaderContentType int64 = 3
+	HeaderKeyID       int64 = 4
+	HeaderIV          int64 = 5
+	HeaderPartialIV   int64 = 6
+)
+
+// Algorithm identifiers.
+const (
+	// AlgorithmChaCha20Poly1305 is the ChaCha20/Poly1305 content encryption
+	// algorithm.
+	AlgorithmChaCha20Poly1305 int64 = 24
+	// AlgorithmDirect is the direct key distribution algorithm, in which the
+	// recipient already has the content encryption key.
+	AlgorithmDirect int64 = -6
+)
+
+const (
+	// KeySize is the length of ChaCha20/Poly1305 keys, in bytes.
+	KeySize = chacha20poly1305.KeySize
+	// IVSize is the length of ChaCha20/Poly1305 IVs, in bytes.
+	IVSize = chacha20poly1305.NonceSize
+
+	tagEncrypt0 = 16
+	tagEncrypt  = 96
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20poly1305.ErrInvalidKey
+	// ErrMalformed is returned when a message is not valid CBOR or does not
+	// have the structure of the expected COSE message.
+	ErrMalformed = errors.New("malformed COSE message")
+	// ErrUnsupportedAlgorithm is returned when a message does not use the
+	// ChaCha20/Poly1305 algorithm, or when none of its recipients use direct
+	// key distribution.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrUnsupportedHeader is returned when a message has critical header
+	// parameters or a Partial IV.
+	ErrUnsupportedHeader = errors.New("unsupported header parameter")
+	// ErrOpen is returned when a message cannot be authenticated.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// A Header is a map of COSE header parameters. Labels are int64 or string
+// values; integer values are decoded as int64.
+type Header map[interface{}]interface{}
+
+// An Encrypt0 is a COSE_Encrypt0 message, which is encrypted with a key the
+// recipient already has.
+type Encrypt0 struct {
+	Protected   Header
+	Unprotected Header
+	Ciphertext  []byte
+
+	protected []byte // the encoded protected header
+}
+
+// Encrypt encrypts plaintext and the optional external additional data with
+// key, which must be 256 bits long. The algorithm is set in the protected
+// header, and a random IV is set in the unprotected header unless either
+// header already has one.
+func (m *Encrypt0) Encrypt(key, plaintext, externalAAD []byte) error {
+	ct, protected, err := encrypt(&m.Protected, &m.Unprotected, "Encrypt0", key, plaintext, externalAAD)
+	if err != nil {
+		return err
+	}
+
+	m.Ciphertext, m.protected = ct, protected
+	return nil
+}
+
+// Decrypt authenticates and decrypts the message with key and the optional
+// external additional data.
+func (m *Encrypt0) Decrypt(key, externalAAD []byte) ([]byte, error) {
+	return decrypt(m.Protected, m.Unprotected, m.protected, "Encrypt0", key, m.Ciphertext, externalAAD)
+}
+
+// MarshalCBOR returns the message as a tagged COSE_Encrypt0 structure.
+func (m *Encrypt0) MarshalCBOR() ([]byte, error) {
+	protected, err := encodedOr(m.protected, m.Protected)
+	if err != nil {
+		return nil, err
+	}
+
+	return cborMarshal(cborTag{tagEncrypt0, []interface{}{
+		protected, orEmpty(m.Unprotected), m.Ciphertext,
+	}})
+}
+
+// UnmarshalCBOR parses a tagged or untagged COSE_Encrypt0 structure.
+func (m *Encrypt0) UnmarshalCBOR(data []byte) error {
+	a, err := unmarshalMessage(data, tagEncrypt0, 3)
+	if err != nil {
+		return err
+	}
+
+	var msg Encrypt0
+	if err := parseHeaders(a, &msg.protected, &msg.Protected, &msg.Unprotected); err != nil {
+		return err
+	}
+
+	if msg.Ciphertext, err = asBytes(a[2]); err != nil {
+		return err
+	}
+
+	*m = msg
+	return nil
+}
+
+// A Recipient is a COSE_recipient structure of a COSE_Encrypt message.
+type Recipient struct {
+	Protected   Header
+	Unprotected Header
+	Ciphertext  []byte
+
+	protected []byte // the encoded protected header
+}
+
+// NewDirectRecipient returns a recipient which uses direct key distribution,
+// identified by the optional key ID.
+func NewDirectRecipient(keyID []byte) *Recipient {
+	r := &Recipient{Unprotected: Header{HeaderAlgorithm: AlgorithmDirect}}
+	if keyID != nil {
+		r.Unprotected[HeaderKeyID] = keyID
+	}
+	return r
+}
+
+// An Encrypt is a COSE_Encrypt message, which has one or more recipients.
+type Encrypt struct {
+	Protected   Header
+	Unprotected Header
+	Ciphertext  []byte
+	Recipients  []*Recipient
+
+	protected []byte // the encoded protected header
+}
+
+// Encrypt encrypts plaintext and the optional external additional data with
+// the content encryption key, which must be 256 bits long, and must be shared
+// with the recipients. The headers are completed as for Encrypt0.
+func (m *Encrypt) Encrypt(key, plaintext, externalAAD []byte) error {
+	if len(m.Recipients) == 0 {
+		return errors.New("no recipients specified")
+	}
+
+	ct, protected, err := encrypt(&m.Protected, &m.Unprotected, "Encrypt", key, plaintext, externalAAD)
+	if err != nil {
+		return err
+	}
+
+	m.Ciphertext, m.protected = ct, protected
+	return nil
+}
+
+// Decrypt authenticates and decrypts the message with key and the optional
+// external additional data. The message must have a direct recipient, and if
+// keyID is not nil, that recipient must have the same key ID.
+func (m *Encrypt) Decrypt(keyID, key, externalAAD []byte) ([]byte, error) {
+	found := false
+	for _, r := range m.Recipients {
+		alg, err := algorithm(r.Protected, r.Unprotected)
+		if err != nil || alg != AlgorithmDirect || len(r.Ciphertext) != 0 {
+			continue
+		}
+
+		if keyID != nil {
+			kid, ok := r.Unprotected[HeaderKeyID].([]byte)
+			if !ok {
+				kid, ok = r.Protected[HeaderKeyID].([]byte)
+			}
+			if !ok || string(kid) != string(keyID) {
+				continue
+			}
+		}
+
+		found = true
+		break
+	}
+
+	if !found {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	return decrypt(m.Protected, m.Unprotected, m.protected, "Encrypt", key, m.Ciphertext, externalAAD)
+}
+
+// MarshalCBOR returns the message as a tagged COSE_Encrypt structure.
+func (m *Encrypt) MarshalCBOR() ([]byte, error) {
+	protected, err := encodedOr(m.protected, m.Protected)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make([]interface{}, len(m.Recipients))
+	for i, r := range m.Recipients {
+		p, err := encodedOr(r.protected, r.Protected)
+		if err != nil {
+			return nil, err
+		}
+
+		ct := r.Ciphertext
+		if ct == nil {
+			ct = []byte{}
+		}
+		recipients[i] = []interface{}{p, orEmpty(r.Unprotected), ct}
+	}
+
+	return cborMarshal(cborTag{tagEncrypt, []interface{}{
+		protected, orEmpty(m.Unprotected), m.Ciphertext, recipients,
+	}})
+}
+
+// UnmarshalCBOR parses a tagged or untagged COSE_Encrypt structure. Nested
+// recipients are not supported.
+func (m *Encrypt) UnmarshalCBOR(data []byte) error {
+	a, err := unmarshalMessage(data, tagEncrypt, 4)
+	if err != nil {
+		return err
+	}
+
+	var msg Encrypt
+	if err := parseHeaders(a, &msg.protected, &msg.Protected, &msg.Unprotected); err != nil {
+		return err
+	}
+
+	if msg.Ciphertext, err = asBytes(a[2]); err != nil {
+		return err
+	}
+
+	recipients, ok := a[3].([]interface{})
+	if !ok || len(recipients) == 0 {
+		return ErrMalformed
+	}
+
+	for _, v := range recipients {
+		ra, ok := v.([]interface{})
+		if !ok || len(ra) != 3 {
+			return ErrMalformed
+		}
+
+		r := new(Recipient)
+		if err := parseHeaders(ra, &r.protected, &r.Protected, &r.Unprotected); err != nil {
+			return err
+		}
+
+		if r.Ciphertext, err = asBytes(ra[2]); err != nil {
+			return err
+		}
+		msg.Recipients = append(msg.Recipients, r)
+	}
+
+	*m = msg
+	return nil
+}
+
+func encrypt(protected, unprotected *Header, context string, key, plaintext, externalAAD []byte) ([]byte, []byte, error) {
+	a, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *protected == nil {
+		*protected = Header{}
+	}
+
+	if *unprotected == nil {
+		*unprotected = Header{}
+	}
+
+	if _, err := algorithm(*protected, *unprotected); err != nil {
+		(*protected)[HeaderAlgorithm] = AlgorithmChaCha20Poly1305
+	}
+
+	_, inProtected := (*protected)[HeaderIV]
+	_, inUnprotected := (*unprotected)[HeaderIV]
+	if !inProtected && !inUnprotected {
+		iv := make([]byte, IVSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, err
+		}
+		(*unprotected)[HeaderIV] = iv
+	}
+
+	encoded, err := encodeProtected(*protected)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iv, err := checkHeaders(*protected, *unprotected)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aad, err := encStructure(context, encoded, externalAAD)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a.Seal(nil, iv, plaintext, aad), encoded, nil
+}
+
+func decrypt(protected, unprotected Header, encoded []byte, context string, key, ciphertext, externalAAD []byte) ([]byte, error) {
+	a, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if encoded, err = encodedOr(encoded, protected); err != nil {
+		return nil, err
+	}
+
+	iv, err := checkHeaders(protected, unprotected)
+	if err != nil {
+		return nil, err
+	}
+
+	aad, err := encStructure(context, encoded, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := a.Open(nil, iv, ciphertext, aad)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return plaintext, nil
+}
+
+// checkHeaders validates the content layer headers and returns the IV.
+func checkHeaders(protected, unprotected Header) ([]byte, error) {
+	for k := range protected {
+		if _, ok := unprotected[k]; ok {
+			return nil, ErrMalformed
+		}
+	}
+
+	alg, err := algorithm(protected, unprotected)
+	if err != nil {
+		return nil, err
+	}
+
+	if alg != AlgorithmChaCha20Poly1305 {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	for _, h := range []Header{protected, unprotected} {
+		if _, ok := h[HeaderCritical]; ok {
+			return nil, ErrUnsupportedHeader
+		}
+
+		if _, ok := h[HeaderPartialIV]; ok {
+			return nil, ErrUnsupportedHeader
+		}
+	}
+
+	iv, ok := protected[HeaderIV].([]byte)
+	if !ok {
+		iv, ok = unprotected[HeaderIV].([]byte)
+	}
+
+	if !ok || len(iv) != IVSize {
+		return nil, ErrMalformed
+	}
+
+	return iv, nil
+}
+
+// algorithm returns the algorithm parameter from either header.
+func algorithm(protected, unprotected Header) (int64, error) {
+	v, ok := protected[HeaderAlgorithm]
+	if !ok {
+		v, ok = unprotected[HeaderAlgorithm]
+	}
+
+	if !ok {
+		return 0, ErrUnsupportedAlgorithm
+	}
+
+	switch alg := v.(type) {
+	case int64:
+		return alg, nil
+	case int:
+		return int64(alg), nil
+	default:
+		return 0, ErrUnsupportedAlgorithm
+	}
+}
+
+// encStructure returns the encoded Enc_structure, which is the additional
+// authenticated data for the content.
+func encStructure(context string, protected, externalAAD []byte) ([]byte, error) {
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+	return cborMarshal([]interface{}{context, protected, externalAAD})
+}
+
+// encodeProtected encodes a protected header, which is an empty byte string
+// rather than an encoded empty map when there are no parameters.
+func encodeProtected(h Header) ([]byte, error) {
+	if len(h) == 0 {
+		return []byte{}, nil
+	}
+	return cborMarshal(h)
+}
+
+// encodedOr returns the encoded protected header as it was received, or
+// encodes h if there is none.
+func encodedOr(encoded []byte, h Header) ([]byte, error) {
+	if encoded != nil {
+		return encoded, nil
+	}
+	return encodeProtected(h)
+}
+
+func orEmpty(h Header) Header {
+	if h == nil {
+		return Header{}
+	}
+	return h
+}
+
+// unmarshalMessage decodes a COSE message, which may have the given tag, into
+// an array of n elements.
+func unmarshalMessage(data []byte, tag uint64, n int) ([]interface{}, error) {
+	v, err := cborUnmarshal(data)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if t, ok := v.(cborTag); ok {
+		if t.number != tag {
+			return nil, ErrMalformed
+		}
+		v = t.content
+	}
+
+	a, ok := v.([]interface{})
+	if !ok || len(a) != n {
+		return nil, ErrMalformed
+	}
+
+	return a, nil
+}
+
+// parseHeaders parses the protected and unprotected headers of a COSE
+// structure, keeping the encoded protected header for the Enc_structure.
+func parseHeaders(a []interface{}, encoded *[]byte, protected, unprotected *Header) error {
+	p, err := asBytes(a[0])
+	if err != nil {
+		return err
+	}
+
+	*protected = Header{}
+	if len(p) != 0 {
+		v, err := cborUnmarshal(p)
+		if err != nil {
+			return ErrMalformed
+		}
+
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return ErrMalformed
+		}
+		*protected = Header(m)
+	}
+	*encoded = p
+
+	m, ok := a[1].(map[interface{}]interface{})
+	if !ok {
+		return ErrMalformed
+	}
+	*unprotected = Header(m)
+
+	return nil
+}
+
+func asBytes(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	return b, nil
+}
@@ -0,0 +1,335 @@
+package cose_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/cose"
+)
+
+type testVector struct {
+	encrypt     bool // COSE_Encrypt rather than COSE_Encrypt0
+	externalAAD string
+	message     string
+}
+
+const (
+	testKey       = "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	testPlaintext = "This is the content."
+)
+
+// RFC 9052 has no ChaCha20/Poly1305 examples, so these follow the style of
+// its Appendix C: each ciphertext was sealed with libsodium's
+// crypto_aead_chacha20poly1305_ietf_encrypt, with the message's IV header as
+// the nonce and its Enc_structure as the additional data, and the messages
+// and Enc_structures were encoded with a separate CBOR encoder in Python.
+var testVectors = []testVector{
+	testVector{
+		false,
+		"",
+		"d08344a1011818a1054c26682306d4fb28ca01b43b805824d00faa1d330ad253d12c96ae" +
+			"c2b429080473f21dcaacb031bb28b76dde0f57c3d0f5037c",
+	},
+	testVector{
+		false,
+		"0011bbcc22dd44ee55ff660077",
+		"d08350a2011818036a746578742f706c61696ea2044a6f75722d736563726574054c5c3a" +
+			"9950bd2852f66e6c8d4f58245c674839a020fd7a6207a47bbb63cf009f22aee285261543" +
+			"cd4d90b7b49bd69887f532e6",
+	},
+	testVector{
+		true,
+		"",
+		"d8608444a1011818a1054c0e6dfe2c5a1b8e4f7d3c2b1a58244b7dc8b5edc87115c84290" +
+			"a01143d2ee86d64814dc1177edf0d36fdb676da7b8703535b5818340a20125044a6f7572" +
+			"2d73656372657440",
+	},
+}
+
+type message interface {
+	MarshalCBOR() ([]byte, error)
+	UnmarshalCBOR([]byte) error
+}
+
+func TestDecrypt(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		data, _ := hex.DecodeString(vector.message)
+		externalAAD, _ := hex.DecodeString(vector.externalAAD)
+
+		var m message
+		var decrypt func() ([]byte, error)
+		if vector.encrypt {
+			e := new(cose.Encrypt)
+			m, decrypt = e, func() ([]byte, error) {
+				return e.Decrypt([]byte("our-secret"), key, externalAAD)
+			}
+		} else {
+			e := new(cose.Encrypt0)
+			m, decrypt = e, func() ([]byte, error) {
+				return e.Decrypt(key, externalAAD)
+			}
+		}
+
+		if err := m.UnmarshalCBOR(data); err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext, err := decrypt()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plaintext) != testPlaintext {
+			t.Errorf("Bad plaintext: expected %q, was %q", testPlaintext, plaintext)
+		}
+
+		encoded, err := m.MarshalCBOR()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, encoded) {
+			t.Errorf("Bad encoding: expected %x, was %x", data, encoded)
+		}
+
+		// the last byte is part of the tag
+		data[len(data)-1] ^= 1
+		if vector.encrypt {
+			// unless there are recipients after the ciphertext
+			data[len(data)-1] ^= 1
+			data[len(data)-30] ^= 1
+		}
+
+		if err := m.UnmarshalCBOR(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := decrypt(); err != cose.ErrOpen {
+			t.Error("Should have rejected a modified message")
+		}
+	}
+}
+
+func TestEncrypt0RoundTrip(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	m := &cose.Encrypt0{
+		Unprotected: cose.Header{cose.HeaderKeyID: []byte("our-secret")},
+	}
+
+	if err := m.Encrypt(key, []byte(testPlaintext), []byte("external")); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Protected[cose.HeaderAlgorithm] != cose.AlgorithmChaCha20Poly1305 {
+		t.Errorf("Bad algorithm: %v", m.Protected[cose.HeaderAlgorithm])
+	}
+
+	data, err := m.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opened cose.Encrypt0
+	if err := opened.UnmarshalCBOR(data); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := opened.Decrypt(key, []byte("external"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != testPlaintext {
+		t.Errorf("Bad plaintext: expected %q, was %q", testPlaintext, plaintext)
+	}
+
+	if _, err := opened.Decrypt(key, nil); err != cose.ErrOpen {
+		t.Error("Should have rejected different external data")
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	m := &cose.Encrypt{
+		Recipients: []*cose.Recipient{cose.NewDirectRecipient([]byte("our-secret"))},
+	}
+
+	if err := m.Encrypt(key, []byte(testPlaintext), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := m.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opened cose.Encrypt
+	if err := opened.UnmarshalCBOR(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := opened.Decrypt([]byte("their-secret"), key, nil); err != cose.ErrUnsupportedAlgorithm {
+		t.Error("Should not have found a recipient with a different key ID")
+	}
+
+	plaintext, err := opened.Decrypt(nil, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != testPlaintext {
+		t.Errorf("Bad plaintext: expected %q, was %q", testPlaintext, plaintext)
+	}
+}
+
+// The Encrypt0 example from RFC 9052 Appendix C.4.1, which uses AES-CCM and
+// so can't be decrypted, but checks the codec against the RFC's own bytes.
+const rfcEncrypt0 = "d08343a1010aa1054d89f52f65a1c580933b5261a78c581c5974e1b99a3a4cc09a659a" +
+	"a2e9e7fff161d38ce71cb45ce460ffb569"
+
+func TestRFCEncrypt0(t *testing.T) {
+	data, _ := hex.DecodeString(rfcEncrypt0)
+
+	var m cose.Encrypt0
+	if err := m.UnmarshalCBOR(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if alg := m.Protected[cose.HeaderAlgorithm]; alg != int64(10) {
+		t.Errorf("Bad algorithm: expected 10, was %v", alg)
+	}
+
+	iv, _ := m.Unprotected[cose.HeaderIV].([]byte)
+	if hex.EncodeToString(iv) != "89f52f65a1c580933b5261a78c" {
+		t.Errorf("Bad IV: expected 89f52f65a1c580933b5261a78c, was %x", iv)
+	}
+
+	ciphertext := "5974e1b99a3a4cc09a659aa2e9e7fff161d38ce71cb45ce460ffb569"
+	if hex.EncodeToString(m.Ciphertext) != ciphertext {
+		t.Errorf("Bad ciphertext: expected %s, was %x", ciphertext, m.Ciphertext)
+	}
+
+	encoded, err := m.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, encoded) {
+		t.Errorf("Bad encoding: expected %x, was %x", data, encoded)
+	}
+
+	if _, err := m.Decrypt(make([]byte, cose.KeySize), nil); err != cose.ErrUnsupportedAlgorithm {
+		t.Errorf("Bad error: expected %v, was %v", cose.ErrUnsupportedAlgorithm, err)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	messages := []struct {
+		message string
+		err     error
+	}{
+		// A128GCM
+		{"d08343a10101a1054c26682306d4fb28ca01b43b8040", cose.ErrUnsupportedAlgorithm},
+		// no algorithm
+		{"d08340a1054c26682306d4fb28ca01b43b8040", cose.ErrUnsupportedAlgorithm},
+		// a critical parameter
+		{"d08347a2011818028101a1054c26682306d4fb28ca01b43b8040", cose.ErrUnsupportedHeader},
+		// a Partial IV
+		{"d08344a1011818a106410140", cose.ErrUnsupportedHeader},
+		// a short IV
+		{"d08344a1011818a1054b26682306d4fb28ca01b43b40", cose.ErrMalformed},
+		// the algorithm in both headers
+		{"d08344a1011818a2011818054c26682306d4fb28ca01b43b8040", cose.ErrMalformed},
+	}
+
+	for i, v := range messages {
+		t.Logf("Running message %d", i)
+
+		data, _ := hex.DecodeString(v.message)
+
+		var m cose.Encrypt0
+		err := m.UnmarshalCBOR(data)
+		if err == nil {
+			_, err = m.Decrypt(key, nil)
+		}
+
+		if err != v.err {
+			t.Errorf("Bad error: expected %v, was %v", v.err, err)
+		}
+	}
+}
+
+func TestMalformedCBOR(t *testing.T) {
+	messages := []string{
+		"",
+		// COSE_Encrypt tag
+		"d8608340a040",
+		// trailing data
+		"d08340a04000",
+		// indefinite-length array
+		"d09f40a040ff",
+		// duplicate map keys
+		"d08340a2040040044040",
+		// a float
+		"d08340a0f93c00",
+		// truncated byte string
+		"d08340a045010203",
+		// too many array elements
+		"d09b7fffffffffffffff",
+	}
+
+	for i, v := range messages {
+		t.Logf("Running message %d", i)
+
+		data, _ := hex.DecodeString(v)
+
+		var m cose.Encrypt0
+		if err := m.UnmarshalCBOR(data); err != cose.ErrMalformed {
+			t.Errorf("Should have rejected %s, was %v", v, err)
+		}
+	}
+}
+
+func ExampleEncrypt0() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	m := &cose.Encrypt0{
+		Unprotected: cose.Header{cose.HeaderKeyID: []byte("device-7")},
+	}
+
+	if err := m.Encrypt(key, []byte("hello I am a secret reading"), nil); err != nil {
+		panic(err)
+	}
+
+	data, err := m.MarshalCBOR()
+	if err != nil {
+		panic(err)
+	}
+
+	var received cose.Encrypt0
+	if err := received.UnmarshalCBOR(data); err != nil {
+		panic(err)
+	}
+
+	plaintext, err := received.Decrypt(key, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s: %s\n", received.Unprotected[cose.HeaderKeyID], plaintext)
+	// Output:
+	// device-7: hello I am a secret reading
+}
@@ -0,0 +1,22 @@
+package cose
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncStructure(t *testing.T) {
+	// the AAD of RFC 9052 Appendix C.4.1, with its protected header of
+	// {1: 10}
+	protected, _ := hex.DecodeString("a1010a")
+	expected := "8368456e63727970743043a1010a40"
+
+	aad, err := encStructure("Encrypt0", protected, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(aad) != expected {
+		t.Errorf("Bad Enc_structure: expected %s, was %x", expected, aad)
+	}
+}
@@ -0,0 +1,92 @@
+package dnscrypt
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"encoding/binary"
+	"time"
+)
+
+// CertMagic begins every certificate.
+const CertMagic = "DNSC"
+
+// a certificate's magic, es-version, protocol minor version and signature
+const certHeaderSize = 4 + 2 + 2 + ed25519.SignatureSize
+
+// Cert is a resolver certificate, which a client fetches from the provider's
+// TXT record before sending queries. It is signed with the provider's
+// long-term Ed25519 key, and binds the resolver's short-term X25519 key to
+// the client magic which selects it.
+type Cert struct {
+	// ResolverPublicKey is the resolver's short-term X25519 public key.
+	ResolverPublicKey *ecdh.PublicKey
+	// ClientMagic begins each query encrypted for this certificate.
+	ClientMagic [ClientMagicSize]byte
+	// Serial distinguishes certificates, with higher serials preferred.
+	Serial uint32
+	// NotBefore and NotAfter bound the certificate's validity, and are sent
+	// with a precision of one second.
+	NotBefore, NotAfter time.Time
+	// Extensions is empty in this version of the protocol.
+	Extensions []byte
+}
+
+// ParseCert parses a certificate, returning ErrInvalidSignature unless it
+// was signed with the given provider key. Only certificates for
+// XChaCha20-Poly1305 are supported. The validity period is not checked.
+func ParseCert(data []byte, providerKey ed25519.PublicKey) (*Cert, error) {
+	if len(data) < certHeaderSize+32+ClientMagicSize+12 ||
+		string(data[:4]) != CertMagic || binary.BigEndian.Uint16(data[6:]) != 0 {
+		return nil, ErrInvalidCert
+	}
+
+	if binary.BigEndian.Uint16(data[4:]) != ESVersionXChaCha20Poly1305 {
+		return nil, ErrUnsupportedVersion
+	}
+
+	signed := data[certHeaderSize:]
+	if !ed25519.Verify(providerKey, signed, data[8:certHeaderSize]) {
+		return nil, ErrInvalidSignature
+	}
+
+	pk, err := ecdh.X25519().NewPublicKey(signed[:32])
+	if err != nil {
+		return nil, ErrInvalidCert
+	}
+
+	c := &Cert{
+		ResolverPublicKey: pk,
+		Serial:            binary.BigEndian.Uint32(signed[40:]),
+		NotBefore:         time.Unix(int64(binary.BigEndian.Uint32(signed[44:])), 0),
+		NotAfter:          time.Unix(int64(binary.BigEndian.Uint32(signed[48:])), 0),
+		Extensions:        append([]byte{}, signed[52:]...),
+	}
+	copy(c.ClientMagic[:], signed[32:])
+
+	return c, nil
+}
+
+// Sign returns the certificate signed with the given provider key.
+func (c *Cert) Sign(providerKey ed25519.PrivateKey) []byte {
+	signed := append([]byte{}, c.ResolverPublicKey.Bytes()...)
+	signed = append(signed, c.ClientMagic[:]...)
+	signed = binary.BigEndian.AppendUint32(signed, c.Serial)
+	signed = binary.BigEndian.AppendUint32(signed, uint32(c.NotBefore.Unix()))
+	signed = binary.BigEndian.AppendUint32(signed, uint32(c.NotAfter.Unix()))
+	signed = append(signed, c.Extensions...)
+
+	cert := append([]byte(CertMagic), 0, ESVersionXChaCha20Poly1305, 0, 0)
+	cert = append(cert, ed25519.Sign(providerKey, signed)...)
+	return append(cert, signed...)
+}
+
+// Valid returns true if the certificate is valid at the given time.
+func (c *Cert) Valid(now time.Time) bool {
+	return !now.Before(c.NotBefore) && !now.After(c.NotAfter)
+}
+
+// magicEqual returns true if b begins with the client magic.
+func (c *Cert) magicEqual(b []byte) bool {
+	return bytes.HasPrefix(b, c.ClientMagic[:])
+}
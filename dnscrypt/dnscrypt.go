@@ -0,0 +1,246 @@
+// Package dnscrypt implements the encryption of DNSCrypt v2 queries and
+// responses with XChaCha20-Poly1305 (es-version 2).
+//
+// A client fetches a certificate, signed by the provider, which holds the
+// resolver's short-term X25519 public key. The client and resolver hash the
+// X25519 shared secret with HChaCha20 into a key for libsodium's
+// crypto_box_curve25519xchacha20poly1305. Each query is padded with ISO/IEC
+// 7816-4 padding to a multiple of 64 bytes, and sent with the certificate's
+// client magic, the client's public key and a 12-byte client nonce, which is
+// followed by 12 zero bytes in the box's nonce. The response repeats the
+// client nonce with a 12-byte resolver nonce, and the box's nonce is the two
+// halves together.
+//
+// Fetching certificates and the TCP length prefix are left to the caller.
+//
+// For more information, see https://dnscrypt.info/protocol
+package dnscrypt
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"io"
+
+	"github.com/codahale/chacha20/box"
+	"github.com/codahale/chacha20/secretbox"
+)
+
+const (
+	// ESVersionXChaCha20Poly1305 is the version of certificates for
+	// XChaCha20-Poly1305.
+	ESVersionXChaCha20Poly1305 = 2
+	// ResolverMagic begins every response.
+	ResolverMagic = "r6fnvWj8"
+	// ClientMagicSize is the length of the client magic, in bytes.
+	ClientMagicSize = 8
+	// HalfNonceSize is the length of the client and resolver nonces, in
+	// bytes.
+	HalfNonceSize = box.NonceSize / 2
+	// MinUDPQuerySize is the shortest padded query which may be sent over
+	// UDP, in bytes.
+	MinUDPQuerySize = 256
+	// PaddingMultiple is the multiple to which queries and responses are
+	// padded, in bytes.
+	PaddingMultiple = 64
+
+	publicKeySize = 32
+)
+
+var (
+	// ErrInvalidCert is returned when a certificate is malformed.
+	ErrInvalidCert = errors.New("invalid certificate")
+	// ErrUnsupportedVersion is returned when a certificate is not for
+	// XChaCha20-Poly1305.
+	ErrUnsupportedVersion = errors.New("unsupported es-version")
+	// ErrInvalidSignature is returned when a certificate was not signed with
+	// the provider's key.
+	ErrInvalidSignature = errors.New("invalid certificate signature")
+	// ErrInvalidPacket is returned when a query or response is too short, or
+	// does not begin with the expected magic or nonce.
+	ErrInvalidPacket = errors.New("invalid packet")
+	// ErrInvalidPadding is returned when a decrypted query or response is not
+	// correctly padded.
+	ErrInvalidPadding = errors.New("invalid padding")
+	// ErrTooLarge is returned when a response is larger than the maximum
+	// size.
+	ErrTooLarge = errors.New("response too large")
+	// ErrOpen is returned when a query or response cannot be authenticated,
+	// either because it was not encrypted for the given keys or because it
+	// was modified.
+	ErrOpen = box.ErrOpen
+)
+
+// Client encrypts queries for a resolver and decrypts its responses.
+type Client struct {
+	cert      *Cert
+	publicKey []byte
+	sharedKey []byte
+}
+
+// NewClient returns a Client which encrypts queries for the resolver with the
+// given certificate, using the client's X25519 private key. Clients may use
+// a new key pair for each query for better privacy.
+func NewClient(cert *Cert, privateKey *ecdh.PrivateKey) (*Client, error) {
+	key, err := box.PrecomputeXChaCha(cert.ResolverPublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		cert:      cert,
+		publicKey: privateKey.PublicKey().Bytes(),
+		sharedKey: key,
+	}, nil
+}
+
+// EncryptQuery pads and encrypts a DNS query, using 12 bytes read from rand as
+// the client nonce. The padded query is at least minSize bytes, which is
+// MinUDPQuerySize over UDP. It returns the packet and the client nonce, which
+// is needed to decrypt the response.
+func (c *Client) EncryptQuery(rand io.Reader, query []byte, minSize int) ([]byte, []byte, error) {
+	nonce := make([]byte, box.NonceSize)
+	if _, err := io.ReadFull(rand, nonce[:HalfNonceSize]); err != nil {
+		return nil, nil, err
+	}
+
+	packet := append(append([]byte{}, c.cert.ClientMagic[:]...), c.publicKey...)
+	packet = append(packet, nonce[:HalfNonceSize]...)
+
+	packet, err := secretbox.SealXChaCha(packet, pad(query, minSize), nonce, c.sharedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return packet, nonce[:HalfNonceSize], nil
+}
+
+// DecryptResponse authenticates and decrypts a response to the query with
+// the given client nonce, and returns the DNS response.
+func (c *Client) DecryptResponse(packet, clientNonce []byte) ([]byte, error) {
+	if len(packet) < len(ResolverMagic)+box.NonceSize+box.Overhead ||
+		string(packet[:len(ResolverMagic)]) != ResolverMagic ||
+		string(packet[len(ResolverMagic):len(ResolverMagic)+HalfNonceSize]) != string(clientNonce) {
+		return nil, ErrInvalidPacket
+	}
+
+	nonce := packet[len(ResolverMagic) : len(ResolverMagic)+box.NonceSize]
+	padded, err := secretbox.OpenXChaCha(nil, packet[len(ResolverMagic)+box.NonceSize:], nonce, c.sharedKey)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return unpad(padded)
+}
+
+// Server decrypts queries for one certificate and encrypts their responses.
+type Server struct {
+	cert       *Cert
+	privateKey *ecdh.PrivateKey
+}
+
+// NewServer returns a Server for the given certificate and the resolver's
+// X25519 private key.
+func NewServer(cert *Cert, privateKey *ecdh.PrivateKey) (*Server, error) {
+	if !privateKey.PublicKey().Equal(cert.ResolverPublicKey) {
+		return nil, box.ErrInvalidKey
+	}
+
+	return &Server{cert: cert, privateKey: privateKey}, nil
+}
+
+// Query is a decrypted query, with what is needed to encrypt its response.
+type Query struct {
+	// Message is the DNS query.
+	Message []byte
+	// Size is the length of the encrypted query, in bytes. Responses over
+	// UDP must not be longer.
+	Size int
+
+	clientNonce []byte
+	sharedKey   []byte
+}
+
+// DecryptQuery authenticates and decrypts a query. Servers should use
+// ClientMagic to find the certificate, and so the Server, for a query.
+func (s *Server) DecryptQuery(packet []byte) (*Query, error) {
+	header := ClientMagicSize + publicKeySize + HalfNonceSize
+	if len(packet) < header+box.Overhead || !s.cert.magicEqual(packet) {
+		return nil, ErrInvalidPacket
+	}
+
+	pk, err := ecdh.X25519().NewPublicKey(packet[ClientMagicSize : ClientMagicSize+publicKeySize])
+	if err != nil {
+		return nil, ErrInvalidPacket
+	}
+
+	key, err := box.PrecomputeXChaCha(pk, s.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, box.NonceSize)
+	copy(nonce, packet[ClientMagicSize+publicKeySize:header])
+
+	padded, err := secretbox.OpenXChaCha(nil, packet[header:], nonce, key)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	message, err := unpad(padded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{
+		Message:     message,
+		Size:        len(packet),
+		clientNonce: nonce[:HalfNonceSize],
+		sharedKey:   key,
+	}, nil
+}
+
+// EncryptResponse pads and encrypts a DNS response to a query, using 12 bytes
+// read from rand as the resolver nonce. If maxSize is positive and the
+// encrypted response would be longer, it returns ErrTooLarge, and a
+// truncated response should be sent instead.
+func (s *Server) EncryptResponse(rand io.Reader, q *Query, response []byte, maxSize int) ([]byte, error) {
+	nonce := make([]byte, box.NonceSize)
+	copy(nonce, q.clientNonce)
+	if _, err := io.ReadFull(rand, nonce[HalfNonceSize:]); err != nil {
+		return nil, err
+	}
+
+	padded := pad(response, 0)
+	if maxSize > 0 && len(ResolverMagic)+box.NonceSize+len(padded)+box.Overhead > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	packet := append(append([]byte{}, ResolverMagic...), nonce...)
+	return secretbox.SealXChaCha(packet, padded, nonce, q.sharedKey)
+}
+
+// pad appends 0x80 and then zeros to a message, up to a multiple of
+// PaddingMultiple which is at least minSize.
+func pad(message []byte, minSize int) []byte {
+	n := max((len(message)+1+PaddingMultiple-1)/PaddingMultiple*PaddingMultiple, minSize)
+	n = (n + PaddingMultiple - 1) / PaddingMultiple * PaddingMultiple
+
+	padded := make([]byte, n)
+	copy(padded, message)
+	padded[len(message)] = 0x80
+	return padded
+}
+
+// unpad removes the padding from a message.
+func unpad(padded []byte) ([]byte, error) {
+	for i := len(padded) - 1; i >= 0; i-- {
+		switch padded[i] {
+		case 0:
+		case 0x80:
+			return padded[:i], nil
+		default:
+			return nil, ErrInvalidPadding
+		}
+	}
+	return nil, ErrInvalidPadding
+}
@@ -0,0 +1,376 @@
+package dnscrypt_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codahale/chacha20/dnscrypt"
+)
+
+// produced with libsodium. The certificate, with serial 1 and the resolver's
+// X25519 private key 404142...5f, was signed with crypto_sign_detached by the
+// provider key from crypto_sign_seed_keypair with the seed 000102...1f. The
+// query and response were padded to 256 and 64 bytes and sealed with
+// crypto_box_curve25519xchacha20poly1305_easy_afternm, under the key from
+// crypto_box_curve25519xchacha20poly1305_beforenm with the client's private key
+// 606162...7f, using the client nonce followed by 12 zero bytes as the query's
+// nonce and the client and resolver nonces as the response's.
+const (
+	testProviderKey = "03a107bff3ce10be1d70dd18e74bc09967e4d6309ba50d5f1ddc8664125531b8"
+	testCert        = "444e534300020000ce33b53545d34f23b34b402b85600a985a5231a16a73ac927f8c8521" +
+		"fe74e1fc2e39c4ae49ddd8fe6c801bb6c169c51a961fcca5d279fc338f33a2f5d3c5ad01" +
+		"79a631eede1bf9c98f12032cdeadd0e7a079398fc786b88cc846ec89af85a51a79a631ee" +
+		"de1bf9c9000000016553f1006b49d200"
+	testQuery = "79a631eede1bf9c9675dd574ed7789310b3d2e7681f3790b466c773b1521fecf36577958" +
+		"371ea52f000102030405060708090a0ba9c9a2977c0ffa6d10e30c0f82e0c0c4e982a4a8" +
+		"743a98554c35fe3e1ebf5394bc46464f87dfeb6b4831ce4f3b194c62550660a9de86cf20" +
+		"378921c712da1c59f468dfc650de8767b5077b6b1ab06882f51d90ad2c216712ca1ab398" +
+		"2636b93bf79b9700b4564e4f9d1ca6d0b728d766cd13ce4e914101680dfe4fd57042af46" +
+		"8a5be1b8ddad61d511c221d146838db9db81e82bec2e2c5932aee883e6ea8e280a9717f7" +
+		"bdbf7334e5c8eed49387d43fbb50eea05bc37565279ab5365632233777db761804ff61b7" +
+		"33af3043a2d26906a58df585c2f0c67ca537bcdfde96f406fa7a36145c55ef2e7ccec807" +
+		"c948016f1e32593ece33a1b0602e1af778033b9793c8c84856f1c71328fe98fd2443ec7e"
+	testResponse = "7236666e76576a38000102030405060708090a0b0c0d0e0f1011121314151617aec686c2" +
+		"39bc23101aa1bc809c7f4e0153c46f1e8a5803e8eafd93b805c39d864a5bce2fc8a4e7de" +
+		"541463cb6058db96a2f3592193280379bfe96cdd1573b254e46f293dc80d2843ed96395a" +
+		"5505b9fc"
+
+	testClientNonce   = "000102030405060708090a0b"
+	testResolverNonce = "0c0d0e0f1011121314151617"
+	testDNSQuery      = "123401000001000000000000076578616d706c6503636f6d0000010001"
+	testDNSResponse   = "123481800001000100000000076578616d706c6503636f6d0000010001c00c000100" +
+		"01000e10000417d7008a"
+)
+
+func testKey(start byte) *ecdh.PrivateKey {
+	b := make([]byte, 32)
+	for i := range b {
+		b[i] = start + byte(i)
+	}
+
+	k, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func parseTestCert(t *testing.T) *dnscrypt.Cert {
+	providerKey, _ := hex.DecodeString(testProviderKey)
+	data, _ := hex.DecodeString(testCert)
+
+	cert, err := dnscrypt.ParseCert(data, providerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestParseCert(t *testing.T) {
+	cert := parseTestCert(t)
+
+	if !cert.ResolverPublicKey.Equal(testKey(0x40).PublicKey()) {
+		t.Errorf("Bad resolver key: %x", cert.ResolverPublicKey.Bytes())
+	}
+
+	if cert.Serial != 1 || cert.NotBefore.Unix() != 1700000000 || cert.NotAfter.Unix() != 1800000000 {
+		t.Errorf("Bad certificate: %+v", cert)
+	}
+
+	if !cert.Valid(time.Unix(1750000000, 0)) || cert.Valid(time.Unix(1800000001, 0)) {
+		t.Error("Bad validity period")
+	}
+
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+
+	signed := hex.EncodeToString(cert.Sign(ed25519.NewKeyFromSeed(seed)))
+	if signed != testCert {
+		t.Errorf("Bad certificate: expected %s, was %s", testCert, signed)
+	}
+}
+
+func TestBadCerts(t *testing.T) {
+	providerKey, _ := hex.DecodeString(testProviderKey)
+
+	certs := []struct {
+		offset int
+		err    error
+	}{
+		{0, dnscrypt.ErrInvalidCert},
+		{5, dnscrypt.ErrUnsupportedVersion},
+		{7, dnscrypt.ErrInvalidCert},
+		{8, dnscrypt.ErrInvalidSignature},
+		{123, dnscrypt.ErrInvalidSignature},
+	}
+
+	for i, v := range certs {
+		t.Logf("Running certificate %d", i)
+
+		data, _ := hex.DecodeString(testCert)
+		data[v.offset] ^= 1
+
+		if _, err := dnscrypt.ParseCert(data, providerKey); err != v.err {
+			t.Errorf("Bad error: expected %v, was %v", v.err, err)
+		}
+	}
+
+	data, _ := hex.DecodeString(testCert)
+	if _, err := dnscrypt.ParseCert(data[:100], providerKey); err != dnscrypt.ErrInvalidCert {
+		t.Error("Should have rejected a short certificate")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	cert := parseTestCert(t)
+	nonce, _ := hex.DecodeString(testClientNonce)
+	query, _ := hex.DecodeString(testDNSQuery)
+
+	client, err := dnscrypt.NewClient(cert, testKey(0x60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, clientNonce, err := client.EncryptQuery(bytes.NewReader(nonce), query, dnscrypt.MinUDPQuerySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(packet) != testQuery {
+		t.Errorf("Bad query: expected %s, was %x", testQuery, packet)
+	}
+
+	if !bytes.Equal(clientNonce, nonce) {
+		t.Errorf("Bad client nonce: expected %x, was %x", nonce, clientNonce)
+	}
+
+	server, err := dnscrypt.NewServer(cert, testKey(0x40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := server.DecryptQuery(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(q.Message, query) {
+		t.Errorf("Bad query: expected %x, was %x", query, q.Message)
+	}
+
+	packet[len(packet)-1] ^= 1
+	if _, err := server.DecryptQuery(packet); err != dnscrypt.ErrOpen {
+		t.Error("Should have rejected a modified query")
+	}
+
+	packet[0] ^= 1
+	if _, err := server.DecryptQuery(packet); err != dnscrypt.ErrInvalidPacket {
+		t.Error("Should have rejected a different client magic")
+	}
+}
+
+func TestResponse(t *testing.T) {
+	cert := parseTestCert(t)
+	clientNonce, _ := hex.DecodeString(testClientNonce)
+	resolverNonce, _ := hex.DecodeString(testResolverNonce)
+	query, _ := hex.DecodeString(testQuery)
+	response, _ := hex.DecodeString(testDNSResponse)
+
+	server, err := dnscrypt.NewServer(cert, testKey(0x40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := server.DecryptQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := server.EncryptResponse(bytes.NewReader(resolverNonce), q, response, q.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(packet) != testResponse {
+		t.Errorf("Bad response: expected %s, was %x", testResponse, packet)
+	}
+
+	if _, err := server.EncryptResponse(rand.Reader, q, make([]byte, 512), q.Size); err != dnscrypt.ErrTooLarge {
+		t.Error("Should have rejected a response longer than the query")
+	}
+
+	client, err := dnscrypt.NewClient(cert, testKey(0x60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := client.DecryptResponse(packet, clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, response) {
+		t.Errorf("Bad response: expected %x, was %x", response, decrypted)
+	}
+
+	if _, err := client.DecryptResponse(packet, resolverNonce); err != dnscrypt.ErrInvalidPacket {
+		t.Error("Should have rejected a response to another query")
+	}
+
+	packet[len(packet)-1] ^= 1
+	if _, err := client.DecryptResponse(packet, clientNonce); err != dnscrypt.ErrOpen {
+		t.Error("Should have rejected a modified response")
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	cert := parseTestCert(t)
+
+	server, err := dnscrypt.NewServer(cert, testKey(0x40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a resolver which answers every query with the query itself
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			q, err := server.DecryptQuery(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			packet, err := server.EncryptResponse(rand.Reader, q, q.Message, q.Size)
+			if err != nil {
+				continue
+			}
+
+			conn.WriteTo(packet, addr)
+		}
+	}()
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := dnscrypt.NewClient(cert, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	query, _ := hex.DecodeString(testDNSQuery)
+	packet, nonce, err := client.EncryptQuery(rand.Reader, query, dnscrypt.MinUDPQuerySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.DecryptResponse(buf[:n], nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(response, query) {
+		t.Errorf("Bad response: expected %x, was %x", query, response)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	if _, err := dnscrypt.NewServer(parseTestCert(t), testKey(0x60)); err == nil {
+		t.Error("Should have rejected a key which is not the certificate's")
+	}
+}
+
+func Example() {
+	providerPub, provider, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	resolverKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	// the resolver publishes a certificate
+	cert := &dnscrypt.Cert{
+		ResolverPublicKey: resolverKey.PublicKey(),
+		Serial:            1,
+		NotBefore:         time.Now(),
+		NotAfter:          time.Now().Add(24 * time.Hour),
+	}
+	copy(cert.ClientMagic[:], resolverKey.PublicKey().Bytes())
+	data := cert.Sign(provider)
+
+	// which the client checks with the provider's key
+	cert, err = dnscrypt.ParseCert(data, providerPub)
+	if err != nil {
+		panic(err)
+	}
+
+	clientKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := dnscrypt.NewClient(cert, clientKey)
+	if err != nil {
+		panic(err)
+	}
+
+	packet, _, err := client.EncryptQuery(rand.Reader, []byte("hello I am a DNS query"), dnscrypt.MinUDPQuerySize)
+	if err != nil {
+		panic(err)
+	}
+
+	server, err := dnscrypt.NewServer(cert, resolverKey)
+	if err != nil {
+		panic(err)
+	}
+
+	q, err := server.DecryptQuery(packet)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%d bytes: %s\n", len(packet), q.Message)
+	// Output:
+	// 324 bytes: hello I am a DNS query
+}
@@ -0,0 +1,209 @@
+// Package envelope implements a self-describing format for sealed blobs, so
+// that ciphertexts record how they were sealed and can be opened after keys
+// and algorithms change.
+//
+// An envelope is the 3-byte magic "CPE", a version byte, an algorithm ID, the
+// big-endian 32-bit ID of the key, and a random nonce of the length the
+// algorithm requires, followed by the ciphertext and its tag. The header is
+// authenticated along with the caller's additional data, so an envelope
+// cannot be opened with a different algorithm, key or version than it was
+// sealed with.
+//
+// New algorithms can be added with new IDs without changing the version, as
+// an algorithm's ID determines the length of its nonce.
+//
+// Envelopes are sealed and opened with a Keyring, which seals with its primary
+// key and opens with any key it holds, so keys can be rotated without losing
+// access to old envelopes.
+package envelope
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// Magic begins every envelope.
+	Magic = "CPE"
+	// Version is the version of the format.
+	Version = 1
+	// KeySize is the length of keys for all current algorithms, in bytes.
+	KeySize = chacha20poly1305.KeySize
+
+	// the magic, version, algorithm and key ID
+	prefixSize = len(Magic) + 1 + 1 + 4
+)
+
+// Algorithm identifies an AEAD algorithm.
+type Algorithm byte
+
+const (
+	// ChaCha20Poly1305 is ChaCha20-Poly1305 from RFC 8439, with a random
+	// 96-bit nonce. A key should seal no more than 2^32 envelopes.
+	ChaCha20Poly1305 Algorithm = 1
+	// XChaCha20Poly1305 is XChaCha20-Poly1305, with a random 192-bit nonce,
+	// which can seal practically any number of envelopes with one key.
+	XChaCha20Poly1305 Algorithm = 2
+)
+
+type algorithm struct {
+	name      string
+	nonceSize int
+	new       func(key []byte) (cipher.AEAD, error)
+}
+
+var algorithms = map[Algorithm]algorithm{
+	ChaCha20Poly1305:  {"ChaCha20-Poly1305", chacha20poly1305.NonceSize, chacha20poly1305.New},
+	XChaCha20Poly1305: {"XChaCha20-Poly1305", chacha20poly1305.NonceSizeX, chacha20poly1305.NewX},
+}
+
+func (a Algorithm) String() string {
+	if alg, ok := algorithms[a]; ok {
+		return alg.name
+	}
+	return fmt.Sprintf("Algorithm(%d)", byte(a))
+}
+
+var (
+	// ErrInvalidKey is returned when a key has an unknown algorithm or is not
+	// the right length for its algorithm.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrInvalidEnvelope is returned when an envelope does not begin with
+	// Magic or is too short.
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+	// ErrUnsupportedVersion is returned when an envelope has a version other
+	// than Version.
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+	// ErrUnsupportedAlgorithm is returned when an envelope's algorithm is
+	// unknown.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrUnknownKey is returned when an envelope was sealed with a key which
+	// is not in the keyring.
+	ErrUnknownKey = errors.New("unknown key ID")
+	// ErrAlgorithmMismatch is returned when an envelope's algorithm is not
+	// the algorithm of the key it names.
+	ErrAlgorithmMismatch = errors.New("algorithm does not match key")
+	// ErrOpen is returned when an envelope cannot be authenticated, either
+	// because it was not sealed with the given key and additional data or
+	// because it was modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// Key is a secret key for one algorithm, identified by an ID which is
+// recorded in the envelopes it seals.
+type Key struct {
+	ID        uint32
+	Algorithm Algorithm
+	Secret    []byte
+}
+
+func (k *Key) aead() (cipher.AEAD, error) {
+	alg, ok := algorithms[k.Algorithm]
+	if !ok || len(k.Secret) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return alg.new(k.Secret)
+}
+
+// Header is the unencrypted description at the start of an envelope.
+type Header struct {
+	Version   byte
+	Algorithm Algorithm
+	KeyID     uint32
+	Nonce     []byte
+}
+
+// ParseHeader returns the header of an envelope without opening it, such as
+// to find which key sealed it.
+func ParseHeader(envelope []byte) (*Header, error) {
+	if len(envelope) < prefixSize || string(envelope[:len(Magic)]) != Magic {
+		return nil, ErrInvalidEnvelope
+	}
+
+	h := &Header{
+		Version:   envelope[len(Magic)],
+		Algorithm: Algorithm(envelope[len(Magic)+1]),
+		KeyID:     binary.BigEndian.Uint32(envelope[len(Magic)+2:]),
+	}
+
+	if h.Version != Version {
+		return nil, ErrUnsupportedVersion
+	}
+
+	alg, ok := algorithms[h.Algorithm]
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	if len(envelope) < prefixSize+alg.nonceSize+chacha20poly1305.Overhead {
+		return nil, ErrInvalidEnvelope
+	}
+	h.Nonce = envelope[prefixSize : prefixSize+alg.nonceSize]
+
+	return h, nil
+}
+
+// Seal encrypts and authenticates plaintext and additional data with the
+// keyring's primary key and a random nonce, and returns the envelope.
+func Seal(keyring *Keyring, plaintext, additionalData []byte) ([]byte, error) {
+	k := keyring.Primary()
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, prefixSize+aead.NonceSize(), prefixSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(header, Magic)
+	header[len(Magic)] = Version
+	header[len(Magic)+1] = byte(k.Algorithm)
+	binary.BigEndian.PutUint32(header[len(Magic)+2:], k.ID)
+
+	nonce := header[prefixSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(header, nonce, plaintext, authenticated(header, additionalData)), nil
+}
+
+// Open authenticates and decrypts an envelope sealed with any key in the
+// keyring, and returns the plaintext.
+func Open(keyring *Keyring, envelope, additionalData []byte) ([]byte, error) {
+	h, err := ParseHeader(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	k := keyring.Key(h.KeyID)
+	if k == nil {
+		return nil, ErrUnknownKey
+	}
+
+	if k.Algorithm != h.Algorithm {
+		return nil, ErrAlgorithmMismatch
+	}
+
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	header := envelope[:prefixSize+len(h.Nonce)]
+	plaintext, err := aead.Open(nil, h.Nonce, envelope[len(header):], authenticated(header, additionalData))
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
+
+// authenticated returns the header followed by the additional data. The
+// header's length is fixed by its algorithm, so the two cannot be confused.
+func authenticated(header, additionalData []byte) []byte {
+	return append(append(make([]byte, 0, len(header)+len(additionalData)), header...), additionalData...)
+}
@@ -0,0 +1,192 @@
+package envelope_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/envelope"
+)
+
+type testVector struct {
+	algorithm envelope.Algorithm
+	envelope  string
+}
+
+// each the header for key ID 7 and the nonce 404142..., followed by
+// "hello, world" sealed with libsodium's crypto_aead_chacha20poly1305_ietf_encrypt
+// or crypto_aead_xchacha20poly1305_ietf_encrypt under the key 000102...1f, with
+// that nonce and the header followed by "meta" as the additional data
+var testVectors = []testVector{
+	{
+		envelope.ChaCha20Poly1305,
+		"435045010100000007404142434445464748494a4b903110ed1d66ce7637bd9a64d665" +
+			"3a364f2d74c22680dbfe56ce7e48",
+	},
+	{
+		envelope.XChaCha20Poly1305,
+		"435045010200000007404142434445464748494a4b4c4d4e4f50515253545556" +
+			"57bc5c691cbfcc5961e086ebdab209304e00fabc5847b9662f984d3bbc",
+	},
+}
+
+func testKey(id uint32, alg envelope.Algorithm) *envelope.Key {
+	secret := make([]byte, envelope.KeySize)
+	for i := range secret {
+		secret[i] = byte(i)
+	}
+	return &envelope.Key{ID: id, Algorithm: alg, Secret: secret}
+}
+
+func TestOpen(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		kr, err := envelope.NewKeyring(testKey(7, vector.algorithm))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env, _ := hex.DecodeString(vector.envelope)
+		plaintext, err := envelope.Open(kr, env, []byte("meta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plaintext) != "hello, world" {
+			t.Errorf("Bad plaintext: expected %q, was %q", "hello, world", plaintext)
+		}
+
+		for j := range env {
+			bad := append([]byte(nil), env...)
+			bad[j] ^= 1
+			if _, err := envelope.Open(kr, bad, []byte("meta")); err == nil {
+				t.Errorf("Should have rejected an envelope modified at byte %d", j)
+			}
+		}
+
+		if _, err := envelope.Open(kr, env, []byte("data")); err != envelope.ErrOpen {
+			t.Error("Should have rejected the wrong additional data")
+		}
+	}
+}
+
+func TestSeal(t *testing.T) {
+	for _, alg := range []envelope.Algorithm{envelope.ChaCha20Poly1305, envelope.XChaCha20Poly1305} {
+		kr, err := envelope.NewKeyring(testKey(1, alg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env, err := envelope.Seal(kr, []byte("hello, world"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := envelope.ParseHeader(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if h.Version != envelope.Version || h.Algorithm != alg || h.KeyID != 1 {
+			t.Errorf("Bad header for %v: %+v", alg, h)
+		}
+
+		plaintext, err := envelope.Open(kr, env, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plaintext) != "hello, world" {
+			t.Errorf("Bad plaintext: expected %q, was %q", "hello, world", plaintext)
+		}
+	}
+}
+
+func TestOpenRejections(t *testing.T) {
+	kr, err := envelope.NewKeyring(testKey(7, envelope.XChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, _ := hex.DecodeString(testVectors[1].envelope)
+
+	modified := func(i int, b byte) []byte {
+		bad := append([]byte(nil), env...)
+		bad[i] = b
+		return bad
+	}
+
+	mismatched, err := envelope.NewKeyring(testKey(7, envelope.ChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := envelope.NewKeyring(testKey(8, envelope.XChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		keyring  *envelope.Keyring
+		envelope []byte
+		err      error
+	}{
+		{"a short envelope", kr, env[:40], envelope.ErrInvalidEnvelope},
+		{"bad magic", kr, modified(0, 'X'), envelope.ErrInvalidEnvelope},
+		{"an unknown version", kr, modified(3, 2), envelope.ErrUnsupportedVersion},
+		{"an unknown algorithm", kr, modified(4, 0xff), envelope.ErrUnsupportedAlgorithm},
+		{"an unknown key", other, env, envelope.ErrUnknownKey},
+		{"a key for another algorithm", mismatched, env, envelope.ErrAlgorithmMismatch},
+	} {
+		if _, err := envelope.Open(c.keyring, c.envelope, []byte("meta")); err != c.err {
+			t.Errorf("Should have rejected %s with %v, was %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	k := testKey(1, envelope.XChaCha20Poly1305)
+
+	if _, err := envelope.NewKeyring(k, testKey(1, envelope.ChaCha20Poly1305)); err != envelope.ErrDuplicateKey {
+		t.Error("Should have rejected a duplicate key ID")
+	}
+
+	if _, err := envelope.NewKeyring(k, testKey(2, 0)); err != envelope.ErrInvalidKey {
+		t.Error("Should have rejected an unknown algorithm")
+	}
+
+	short := testKey(2, envelope.ChaCha20Poly1305)
+	short.Secret = short.Secret[:16]
+	if _, err := envelope.NewKeyring(short); err != envelope.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+}
+
+func Example() {
+	key := &envelope.Key{
+		ID:        1,
+		Algorithm: envelope.XChaCha20Poly1305,
+		Secret:    bytes.Repeat([]byte{0x42}, envelope.KeySize),
+	}
+
+	keyring, err := envelope.NewKeyring(key)
+	if err != nil {
+		panic(err)
+	}
+
+	sealed, err := envelope.Seal(keyring, []byte("hello, world"), []byte("user:1234"))
+	if err != nil {
+		panic(err)
+	}
+
+	plaintext, err := envelope.Open(keyring, sealed, []byte("user:1234"))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(plaintext))
+	// Output:
+	// hello, world
+}
@@ -0,0 +1,289 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrDuplicateKey is returned when a keyring has two keys with the same
+	// ID.
+	ErrDuplicateKey = errors.New("duplicate key ID")
+	// ErrPrimaryKey is returned when removing the primary key from a keyring.
+	ErrPrimaryKey = errors.New("cannot remove the primary key")
+	// ErrInvalidKeyring is returned when a serialized keyring is malformed.
+	ErrInvalidKeyring = errors.New("invalid keyring")
+)
+
+// GenerateKey returns a new key with the given ID and algorithm and a secret
+// read from rand.
+func GenerateKey(rand io.Reader, id uint32, alg Algorithm) (*Key, error) {
+	k := &Key{ID: id, Algorithm: alg, Secret: make([]byte, KeySize)}
+	if _, err := k.aead(); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(rand, k.Secret); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Keyring holds the keys which can open envelopes: the primary key, which
+// seals new envelopes, and retired keys, which are kept only to open old ones.
+// It is safe for concurrent use.
+type Keyring struct {
+	m       sync.RWMutex
+	keys    map[uint32]*Key
+	primary uint32
+}
+
+// NewKeyring returns a Keyring with the given primary key and any number of
+// retired keys, all with distinct IDs.
+func NewKeyring(primary *Key, retired ...*Key) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[uint32]*Key), primary: primary.ID}
+	for _, k := range append([]*Key{primary}, retired...) {
+		if err := kr.add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	return kr, nil
+}
+
+func (kr *Keyring) add(k *Key) error {
+	if _, err := k.aead(); err != nil {
+		return err
+	}
+
+	if _, ok := kr.keys[k.ID]; ok {
+		return ErrDuplicateKey
+	}
+	kr.keys[k.ID] = k
+	return nil
+}
+
+// Primary returns the key which seals new envelopes.
+func (kr *Keyring) Primary() *Key {
+	kr.m.RLock()
+	defer kr.m.RUnlock()
+
+	return kr.keys[kr.primary]
+}
+
+// Key returns the key with the given ID, or nil if there is none.
+func (kr *Keyring) Key(id uint32) *Key {
+	kr.m.RLock()
+	defer kr.m.RUnlock()
+
+	return kr.keys[id]
+}
+
+// Keys returns all the keys in the keyring, ordered by ID.
+func (kr *Keyring) Keys() []*Key {
+	kr.m.RLock()
+	defer kr.m.RUnlock()
+
+	keys := make([]*Key, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// Add adds a retired key, which opens envelopes but does not seal them.
+func (kr *Keyring) Add(k *Key) error {
+	kr.m.Lock()
+	defer kr.m.Unlock()
+
+	return kr.add(k)
+}
+
+// Rotate adds a new key and makes it the primary key. The previous primary key
+// is retired, so envelopes it sealed can still be opened.
+func (kr *Keyring) Rotate(k *Key) error {
+	kr.m.Lock()
+	defer kr.m.Unlock()
+
+	if err := kr.add(k); err != nil {
+		return err
+	}
+	kr.primary = k.ID
+	return nil
+}
+
+// Remove removes a retired key, after which envelopes it sealed can no longer
+// be opened.
+func (kr *Keyring) Remove(id uint32) error {
+	kr.m.Lock()
+	defer kr.m.Unlock()
+
+	if id == kr.primary {
+		return ErrPrimaryKey
+	}
+
+	if _, ok := kr.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	delete(kr.keys, id)
+	return nil
+}
+
+// Reencrypt opens an envelope sealed with any key in the keyring and seals it
+// again with the primary key, so that the key which sealed it can be removed.
+// Envelopes already sealed with the primary key are returned unchanged.
+func Reencrypt(keyring *Keyring, envelope, additionalData []byte) ([]byte, error) {
+	plaintext, err := Open(keyring, envelope, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open has parsed the header, and the primary key's ID implies its
+	// algorithm.
+	h, _ := ParseHeader(envelope)
+	if h.KeyID == keyring.Primary().ID {
+		return envelope, nil
+	}
+	return Seal(keyring, plaintext, additionalData)
+}
+
+// the additional data which binds a serialized keyring's envelopes to their
+// purpose
+const keyringAD = "envelope keyring"
+
+// the length of a key's ID, algorithm and secret in the binary encoding
+const encodedKeySize = 4 + 1 + KeySize
+
+// Wrap returns the keyring encoded as the primary key's ID and each
+// key's ID, algorithm and secret, sealed in an envelope with the master
+// keyring's primary key.
+func (kr *Keyring) Wrap(master *Keyring) ([]byte, error) {
+	keys := kr.Keys()
+
+	b := make([]byte, 4, 4+len(keys)*encodedKeySize)
+	binary.BigEndian.PutUint32(b, kr.Primary().ID)
+	for _, k := range keys {
+		b = append(b, 0, 0, 0, 0, byte(k.Algorithm))
+		binary.BigEndian.PutUint32(b[len(b)-5:], k.ID)
+		b = append(b, k.Secret...)
+	}
+
+	return Seal(master, b, []byte(keyringAD))
+}
+
+// Unwrap opens a keyring encoded with Wrap using the master
+// keyring.
+func Unwrap(master *Keyring, data []byte) (*Keyring, error) {
+	b, err := Open(master, data, []byte(keyringAD))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < 4 || (len(b)-4)%encodedKeySize != 0 {
+		return nil, ErrInvalidKeyring
+	}
+
+	kr := &Keyring{keys: make(map[uint32]*Key), primary: binary.BigEndian.Uint32(b)}
+	for b = b[4:]; len(b) > 0; b = b[encodedKeySize:] {
+		k := &Key{
+			ID:        binary.BigEndian.Uint32(b),
+			Algorithm: Algorithm(b[4]),
+			Secret:    append([]byte(nil), b[5:encodedKeySize]...),
+		}
+
+		if err := kr.add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := kr.keys[kr.primary]; !ok {
+		return nil, ErrInvalidKeyring
+	}
+	return kr, nil
+}
+
+type jsonKeyring struct {
+	Primary uint32    `json:"primary"`
+	Keys    []jsonKey `json:"keys"`
+}
+
+type jsonKey struct {
+	ID        uint32    `json:"id"`
+	Algorithm Algorithm `json:"algorithm"`
+	Secret    string    `json:"secret"`
+}
+
+// WrapJSON returns the keyring as a JSON object with the primary key's ID
+// and each key's ID and algorithm in the clear, and each key's secret sealed
+// in an envelope with the master keyring's primary key. The envelopes
+// authenticate the ID, algorithm and primary status of their keys, though
+// not the presence of the other keys.
+func (kr *Keyring) WrapJSON(master *Keyring) ([]byte, error) {
+	primary := kr.Primary().ID
+	j := jsonKeyring{Primary: primary}
+	for _, k := range kr.Keys() {
+		secret, err := Seal(master, k.Secret, keyAD(k, k.ID == primary))
+		if err != nil {
+			return nil, err
+		}
+
+		j.Keys = append(j.Keys, jsonKey{
+			ID:        k.ID,
+			Algorithm: k.Algorithm,
+			Secret:    base64.StdEncoding.EncodeToString(secret),
+		})
+	}
+
+	return json.Marshal(j)
+}
+
+// UnwrapJSON opens a keyring encoded with WrapJSON using the master
+// keyring.
+func UnwrapJSON(master *Keyring, data []byte) (*Keyring, error) {
+	var j jsonKeyring
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+
+	kr := &Keyring{keys: make(map[uint32]*Key), primary: j.Primary}
+	for _, jk := range j.Keys {
+		sealed, err := base64.StdEncoding.DecodeString(jk.Secret)
+		if err != nil {
+			return nil, ErrInvalidKeyring
+		}
+
+		k := &Key{ID: jk.ID, Algorithm: jk.Algorithm}
+		k.Secret, err = Open(master, sealed, keyAD(k, k.ID == j.Primary))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := kr.add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := kr.keys[kr.primary]; !ok {
+		return nil, ErrInvalidKeyring
+	}
+	return kr, nil
+}
+
+// keyAD returns the additional data for a key's sealed secret in the JSON
+// encoding.
+func keyAD(k *Key, primary bool) []byte {
+	ad := make([]byte, len(keyringAD)+6)
+	copy(ad, keyringAD)
+	binary.BigEndian.PutUint32(ad[len(keyringAD):], k.ID)
+	ad[len(keyringAD)+4] = byte(k.Algorithm)
+	if primary {
+		ad[len(keyringAD)+5] = 1
+	}
+	return ad
+}
@@ -0,0 +1,210 @@
+package envelope_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/codahale/chacha20/envelope"
+)
+
+func generateKey(t *testing.T, id uint32) *envelope.Key {
+	k, err := envelope.GenerateKey(rand.Reader, id, envelope.XChaCha20Poly1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestGenerateKey(t *testing.T) {
+	k, err := envelope.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{1}, 32)), 3, envelope.ChaCha20Poly1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.ID != 3 || k.Algorithm != envelope.ChaCha20Poly1305 || !bytes.Equal(k.Secret, bytes.Repeat([]byte{1}, 32)) {
+		t.Errorf("Bad key: %+v", k)
+	}
+
+	if _, err := envelope.GenerateKey(rand.Reader, 3, 0); err != envelope.ErrInvalidKey {
+		t.Error("Should have rejected an unknown algorithm")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	kr, err := envelope.NewKeyring(generateKey(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := envelope.Seal(kr, []byte("hello, world"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.Rotate(generateKey(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.Rotate(generateKey(t, 1)); err != envelope.ErrDuplicateKey {
+		t.Error("Should have rejected a duplicate key ID")
+	}
+
+	if kr.Primary().ID != 2 {
+		t.Errorf("Bad primary key: expected 2, was %d", kr.Primary().ID)
+	}
+
+	if _, err := envelope.Open(kr, old, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := envelope.Reencrypt(kr, old, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := envelope.ParseHeader(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.KeyID != 2 {
+		t.Errorf("Bad key ID: expected 2, was %d", h.KeyID)
+	}
+
+	again, err := envelope.Reencrypt(kr, migrated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(again, migrated) {
+		t.Error("Should have left an envelope sealed with the primary key unchanged")
+	}
+
+	if err := kr.Remove(2); err != envelope.ErrPrimaryKey {
+		t.Error("Should have refused to remove the primary key")
+	}
+
+	if err := kr.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.Remove(1); err != envelope.ErrUnknownKey {
+		t.Error("Should have rejected an unknown key ID")
+	}
+
+	if _, err := envelope.Open(kr, old, nil); err != envelope.ErrUnknownKey {
+		t.Error("Should have rejected an envelope sealed with a removed key")
+	}
+
+	if _, err := envelope.Reencrypt(kr, old, nil); err != envelope.ErrUnknownKey {
+		t.Error("Should have rejected an envelope sealed with a removed key")
+	}
+
+	plaintext, err := envelope.Open(kr, migrated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != "hello, world" {
+		t.Errorf("Bad plaintext: expected %q, was %q", "hello, world", plaintext)
+	}
+}
+
+func testKeyring(t *testing.T) (*envelope.Keyring, *envelope.Keyring) {
+	kr, err := envelope.NewKeyring(generateKey(t, 2), generateKey(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.Add(testKey(5, envelope.ChaCha20Poly1305)); err != nil {
+		t.Fatal(err)
+	}
+
+	master, err := envelope.NewKeyring(generateKey(t, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kr, master
+}
+
+func sameKeys(t *testing.T, expected, actual *envelope.Keyring) {
+	if actual.Primary().ID != expected.Primary().ID {
+		t.Errorf("Bad primary key: expected %d, was %d", expected.Primary().ID, actual.Primary().ID)
+	}
+
+	e, a := expected.Keys(), actual.Keys()
+	if len(a) != len(e) {
+		t.Fatalf("Bad number of keys: expected %d, was %d", len(e), len(a))
+	}
+
+	for i := range e {
+		if a[i].ID != e[i].ID || a[i].Algorithm != e[i].Algorithm || !bytes.Equal(a[i].Secret, e[i].Secret) {
+			t.Errorf("Bad key: expected %+v, was %+v", e[i], a[i])
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	kr, master := testKeyring(t)
+
+	wrapped, err := kr.Wrap(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := envelope.Unwrap(master, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameKeys(t, kr, unwrapped)
+
+	other, _ := testKeyring(t)
+	if _, err := envelope.Unwrap(other, wrapped); err != envelope.ErrUnknownKey {
+		t.Error("Should have rejected the wrong master keyring")
+	}
+
+	// an envelope of something else sealed with the master key
+	sealed, err := envelope.Seal(master, []byte("hello, world"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := envelope.Unwrap(master, sealed); err != envelope.ErrOpen {
+		t.Error("Should have rejected an envelope which is not a keyring")
+	}
+}
+
+func TestWrapJSON(t *testing.T) {
+	kr, master := testKeyring(t)
+
+	wrapped, err := kr.WrapJSON(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := envelope.UnwrapJSON(master, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameKeys(t, kr, unwrapped)
+
+	var j map[string]interface{}
+	if err := json.Unmarshal(wrapped, &j); err != nil {
+		t.Fatal(err)
+	}
+
+	j["primary"] = 1
+	demoted, _ := json.Marshal(j)
+	if _, err := envelope.UnwrapJSON(master, demoted); err != envelope.ErrOpen {
+		t.Error("Should have rejected a changed primary key")
+	}
+
+	j["primary"] = 3
+	missing, _ := json.Marshal(j)
+	if _, err := envelope.UnwrapJSON(master, missing); err != envelope.ErrOpen {
+		t.Error("Should have rejected a missing primary key")
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/codahale/chacha20
+
+go 1.24
@@ -0,0 +1,367 @@
+// Package hpke provides Hybrid Public Key Encryption (RFC 9180) with the
+// DHKEM(X25519, HKDF-SHA256) KEM, the HKDF-SHA256 KDF, and the
+// ChaCha20Poly1305 AEAD, in the base and PSK modes.
+//
+// A sender encapsulates a shared secret to the recipient's X25519 public key,
+// producing an encapsulated key which must be sent to the recipient, and both
+// sides derive an encryption context from it. The sender's context seals a
+// sequence of messages which the recipient's context opens in the same
+// order. Both contexts can also export secrets derived from the shared
+// secret.
+//
+// In the PSK mode, a pre-shared key is mixed into the key schedule, which
+// authenticates the sender as a holder of the PSK.
+//
+// For more information, see https://tools.ietf.org/html/rfc9180
+package hpke
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// ModeBase is the mode without sender authentication.
+	ModeBase byte = 0x00
+	// ModePSK is the mode authenticated with a pre-shared key.
+	ModePSK byte = 0x01
+
+	// KEMID is the identifier of DHKEM(X25519, HKDF-SHA256).
+	KEMID uint16 = 0x0020
+	// KDFID is the identifier of HKDF-SHA256.
+	KDFID uint16 = 0x0001
+	// AEADID is the identifier of ChaCha20Poly1305.
+	AEADID uint16 = 0x0003
+
+	// EncapsulatedKeySize is the length of the encapsulated key, in bytes.
+	EncapsulatedKeySize = 32
+	// MaxExportSize is the length of the longest secret which can be
+	// exported, in bytes.
+	MaxExportSize = 255 * sha256.Size
+
+	nSecret = 32
+	nk      = chacha20poly1305.KeySize
+	nn      = chacha20poly1305.NonceSize
+)
+
+var (
+	// ErrInvalidKey is returned when a public or private key is not an X25519
+	// key, or when an encapsulated key is invalid.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrInvalidPSK is returned when the PSK or its ID is missing in the PSK
+	// mode.
+	ErrInvalidPSK = errors.New("invalid PSK inputs")
+	// ErrMessageLimit is returned when a context has sealed or opened as many
+	// messages as its sequence number allows.
+	ErrMessageLimit = errors.New("message limit reached")
+	// ErrExportTooLarge is returned when an exported secret would be longer
+	// than MaxExportSize.
+	ErrExportTooLarge = errors.New("export too large")
+	// ErrOpen is returned when a ciphertext cannot be authenticated.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+var (
+	kemSuiteID = []byte{'K', 'E', 'M', byte(KEMID >> 8), byte(KEMID)}
+	suiteID    = []byte{
+		'H', 'P', 'K', 'E',
+		byte(KEMID >> 8), byte(KEMID),
+		byte(KDFID >> 8), byte(KDFID),
+		byte(AEADID >> 8), byte(AEADID),
+	}
+)
+
+// A Sender is the sender's encryption context.
+type Sender struct {
+	context
+}
+
+// A Receiver is the recipient's encryption context.
+type Receiver struct {
+	context
+}
+
+// SetupBaseS encapsulates a shared secret to the recipient's public key with
+// an ephemeral key derived from 32 bytes read from rand, and returns the
+// encapsulated key and the sender's context.
+func SetupBaseS(rand io.Reader, pkR *ecdh.PublicKey, info []byte) ([]byte, *Sender, error) {
+	return setupS(rand, pkR, ModeBase, info, nil, nil)
+}
+
+// SetupPSKS is SetupBaseS in the PSK mode. The psk and pskID arguments must
+// not be empty.
+func SetupPSKS(rand io.Reader, pkR *ecdh.PublicKey, info, psk, pskID []byte) ([]byte, *Sender, error) {
+	return setupS(rand, pkR, ModePSK, info, psk, pskID)
+}
+
+// SetupBaseR decapsulates the shared secret from the encapsulated key with the
+// recipient's private key, and returns the recipient's context.
+func SetupBaseR(enc []byte, skR *ecdh.PrivateKey, info []byte) (*Receiver, error) {
+	return setupR(enc, skR, ModeBase, info, nil, nil)
+}
+
+// SetupPSKR is SetupBaseR in the PSK mode.
+func SetupPSKR(enc []byte, skR *ecdh.PrivateKey, info, psk, pskID []byte) (*Receiver, error) {
+	return setupR(enc, skR, ModePSK, info, psk, pskID)
+}
+
+// SealBase encrypts a single message to the recipient's public key in the
+// base mode, and returns the encapsulated key and the ciphertext.
+func SealBase(rand io.Reader, pkR *ecdh.PublicKey, info, aad, plaintext []byte) ([]byte, []byte, error) {
+	enc, s, err := SetupBaseS(rand, pkR, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, err := s.Seal(aad, plaintext)
+	return enc, ciphertext, err
+}
+
+// OpenBase decrypts a single message sealed with SealBase.
+func OpenBase(enc []byte, skR *ecdh.PrivateKey, info, aad, ciphertext []byte) ([]byte, error) {
+	r, err := SetupBaseR(enc, skR, info)
+	if err != nil {
+		return nil, err
+	}
+	return r.Open(aad, ciphertext)
+}
+
+// SealPSK is SealBase in the PSK mode.
+func SealPSK(rand io.Reader, pkR *ecdh.PublicKey, info, aad, plaintext, psk, pskID []byte) ([]byte, []byte, error) {
+	enc, s, err := SetupPSKS(rand, pkR, info, psk, pskID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, err := s.Seal(aad, plaintext)
+	return enc, ciphertext, err
+}
+
+// OpenPSK decrypts a single message sealed with SealPSK.
+func OpenPSK(enc []byte, skR *ecdh.PrivateKey, info, aad, ciphertext, psk, pskID []byte) ([]byte, error) {
+	r, err := SetupPSKR(enc, skR, info, psk, pskID)
+	if err != nil {
+		return nil, err
+	}
+	return r.Open(aad, ciphertext)
+}
+
+// Seal encrypts and authenticates the next message and the additional data.
+func (s *Sender) Seal(aad, plaintext []byte) ([]byte, error) {
+	nonce, err := s.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// Open authenticates and decrypts the next message. If it cannot be
+// authenticated, the sequence number is not advanced.
+func (r *Receiver) Open(aad, ciphertext []byte) ([]byte, error) {
+	if r.seq == ^uint64(0) {
+		return nil, ErrMessageLimit
+	}
+
+	plaintext, err := r.aead.Open(nil, r.nonce(), ciphertext, aad)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	r.seq++
+	return plaintext, nil
+}
+
+// DeriveKeyPair deterministically derives an X25519 key pair from at least
+// 32 bytes of input keying material.
+func DeriveKeyPair(ikm []byte) (*ecdh.PrivateKey, error) {
+	if len(ikm) < nSecret {
+		return nil, errors.New("input keying material too short")
+	}
+
+	prk := labeledExtract(kemSuiteID, nil, "dkp_prk", ikm)
+	sk := labeledExpand(kemSuiteID, prk, "sk", nil, nSecret)
+	return ecdh.X25519().NewPrivateKey(sk)
+}
+
+func setupS(rand io.Reader, pkR *ecdh.PublicKey, mode byte, info, psk, pskID []byte) ([]byte, *Sender, error) {
+	if pkR.Curve() != ecdh.X25519() {
+		return nil, nil, ErrInvalidKey
+	}
+
+	ikm := make([]byte, nSecret)
+	if _, err := io.ReadFull(rand, ikm); err != nil {
+		return nil, nil, err
+	}
+
+	skE, err := DeriveKeyPair(ikm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	enc := skE.PublicKey().Bytes()
+	shared := extractAndExpand(dh, enc, pkR.Bytes())
+
+	c, err := keySchedule(mode, shared, info, psk, pskID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, &Sender{c}, nil
+}
+
+func setupR(enc []byte, skR *ecdh.PrivateKey, mode byte, info, psk, pskID []byte) (*Receiver, error) {
+	if skR.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidKey
+	}
+
+	pkE, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	dh, err := skR.ECDH(pkE)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	shared := extractAndExpand(dh, enc, skR.PublicKey().Bytes())
+
+	c, err := keySchedule(mode, shared, info, psk, pskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Receiver{c}, nil
+}
+
+// extractAndExpand derives the KEM shared secret from the Diffie-Hellman
+// output and the KEM context, which is the encapsulated key followed by the
+// recipient's public key.
+func extractAndExpand(dh, enc, pkR []byte) []byte {
+	kemContext := make([]byte, 0, len(enc)+len(pkR))
+	kemContext = append(kemContext, enc...)
+	kemContext = append(kemContext, pkR...)
+
+	prk := labeledExtract(kemSuiteID, nil, "eae_prk", dh)
+	return labeledExpand(kemSuiteID, prk, "shared_secret", kemContext, nSecret)
+}
+
+type context struct {
+	aead           cipher.AEAD
+	baseNonce      [nn]byte
+	exporterSecret []byte
+	seq            uint64
+}
+
+func keySchedule(mode byte, shared, info, psk, pskID []byte) (context, error) {
+	if (len(psk) == 0) != (len(pskID) == 0) || (mode == ModePSK) != (len(psk) != 0) {
+		return context{}, ErrInvalidPSK
+	}
+
+	pskIDHash := labeledExtract(suiteID, nil, "psk_id_hash", pskID)
+	infoHash := labeledExtract(suiteID, nil, "info_hash", info)
+
+	ksc := make([]byte, 0, 1+len(pskIDHash)+len(infoHash))
+	ksc = append(ksc, mode)
+	ksc = append(ksc, pskIDHash...)
+	ksc = append(ksc, infoHash...)
+
+	secret := labeledExtract(suiteID, shared, "secret", psk)
+	key := labeledExpand(suiteID, secret, "key", ksc, nk)
+
+	// The key is always the right size.
+	a, _ := chacha20poly1305.New(key)
+
+	c := context{
+		aead:           a,
+		exporterSecret: labeledExpand(suiteID, secret, "exp", ksc, sha256.Size),
+	}
+	copy(c.baseNonce[:], labeledExpand(suiteID, secret, "base_nonce", ksc, nn))
+
+	return c, nil
+}
+
+// Export derives a secret of the given length from the shared secret and the
+// exporter context.
+func (s *Sender) Export(exporterContext []byte, length int) ([]byte, error) {
+	return s.export(exporterContext, length)
+}
+
+// Export derives a secret of the given length from the shared secret and the
+// exporter context. It returns the same secret as the sender's Export.
+func (r *Receiver) Export(exporterContext []byte, length int) ([]byte, error) {
+	return r.export(exporterContext, length)
+}
+
+func (c *context) export(exporterContext []byte, length int) ([]byte, error) {
+	if length > MaxExportSize || length < 0 {
+		return nil, ErrExportTooLarge
+	}
+
+	if length == 0 {
+		return []byte{}, nil
+	}
+	return labeledExpand(suiteID, c.exporterSecret, "sec", exporterContext, length), nil
+}
+
+// nonce returns the base nonce XORed with the sequence number.
+func (c *context) nonce() []byte {
+	var seq [nn]byte
+	binary.BigEndian.PutUint64(seq[nn-8:], c.seq)
+	for i := range seq {
+		seq[i] ^= c.baseNonce[i]
+	}
+	return seq[:]
+}
+
+func (c *context) nextNonce() ([]byte, error) {
+	if c.seq == ^uint64(0) {
+		return nil, ErrMessageLimit
+	}
+
+	n := c.nonce()
+	c.seq++
+	return n, nil
+}
+
+func labeledExtract(suite, salt []byte, label string, ikm []byte) []byte {
+	labeled := make([]byte, 0, 7+len(suite)+len(label)+len(ikm))
+	labeled = append(labeled, "HPKE-v1"...)
+	labeled = append(labeled, suite...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, ikm...)
+
+	prk, err := hkdf.Extract(sha256.New, labeled, salt)
+	if err != nil {
+		panic(err)
+	}
+	return prk
+}
+
+func labeledExpand(suite, prk []byte, label string, info []byte, length int) []byte {
+	labeled := make([]byte, 0, 2+7+len(suite)+len(label)+len(info))
+	labeled = binary.BigEndian.AppendUint16(labeled, uint16(length))
+	labeled = append(labeled, "HPKE-v1"...)
+	labeled = append(labeled, suite...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, info...)
+
+	out, err := hkdf.Expand(sha256.New, prk, string(labeled), length)
+	if err != nil {
+		// Expand only fails for lengths checked by the callers.
+		panic(err)
+	}
+	return out
+}
@@ -0,0 +1,319 @@
+package hpke_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/hpke"
+)
+
+// stolen from the RFC 9180 test vectors for DHKEM(X25519, HKDF-SHA256),
+// HKDF-SHA256 and ChaCha20Poly1305, https://github.com/cfrg/draft-irtf-cfrg-hpke
+type testVector struct {
+	mode        byte
+	info        string
+	ikmE        string
+	skR         string
+	pkR         string
+	enc         string
+	psk         string
+	pskID       string
+	encryptions []encryption
+	exports     []export
+}
+
+type encryption struct {
+	seq        int
+	aad        string
+	plaintext  string
+	ciphertext string
+}
+
+type export struct {
+	context string
+	length  int
+	value   string
+}
+
+var testVectors = []testVector{
+	testVector{
+		mode: hpke.ModeBase,
+		info: "4f6465206f6e2061204772656369616e2055726e",
+		ikmE: "909a9b35d3dc4713a5e72a4da274b55d3d3821a37e5d099e74a647db583a904b",
+		skR:  "8057991eef8f1f1af18f4a9491d16a1ce333f695d4db8e38da75975c4478e0fb",
+		pkR:  "4310ee97d88cc1f088a5576c77ab0cf5c3ac797f3d95139c6c84b5429c59662a",
+		enc:  "1afa08d3dec047a643885163f1180476fa7ddb54c6a8029ea33f95796bf2ac4a",
+		encryptions: []encryption{
+			encryption{0, "436f756e742d30", "4265617574792069732074727574682c20747275746820626561757479",
+				"1c5250d8034ec2b784ba2cfd69dbdb8af406cfe3ff938e131f0def8c8b60b4db" +
+					"21993c62ce81883d2dd1b51a28"},
+			encryption{1, "436f756e742d31", "4265617574792069732074727574682c20747275746820626561757479",
+				"6b53c051e4199c518de79594e1c4ab18b96f081549d45ce015be002090bb119e" +
+					"85285337cc95ba5f59992dc98c"},
+			encryption{2, "436f756e742d32", "4265617574792069732074727574682c20747275746820626561757479",
+				"71146bd6795ccc9c49ce25dda112a48f202ad220559502cef1f34271e0cb4b02" +
+					"b4f10ecac6f48c32f878fae86b"},
+			encryption{4, "436f756e742d34", "4265617574792069732074727574682c20747275746820626561757479",
+				"63357a2aa291f5a4e5f27db6baa2af8cf77427c7c1a909e0b37214dd47db122b" +
+					"b153495ff0b02e9e54a50dbe16"},
+			encryption{255, "436f756e742d323535", "4265617574792069732074727574682c20747275746820626561757479",
+				"18ab939d63ddec9f6ac2b60d61d36a7375d2070c9b683861110757062c52b888" +
+					"0a5f6b3936da9cd6c23ef2a95c"},
+			encryption{256, "436f756e742d323536", "4265617574792069732074727574682c20747275746820626561757479",
+				"7a4a13e9ef23978e2c520fd4d2e757514ae160cd0cd05e556ef692370ca53076" +
+					"214c0c40d4c728d6ed9e727a5b"},
+		},
+		exports: []export{
+			export{"", 32, "4bbd6243b8bb54cec311fac9df81841b6fd61f56538a775e7c80a9f40160606e"},
+			export{"00", 32, "8c1df14732580e5501b00f82b10a1647b40713191b7c1240ac80e2b68808ba69"},
+			export{"54657374436f6e74657874", 32, "5acb09211139c43b3090489a9da433e8a30ee7188ba8b0a9a1ccf0c229283e53"},
+		},
+	},
+	testVector{
+		mode:  hpke.ModePSK,
+		info:  "4f6465206f6e2061204772656369616e2055726e",
+		ikmE:  "35706a0b09fb26fb45c39c2f5079c709c7cf98e43afa973f14d88ece7e29c2e3",
+		skR:   "77d114e0212be51cb1d76fa99dd41cfd4d0166b08caa09074430a6c59ef17879",
+		pkR:   "13640af826b722fc04feaa4de2f28fbd5ecc03623b317834e7ff4120dbe73062",
+		enc:   "2261299c3f40a9afc133b969a97f05e95be2c514e54f3de26cbe5644ac735b04",
+		psk:   "0247fd33b913760fa1fa51e1892d9f307fbe65eb171e8132c2af18555a738b82",
+		pskID: "456e6e796e20447572696e206172616e204d6f726961",
+		encryptions: []encryption{
+			encryption{0, "436f756e742d30", "4265617574792069732074727574682c20747275746820626561757479",
+				"4a177f9c0d6f15cfdf533fb65bf84aecdc6ab16b8b85b4cf65a370e07fc1d78d" +
+					"28fb073214525276f4a89608ff"},
+			encryption{1, "436f756e742d31", "4265617574792069732074727574682c20747275746820626561757479",
+				"5c3cabae2f0b3e124d8d864c116fd8f20f3f56fda988c3573b40b09997fd6c76" +
+					"9e77c8eda6cda4f947f5b704a8"},
+			encryption{2, "436f756e742d32", "4265617574792069732074727574682c20747275746820626561757479",
+				"14958900b44bdae9cbe5a528bf933c5c990dbb8e282e6e495adf8205d19da9eb" +
+					"270e3a6f1e0613ab7e757962a4"},
+			encryption{4, "436f756e742d34", "4265617574792069732074727574682c20747275746820626561757479",
+				"c2a7bc09ddb853cf2effb6e8d058e346f7fe0fb3476528c80db6b698415c5f8c" +
+					"50b68a9a355609e96d2117f8d3"},
+			encryption{255, "436f756e742d323535", "4265617574792069732074727574682c20747275746820626561757479",
+				"2414d0788e4bc39a59a26d7bd5d78e111c317d44c37bd5a4c2a1235f2ddc2085" +
+					"c487d406490e75210c958724a7"},
+			encryption{256, "436f756e742d323536", "4265617574792069732074727574682c20747275746820626561757479",
+				"c567ae1c3f0f75abe1dd9e4532b422600ed4a6e5b9484dafb1e43ab9f5fd662b" +
+					"28c00e2e81d3cde955dae7e218"},
+		},
+		exports: []export{
+			export{"", 32, "813c1bfc516c99076ae0f466671f0ba5ff244a41699f7b2417e4c59d46d39f40"},
+			export{"00", 32, "2745cf3d5bb65c333658732954ee7af49eb895ce77f8022873a62a13c94cb4e1"},
+			export{"54657374436f6e74657874", 32, "ad40e3ae14f21c99bfdebc20ae14ab86f4ca2dc9a4799d200f43a25f99fa78ae"},
+		},
+	},
+}
+
+func decode(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func TestSender(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		pkR, err := ecdh.X25519().NewPublicKey(decode(vector.pkR))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rand := bytes.NewReader(decode(vector.ikmE))
+
+		var enc []byte
+		var s *hpke.Sender
+		if vector.mode == hpke.ModePSK {
+			enc, s, err = hpke.SetupPSKS(rand, pkR, decode(vector.info), decode(vector.psk), decode(vector.pskID))
+		} else {
+			enc, s, err = hpke.SetupBaseS(rand, pkR, decode(vector.info))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(enc) != vector.enc {
+			t.Errorf("Bad encapsulated key: expected %s, was %x", vector.enc, enc)
+		}
+
+		seq := 0
+		for _, e := range vector.encryptions {
+			for ; seq < e.seq; seq++ {
+				if _, err := s.Seal(nil, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ciphertext, err := s.Seal(decode(e.aad), decode(e.plaintext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			seq++
+
+			if hex.EncodeToString(ciphertext) != e.ciphertext {
+				t.Errorf("Bad ciphertext %d: expected %s, was %x", e.seq, e.ciphertext, ciphertext)
+			}
+		}
+
+		for _, e := range vector.exports {
+			value, err := s.Export(decode(e.context), e.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hex.EncodeToString(value) != e.value {
+				t.Errorf("Bad exported value: expected %s, was %x", e.value, value)
+			}
+		}
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		skR, err := ecdh.X25519().NewPrivateKey(decode(vector.skR))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r *hpke.Receiver
+		if vector.mode == hpke.ModePSK {
+			r, err = hpke.SetupPSKR(decode(vector.enc), skR, decode(vector.info), decode(vector.psk), decode(vector.pskID))
+		} else {
+			r, err = hpke.SetupBaseR(decode(vector.enc), skR, decode(vector.info))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Messages 0 and 1 must be opened in order.
+		if _, err := r.Open(decode(vector.encryptions[1].aad), decode(vector.encryptions[1].ciphertext)); err != hpke.ErrOpen {
+			t.Error("Should have rejected an out of order message")
+		}
+
+		for _, e := range vector.encryptions[:3] {
+			plaintext, err := r.Open(decode(e.aad), decode(e.ciphertext))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hex.EncodeToString(plaintext) != e.plaintext {
+				t.Errorf("Bad plaintext %d: expected %s, was %x", e.seq, e.plaintext, plaintext)
+			}
+		}
+
+		for _, e := range vector.exports {
+			value, err := r.Export(decode(e.context), e.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hex.EncodeToString(value) != e.value {
+				t.Errorf("Bad exported value: expected %s, was %x", e.value, value)
+			}
+		}
+	}
+}
+
+func TestSingleShot(t *testing.T) {
+	skR, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	psk := bytes.Repeat([]byte{0x42}, 32)
+	pskID := []byte("our PSK")
+
+	enc, ciphertext, err := hpke.SealPSK(rand.Reader, skR.PublicKey(), []byte("info"), []byte("aad"), []byte("hello"), psk, pskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := hpke.OpenPSK(enc, skR, []byte("info"), []byte("aad"), ciphertext, psk, pskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != "hello" {
+		t.Errorf("Bad plaintext: expected %q, was %q", "hello", plaintext)
+	}
+
+	if _, err := hpke.OpenBase(enc, skR, []byte("info"), []byte("aad"), ciphertext); err != hpke.ErrOpen {
+		t.Error("Should have rejected a message in the wrong mode")
+	}
+
+	if _, err := hpke.OpenPSK(enc, skR, []byte("info"), []byte("aad"), ciphertext, psk[1:], pskID); err != hpke.ErrOpen {
+		t.Error("Should have rejected a message with the wrong PSK")
+	}
+}
+
+func TestBadInputs(t *testing.T) {
+	skR, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if _, _, err := hpke.SetupPSKS(rand.Reader, skR.PublicKey(), nil, []byte("psk"), nil); err != hpke.ErrInvalidPSK {
+		t.Error("Should have rejected a PSK without an ID")
+	}
+
+	if _, _, err := hpke.SetupPSKS(rand.Reader, skR.PublicKey(), nil, nil, nil); err != hpke.ErrInvalidPSK {
+		t.Error("Should have rejected the PSK mode without a PSK")
+	}
+
+	if _, err := hpke.SetupBaseR(make([]byte, hpke.EncapsulatedKeySize), skR, nil); err != hpke.ErrInvalidKey {
+		t.Error("Should have rejected a low order encapsulated key")
+	}
+
+	if _, err := hpke.SetupBaseR(make([]byte, 3), skR, nil); err != hpke.ErrInvalidKey {
+		t.Error("Should have rejected a short encapsulated key")
+	}
+
+	p256, _ := ecdh.P256().GenerateKey(rand.Reader)
+	if _, _, err := hpke.SetupBaseS(rand.Reader, p256.PublicKey(), nil); err != hpke.ErrInvalidKey {
+		t.Error("Should have rejected a P-256 key")
+	}
+
+	_, s, _ := hpke.SetupBaseS(rand.Reader, skR.PublicKey(), nil)
+	if _, err := s.Export(nil, hpke.MaxExportSize+1); err != hpke.ErrExportTooLarge {
+		t.Error("Should have rejected a long export")
+	}
+}
+
+func Example() {
+	// The recipient publishes a public key.
+	skR, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	// The sender encapsulates a shared secret to it, and seals messages.
+	info := []byte("example application")
+	enc, s, err := hpke.SetupBaseS(rand.Reader, skR.PublicKey(), info)
+	if err != nil {
+		panic(err)
+	}
+
+	first, _ := s.Seal(nil, []byte("hello I am"))
+	second, _ := s.Seal(nil, []byte("a secret payload"))
+
+	// The recipient uses the encapsulated key to open them.
+	r, err := hpke.SetupBaseR(enc, skR, info)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range [][]byte{first, second} {
+		plaintext, err := r.Open(nil, c)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", plaintext)
+	}
+	// Output:
+	// hello I am
+	// a secret payload
+}
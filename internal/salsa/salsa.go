@@ -0,0 +1,136 @@
+// Package salsa provides the Salsa20 core, HSalsa20 and the XSalsa20 stream
+// cipher, which NaCl's secretbox and box constructions are built on.
+//
+// From Bernstein, Daniel J. "Extending the Salsa20 nonce." Workshop Record of
+// Symmetric Key Encryption Workshop. 2011.
+// (http://cr.yp.to/snuffle/xsalsa-20110204.pdf)
+package salsa
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	stateSize = 16            // the size of Salsa20's state, in words
+	blockSize = stateSize * 4 // the size of Salsa20's block, in bytes
+)
+
+// HSalsa20 derives a 256-bit subkey from a 256-bit key and a 128-bit input.
+func HSalsa20(out *[32]byte, in *[16]byte, key *[32]byte) {
+	var state, x [stateSize]uint32
+	initState(&state, key)
+	state[6] = binary.LittleEndian.Uint32(in[0:])
+	state[7] = binary.LittleEndian.Uint32(in[4:])
+	state[8] = binary.LittleEndian.Uint32(in[8:])
+	state[9] = binary.LittleEndian.Uint32(in[12:])
+
+	core(&state, &x, 20, true)
+
+	for i, j := range [8]int{0, 5, 10, 15, 6, 7, 8, 9} {
+		binary.LittleEndian.PutUint32(out[i*4:], x[j])
+	}
+}
+
+// NewXSalsa20 returns a cipher.Stream which produces the XSalsa20 keystream
+// for the given key and 192-bit nonce.
+func NewXSalsa20(key *[32]byte, nonce *[24]byte) cipher.Stream {
+	var subkey [32]byte
+	var in [16]byte
+	copy(in[:], nonce[:16])
+	HSalsa20(&subkey, &in, key)
+
+	s := new(stream)
+	initState(&s.state, &subkey)
+	s.state[6] = binary.LittleEndian.Uint32(nonce[16:])
+	s.state[7] = binary.LittleEndian.Uint32(nonce[20:])
+	s.offset = blockSize
+	return s
+}
+
+type stream struct {
+	state  [stateSize]uint32 // the state as an array of 16 32-bit words
+	block  [blockSize]byte   // the keystream as an array of 64 bytes
+	offset int               // the offset of used bytes in block
+}
+
+func (s *stream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("salsa: output smaller than input")
+	}
+
+	for i := range src {
+		if s.offset == blockSize {
+			s.advance()
+		}
+		dst[i] = src[i] ^ s.block[s.offset]
+		s.offset++
+	}
+}
+
+// advances the keystream
+func (s *stream) advance() {
+	var x [stateSize]uint32
+	core(&s.state, &x, 20, false)
+	for i, v := range x {
+		binary.LittleEndian.PutUint32(s.block[i*4:], v)
+	}
+
+	s.offset = 0
+	s.state[8]++
+	if s.state[8] == 0 {
+		s.state[9]++
+	}
+}
+
+func initState(state *[stateSize]uint32, key *[32]byte) {
+	// the magic constants for 256-bit keys
+	state[0] = 0x61707865
+	state[5] = 0x3320646e
+	state[10] = 0x79622d32
+	state[15] = 0x6b206574
+
+	state[1] = binary.LittleEndian.Uint32(key[0:])
+	state[2] = binary.LittleEndian.Uint32(key[4:])
+	state[3] = binary.LittleEndian.Uint32(key[8:])
+	state[4] = binary.LittleEndian.Uint32(key[12:])
+	state[11] = binary.LittleEndian.Uint32(key[16:])
+	state[12] = binary.LittleEndian.Uint32(key[20:])
+	state[13] = binary.LittleEndian.Uint32(key[24:])
+	state[14] = binary.LittleEndian.Uint32(key[28:])
+}
+
+func core(input, output *[stateSize]uint32, rounds int, hsalsa bool) {
+	x := *input
+
+	for i := 0; i < rounds; i += 2 {
+		// column round
+		quarterRound(&x[0], &x[4], &x[8], &x[12])
+		quarterRound(&x[5], &x[9], &x[13], &x[1])
+		quarterRound(&x[10], &x[14], &x[2], &x[6])
+		quarterRound(&x[15], &x[3], &x[7], &x[11])
+
+		// row round
+		quarterRound(&x[0], &x[1], &x[2], &x[3])
+		quarterRound(&x[5], &x[6], &x[7], &x[4])
+		quarterRound(&x[10], &x[11], &x[8], &x[9])
+		quarterRound(&x[15], &x[12], &x[13], &x[14])
+	}
+
+	if hsalsa {
+		*output = x
+		return
+	}
+
+	for i := range x {
+		output[i] = x[i] + input[i]
+	}
+}
+
+func quarterRound(a, b, c, d *uint32) {
+	*b ^= bits.RotateLeft32(*a+*d, 7)
+	*c ^= bits.RotateLeft32(*b+*a, 9)
+	*d ^= bits.RotateLeft32(*c+*b, 13)
+	*a ^= bits.RotateLeft32(*d+*c, 18)
+}
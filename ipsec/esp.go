@@ -0,0 +1,156 @@
+package ipsec
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	// ESPHeaderSize is the length of an ESP header, the SPI and the low 32
+	// bits of the sequence number, in bytes.
+	ESPHeaderSize = 8
+	// NextHeaderNone is the next header value of dummy packets, which carry
+	// no data and should be discarded.
+	NextHeaderNone = 59
+)
+
+var (
+	// ErrInvalidPacket is returned when an ESP packet is too short to contain
+	// a header, an IV, a trailer and an ICV.
+	ErrInvalidPacket = errors.New("invalid ESP packet")
+	// ErrWrongSPI is returned when an ESP packet belongs to another security
+	// association.
+	ErrWrongSPI = errors.New("packet for another security association")
+	// ErrSequenceOverflow is returned when a sequence number does not fit in
+	// 32 bits and extended sequence numbers are not in use.
+	ErrSequenceOverflow = errors.New("sequence number overflow")
+)
+
+// ESP encapsulates and decapsulates the ESP packets of one direction of a
+// security association.
+type ESP struct {
+	t   *transform
+	spi uint32
+	esn bool
+}
+
+// NewESP returns an ESP for the given 36 bytes of keying material and SPI.
+// If esn is true, the security association uses 64-bit extended sequence
+// numbers, whose high 32 bits are authenticated but not sent.
+func NewESP(keymat []byte, spi uint32, esn bool) (*ESP, error) {
+	t, err := newTransform(keymat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ESP{t: t, spi: spi, esn: esn}, nil
+}
+
+// ParseESPHeader returns the SPI and the low 32 bits of the sequence number
+// of an ESP packet, so that it can be matched to its security association.
+func ParseESPHeader(packet []byte) (spi, seq uint32, err error) {
+	if len(packet) < ESPHeaderSize+IVSize+2+ICVSize {
+		return 0, 0, ErrInvalidPacket
+	}
+
+	return binary.BigEndian.Uint32(packet), binary.BigEndian.Uint32(packet[4:]), nil
+}
+
+// aad returns the SPI and the sequence number, including its high 32 bits if
+// extended sequence numbers are in use.
+func (e *ESP) aad(seq uint64) []byte {
+	aad := binary.BigEndian.AppendUint32(make([]byte, 0, 12), e.spi)
+	if e.esn {
+		aad = binary.BigEndian.AppendUint32(aad, uint32(seq>>32))
+	}
+	return binary.BigEndian.AppendUint32(aad, uint32(seq))
+}
+
+// Seal encrypts a payload, such as an IP packet, with the given sequence
+// number and IV, and appends the ESP packet to dst. The payload is padded
+// to a multiple of four bytes with the trailer, which records the type of
+// the payload in nextHeader.
+func (e *ESP) Seal(dst []byte, seq, iv uint64, nextHeader byte, payload []byte) ([]byte, error) {
+	if !e.esn && seq > math.MaxUint32 {
+		return nil, ErrSequenceOverflow
+	}
+
+	padLength := (4 - (len(payload)+2)%4) % 4
+
+	plaintext := make([]byte, 0, len(payload)+padLength+2)
+	plaintext = append(plaintext, payload...)
+	for i := 1; i <= padLength; i++ {
+		plaintext = append(plaintext, byte(i))
+	}
+	plaintext = append(plaintext, byte(padLength), nextHeader)
+
+	out := binary.BigEndian.AppendUint32(dst, e.spi)
+	out = binary.BigEndian.AppendUint32(out, uint32(seq))
+	out = appendIV(out, iv)
+	ivBytes := out[len(out)-IVSize:]
+
+	return e.t.aead.Seal(out, e.t.nonce(ivBytes), plaintext, e.aad(seq)), nil
+}
+
+// Open authenticates and decrypts an ESP packet, and appends its payload to
+// dst, returning the payload's type from the trailer. If extended sequence
+// numbers are in use, seqHigh must be the high 32 bits of the packet's
+// sequence number, as estimated by EstimateSequence; otherwise it is
+// ignored. Replay protection is left to the caller, and must only be updated
+// once a packet has been opened.
+func (e *ESP) Open(dst []byte, seqHigh uint32, packet []byte) ([]byte, byte, error) {
+	spi, seqLow, err := ParseESPHeader(packet)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if spi != e.spi {
+		return nil, 0, ErrWrongSPI
+	}
+
+	iv := packet[ESPHeaderSize : ESPHeaderSize+IVSize]
+	seq := uint64(seqHigh)<<32 | uint64(seqLow)
+
+	out, err := e.t.aead.Open(dst, e.t.nonce(iv), packet[ESPHeaderSize+IVSize:], e.aad(seq))
+	if err != nil {
+		return nil, 0, ErrOpen
+	}
+
+	plaintext := out[len(dst):]
+	nextHeader := plaintext[len(plaintext)-1]
+	padLength := int(plaintext[len(plaintext)-2])
+	if padLength > len(plaintext)-2 {
+		return nil, 0, ErrInvalidPadding
+	}
+
+	n := len(plaintext) - 2 - padLength
+	for i, b := range plaintext[n : n+padLength] {
+		if b != byte(i+1) {
+			return nil, 0, ErrInvalidPadding
+		}
+	}
+
+	return out[:len(dst)+n], nextHeader, nil
+}
+
+// EstimateSequence returns the full 64-bit sequence number of a packet with
+// the given low 32 bits, given the highest sequence number authenticated so
+// far and the size of the replay window, as in RFC 4303 Appendix A. The
+// window must be at least 1. Packets from before the window are assumed to be
+// from after a wrap of the low 32 bits; if they are not, they will fail to
+// authenticate.
+func EstimateSequence(seqLow uint32, highest uint64, window uint32) uint64 {
+	tl, th := uint32(highest), uint32(highest>>32)
+	bottom := tl - window + 1
+
+	if tl >= window-1 {
+		if seqLow < bottom {
+			th++
+		}
+	} else if seqLow >= bottom {
+		th--
+	}
+
+	return uint64(th)<<32 | uint64(seqLow)
+}
@@ -0,0 +1,105 @@
+package ipsec
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	// IKEHeaderSize is the length of an IKEv2 message header, in bytes.
+	IKEHeaderSize = 28
+	// PayloadTypeSK is the payload type of the Encrypted and Authenticated
+	// payload.
+	PayloadTypeSK = 46
+
+	skHeaderSize = 4
+)
+
+var (
+	// ErrInvalidMessage is returned when an IKEv2 message is too short, has
+	// inconsistent lengths, or does not begin with an SK payload.
+	ErrInvalidMessage = errors.New("invalid IKEv2 message")
+	// ErrMessageTooLong is returned when the payloads are too long for an SK
+	// payload.
+	ErrMessageTooLong = errors.New("message too long")
+)
+
+// IKE encrypts and decrypts the SK payloads of IKEv2 messages sent in one
+// direction, with either SK_ei and SK_ai or SK_er and SK_ar.
+type IKE struct {
+	t *transform
+}
+
+// NewIKE returns an IKE for the given 36 bytes of keying material. The
+// transform has no separate integrity key.
+func NewIKE(keymat []byte) (*IKE, error) {
+	t, err := newTransform(keymat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IKE{t: t}, nil
+}
+
+// Seal encrypts the payloads of a message, the first of which has type
+// nextPayload, into an SK payload, and appends the message to dst. The
+// 28-byte IKE header is copied from header, with its Next Payload and Length
+// fields set for the SK payload. The header and the SK payload's own header
+// are authenticated but not encrypted.
+func (k *IKE) Seal(dst, header []byte, nextPayload byte, iv uint64, payloads []byte) ([]byte, error) {
+	if len(header) != IKEHeaderSize {
+		return nil, ErrInvalidMessage
+	}
+
+	// no padding is needed, only the Pad Length
+	plaintext := append(append(make([]byte, 0, len(payloads)+1), payloads...), 0)
+
+	skLength := skHeaderSize + IVSize + len(plaintext) + ICVSize
+	if skLength > math.MaxUint16 {
+		return nil, ErrMessageTooLong
+	}
+
+	start := len(dst)
+	out := append(dst, header...)
+	out[start+16] = PayloadTypeSK
+	binary.BigEndian.PutUint32(out[start+24:], uint32(IKEHeaderSize+skLength))
+
+	out = append(out, nextPayload, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(skLength))
+
+	aad := out[start:]
+	out = appendIV(out, iv)
+	nonce := k.t.nonce(out[len(out)-IVSize:])
+
+	return k.t.aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// Open authenticates and decrypts a message's SK payload, and appends the
+// payloads it contains to dst, returning the type of the first one.
+func (k *IKE) Open(dst, message []byte) ([]byte, byte, error) {
+	if len(message) < IKEHeaderSize+skHeaderSize+IVSize+1+ICVSize ||
+		message[16] != PayloadTypeSK ||
+		binary.BigEndian.Uint32(message[24:]) != uint32(len(message)) ||
+		int(binary.BigEndian.Uint16(message[IKEHeaderSize+2:])) != len(message)-IKEHeaderSize {
+		return nil, 0, ErrInvalidMessage
+	}
+
+	nextPayload := message[IKEHeaderSize]
+	aad := message[:IKEHeaderSize+skHeaderSize]
+	iv := message[IKEHeaderSize+skHeaderSize : IKEHeaderSize+skHeaderSize+IVSize]
+
+	out, err := k.t.aead.Open(dst, k.t.nonce(iv), message[len(aad)+IVSize:], aad)
+	if err != nil {
+		return nil, 0, ErrOpen
+	}
+
+	// the padding's contents are arbitrary
+	plaintext := out[len(dst):]
+	padLength := int(plaintext[len(plaintext)-1])
+	if padLength > len(plaintext)-1 {
+		return nil, 0, ErrInvalidPadding
+	}
+
+	return out[:len(out)-1-padLength], nextPayload, nil
+}
@@ -0,0 +1,75 @@
+// Package ipsec implements the ChaCha20-Poly1305 transform for IPsec from
+// RFC 7634, in ESP packets and in the Encrypted (SK) payloads of IKEv2.
+//
+// Each key is 36 bytes of keying material: a 256-bit ChaCha20-Poly1305 key
+// followed by a 4-byte salt. The nonce for each packet or message is the salt
+// followed by an explicit 8-byte IV which is sent with it. The IV must never
+// be repeated under the same key, and a counter, such as the sequence
+// number, is a good choice.
+//
+// For more information, see https://tools.ietf.org/html/rfc7634
+package ipsec
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// KeySize is the length of the keying material for one direction of a
+	// security association, in bytes.
+	KeySize = chacha20poly1305.KeySize + SaltSize
+	// SaltSize is the length of the salt at the end of the keying material,
+	// in bytes.
+	SaltSize = 4
+	// IVSize is the length of the explicit IV, in bytes.
+	IVSize = 8
+	// ICVSize is the length of the integrity check value, in bytes.
+	ICVSize = chacha20poly1305.Overhead
+)
+
+var (
+	// ErrInvalidKey is returned when the keying material is not 36 bytes long.
+	ErrInvalidKey = errors.New("invalid keying material")
+	// ErrInvalidPadding is returned when a decrypted packet or message has
+	// malformed padding.
+	ErrInvalidPadding = errors.New("invalid padding")
+	// ErrOpen is returned when a packet or message cannot be authenticated,
+	// either because it was not sealed with the given key or because it was
+	// modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// transform is ChaCha20-Poly1305 with the salt from its keying material.
+type transform struct {
+	aead cipher.AEAD
+	salt [SaltSize]byte
+}
+
+func newTransform(keymat []byte) (*transform, error) {
+	if len(keymat) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	aead, err := chacha20poly1305.New(keymat[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transform{aead: aead}
+	copy(t.salt[:], keymat[chacha20poly1305.KeySize:])
+	return t, nil
+}
+
+// nonce returns the salt followed by the IV.
+func (t *transform) nonce(iv []byte) []byte {
+	return append(t.salt[:len(t.salt):len(t.salt)], iv...)
+}
+
+// appendIV appends an IV to b, big-endian.
+func appendIV(b []byte, iv uint64) []byte {
+	return binary.BigEndian.AppendUint64(b, iv)
+}
@@ -0,0 +1,294 @@
+package ipsec_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/ipsec"
+)
+
+// These are the keying material, SPI, sequence number, IV, IKE header and
+// payloads of the examples in RFC 7634 Appendix A. The ESP payload is the
+// ICMP echo request in IPv4 of Appendix A.1, and the first packet is the one
+// given there; the second, with an extended sequence number, was produced
+// with libsodium. The IKE message is the one in Appendix A.2.
+const (
+	testKeymat = "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f" +
+		"a0a1a2a3"
+	testSPI     = 0x01020304
+	testSeq     = 0x05060708
+	testIV      = 0x1011121314151617
+	testPayload = "45000054a6f200004001e778c6336405c000020508005b7a3a080000553bec1000073627" +
+		"08090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b" +
+		"2c2d2e2f3031323334353637"
+
+	testIKEHeader   = "c0c1c2c3c4c5c6c7d0d1d2d3d4d5d6d72e2025080000000a00000045"
+	testIKEPayloads = "0000000c000040010000000a"
+	testIKEMessage  = "c0c1c2c3c4c5c6c7d0d1d2d3d4d5d6d72e2025080000000a0000004529000029101112" +
+		"1314151617610394701f8d017f7c1292488944e4d5419bd81f7556202c92cf9c2482"
+)
+
+type testVector struct {
+	esn     bool
+	seqHigh uint32
+	packet  string
+}
+
+var testVectors = []testVector{
+	testVector{
+		false,
+		0,
+		"0102030405060708101112131415161724039428b97f417e3c13753a4f05087b67c352e6" +
+			"a7fab1b982d466ef407ae5c614ee8099d52844eb61aa95dfab4c02f72aa71e7c4c4f64c9" +
+			"befe2facc638e8f3cbec163fac469b502773f6fb94e664da9165b82829f641e02ca5ec9f" +
+			"4a64337ede6b8a2aa61068ce",
+	},
+	testVector{
+		true,
+		1,
+		"0102030405060708101112131415161724039428b97f417e3c13753a4f05087b67c352e6" +
+			"a7fab1b982d466ef407ae5c614ee8099d52844eb61aa95dfab4c02f72aa71e7c4c4f64c9" +
+			"befe2facc638e8f3cbec163fac469b502773f6fb94e664da9165b82829f641e0dec270ba" +
+			"9f2148388d78cbe3dd43b921",
+	},
+}
+
+func TestESPSeal(t *testing.T) {
+	keymat, _ := hex.DecodeString(testKeymat)
+	payload, _ := hex.DecodeString(testPayload)
+
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		e, err := ipsec.NewESP(keymat, testSPI, vector.esn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packet, err := e.Seal(nil, uint64(vector.seqHigh)<<32|testSeq, testIV, 4, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(packet) != vector.packet {
+			t.Errorf("Bad packet: expected %s, was %x", vector.packet, packet)
+		}
+	}
+}
+
+func TestESPOpen(t *testing.T) {
+	keymat, _ := hex.DecodeString(testKeymat)
+
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		e, err := ipsec.NewESP(keymat, testSPI, vector.esn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packet, _ := hex.DecodeString(vector.packet)
+
+		spi, seq, err := ipsec.ParseESPHeader(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if spi != testSPI || seq != testSeq {
+			t.Errorf("Bad header: SPI %x, sequence number %x", spi, seq)
+		}
+
+		payload, nextHeader, err := e.Open(nil, vector.seqHigh, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(payload) != testPayload {
+			t.Errorf("Bad payload: expected %s, was %x", testPayload, payload)
+		}
+
+		if nextHeader != 4 {
+			t.Errorf("Bad next header: expected 4, was %d", nextHeader)
+		}
+
+		if vector.esn {
+			if _, _, err := e.Open(nil, 0, packet); err != ipsec.ErrOpen {
+				t.Error("Should have rejected the wrong high sequence number")
+			}
+		}
+
+		packet[4] ^= 1
+		if _, _, err := e.Open(nil, vector.seqHigh, packet); err != ipsec.ErrOpen {
+			t.Error("Should have rejected a modified sequence number")
+		}
+
+		packet[0] ^= 1
+		if _, _, err := e.Open(nil, vector.seqHigh, packet); err != ipsec.ErrWrongSPI {
+			t.Error("Should have rejected a different SPI")
+		}
+
+		if _, _, err := e.Open(nil, vector.seqHigh, packet[:33]); err != ipsec.ErrInvalidPacket {
+			t.Error("Should have rejected a short packet")
+		}
+	}
+}
+
+func TestESPPadding(t *testing.T) {
+	keymat, _ := hex.DecodeString(testKeymat)
+
+	e, err := ipsec.NewESP(keymat, testSPI, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 0; n < 8; n++ {
+		payload := bytes.Repeat([]byte{0xff}, n)
+
+		packet, err := e.Seal(nil, uint64(n), uint64(n), ipsec.NextHeaderNone, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (len(packet)-ipsec.ESPHeaderSize-ipsec.IVSize-ipsec.ICVSize)%4 != 0 {
+			t.Errorf("Bad alignment for %d bytes: %d", n, len(packet))
+		}
+
+		opened, nextHeader, err := e.Open(nil, 0, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(payload, opened) || nextHeader != ipsec.NextHeaderNone {
+			t.Errorf("Bad round trip for %d bytes: %x, %d", n, opened, nextHeader)
+		}
+	}
+
+	if _, err := e.Seal(nil, 1<<32, 0, 4, nil); err != ipsec.ErrSequenceOverflow {
+		t.Error("Should have rejected a 64-bit sequence number without ESN")
+	}
+}
+
+func TestEstimateSequence(t *testing.T) {
+	estimates := []struct {
+		seqLow  uint32
+		highest uint64
+		window  uint32
+		seq     uint64
+	}{
+		// within and ahead of the window
+		{90, 100, 64, 90},
+		{200, 100, 64, 200},
+		// after the low bits wrap
+		{5, 0xfffffff0, 64, 0x100000005},
+		// before the window, so after the next wrap
+		{10, 100, 64, 0x10000000a},
+		// the window spans a wrap
+		{0xfffffff0, 0x100000005, 64, 0xfffffff0},
+		{7, 0x100000005, 64, 0x100000007},
+	}
+
+	for i, v := range estimates {
+		if seq := ipsec.EstimateSequence(v.seqLow, v.highest, v.window); seq != v.seq {
+			t.Errorf("Bad estimate %d: expected %x, was %x", i, v.seq, seq)
+		}
+	}
+}
+
+func TestIKESeal(t *testing.T) {
+	keymat, _ := hex.DecodeString(testKeymat)
+	header, _ := hex.DecodeString(testIKEHeader)
+	payloads, _ := hex.DecodeString(testIKEPayloads)
+
+	k, err := ipsec.NewIKE(keymat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the header's length is recalculated
+	header[27] = 0
+	message, err := k.Seal(nil, header, 41, testIV, payloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(message) != testIKEMessage {
+		t.Errorf("Bad message: expected %s, was %x", testIKEMessage, message)
+	}
+}
+
+func TestIKEOpen(t *testing.T) {
+	keymat, _ := hex.DecodeString(testKeymat)
+	message, _ := hex.DecodeString(testIKEMessage)
+
+	k, err := ipsec.NewIKE(keymat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads, nextPayload, err := k.Open(nil, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(payloads) != testIKEPayloads {
+		t.Errorf("Bad payloads: expected %s, was %x", testIKEPayloads, payloads)
+	}
+
+	if nextPayload != 41 {
+		t.Errorf("Bad next payload: expected 41, was %d", nextPayload)
+	}
+
+	// the message ID is authenticated
+	message[23] ^= 1
+	if _, _, err := k.Open(nil, message); err != ipsec.ErrOpen {
+		t.Error("Should have rejected a modified header")
+	}
+
+	if _, _, err := k.Open(nil, message[:len(message)-1]); err != ipsec.ErrInvalidMessage {
+		t.Error("Should have rejected a truncated message")
+	}
+}
+
+func TestBadKeymat(t *testing.T) {
+	if _, err := ipsec.NewESP(make([]byte, 32), 1, false); err != ipsec.ErrInvalidKey {
+		t.Error("Should have rejected keying material without a salt")
+	}
+
+	if _, err := ipsec.NewIKE(make([]byte, 32)); err != ipsec.ErrInvalidKey {
+		t.Error("Should have rejected keying material without a salt")
+	}
+}
+
+func ExampleESP() {
+	keymat, err := hex.DecodeString("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9fa0a1a2a3")
+	if err != nil {
+		panic(err)
+	}
+
+	sender, err := ipsec.NewESP(keymat, 0x1000, false)
+	if err != nil {
+		panic(err)
+	}
+
+	receiver, err := ipsec.NewESP(keymat, 0x1000, false)
+	if err != nil {
+		panic(err)
+	}
+
+	// the sequence number doubles as the IV
+	packet, err := sender.Seal(nil, 1, 1, ipsec.NextHeaderNone, []byte("hello I am a tunneled packet"))
+	if err != nil {
+		panic(err)
+	}
+
+	payload, _, err := receiver.Open(nil, 0, packet)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", payload)
+	// Output:
+	// hello I am a tunneled packet
+}
@@ -0,0 +1,490 @@
+// Package jwe provides JSON Web Encryption (RFC 7516) with the ChaCha20-based
+// content encryption algorithms from draft-amringer-jose-chacha: C20P, which
+// is ChaCha20-Poly1305 with a 96-bit IV, and XC20P, which is
+// XChaCha20-Poly1305 with a 192-bit IV.
+//
+// The content encryption key is either shared directly ("dir"), or derived
+// with ECDH-ES from an ephemeral key and the recipient's X25519, P-256, P-384
+// or P-521 public key, using the Concat KDF from RFC 7518 section 4.6. Both
+// key management algorithms produce a single recipient with an empty
+// encrypted key.
+//
+// Tokens can be read and written in the compact serialization, and in the
+// flattened JSON serialization. The general JSON serialization is accepted
+// when it has exactly one recipient. The "zip" header and critical header
+// extensions are not supported, and tokens which use them are rejected.
+//
+// For more information, see https://tools.ietf.org/html/rfc7516 and
+// https://tools.ietf.org/html/draft-amringer-jose-chacha-02
+package jwe
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// AlgorithmDirect uses a shared symmetric key as the content encryption
+	// key.
+	AlgorithmDirect = "dir"
+	// AlgorithmECDHES derives the content encryption key from an ECDH key
+	// agreement between an ephemeral key and the recipient's key.
+	AlgorithmECDHES = "ECDH-ES"
+
+	// EncryptionC20P is ChaCha20-Poly1305 with a 96-bit IV.
+	EncryptionC20P = "C20P"
+	// EncryptionXC20P is XChaCha20-Poly1305 with a 192-bit IV.
+	EncryptionXC20P = "XC20P"
+
+	// KeySize is the length of content encryption keys, in bytes.
+	KeySize = chacha20poly1305.KeySize
+
+	tagSize = chacha20poly1305.Overhead
+)
+
+var (
+	// ErrUnsupportedAlgorithm is returned when the "alg" header is missing or
+	// is not a supported key management algorithm.
+	ErrUnsupportedAlgorithm = errors.New("unsupported key management algorithm")
+	// ErrUnsupportedEncryption is returned when the "enc" header is missing or
+	// is not a supported content encryption algorithm.
+	ErrUnsupportedEncryption = errors.New("unsupported content encryption algorithm")
+	// ErrUnsupportedHeader is returned when the header uses compression or
+	// critical extensions.
+	ErrUnsupportedHeader = errors.New("unsupported header parameter")
+	// ErrInvalidKey is returned when the key is of the wrong type or size for
+	// the key management algorithm, or is on the wrong curve.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrMalformed is returned when a token or its header cannot be parsed or
+	// is inconsistent with its algorithms.
+	ErrMalformed = errors.New("malformed JWE")
+	// ErrNotCompact is returned when a JWE with additional authenticated data
+	// or unprotected headers is serialized in the compact serialization.
+	ErrNotCompact = errors.New("JWE cannot use the compact serialization")
+	// ErrOpen is returned when the ciphertext cannot be authenticated.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// A Header is a JOSE header. Members which are not listed are ignored when
+// parsing and are not preserved when encrypting.
+type Header struct {
+	Algorithm   string `json:"alg,omitempty"`
+	Encryption  string `json:"enc,omitempty"`
+	KeyID       string `json:"kid,omitempty"`
+	Type        string `json:"typ,omitempty"`
+	ContentType string `json:"cty,omitempty"`
+
+	// EphemeralPublicKey is set by Encrypt for ECDH-ES.
+	EphemeralPublicKey *JWK `json:"epk,omitempty"`
+	// AgreementPartyUInfo and AgreementPartyVInfo are optional
+	// base64url-encoded values which are mixed into the ECDH-ES key
+	// derivation.
+	AgreementPartyUInfo string `json:"apu,omitempty"`
+	AgreementPartyVInfo string `json:"apv,omitempty"`
+
+	// Compression and Critical are not supported, and tokens which use them
+	// are rejected.
+	Compression string   `json:"zip,omitempty"`
+	Critical    []string `json:"crit,omitempty"`
+}
+
+// A JWE is an encrypted message.
+type JWE struct {
+	// Header is the union of the protected and unprotected headers.
+	Header Header
+	// AAD is the additional authenticated data, which is only available in
+	// the JSON serialization.
+	AAD []byte
+
+	protected    string          // the encoded protected header
+	unprotected  json.RawMessage // the shared unprotected header
+	perRecipient json.RawMessage // the per-recipient unprotected header
+	encryptedKey []byte
+	iv           []byte
+	ciphertext   []byte
+	tag          []byte
+}
+
+var b64 = base64.RawURLEncoding.Strict()
+
+// Encrypt encrypts plaintext and the optional additional authenticated data
+// with the algorithms named in header, which must set Algorithm and
+// Encryption. For "dir" the key must be a KeySize-byte []byte, and for
+// "ECDH-ES" it must be an *ecdh.PublicKey. The header becomes the protected
+// header of the result.
+func Encrypt(header Header, key interface{}, plaintext, aad []byte) (*JWE, error) {
+	if header.Compression != "" || len(header.Critical) != 0 {
+		return nil, ErrUnsupportedHeader
+	}
+
+	cek, err := encryptionKey(&header, key)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newAEAD(header.Encryption, cek)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := json.Marshal(&header)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &JWE{
+		Header:    header,
+		AAD:       aad,
+		protected: b64.EncodeToString(protected),
+		iv:        make([]byte, a.NonceSize()),
+	}
+
+	if _, err := rand.Read(j.iv); err != nil {
+		return nil, err
+	}
+
+	sealed := a.Seal(nil, j.iv, plaintext, j.authenticatedData())
+	j.ciphertext = sealed[:len(plaintext)]
+	j.tag = sealed[len(plaintext):]
+
+	return j, nil
+}
+
+// Decrypt authenticates and decrypts the JWE. For "dir" the key must be a
+// KeySize-byte []byte, and for "ECDH-ES" it must be an *ecdh.PrivateKey.
+func (j *JWE) Decrypt(key interface{}) ([]byte, error) {
+	cek, err := decryptionKey(&j.Header, key)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newAEAD(j.Header.Encryption, cek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(j.encryptedKey) != 0 || len(j.iv) != a.NonceSize() || len(j.tag) != tagSize {
+		return nil, ErrMalformed
+	}
+
+	sealed := make([]byte, 0, len(j.ciphertext)+len(j.tag))
+	sealed = append(sealed, j.ciphertext...)
+	sealed = append(sealed, j.tag...)
+
+	plaintext, err := a.Open(sealed[:0], j.iv, sealed, j.authenticatedData())
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return plaintext, nil
+}
+
+// authenticatedData returns the encoded protected header, followed by the
+// encoded additional authenticated data if there is any.
+func (j *JWE) authenticatedData() []byte {
+	if len(j.AAD) == 0 {
+		return []byte(j.protected)
+	}
+	return []byte(j.protected + "." + b64.EncodeToString(j.AAD))
+}
+
+func newAEAD(enc string, key []byte) (cipher.AEAD, error) {
+	switch enc {
+	case EncryptionC20P:
+		return chacha20poly1305.New(key)
+	case EncryptionXC20P:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, ErrUnsupportedEncryption
+	}
+}
+
+// encryptionKey returns the content encryption key for the sender, setting
+// the ephemeral key in the header for ECDH-ES.
+func encryptionKey(header *Header, key interface{}) ([]byte, error) {
+	switch header.Algorithm {
+	case AlgorithmDirect:
+		k, ok := key.([]byte)
+		if !ok || len(k) != KeySize {
+			return nil, ErrInvalidKey
+		}
+		return k, nil
+	case AlgorithmECDHES:
+		pub, ok := key.(*ecdh.PublicKey)
+		if !ok {
+			return nil, ErrInvalidKey
+		}
+
+		ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		header.EphemeralPublicKey, err = newJWK(ephemeral.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+
+		z, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		return deriveKey(header, z)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// decryptionKey returns the content encryption key for the recipient.
+func decryptionKey(header *Header, key interface{}) ([]byte, error) {
+	switch header.Algorithm {
+	case AlgorithmDirect:
+		k, ok := key.([]byte)
+		if !ok || len(k) != KeySize {
+			return nil, ErrInvalidKey
+		}
+		return k, nil
+	case AlgorithmECDHES:
+		priv, ok := key.(*ecdh.PrivateKey)
+		if !ok {
+			return nil, ErrInvalidKey
+		}
+
+		if header.EphemeralPublicKey == nil {
+			return nil, ErrMalformed
+		}
+
+		epk, err := header.EphemeralPublicKey.publicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if epk.Curve() != priv.Curve() {
+			return nil, ErrInvalidKey
+		}
+
+		z, err := priv.ECDH(epk)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		return deriveKey(header, z)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// deriveKey derives the content encryption key from an ECDH-ES shared secret.
+// For direct key agreement, the algorithm ID is the "enc" value.
+func deriveKey(header *Header, z []byte) ([]byte, error) {
+	if _, err := newAEAD(header.Encryption, make([]byte, KeySize)); err != nil {
+		return nil, err
+	}
+
+	apu, err := b64.DecodeString(header.AgreementPartyUInfo)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	apv, err := b64.DecodeString(header.AgreementPartyVInfo)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return concatKDF(z, []byte(header.Encryption), apu, apv, KeySize), nil
+}
+
+// CompactSerialize returns the JWE in the compact serialization, which has
+// no room for additional authenticated data or unprotected headers.
+func (j *JWE) CompactSerialize() (string, error) {
+	if len(j.AAD) != 0 || len(j.unprotected) != 0 || len(j.perRecipient) != 0 {
+		return "", ErrNotCompact
+	}
+
+	return strings.Join([]string{
+		j.protected,
+		b64.EncodeToString(j.encryptedKey),
+		b64.EncodeToString(j.iv),
+		b64.EncodeToString(j.ciphertext),
+		b64.EncodeToString(j.tag),
+	}, "."), nil
+}
+
+// ParseCompact parses a JWE in the compact serialization.
+func ParseCompact(s string) (*JWE, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 5 {
+		return nil, ErrMalformed
+	}
+
+	var fields [4][]byte
+	for i := range fields {
+		b, err := b64.DecodeString(parts[i+1])
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		fields[i] = b
+	}
+
+	j := &JWE{
+		protected:    parts[0],
+		encryptedKey: fields[0],
+		iv:           fields[1],
+		ciphertext:   fields[2],
+		tag:          fields[3],
+	}
+
+	if err := j.parseHeaders(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+type jsonRecipient struct {
+	Header       json.RawMessage `json:"header,omitempty"`
+	EncryptedKey string          `json:"encrypted_key,omitempty"`
+}
+
+type jsonJWE struct {
+	Protected   string          `json:"protected,omitempty"`
+	Unprotected json.RawMessage `json:"unprotected,omitempty"`
+	jsonRecipient
+	Recipients []jsonRecipient `json:"recipients,omitempty"`
+	AAD        string          `json:"aad,omitempty"`
+	IV         string          `json:"iv"`
+	Ciphertext string          `json:"ciphertext"`
+	Tag        string          `json:"tag"`
+}
+
+// JSONSerialize returns the JWE in the flattened JSON serialization.
+func (j *JWE) JSONSerialize() ([]byte, error) {
+	v := jsonJWE{
+		Protected:   j.protected,
+		Unprotected: j.unprotected,
+		jsonRecipient: jsonRecipient{
+			Header:       j.perRecipient,
+			EncryptedKey: b64.EncodeToString(j.encryptedKey),
+		},
+		IV:         b64.EncodeToString(j.iv),
+		Ciphertext: b64.EncodeToString(j.ciphertext),
+		Tag:        b64.EncodeToString(j.tag),
+	}
+
+	if len(j.AAD) != 0 {
+		v.AAD = b64.EncodeToString(j.AAD)
+	}
+
+	return json.Marshal(&v)
+}
+
+// ParseJSON parses a JWE in the flattened JSON serialization, or in the
+// general JSON serialization with a single recipient.
+func ParseJSON(data []byte) (*JWE, error) {
+	var v jsonJWE
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, ErrMalformed
+	}
+
+	r := v.jsonRecipient
+	if v.Recipients != nil {
+		if len(v.Recipients) != 1 || r.Header != nil || r.EncryptedKey != "" {
+			return nil, ErrMalformed
+		}
+		r = v.Recipients[0]
+	}
+
+	j := &JWE{
+		protected:    v.Protected,
+		unprotected:  v.Unprotected,
+		perRecipient: r.Header,
+	}
+
+	for _, f := range []struct {
+		dst *[]byte
+		s   string
+	}{
+		{&j.encryptedKey, r.EncryptedKey},
+		{&j.AAD, v.AAD},
+		{&j.iv, v.IV},
+		{&j.ciphertext, v.Ciphertext},
+		{&j.tag, v.Tag},
+	} {
+		b, err := b64.DecodeString(f.s)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		*f.dst = b
+	}
+
+	if err := j.parseHeaders(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// parseHeaders decodes the protected and unprotected headers, which must not
+// share any members, into the JWE's header and validates it.
+func (j *JWE) parseHeaders() error {
+	protected, err := b64.DecodeString(j.protected)
+	if err != nil {
+		return ErrMalformed
+	}
+
+	merged := make(map[string]json.RawMessage)
+	for _, h := range []json.RawMessage{protected, j.unprotected, j.perRecipient} {
+		if len(h) == 0 {
+			continue
+		}
+
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(h, &members); err != nil || members == nil {
+			return ErrMalformed
+		}
+
+		for k, v := range members {
+			if _, ok := merged[k]; ok {
+				return ErrMalformed
+			}
+			merged[k] = v
+		}
+	}
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, &j.Header); err != nil {
+		return ErrMalformed
+	}
+
+	switch j.Header.Algorithm {
+	case AlgorithmDirect, AlgorithmECDHES:
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	switch j.Header.Encryption {
+	case EncryptionC20P, EncryptionXC20P:
+	default:
+		return ErrUnsupportedEncryption
+	}
+
+	if _, ok := merged["zip"]; ok {
+		return ErrUnsupportedHeader
+	}
+
+	if _, ok := merged["crit"]; ok {
+		return ErrUnsupportedHeader
+	}
+
+	return nil
+}
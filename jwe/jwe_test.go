@@ -0,0 +1,309 @@
+package jwe_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/codahale/chacha20/jwe"
+)
+
+type testVector struct {
+	json  bool
+	key   string // the shared key, or the recipient's X25519 private key
+	token string
+}
+
+const testPlaintext = "Live long and prosper."
+
+// sealed with libsodium's crypto_aead_chacha20poly1305_ietf_encrypt for C20P
+// and crypto_aead_xchacha20poly1305_ietf_encrypt for XC20P, with the iv as the
+// nonce and the encoded protected header as the additional data, followed by
+// "." and the encoded aad in the JSON serialization. The ECDH-ES content key
+// is the first 32 bytes of the SHA-256 hash of the counter 1, the X25519
+// shared secret computed by libsodium's crypto_scalarmult from the ephemeral
+// private key 808182...9f, and the Concat KDF's OtherInfo for "XC20P" with
+// "Alice" and "Bob" as apu and apv.
+var testVectors = []testVector{
+	testVector{
+		false,
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		"eyJhbGciOiJkaXIiLCJlbmMiOiJDMjBQIn0..AAECAwQFBgcICQoL." +
+			"xZJ-ZQl7yi7Qo16d_D1-EaYDwoIjWg.q5PHI7pwLNUFx_AkSG3y9g",
+	},
+	testVector{
+		false,
+		"404142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f",
+		"eyJhbGciOiJFQ0RILUVTIiwiZW5jIjoiWEMyMFAiLCJlcGsiOnsia3R5IjoiT0tQIiwiY3J2" +
+			"IjoiWDI1NTE5IiwieCI6IlNUNkNfSFJHU2xrbWlCZGlQU0JUeGV1T0xNU3BpTFQtNFhuc2F3" +
+			"RU5VeDAifSwiYXB1IjoiUVd4cFkyVSIsImFwdiI6IlFtOWkifQ..AAECAwQFBgcICQoLDA0O" +
+			"DxAREhMUFRYX.DUc2t2lEyJKRXjvtyf6SVeBG5k8LOQ.yJfNufE0p8ni4xzJ1QUztg",
+	},
+	testVector{
+		true,
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		`{"protected":"eyJlbmMiOiJYQzIwUCJ9","unprotected":{"kid":"k1"},` +
+			`"header":{"alg":"dir"},"aad":"c29tZSBhZGRpdGlvbmFsIGRhdGE",` +
+			`"iv":"ZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXp7",` +
+			`"ciphertext":"MBqP1d9C5uzgEPxRwk_NuzTIIhSWAg","tag":"ZDoSTyd5pZ5_4daHPcQzIg"}`,
+	},
+}
+
+func parse(json bool, token string) (*jwe.JWE, error) {
+	if json {
+		return jwe.ParseJSON([]byte(token))
+	}
+	return jwe.ParseCompact(token)
+}
+
+func TestDecrypt(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		k, _ := hex.DecodeString(vector.key)
+
+		j, err := parse(vector.json, vector.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var key interface{} = k
+		if j.Header.Algorithm == jwe.AlgorithmECDHES {
+			key, err = ecdh.X25519().NewPrivateKey(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		plaintext, err := j.Decrypt(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plaintext) != testPlaintext {
+			t.Errorf("Bad plaintext: expected %q, was %q", testPlaintext, plaintext)
+		}
+
+		// change the first character of the ciphertext
+		modified := []byte(vector.token)
+		n := strings.Index(vector.token, `"ciphertext":"`) + len(`"ciphertext":"`)
+		if !vector.json {
+			parts := strings.Split(vector.token, ".")
+			n = len(strings.Join(parts[:3], ".")) + 1
+		}
+		if modified[n] == 'A' {
+			modified[n] = 'B'
+		} else {
+			modified[n] = 'A'
+		}
+
+		j, err = parse(vector.json, string(modified))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := j.Decrypt(key); err != jwe.ErrOpen {
+			t.Error("Should have rejected a modified ciphertext")
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, curve := range []ecdh.Curve{ecdh.X25519(), ecdh.P256(), ecdh.P384(), ecdh.P521()} {
+		for _, enc := range []string{jwe.EncryptionC20P, jwe.EncryptionXC20P} {
+			t.Logf("Running %v with %s", curve, enc)
+
+			priv, err := curve.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header := jwe.Header{
+				Algorithm:           jwe.AlgorithmECDHES,
+				Encryption:          enc,
+				KeyID:               "recipient",
+				AgreementPartyVInfo: base64.RawURLEncoding.EncodeToString([]byte("Bob")),
+			}
+
+			j, err := jwe.Encrypt(header, priv.PublicKey(), []byte(testPlaintext), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := j.CompactSerialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			j, err = jwe.ParseCompact(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if j.Header.KeyID != "recipient" {
+				t.Errorf("Bad key ID: expected %q, was %q", "recipient", j.Header.KeyID)
+			}
+
+			plaintext, err := j.Decrypt(priv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(plaintext) != testPlaintext {
+				t.Errorf("Bad plaintext: expected %q, was %q", testPlaintext, plaintext)
+			}
+
+			other, _ := ecdh.P256().GenerateKey(rand.Reader)
+			if curve != ecdh.P256() {
+				if _, err := j.Decrypt(other); err != jwe.ErrInvalidKey {
+					t.Error("Should have rejected a key on the wrong curve")
+				}
+			}
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	key := make([]byte, jwe.KeySize)
+	header := jwe.Header{Algorithm: jwe.AlgorithmDirect, Encryption: jwe.EncryptionXC20P}
+
+	j, err := jwe.Encrypt(header, key, []byte(testPlaintext), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := j.CompactSerialize(); err != jwe.ErrNotCompact {
+		t.Error("Should not have used the compact serialization with AAD")
+	}
+
+	data, err := j.JSONSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = jwe.ParseJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(j.AAD) != "aad" {
+		t.Errorf("Bad AAD: expected %q, was %q", "aad", j.AAD)
+	}
+
+	plaintext, err := j.Decrypt(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != testPlaintext {
+		t.Errorf("Bad plaintext: expected %q, was %q", testPlaintext, plaintext)
+	}
+}
+
+func TestGeneralJSON(t *testing.T) {
+	key := make([]byte, jwe.KeySize)
+	header := jwe.Header{Algorithm: jwe.AlgorithmDirect, Encryption: jwe.EncryptionC20P}
+
+	j, err := jwe.Encrypt(header, key, []byte(testPlaintext), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := j.CompactSerialize()
+	parts := strings.Split(token, ".")
+	general := fmt.Sprintf(`{"protected":%q,"recipients":[{}],"iv":%q,"ciphertext":%q,"tag":%q}`,
+		parts[0], parts[2], parts[3], parts[4])
+
+	j, err = jwe.ParseJSON([]byte(general))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := j.Decrypt(key); err != nil {
+		t.Error(err)
+	}
+
+	multiple := strings.Replace(general, `[{}]`, `[{},{}]`, 1)
+	if _, err := jwe.ParseJSON([]byte(multiple)); err != jwe.ErrMalformed {
+		t.Error("Should have rejected multiple recipients")
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tokens := []struct {
+		header string
+		err    error
+	}{
+		{`{"alg":"RSA-OAEP","enc":"C20P"}`, jwe.ErrUnsupportedAlgorithm},
+		{`{"alg":"dir","enc":"A256GCM"}`, jwe.ErrUnsupportedEncryption},
+		{`{"enc":"C20P"}`, jwe.ErrUnsupportedAlgorithm},
+		{`{"alg":"dir","enc":"C20P","zip":"DEF"}`, jwe.ErrUnsupportedHeader},
+		{`{"alg":"dir","enc":"C20P","crit":["exp"],"exp":1}`, jwe.ErrUnsupportedHeader},
+		{`{"alg":"dir","enc":"C20P"`, jwe.ErrMalformed},
+	}
+
+	for i, v := range tokens {
+		t.Logf("Running header %d", i)
+
+		token := base64.RawURLEncoding.EncodeToString([]byte(v.header)) +
+			"..AAECAwQFBgcICQoL.AAAA.AAAAAAAAAAAAAAAAAAAAAA"
+		if _, err := jwe.ParseCompact(token); err != v.err {
+			t.Errorf("Bad error: expected %v, was %v", v.err, err)
+		}
+	}
+
+	duplicate := `{"protected":"eyJhbGciOiJkaXIiLCJlbmMiOiJDMjBQIn0","header":{"alg":"dir"},` +
+		`"iv":"AAECAwQFBgcICQoL","ciphertext":"","tag":"AAAAAAAAAAAAAAAAAAAAAA"}`
+	if _, err := jwe.ParseJSON([]byte(duplicate)); err != jwe.ErrMalformed {
+		t.Error("Should have rejected a header member in both headers")
+	}
+}
+
+func TestBadKey(t *testing.T) {
+	header := jwe.Header{Algorithm: jwe.AlgorithmDirect, Encryption: jwe.EncryptionC20P}
+	if _, err := jwe.Encrypt(header, make([]byte, 3), nil, nil); err != jwe.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+
+	priv, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	header.Algorithm = jwe.AlgorithmECDHES
+	if _, err := jwe.Encrypt(header, priv, nil, nil); err != jwe.ErrInvalidKey {
+		t.Error("Should have rejected a private key")
+	}
+}
+
+func ExampleEncrypt() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	header := jwe.Header{Algorithm: jwe.AlgorithmDirect, Encryption: jwe.EncryptionXC20P}
+	j, err := jwe.Encrypt(header, key, []byte("hello I am a secret token"), nil)
+	if err != nil {
+		panic(err)
+	}
+
+	token, err := j.CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+
+	j, err = jwe.ParseCompact(token)
+	if err != nil {
+		panic(err)
+	}
+
+	plaintext, err := j.Decrypt(key)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s %s: %s\n", j.Header.Algorithm, j.Header.Encryption, plaintext)
+	// Output:
+	// dir XC20P: hello I am a secret token
+}
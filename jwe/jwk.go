@@ -0,0 +1,113 @@
+package jwe
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// A JWK is a JSON Web Key (RFC 7517) holding an elliptic curve public key,
+// as used for the ECDH-ES ephemeral public key. X25519 keys have the key type
+// "OKP" (RFC 8037) and no y coordinate, and NIST curve keys have the key type
+// "EC".
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y,omitempty"`
+}
+
+type curveParams struct {
+	name      string
+	curve     ecdh.Curve
+	coordSize int // the size of each coordinate for NIST curves, in bytes
+}
+
+var curves = []curveParams{
+	{"X25519", ecdh.X25519(), 0},
+	{"P-256", ecdh.P256(), 32},
+	{"P-384", ecdh.P384(), 48},
+	{"P-521", ecdh.P521(), 66},
+}
+
+func newJWK(pub *ecdh.PublicKey) (*JWK, error) {
+	for _, c := range curves {
+		if c.curve != pub.Curve() {
+			continue
+		}
+
+		b := pub.Bytes()
+		if c.coordSize == 0 {
+			return &JWK{KeyType: "OKP", Curve: c.name, X: b64.EncodeToString(b)}, nil
+		}
+
+		// NIST public keys are encoded as 0x04 || x || y.
+		return &JWK{
+			KeyType: "EC",
+			Curve:   c.name,
+			X:       b64.EncodeToString(b[1 : 1+c.coordSize]),
+			Y:       b64.EncodeToString(b[1+c.coordSize:]),
+		}, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// publicKey validates the JWK and returns it as an ECDH public key.
+func (k *JWK) publicKey() (*ecdh.PublicKey, error) {
+	for _, c := range curves {
+		if c.name != k.Curve {
+			continue
+		}
+
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+
+		var b []byte
+		if c.coordSize == 0 {
+			if k.KeyType != "OKP" || k.Y != "" {
+				return nil, ErrMalformed
+			}
+			b = x
+		} else {
+			y, err := b64.DecodeString(k.Y)
+			if k.KeyType != "EC" || err != nil || len(x) != c.coordSize || len(y) != c.coordSize {
+				return nil, ErrMalformed
+			}
+			b = append(append([]byte{4}, x...), y...)
+		}
+
+		pub, err := c.curve.NewPublicKey(b)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		return pub, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// concatKDF is the single-step key derivation function from NIST SP 800-56A
+// with SHA-256, with the OtherInfo layout from RFC 7518 section 4.6.2: the
+// length-prefixed algorithm ID, PartyUInfo and PartyVInfo, followed by the
+// key length in bits.
+func concatKDF(z, algorithmID, apu, apv []byte, size int) []byte {
+	var otherInfo []byte
+	for _, f := range [][]byte{algorithmID, apu, apv} {
+		otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(f)))
+		otherInfo = append(otherInfo, f...)
+	}
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(size*8))
+
+	var out []byte
+	for counter := uint32(1); len(out) < size; counter++ {
+		h := sha256.New()
+		var c [4]byte
+		binary.BigEndian.PutUint32(c[:], counter)
+		h.Write(c[:])
+		h.Write(z)
+		h.Write(otherInfo)
+		out = h.Sum(out)
+	}
+	return out[:size]
+}
@@ -0,0 +1,328 @@
+package noise
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrMissingKey is returned when a handshake pattern requires a static
+	// key or a remote static key which was not provided.
+	ErrMissingKey = errors.New("missing key for handshake pattern")
+	// ErrOutOfTurn is returned when a party writes a message when it should
+	// read one, or the other way around.
+	ErrOutOfTurn = errors.New("handshake message out of turn")
+	// ErrHandshakeComplete is returned when a message is written or read after
+	// the handshake is complete.
+	ErrHandshakeComplete = errors.New("handshake complete")
+	// ErrShortMessage is returned when a handshake message is too short to
+	// contain its tokens.
+	ErrShortMessage = errors.New("handshake message too short")
+	// ErrMessageTooLong is returned when a handshake message would be longer
+	// than MaxMessageSize.
+	ErrMessageTooLong = errors.New("handshake message too long")
+)
+
+// Config describes one party's side of a handshake.
+type Config struct {
+	// Pattern is the handshake pattern.
+	Pattern HandshakePattern
+	// Hash is the hash function, crypto.SHA256 or crypto.SHA512.
+	Hash crypto.Hash
+	// Initiator is true if this party sends the first message.
+	Initiator bool
+	// Prologue is data which both parties must agree on, but which is not
+	// sent.
+	Prologue []byte
+	// StaticKey is this party's static X25519 key, if the pattern requires
+	// one.
+	StaticKey *ecdh.PrivateKey
+	// RemoteStaticKey is the other party's static X25519 key, if the pattern
+	// requires it to be known in advance.
+	RemoteStaticKey *ecdh.PublicKey
+	// Random is the source of the ephemeral key. An ephemeral key is made of
+	// 32 bytes read from it. If nil, crypto/rand.Reader is used.
+	Random io.Reader
+}
+
+// A HandshakeState runs one party's side of a handshake. If writing or reading
+// a message fails, the handshake must be abandoned.
+type HandshakeState struct {
+	ss        *SymmetricState
+	s, e      *ecdh.PrivateKey
+	rs, re    *ecdh.PublicKey
+	initiator bool
+	messages  [][]Token
+	index     int
+	random    io.Reader
+}
+
+// NewHandshakeState returns a HandshakeState for the given configuration. The
+// protocol name is Noise_<pattern>_25519_ChaChaPoly_<hash>.
+func NewHandshakeState(c Config) (*HandshakeState, error) {
+	name, err := hashName(c.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := NewSymmetricState("Noise_"+c.Pattern.Name+"_25519_ChaChaPoly_"+name, c.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HandshakeState{
+		ss:        ss,
+		s:         c.StaticKey,
+		rs:        c.RemoteStaticKey,
+		initiator: c.Initiator,
+		messages:  c.Pattern.Messages,
+		random:    c.Random,
+	}
+
+	if h.random == nil {
+		h.random = rand.Reader
+	}
+
+	if (h.s != nil && h.s.Curve() != ecdh.X25519()) || (h.rs != nil && h.rs.Curve() != ecdh.X25519()) {
+		return nil, ErrInvalidKey
+	}
+
+	local, remote := c.Pattern.InitiatorPreMessages, c.Pattern.ResponderPreMessages
+	if !h.initiator {
+		local, remote = remote, local
+	}
+
+	// check that every static key the pattern uses is present
+	needsStatic := hasToken(local, TokenS)
+	for i, m := range h.messages {
+		if (i%2 == 0) == h.initiator && hasToken(m, TokenS) {
+			needsStatic = true
+		}
+	}
+
+	if (needsStatic && h.s == nil) || (hasToken(remote, TokenS) && h.rs == nil) {
+		return nil, ErrMissingKey
+	}
+
+	ss.MixHash(c.Prologue)
+
+	// the pre-messages are hashed in order, the initiator's first
+	if hasToken(c.Pattern.InitiatorPreMessages, TokenS) {
+		if h.initiator {
+			ss.MixHash(h.s.PublicKey().Bytes())
+		} else {
+			ss.MixHash(h.rs.Bytes())
+		}
+	}
+
+	if hasToken(c.Pattern.ResponderPreMessages, TokenS) {
+		if h.initiator {
+			ss.MixHash(h.rs.Bytes())
+		} else {
+			ss.MixHash(h.s.PublicKey().Bytes())
+		}
+	}
+
+	return h, nil
+}
+
+// WriteMessage writes the next handshake message with the given payload and
+// appends it to dst. After the last message of the handshake, it also returns
+// the CipherStates for transport messages from the initiator and from the
+// responder.
+func (h *HandshakeState) WriteMessage(dst, payload []byte) ([]byte, *CipherState, *CipherState, error) {
+	if err := h.checkTurn(true); err != nil {
+		return nil, nil, nil, err
+	}
+
+	out := dst
+	for _, t := range h.messages[h.index] {
+		var err error
+		switch t {
+		case TokenE:
+			var seed [DHSize]byte
+			if _, err = io.ReadFull(h.random, seed[:]); err != nil {
+				return nil, nil, nil, err
+			}
+
+			if h.e, err = ecdh.X25519().NewPrivateKey(seed[:]); err != nil {
+				return nil, nil, nil, err
+			}
+
+			e := h.e.PublicKey().Bytes()
+			out = append(out, e...)
+			h.ss.MixHash(e)
+		case TokenS:
+			out, err = h.ss.EncryptAndHash(out, h.s.PublicKey().Bytes())
+		default:
+			err = h.mixDH(t)
+		}
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	out, err := h.ss.EncryptAndHash(out, payload)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(out)-len(dst) > MaxMessageSize {
+		return nil, nil, nil, ErrMessageTooLong
+	}
+
+	c1, c2 := h.advance()
+	return out, c1, c2, nil
+}
+
+// ReadMessage reads the next handshake message and appends its payload to
+// dst. After the last message of the handshake, it also returns the
+// CipherStates for transport messages from the initiator and from the
+// responder.
+func (h *HandshakeState) ReadMessage(dst, message []byte) ([]byte, *CipherState, *CipherState, error) {
+	if err := h.checkTurn(false); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(message) > MaxMessageSize {
+		return nil, nil, nil, ErrMessageTooLong
+	}
+
+	for _, t := range h.messages[h.index] {
+		var err error
+		switch t {
+		case TokenE:
+			if len(message) < DHSize {
+				return nil, nil, nil, ErrShortMessage
+			}
+
+			if h.re, err = ecdh.X25519().NewPublicKey(message[:DHSize]); err != nil {
+				return nil, nil, nil, ErrInvalidKey
+			}
+
+			h.ss.MixHash(message[:DHSize])
+			message = message[DHSize:]
+		case TokenS:
+			n := DHSize
+			if h.ss.cs.HasKey() {
+				n += TagSize
+			}
+
+			if len(message) < n {
+				return nil, nil, nil, ErrShortMessage
+			}
+
+			var s []byte
+			if s, err = h.ss.DecryptAndHash(nil, message[:n]); err != nil {
+				return nil, nil, nil, err
+			}
+
+			if h.rs, err = ecdh.X25519().NewPublicKey(s); err != nil {
+				return nil, nil, nil, ErrInvalidKey
+			}
+			message = message[n:]
+		default:
+			err = h.mixDH(t)
+		}
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if h.ss.cs.HasKey() && len(message) < TagSize {
+		return nil, nil, nil, ErrShortMessage
+	}
+
+	out, err := h.ss.DecryptAndHash(dst, message)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	c1, c2 := h.advance()
+	return out, c1, c2, nil
+}
+
+// HandshakeHash returns the handshake hash, which uniquely identifies a
+// completed handshake and can be used for channel binding.
+func (h *HandshakeState) HandshakeHash() []byte {
+	return h.ss.HandshakeHash()
+}
+
+// PeerStatic returns the other party's static key, if it is known.
+func (h *HandshakeState) PeerStatic() *ecdh.PublicKey {
+	return h.rs
+}
+
+func (h *HandshakeState) checkTurn(write bool) error {
+	if h.index >= len(h.messages) {
+		return ErrHandshakeComplete
+	}
+
+	if (h.index%2 == 0) == h.initiator != write {
+		return ErrOutOfTurn
+	}
+
+	return nil
+}
+
+// advance moves to the next message, returning the transport CipherStates if
+// the handshake is complete.
+func (h *HandshakeState) advance() (*CipherState, *CipherState) {
+	h.index++
+	if h.index < len(h.messages) {
+		return nil, nil
+	}
+	return h.ss.Split()
+}
+
+// mixDH performs the DH for a token and mixes the result into the chaining
+// key.
+func (h *HandshakeState) mixDH(t Token) error {
+	// the keys of the initiator and the responder for the token
+	var local *ecdh.PrivateKey
+	var remote *ecdh.PublicKey
+	switch t {
+	case TokenEE:
+		local, remote = h.e, h.re
+	case TokenSS:
+		local, remote = h.s, h.rs
+	case TokenES:
+		if h.initiator {
+			local, remote = h.e, h.rs
+		} else {
+			local, remote = h.s, h.re
+		}
+	case TokenSE:
+		if h.initiator {
+			local, remote = h.s, h.re
+		} else {
+			local, remote = h.e, h.rs
+		}
+	}
+
+	if local == nil || remote == nil {
+		return ErrMissingKey
+	}
+
+	shared, err := local.ECDH(remote)
+	if err != nil {
+		return ErrInvalidKey
+	}
+
+	h.ss.MixKey(shared)
+	return nil
+}
+
+func hasToken(tokens []Token, t Token) bool {
+	for _, v := range tokens {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
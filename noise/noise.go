@@ -0,0 +1,280 @@
+// Package noise implements the Noise Protocol Framework with X25519, the
+// ChaChaPoly cipher, and SHA-256 or SHA-512.
+//
+// A HandshakeState runs one of the fundamental handshake patterns, such as
+// NN, XX or IK, and produces a pair of CipherStates for the transport
+// messages which follow: one for each direction. The lower-level
+// SymmetricState and CipherState objects are also exposed for protocols which
+// build on them directly.
+//
+// ChaChaPoly is ChaCha20-Poly1305 with a 96-bit nonce of 32 zero bits
+// followed by the 64-bit message counter, little-endian.
+//
+// For more information, see https://noiseprotocol.org/noise.html
+package noise
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/hkdf"
+	_ "crypto/sha256" // registers crypto.SHA256
+	_ "crypto/sha512" // registers crypto.SHA512
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// KeySize is the length of a cipher key, in bytes.
+	KeySize = chacha20poly1305.KeySize
+	// DHSize is the length of an X25519 public key or shared secret, in bytes.
+	DHSize = 32
+	// TagSize is the length of an authentication tag, in bytes.
+	TagSize = chacha20poly1305.Overhead
+	// MaxMessageSize is the length of the longest Noise message, in bytes.
+	MaxMessageSize = 65535
+
+	// the reserved nonce, used only for rekeying
+	maxNonce = math.MaxUint64
+)
+
+var (
+	// ErrInvalidKey is returned when a cipher key is not 256 bits long, or
+	// when a Diffie-Hellman key is not a valid X25519 key or produces an
+	// all-zero shared secret.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrUnsupportedHash is returned when a hash function other than SHA-256
+	// or SHA-512 is requested.
+	ErrUnsupportedHash = errors.New("unsupported hash function")
+	// ErrNonceExhausted is returned when a CipherState has used every
+	// nonce, and must be rekeyed or replaced.
+	ErrNonceExhausted = errors.New("nonce exhausted")
+	// ErrOpen is returned when a ciphertext cannot be authenticated.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// A CipherState encrypts and decrypts messages with a key and a counter
+// nonce. A CipherState without a key passes messages through unchanged.
+type CipherState struct {
+	aead cipher.AEAD
+	k    [KeySize]byte
+	n    uint64
+}
+
+// NewCipherState returns a CipherState with the given 256-bit key and a nonce
+// of zero.
+func NewCipherState(key []byte) (*CipherState, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	c := new(CipherState)
+	c.initializeKey(key)
+	return c, nil
+}
+
+func (c *CipherState) initializeKey(key []byte) {
+	copy(c.k[:], key)
+	c.aead, _ = chacha20poly1305.New(c.k[:])
+	c.n = 0
+}
+
+// HasKey returns true if the CipherState has a key.
+func (c *CipherState) HasKey() bool {
+	return c.aead != nil
+}
+
+// SetNonce sets the nonce of the next message.
+func (c *CipherState) SetNonce(n uint64) {
+	c.n = n
+}
+
+// Encrypt encrypts and authenticates plaintext and ad, and appends the
+// ciphertext to dst. Each call uses the next nonce.
+func (c *CipherState) Encrypt(dst, ad, plaintext []byte) ([]byte, error) {
+	if !c.HasKey() {
+		return append(dst, plaintext...), nil
+	}
+
+	if c.n == maxNonce {
+		return nil, ErrNonceExhausted
+	}
+
+	out := c.aead.Seal(dst, nonce(c.n), plaintext, ad)
+	c.n++
+	return out, nil
+}
+
+// Decrypt authenticates and decrypts ciphertext and ad, and appends the
+// plaintext to dst. The nonce only advances if the ciphertext is authentic.
+func (c *CipherState) Decrypt(dst, ad, ciphertext []byte) ([]byte, error) {
+	if !c.HasKey() {
+		return append(dst, ciphertext...), nil
+	}
+
+	if c.n == maxNonce {
+		return nil, ErrNonceExhausted
+	}
+
+	out, err := c.aead.Open(dst, nonce(c.n), ciphertext, ad)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	c.n++
+	return out, nil
+}
+
+// Rekey replaces the key with the first 32 bytes of the encryption of 32 zero
+// bytes with the reserved maximum nonce. The nonce is unchanged.
+func (c *CipherState) Rekey() {
+	if !c.HasKey() {
+		return
+	}
+
+	var zeros [KeySize]byte
+	k := c.aead.Seal(nil, nonce(maxNonce), zeros[:], nil)
+
+	n := c.n
+	c.initializeKey(k[:KeySize])
+	c.n = n
+}
+
+func nonce(n uint64) []byte {
+	var b [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(b[4:], n)
+	return b[:]
+}
+
+// A SymmetricState holds the chaining key and handshake hash of a handshake,
+// along with the CipherState they key.
+type SymmetricState struct {
+	cs   CipherState
+	hash crypto.Hash
+	ck   []byte
+	h    []byte
+}
+
+// NewSymmetricState returns a SymmetricState for the given protocol name and
+// hash function, which must be crypto.SHA256 or crypto.SHA512.
+func NewSymmetricState(protocolName string, hash crypto.Hash) (*SymmetricState, error) {
+	if _, err := hashName(hash); err != nil {
+		return nil, err
+	}
+
+	s := &SymmetricState{hash: hash}
+	if len(protocolName) <= hash.Size() {
+		s.h = make([]byte, hash.Size())
+		copy(s.h, protocolName)
+	} else {
+		s.h = s.sum([]byte(protocolName))
+	}
+
+	s.ck = append([]byte{}, s.h...)
+	return s, nil
+}
+
+// MixKey mixes input key material into the chaining key, and keys the
+// CipherState with the result.
+func (s *SymmetricState) MixKey(ikm []byte) {
+	out := s.hkdf(ikm, 2)
+	s.ck = out[0]
+	s.cs.initializeKey(out[1][:KeySize])
+}
+
+// MixHash mixes data into the handshake hash.
+func (s *SymmetricState) MixHash(data []byte) {
+	s.h = s.sum(s.h, data)
+}
+
+// MixKeyAndHash mixes input key material into both the chaining key and the
+// handshake hash, and keys the CipherState with the result.
+func (s *SymmetricState) MixKeyAndHash(ikm []byte) {
+	out := s.hkdf(ikm, 3)
+	s.ck = out[0]
+	s.MixHash(out[1])
+	s.cs.initializeKey(out[2][:KeySize])
+}
+
+// HandshakeHash returns the current handshake hash. Once the handshake is
+// complete, it uniquely identifies the session and can be used for channel
+// binding.
+func (s *SymmetricState) HandshakeHash() []byte {
+	return append([]byte{}, s.h...)
+}
+
+// EncryptAndHash encrypts plaintext with the handshake hash as additional
+// data, mixes the ciphertext into the handshake hash, and appends it to dst.
+func (s *SymmetricState) EncryptAndHash(dst, plaintext []byte) ([]byte, error) {
+	out, err := s.cs.Encrypt(dst, s.h, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	s.MixHash(out[len(dst):])
+	return out, nil
+}
+
+// DecryptAndHash decrypts ciphertext with the handshake hash as additional
+// data, mixes the ciphertext into the handshake hash, and appends the
+// plaintext to dst.
+func (s *SymmetricState) DecryptAndHash(dst, ciphertext []byte) ([]byte, error) {
+	out, err := s.cs.Decrypt(dst, s.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	s.MixHash(ciphertext)
+	return out, nil
+}
+
+// Split returns a pair of CipherStates for transport messages: the first for
+// messages from the initiator to the responder, the second for the other
+// direction.
+func (s *SymmetricState) Split() (*CipherState, *CipherState) {
+	out := s.hkdf(nil, 2)
+
+	c1, c2 := new(CipherState), new(CipherState)
+	c1.initializeKey(out[0][:KeySize])
+	c2.initializeKey(out[1][:KeySize])
+	return c1, c2
+}
+
+func (s *SymmetricState) sum(data ...[]byte) []byte {
+	h := s.hash.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// hkdf derives n hash-length outputs from the chaining key and input key
+// material. Noise's HKDF is HKDF-Extract with the chaining key as the salt,
+// followed by HKDF-Expand with no info.
+func (s *SymmetricState) hkdf(ikm []byte, n int) [][]byte {
+	size := s.hash.Size()
+	key, err := hkdf.Key(s.hash.New, ikm, s.ck, "", n*size)
+	if err != nil {
+		// Never happens, the output is always short enough.
+		panic(err)
+	}
+
+	out := make([][]byte, n)
+	for i := range out {
+		out[i] = key[i*size : (i+1)*size]
+	}
+	return out
+}
+
+// hashName returns the name of a hash function in protocol names.
+func hashName(hash crypto.Hash) (string, error) {
+	switch hash {
+	case crypto.SHA256:
+		return "SHA256", nil
+	case crypto.SHA512:
+		return "SHA512", nil
+	default:
+		return "", ErrUnsupportedHash
+	}
+}
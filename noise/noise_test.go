@@ -0,0 +1,382 @@
+package noise_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+	"github.com/codahale/chacha20/noise"
+)
+
+// testdata/cacophony.txt and testdata/snow.txt are the 25519, ChaChaPoly,
+// SHA256 and SHA512 vectors of the cacophony and snow test suites, as copied
+// into the testdata of github.com/katzenpost/nyquist at commit 816d14da49ee.
+// Vectors for patterns this package doesn't implement, like the deferred and
+// PSK ones, are skipped.
+type testVector struct {
+	ProtocolName     string   `json:"protocol_name"`
+	InitPrologue     string   `json:"init_prologue"`
+	InitPSKs         []string `json:"init_psks"`
+	InitStatic       string   `json:"init_static"`
+	InitEphemeral    string   `json:"init_ephemeral"`
+	InitRemoteStatic string   `json:"init_remote_static"`
+	RespPrologue     string   `json:"resp_prologue"`
+	RespStatic       string   `json:"resp_static"`
+	RespEphemeral    string   `json:"resp_ephemeral"`
+	RespRemoteStatic string   `json:"resp_remote_static"`
+	HandshakeHash    string   `json:"handshake_hash"`
+	Messages         []struct {
+		Payload    string `json:"payload"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"messages"`
+}
+
+var patterns = map[string]noise.HandshakePattern{}
+
+func init() {
+	for _, p := range []noise.HandshakePattern{
+		noise.PatternN, noise.PatternK, noise.PatternX,
+		noise.PatternNN, noise.PatternNK, noise.PatternNX,
+		noise.PatternKN, noise.PatternKK, noise.PatternKX,
+		noise.PatternXN, noise.PatternXK, noise.PatternXX,
+		noise.PatternIN, noise.PatternIK, noise.PatternIX,
+	} {
+		patterns[p.Name] = p
+	}
+}
+
+func decode(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func privateKey(t *testing.T, s string) *ecdh.PrivateKey {
+	if s == "" {
+		return nil
+	}
+
+	k, err := ecdh.X25519().NewPrivateKey(decode(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func publicKey(t *testing.T, s string) *ecdh.PublicKey {
+	if s == "" {
+		return nil
+	}
+
+	k, err := ecdh.X25519().NewPublicKey(decode(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// config returns the configurations for a vector, or false if its protocol
+// is not supported.
+func config(t *testing.T, v testVector) (noise.Config, noise.Config, bool) {
+	parts := strings.Split(v.ProtocolName, "_")
+	if len(parts) != 5 || parts[0] != "Noise" || parts[2] != "25519" || parts[3] != "ChaChaPoly" ||
+		len(v.InitPSKs) > 0 {
+		return noise.Config{}, noise.Config{}, false
+	}
+
+	pattern, ok := patterns[parts[1]]
+	if !ok {
+		return noise.Config{}, noise.Config{}, false
+	}
+
+	hash := map[string]crypto.Hash{"SHA256": crypto.SHA256, "SHA512": crypto.SHA512}[parts[4]]
+	if hash == 0 {
+		return noise.Config{}, noise.Config{}, false
+	}
+
+	initiator := noise.Config{
+		Pattern:         pattern,
+		Hash:            hash,
+		Initiator:       true,
+		Prologue:        decode(v.InitPrologue),
+		StaticKey:       privateKey(t, v.InitStatic),
+		RemoteStaticKey: publicKey(t, v.InitRemoteStatic),
+		Random:          bytes.NewReader(decode(v.InitEphemeral)),
+	}
+
+	responder := noise.Config{
+		Pattern:         pattern,
+		Hash:            hash,
+		Prologue:        decode(v.RespPrologue),
+		StaticKey:       privateKey(t, v.RespStatic),
+		RemoteStaticKey: publicKey(t, v.RespRemoteStatic),
+		Random:          bytes.NewReader(decode(v.RespEphemeral)),
+	}
+
+	return initiator, responder, true
+}
+
+// readVectors reads the vectors from every file in testdata.
+func readVectors(t *testing.T) []testVector {
+	names, err := filepath.Glob("testdata/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors []testVector
+	for _, name := range names {
+		f, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var file struct {
+			Vectors []testVector `json:"vectors"`
+		}
+		if err := json.Unmarshal(f, &file); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		vectors = append(vectors, file.Vectors...)
+	}
+	return vectors
+}
+
+func TestVectors(t *testing.T) {
+	run := 0
+	for _, vector := range readVectors(t) {
+		ci, cr, ok := config(t, vector)
+		if !ok {
+			t.Logf("Skipping %s", vector.ProtocolName)
+			continue
+		}
+
+		t.Logf("Running %s", vector.ProtocolName)
+		run++
+
+		initiator, err := noise.NewHandshakeState(ci)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		responder, err := noise.NewHandshakeState(cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oneWay := len(ci.Pattern.Name) == 1
+
+		// the transport CipherStates of each party, for sending and receiving
+		var initSend, initRecv, respSend, respRecv *noise.CipherState
+		for i, m := range vector.Messages {
+			payload := decode(m.Payload)
+			fromInitiator := oneWay || i%2 == 0
+
+			var ciphertext, plaintext []byte
+			if initSend == nil {
+				w, r := initiator, responder
+				if !fromInitiator {
+					w, r = responder, initiator
+				}
+
+				var c1, c2 *noise.CipherState
+				ciphertext, c1, c2, err = w.WriteMessage(nil, payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var d1, d2 *noise.CipherState
+				plaintext, d1, d2, err = r.ReadMessage(nil, ciphertext)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if c1 != nil {
+					if !fromInitiator {
+						c1, c2, d1, d2 = d1, d2, c1, c2
+					}
+					initSend, initRecv, respRecv, respSend = c1, c2, d1, d2
+
+					for _, h := range [][]byte{initiator.HandshakeHash(), responder.HandshakeHash()} {
+						// snow's vectors have no handshake hash
+						if vector.HandshakeHash != "" && hex.EncodeToString(h) != vector.HandshakeHash {
+							t.Errorf("Bad handshake hash: expected %s, was %x", vector.HandshakeHash, h)
+						}
+					}
+				}
+			} else {
+				w, r := initSend, respRecv
+				if !fromInitiator {
+					w, r = respSend, initRecv
+				}
+
+				if ciphertext, err = w.Encrypt(nil, nil, payload); err != nil {
+					t.Fatal(err)
+				}
+
+				if plaintext, err = r.Decrypt(nil, nil, ciphertext); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if hex.EncodeToString(ciphertext) != m.Ciphertext {
+				t.Errorf("Bad ciphertext %d: expected %s, was %x", i, m.Ciphertext, ciphertext)
+			}
+
+			if !bytes.Equal(payload, plaintext) {
+				t.Errorf("Bad payload %d: expected %x, was %x", i, payload, plaintext)
+			}
+		}
+	}
+
+	if run == 0 {
+		t.Error("Should have run some test vectors")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, noise.KeySize)
+
+	c, err := noise.NewCipherState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetNonce(7)
+	c.Rekey()
+
+	// the new key is the encryption of zeros with the maximum nonce
+	aead, _ := chacha20poly1305.New(key)
+	nonce := []byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	rekeyed, _ := chacha20poly1305.New(aead.Seal(nil, nonce, make([]byte, 32), nil)[:32])
+
+	expected := rekeyed.Seal(nil, []byte{0, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0}, []byte("hello"), nil)
+	actual, err := c.Encrypt(nil, nil, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("Bad ciphertext: expected %x, was %x", expected, actual)
+	}
+}
+
+func TestCipherState(t *testing.T) {
+	var empty noise.CipherState
+	if out, _ := empty.Encrypt(nil, nil, []byte("hello")); string(out) != "hello" {
+		t.Error("Should have passed the plaintext through without a key")
+	}
+
+	key := make([]byte, noise.KeySize)
+	sender, _ := noise.NewCipherState(key)
+	receiver, _ := noise.NewCipherState(key)
+
+	ciphertext, _ := sender.Encrypt(nil, []byte("ad"), []byte("hello"))
+	if _, err := receiver.Decrypt(nil, []byte("da"), ciphertext); err != noise.ErrOpen {
+		t.Error("Should have rejected different additional data")
+	}
+
+	// a failed decryption does not advance the nonce
+	if _, err := receiver.Decrypt(nil, []byte("ad"), ciphertext); err != nil {
+		t.Error(err)
+	}
+
+	sender.SetNonce(1<<64 - 1)
+	if _, err := sender.Encrypt(nil, nil, nil); err != noise.ErrNonceExhausted {
+		t.Error("Should not have used the reserved nonce")
+	}
+
+	if _, err := noise.NewCipherState(key[1:]); err != noise.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	if _, err := noise.NewHandshakeState(noise.Config{Pattern: noise.PatternNN, Hash: crypto.SHA1}); err != noise.ErrUnsupportedHash {
+		t.Error("Should have rejected SHA-1")
+	}
+
+	if _, err := noise.NewHandshakeState(noise.Config{Pattern: noise.PatternIK, Hash: crypto.SHA256, Initiator: true}); err != noise.ErrMissingKey {
+		t.Error("Should have required the initiator's keys")
+	}
+
+	if _, err := noise.NewHandshakeState(noise.Config{Pattern: noise.PatternXX, Hash: crypto.SHA256}); err != noise.ErrMissingKey {
+		t.Error("Should have required the responder's static key")
+	}
+
+	initiator, _ := noise.NewHandshakeState(noise.Config{Pattern: noise.PatternNN, Hash: crypto.SHA256, Initiator: true})
+	responder, _ := noise.NewHandshakeState(noise.Config{Pattern: noise.PatternNN, Hash: crypto.SHA256})
+
+	if _, _, _, err := responder.WriteMessage(nil, nil); err != noise.ErrOutOfTurn {
+		t.Error("Should not have let the responder write first")
+	}
+
+	msg, _, _, _ := initiator.WriteMessage(nil, nil)
+	if _, _, _, err := responder.ReadMessage(nil, msg[:31]); err != noise.ErrShortMessage {
+		t.Error("Should have rejected a short message")
+	}
+
+	responder, _ = noise.NewHandshakeState(noise.Config{Pattern: noise.PatternNN, Hash: crypto.SHA256})
+	responder.ReadMessage(nil, msg)
+	msg, _, _, _ = responder.WriteMessage(nil, []byte("payload"))
+
+	msg[len(msg)-1] ^= 1
+	if _, _, _, err := initiator.ReadMessage(nil, msg); err != noise.ErrOpen {
+		t.Error("Should have rejected a modified message")
+	}
+
+	if _, _, _, err := responder.WriteMessage(nil, nil); err != noise.ErrHandshakeComplete {
+		t.Error("Should not have written a message after the handshake")
+	}
+}
+
+func Example() {
+	// the responder's static key is known to the initiator in advance
+	static, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	initiator, err := noise.NewHandshakeState(noise.Config{
+		Pattern:         noise.PatternNK,
+		Hash:            crypto.SHA256,
+		Initiator:       true,
+		RemoteStaticKey: static.PublicKey(),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	responder, err := noise.NewHandshakeState(noise.Config{
+		Pattern:   noise.PatternNK,
+		Hash:      crypto.SHA256,
+		StaticKey: static,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	msg, _, _, _ := initiator.WriteMessage(nil, []byte("hello"))
+	payload, _, _, _ := responder.ReadMessage(nil, msg)
+	fmt.Printf("%s\n", payload)
+
+	msg, _, respSend, _ := responder.WriteMessage(nil, []byte("hi"))
+	payload, _, initRecv, _ := initiator.ReadMessage(nil, msg)
+	fmt.Printf("%s\n", payload)
+
+	// the handshake is complete, so each side has its transport keys
+	msg, _ = respSend.Encrypt(nil, nil, []byte("welcome"))
+	payload, _ = initRecv.Decrypt(nil, nil, msg)
+	fmt.Printf("%s\n", payload)
+	// Output:
+	// hello
+	// hi
+	// welcome
+}
@@ -0,0 +1,164 @@
+package noise
+
+// A Token is a step of a handshake message: sending or receiving a public
+// key, or performing a Diffie-Hellman operation.
+type Token uint8
+
+const (
+	// TokenE is an ephemeral public key.
+	TokenE Token = iota + 1
+	// TokenS is a static public key, encrypted if a key is available.
+	TokenS
+	// TokenEE is a DH between the two ephemeral keys.
+	TokenEE
+	// TokenES is a DH between the initiator's ephemeral key and the
+	// responder's static key.
+	TokenES
+	// TokenSE is a DH between the initiator's static key and the
+	// responder's ephemeral key.
+	TokenSE
+	// TokenSS is a DH between the two static keys.
+	TokenSS
+)
+
+// A HandshakePattern describes the public keys known to each party before a
+// handshake and the tokens of each handshake message. Messages alternate
+// between the initiator and the responder, starting with the initiator.
+type HandshakePattern struct {
+	Name                 string
+	InitiatorPreMessages []Token
+	ResponderPreMessages []Token
+	Messages             [][]Token
+}
+
+// The one-way patterns, in which only the initiator sends messages.
+var (
+	// PatternN is the one-way pattern with no initiator static key.
+	PatternN = HandshakePattern{
+		Name:                 "N",
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES},
+		},
+	}
+	// PatternK is the one-way pattern with an initiator static key known to
+	// the responder.
+	PatternK = HandshakePattern{
+		Name:                 "K",
+		InitiatorPreMessages: []Token{TokenS},
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES, TokenSS},
+		},
+	}
+	// PatternX is the one-way pattern with an initiator static key
+	// transmitted to the responder.
+	PatternX = HandshakePattern{
+		Name:                 "X",
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES, TokenS, TokenSS},
+		},
+	}
+)
+
+// The interactive patterns. The first letter describes the initiator's
+// static key and the second the responder's: N for none, K for known to the
+// other party in advance, X for transmitted during the handshake, and I for
+// transmitted immediately.
+var (
+	PatternNN = HandshakePattern{
+		Name: "NN",
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE},
+		},
+	}
+	PatternNK = HandshakePattern{
+		Name:                 "NK",
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES},
+			{TokenE, TokenEE},
+		},
+	}
+	PatternNX = HandshakePattern{
+		Name: "NX",
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE, TokenS, TokenES},
+		},
+	}
+	PatternKN = HandshakePattern{
+		Name:                 "KN",
+		InitiatorPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE, TokenSE},
+		},
+	}
+	PatternKK = HandshakePattern{
+		Name:                 "KK",
+		InitiatorPreMessages: []Token{TokenS},
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES, TokenSS},
+			{TokenE, TokenEE, TokenSE},
+		},
+	}
+	PatternKX = HandshakePattern{
+		Name:                 "KX",
+		InitiatorPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE, TokenSE, TokenS, TokenES},
+		},
+	}
+	PatternXN = HandshakePattern{
+		Name: "XN",
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE},
+			{TokenS, TokenSE},
+		},
+	}
+	PatternXK = HandshakePattern{
+		Name:                 "XK",
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES},
+			{TokenE, TokenEE},
+			{TokenS, TokenSE},
+		},
+	}
+	PatternXX = HandshakePattern{
+		Name: "XX",
+		Messages: [][]Token{
+			{TokenE},
+			{TokenE, TokenEE, TokenS, TokenES},
+			{TokenS, TokenSE},
+		},
+	}
+	PatternIN = HandshakePattern{
+		Name: "IN",
+		Messages: [][]Token{
+			{TokenE, TokenS},
+			{TokenE, TokenEE, TokenSE},
+		},
+	}
+	PatternIK = HandshakePattern{
+		Name:                 "IK",
+		ResponderPreMessages: []Token{TokenS},
+		Messages: [][]Token{
+			{TokenE, TokenES, TokenS, TokenSS},
+			{TokenE, TokenEE, TokenSE},
+		},
+	}
+	PatternIX = HandshakePattern{
+		Name: "IX",
+		Messages: [][]Token{
+			{TokenE, TokenS},
+			{TokenE, TokenEE, TokenSE, TokenS, TokenES},
+		},
+	}
+)
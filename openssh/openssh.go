@@ -0,0 +1,174 @@
+// Package openssh implements the chacha20-poly1305@openssh.com packet cipher
+// used by OpenSSH.
+//
+// The cipher is keyed with 512 bits, split into two ChaCha20 keys. The second
+// half encrypts the 4-byte packet length, so that it can be decrypted before
+// the rest of the packet has been read, and the first half encrypts the
+// remainder of the packet. Both use the packet's sequence number as their
+// 64-bit nonce. The first 32 bytes of the payload keystream are used as a
+// one-time Poly1305 key, and the payload is encrypted starting at the second
+// block. The tag authenticates the encrypted length and the encrypted payload.
+//
+// For more information, see
+// https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL.chacha20poly1305
+package openssh
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/poly1305"
+)
+
+const (
+	// KeySize is the length of a chacha20-poly1305@openssh.com key, in bytes.
+	KeySize = 64
+	// LengthSize is the length of the encrypted packet length, in bytes.
+	LengthSize = 4
+	// Overhead is the number of bytes a sealed packet is longer than its
+	// body: the packet length and the authentication tag.
+	Overhead = LengthSize + poly1305.TagSize
+	// MaxBodySize is the length of the longest packet body, in bytes. It is
+	// OpenSSH's limit on the packet length, which receivers check before
+	// reading the rest of the packet.
+	MaxBodySize = 256 * 1024
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 512 bits long.
+	ErrInvalidKey = errors.New("invalid key length (must be 512 bits)")
+	// ErrInvalidPacket is returned when a sealed packet is shorter than the
+	// packet length and tag, its length is longer than MaxBodySize, or its
+	// length does not match its contents.
+	ErrInvalidPacket = errors.New("invalid packet")
+	// ErrOpen is returned when a packet cannot be opened, either because it
+	// was not sealed with the given key and sequence number or because it was
+	// modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// Cipher seals and opens SSH packets in one direction of a connection.
+type Cipher struct {
+	payloadKey, lengthKey [32]byte
+}
+
+// New returns a Cipher for the given 512-bit key, as derived by the SSH key
+// exchange.
+func New(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	c := new(Cipher)
+	copy(c.payloadKey[:], key[:32])
+	copy(c.lengthKey[:], key[32:])
+	return c, nil
+}
+
+// Seal encrypts and authenticates the body of a packet (the padding length,
+// payload and padding) with the given sequence number and appends the
+// encrypted length, encrypted body and tag to dst, which must not overlap
+// body. It panics if body is longer than MaxBodySize.
+func (c *Cipher) Seal(dst []byte, seq uint32, body []byte) []byte {
+	if len(body) > MaxBodySize {
+		panic("openssh: packet body too large")
+	}
+
+	ret, out := sliceForAppend(dst, len(body)+Overhead)
+
+	binary.BigEndian.PutUint32(out, uint32(len(body)))
+	c.lengthStream(seq).XORKeyStream(out[:LengthSize], out[:LengthSize])
+
+	var polyKey [32]byte
+	s := c.payloadStream(seq, &polyKey)
+	s.XORKeyStream(out[LengthSize:len(body)+LengthSize], body)
+
+	var tag [poly1305.TagSize]byte
+	poly1305.Sum(&tag, out[:len(body)+LengthSize], &polyKey)
+	copy(out[len(body)+LengthSize:], tag[:])
+	return ret
+}
+
+// DecryptLength decrypts the packet length from the first LengthSize bytes of
+// a sealed packet, allowing the caller to determine how much more of the
+// packet to read. Lengths longer than MaxBodySize are rejected, but the length
+// is not authenticated until the whole packet is opened.
+func (c *Cipher) DecryptLength(seq uint32, packet []byte) (uint32, error) {
+	if len(packet) < LengthSize {
+		return 0, ErrInvalidPacket
+	}
+
+	var length [LengthSize]byte
+	c.lengthStream(seq).XORKeyStream(length[:], packet[:LengthSize])
+
+	n := binary.BigEndian.Uint32(length[:])
+	if n > MaxBodySize {
+		return 0, ErrInvalidPacket
+	}
+	return n, nil
+}
+
+// Open authenticates and decrypts a packet produced by Seal with the given
+// sequence number and appends its body to dst, which must not overlap packet.
+func (c *Cipher) Open(dst []byte, seq uint32, packet []byte) ([]byte, error) {
+	if len(packet) < Overhead {
+		return nil, ErrInvalidPacket
+	}
+
+	sealed := packet[:len(packet)-poly1305.TagSize]
+
+	var tag [poly1305.TagSize]byte
+	copy(tag[:], packet[len(sealed):])
+
+	var polyKey [32]byte
+	s := c.payloadStream(seq, &polyKey)
+
+	if !poly1305.Verify(&tag, sealed, &polyKey) {
+		return nil, ErrOpen
+	}
+
+	// the length is authentic, but must describe this packet
+	length, err := c.DecryptLength(seq, packet)
+	if err != nil || uint64(length) != uint64(len(sealed)-LengthSize) {
+		return nil, ErrInvalidPacket
+	}
+
+	ret, out := sliceForAppend(dst, len(sealed)-LengthSize)
+	s.XORKeyStream(out, sealed[LengthSize:])
+	return ret, nil
+}
+
+func nonce(seq uint32) []byte {
+	var n [chacha20.NonceSize]byte
+	binary.BigEndian.PutUint64(n[:], uint64(seq))
+	return n[:]
+}
+
+func (c *Cipher) lengthStream(seq uint32) cipher.Stream {
+	s, _ := chacha20.New(c.lengthKey[:], nonce(seq))
+	return s
+}
+
+// payloadStream returns the payload keystream positioned at the second block,
+// having used the first to derive the Poly1305 key.
+func (c *Cipher) payloadStream(seq uint32, polyKey *[32]byte) cipher.Stream {
+	s, _ := chacha20.New(c.payloadKey[:], nonce(seq))
+
+	var block [64]byte
+	s.XORKeyStream(block[:], block[:])
+	copy(polyKey[:], block[:])
+	return s
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
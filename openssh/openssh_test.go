@@ -0,0 +1,188 @@
+package openssh_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/openssh"
+)
+
+// OpenSSH publishes no test vectors for this cipher, so these were captured
+// from a session with OpenSSH 9.2p1's ssh client, using a server which logged
+// the client-to-server key it negotiated. They are the first two packets after
+// the key exchange (the service and "none" authentication requests), an exec
+// request and the disconnect. Each body is a padding length, payload and
+// padding.
+type testVector struct {
+	key    string
+	seq    uint32
+	body   string
+	packet string
+}
+
+var testVectors = []testVector{
+	testVector{
+		"cbe974858b73d401b8474bd4c84f45ab36f2b9240d7b85ffd6e3f8c62b2acab9" +
+			"0f7059c1b6eea65f81ae8cb2b02463da91fe4b8c5c1dfc219e28a44e33e24f67",
+		0,
+		"06050000000c7373682d757365726175746838c6a441c7d4",
+		"570a33b8844947fe52c1346ccc33b0f5f4240f1b6e4f456d5ec5b4e1faf018d28ee3e48b" +
+			"8d40fc3da0f43849",
+	},
+	testVector{
+		"cbe974858b73d401b8474bd4c84f45ab36f2b9240d7b85ffd6e3f8c62b2acab9" +
+			"0f7059c1b6eea65f81ae8cb2b02463da91fe4b8c5c1dfc219e28a44e33e24f67",
+		1,
+		"043200000004746573740000000e7373682d636f6e6e656374696f6e000000046e6f6e65" +
+			"a7a23753",
+		"871d1a6106538814b9199512f6c353d4a6ca98ec4a4dc3ab812e08ed8115d3fb669e6c5b" +
+			"d68ededb704e8ca1f368e45bafdac45858604f308c7b42f2",
+	},
+	testVector{
+		"cbe974858b73d401b8474bd4c84f45ab36f2b9240d7b85ffd6e3f8c62b2acab9" +
+			"0f7059c1b6eea65f81ae8cb2b02463da91fe4b8c5c1dfc219e28a44e33e24f67",
+		5,
+		"066200000000000000046578656301000000076563686f206869f6fc210f9d81",
+		"91bd0f5afcede114c5fed220cb132c5cd894ce75e04ffb496af500072956d1a946bd1bf2" +
+			"c391f795015bc9671ff9c87af2428597",
+	},
+	testVector{
+		"cbe974858b73d401b8474bd4c84f45ab36f2b9240d7b85ffd6e3f8c62b2acab9" +
+			"0f7059c1b6eea65f81ae8cb2b02463da91fe4b8c5c1dfc219e28a44e33e24f67",
+		8,
+		"06010000000b00000014646973636f6e6e656374656420627920757365720000000042ac" +
+			"a3b65f5e",
+		"16367f3dd455746a7dd3c945f49230336ec0edd6aa167045562d4938b307589182d6f123" +
+			"a7e0458cfd56637e4e44a1d385ac30c043c13809cc990af9",
+	},
+}
+
+func TestSeal(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		key, _ := hex.DecodeString(vector.key)
+		body, _ := hex.DecodeString(vector.body)
+
+		c, err := openssh.New(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packet := c.Seal(nil, vector.seq, body)
+		if hex.EncodeToString(packet) != vector.packet {
+			t.Errorf("Bad packet: expected %s, was %x", vector.packet, packet)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		key, _ := hex.DecodeString(vector.key)
+		body, _ := hex.DecodeString(vector.body)
+		packet, _ := hex.DecodeString(vector.packet)
+
+		c, err := openssh.New(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		length, err := c.DecryptLength(vector.seq, packet[:openssh.LengthSize])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if int(length) != len(body) {
+			t.Errorf("Bad length: expected %d, was %d", len(body), length)
+		}
+
+		actual, err := c.Open(nil, vector.seq, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(body, actual) {
+			t.Errorf("Bad body: expected %x, was %x", body, actual)
+		}
+
+		if _, err := c.Open(nil, vector.seq+1, packet); err != openssh.ErrOpen {
+			t.Error("Should have rejected a packet with the wrong sequence number")
+		}
+
+		for _, n := range []int{0, openssh.LengthSize, len(packet) - 1} {
+			packet[n] ^= 1
+			if _, err := c.Open(nil, vector.seq, packet); err != openssh.ErrOpen {
+				t.Errorf("Should have rejected a packet modified at byte %d", n)
+			}
+			packet[n] ^= 1
+		}
+	}
+}
+
+func TestBadInputs(t *testing.T) {
+	if _, err := openssh.New(make([]byte, 32)); err != openssh.ErrInvalidKey {
+		t.Error("Should have rejected a 256-bit key")
+	}
+
+	c, _ := openssh.New(make([]byte, openssh.KeySize))
+
+	if _, err := c.DecryptLength(0, make([]byte, 3)); err != openssh.ErrInvalidPacket {
+		t.Error("Should have rejected a short length")
+	}
+
+	if _, err := c.Open(nil, 0, make([]byte, openssh.Overhead-1)); err != openssh.ErrInvalidPacket {
+		t.Error("Should have rejected a short packet")
+	}
+
+	// a length of 0xffffffff, sealed with the all-zero key
+	long := []byte{0x76 ^ 0xff, 0xb8 ^ 0xff, 0xe0 ^ 0xff, 0xad ^ 0xff}
+	if _, err := c.DecryptLength(0, long); err != openssh.ErrInvalidPacket {
+		t.Error("Should have rejected a length longer than MaxBodySize")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Should have panicked on a body longer than MaxBodySize")
+			}
+		}()
+		c.Seal(nil, 0, make([]byte, openssh.MaxBodySize+1))
+	}()
+
+	// a packet framed with more data than it claims
+	packet := c.Seal(nil, 0, []byte("body"))
+	if _, err := c.Open(nil, 0, append(packet, 0)); err != openssh.ErrOpen {
+		t.Error("Should have rejected a misframed packet")
+	}
+}
+
+func Example() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722" +
+		"5c1f6e85d2d5d4b9dc2b43c64c7f2cc6a4a2c2a3c6e4f2a5c2d3a1c6c8e2a5b1")
+	if err != nil {
+		panic(err)
+	}
+
+	sender, _ := openssh.New(key)
+	receiver, _ := openssh.New(key)
+
+	packet := sender.Seal(nil, 3, []byte("\x04hello\x00\x01\x02\x03"))
+
+	// the receiver reads the length first, then the rest of the packet
+	length, _ := receiver.DecryptLength(3, packet)
+	fmt.Println(length)
+
+	body, err := receiver.Open(nil, 3, packet[:length+openssh.Overhead])
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%q\n", body)
+	// Output:
+	// 10
+	// "\x04hello\x00\x01\x02\x03"
+}
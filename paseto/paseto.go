@@ -0,0 +1,235 @@
+// Package paseto implements PASETO v2.local and v4.local tokens, which are
+// symmetrically encrypted and authenticated with a shared 256-bit key.
+//
+// A v2.local token is encrypted with XChaCha20-Poly1305, using a nonce which
+// is a BLAKE2b hash of the payload keyed with random bytes. A v4.local token
+// derives an encryption key and nonce and an authentication key from the
+// shared key and a random nonce with BLAKE2b, encrypts the payload with
+// XChaCha20, and authenticates the result with a BLAKE2b MAC.
+//
+// Both versions authenticate an optional, unencrypted footer, which is
+// appended to the token. v4.local tokens can also authenticate an implicit
+// assertion, which is not included in the token but must be provided again
+// to decrypt it.
+//
+// For more information, see https://github.com/paseto-standard/paseto-spec
+package paseto
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/blake2b"
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// KeySize is the length of a local key, in bytes.
+	KeySize = 32
+
+	// HeaderV2 is the header of v2.local tokens.
+	HeaderV2 = "v2.local."
+	// HeaderV4 is the header of v4.local tokens.
+	HeaderV4 = "v4.local."
+
+	v2NonceSize = chacha20poly1305.NonceSizeX
+	v4NonceSize = 32
+	v4MACSize   = 32
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = errors.New("invalid key length (must be 256 bits)")
+	// ErrInvalidToken is returned when a token does not have the expected
+	// header or is not correctly encoded.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrOpen is returned when a token cannot be authenticated, either
+	// because it was not encrypted with the given key, footer and implicit
+	// assertion or because it was modified.
+	ErrOpen = errors.New("token authentication failed")
+)
+
+var b64 = base64.RawURLEncoding.Strict()
+
+// EncryptV2 encrypts and authenticates payload and footer as a v2.local token,
+// using 24 bytes read from rand to derive the nonce.
+func EncryptV2(rand io.Reader, key, payload, footer []byte) (string, error) {
+	if len(key) != KeySize {
+		return "", ErrInvalidKey
+	}
+
+	var b [v2NonceSize]byte
+	if _, err := io.ReadFull(rand, b[:]); err != nil {
+		return "", err
+	}
+
+	h, _ := blake2b.New(v2NonceSize, b[:])
+	h.Write(payload)
+	nonce := h.Sum(nil)
+
+	aead, _ := chacha20poly1305.NewX(key)
+	c := aead.Seal(nonce, nonce, payload, pae([]byte(HeaderV2), nonce, footer))
+
+	return token(HeaderV2, c, footer), nil
+}
+
+// DecryptV2 authenticates and decrypts a v2.local token, returning its payload
+// and footer.
+func DecryptV2(key []byte, token string) ([]byte, []byte, error) {
+	if len(key) != KeySize {
+		return nil, nil, ErrInvalidKey
+	}
+
+	body, footer, err := parse(HeaderV2, token, v2NonceSize+chacha20poly1305.Overhead)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce, c := body[:v2NonceSize], body[v2NonceSize:]
+
+	aead, _ := chacha20poly1305.NewX(key)
+	payload, err := aead.Open(nil, nonce, c, pae([]byte(HeaderV2), nonce, footer))
+	if err != nil {
+		return nil, nil, ErrOpen
+	}
+
+	return payload, footer, nil
+}
+
+// EncryptV4 encrypts and authenticates payload, footer and an implicit
+// assertion as a v4.local token, using 32 bytes read from rand as the nonce.
+func EncryptV4(rand io.Reader, key, payload, footer, implicit []byte) (string, error) {
+	if len(key) != KeySize {
+		return "", ErrInvalidKey
+	}
+
+	n := make([]byte, v4NonceSize, v4NonceSize+len(payload)+v4MACSize)
+	if _, err := io.ReadFull(rand, n); err != nil {
+		return "", err
+	}
+
+	ek, n2, ak := v4Keys(key, n)
+
+	s, _ := chacha20.NewXChaCha(ek, n2)
+	c := make([]byte, len(payload))
+	s.XORKeyStream(c, payload)
+
+	t := v4MAC(ak, n, c, footer, implicit)
+
+	return token(HeaderV4, append(append(n, c...), t...), footer), nil
+}
+
+// DecryptV4 authenticates and decrypts a v4.local token with the given
+// implicit assertion, returning its payload and footer.
+func DecryptV4(key []byte, token string, implicit []byte) ([]byte, []byte, error) {
+	if len(key) != KeySize {
+		return nil, nil, ErrInvalidKey
+	}
+
+	body, footer, err := parse(HeaderV4, token, v4NonceSize+v4MACSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := body[:v4NonceSize]
+	c := body[v4NonceSize : len(body)-v4MACSize]
+	t := body[len(body)-v4MACSize:]
+
+	ek, n2, ak := v4Keys(key, n)
+	if subtle.ConstantTimeCompare(t, v4MAC(ak, n, c, footer, implicit)) != 1 {
+		return nil, nil, ErrOpen
+	}
+
+	s, _ := chacha20.NewXChaCha(ek, n2)
+	payload := make([]byte, len(c))
+	s.XORKeyStream(payload, c)
+
+	return payload, footer, nil
+}
+
+// Footer returns the footer of a token without authenticating it, so that it
+// can be used to select a key.
+func Footer(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	switch len(parts) {
+	case 3:
+		return nil, nil
+	case 4:
+		footer, err := b64.DecodeString(parts[3])
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+		return footer, nil
+	default:
+		return nil, ErrInvalidToken
+	}
+}
+
+// v4Keys derives the encryption key, XChaCha20 nonce and authentication key
+// for a v4.local token.
+func v4Keys(key, n []byte) (ek, n2, ak []byte) {
+	h, _ := blake2b.New(chacha20.KeySize+chacha20.XNonceSize, key)
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(n)
+	tmp := h.Sum(nil)
+
+	h, _ = blake2b.New(KeySize, key)
+	h.Write([]byte("paseto-auth-key-for-aead"))
+	h.Write(n)
+
+	return tmp[:chacha20.KeySize], tmp[chacha20.KeySize:], h.Sum(nil)
+}
+
+func v4MAC(ak, n, c, footer, implicit []byte) []byte {
+	h, _ := blake2b.New(v4MACSize, ak)
+	h.Write(pae([]byte(HeaderV4), n, c, footer, implicit))
+	return h.Sum(nil)
+}
+
+// pae is PASETO's pre-authentication encoding, which unambiguously encodes a
+// list of byte strings as the number of strings followed by each string, both
+// prefixed with their 64-bit little-endian lengths.
+func pae(pieces ...[]byte) []byte {
+	out := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, p := range pieces {
+		// the most significant bit is cleared for compatibility with
+		// languages without unsigned integers
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(p))&^(1<<63))
+		out = append(out, p...)
+	}
+	return out
+}
+
+func token(header string, body, footer []byte) string {
+	t := header + b64.EncodeToString(body)
+	if len(footer) > 0 {
+		t += "." + b64.EncodeToString(footer)
+	}
+	return t
+}
+
+// parse checks the header of a token and decodes its body and footer. The
+// body must be at least min bytes long.
+func parse(header, token string, min int) ([]byte, []byte, error) {
+	if !strings.HasPrefix(token, header) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	footer, err := Footer(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encoded := strings.SplitN(token[len(header):], ".", 2)[0]
+	body, err := b64.DecodeString(encoded)
+	if err != nil || len(body) < min {
+		return nil, nil, ErrInvalidToken
+	}
+
+	return body, footer, nil
+}
@@ -0,0 +1,194 @@
+package paseto_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/codahale/chacha20/paseto"
+)
+
+// testdata contains the official test vectors from
+// https://github.com/paseto-standard/test-vectors. Only the local tokens are
+// used.
+type testVector struct {
+	Name       string  `json:"name"`
+	ExpectFail bool    `json:"expect-fail"`
+	Key        string  `json:"key"`
+	Nonce      string  `json:"nonce"`
+	Token      string  `json:"token"`
+	Payload    *string `json:"payload"`
+	Footer     string  `json:"footer"`
+	Implicit   string  `json:"implicit-assertion"`
+}
+
+func loadVectors(t *testing.T, name string) []testVector {
+	f, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file struct {
+		Tests []testVector `json:"tests"`
+	}
+	if err := json.Unmarshal(f, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	var vectors []testVector
+	for _, v := range file.Tests {
+		if v.Key != "" {
+			vectors = append(vectors, v)
+		}
+	}
+	return vectors
+}
+
+type version struct {
+	file    string
+	encrypt func(nonce, key []byte, v testVector) (string, error)
+	decrypt func(key []byte, v testVector) ([]byte, []byte, error)
+}
+
+var versions = []version{
+	{
+		"v2.json",
+		func(nonce, key []byte, v testVector) (string, error) {
+			return paseto.EncryptV2(bytes.NewReader(nonce), key, []byte(*v.Payload), []byte(v.Footer))
+		},
+		func(key []byte, v testVector) ([]byte, []byte, error) {
+			return paseto.DecryptV2(key, v.Token)
+		},
+	},
+	{
+		"v4.json",
+		func(nonce, key []byte, v testVector) (string, error) {
+			return paseto.EncryptV4(bytes.NewReader(nonce), key, []byte(*v.Payload), []byte(v.Footer), []byte(v.Implicit))
+		},
+		func(key []byte, v testVector) ([]byte, []byte, error) {
+			return paseto.DecryptV4(key, v.Token, []byte(v.Implicit))
+		},
+	},
+}
+
+func TestVectors(t *testing.T) {
+	for _, ver := range versions {
+		for _, vector := range loadVectors(t, ver.file) {
+			t.Logf("Running test vector %s", vector.Name)
+
+			key, _ := hex.DecodeString(vector.Key)
+			nonce, _ := hex.DecodeString(vector.Nonce)
+
+			if vector.ExpectFail {
+				if _, _, err := ver.decrypt(key, vector); err == nil {
+					t.Errorf("Should have rejected %s", vector.Name)
+				}
+				continue
+			}
+
+			token, err := ver.encrypt(nonce, key, vector)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if token != vector.Token {
+				t.Errorf("Bad token: expected %s, was %s", vector.Token, token)
+			}
+
+			payload, footer, err := ver.decrypt(key, vector)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(payload) != *vector.Payload {
+				t.Errorf("Bad payload: expected %s, was %s", *vector.Payload, payload)
+			}
+
+			if string(footer) != vector.Footer {
+				t.Errorf("Bad footer: expected %s, was %s", vector.Footer, footer)
+			}
+		}
+	}
+}
+
+func TestModified(t *testing.T) {
+	key := make([]byte, paseto.KeySize)
+
+	v2, _ := paseto.EncryptV2(rand.Reader, key, []byte("payload"), []byte("footer"))
+	v4, _ := paseto.EncryptV4(rand.Reader, key, []byte("payload"), []byte("footer"), []byte("implicit"))
+
+	modify := func(token string) string {
+		// replace the footer, which is authenticated but not encrypted,
+		// with "FOOTER"
+		i := strings.LastIndex(token, ".")
+		return token[:i+1] + "Rk9PVEVS"
+	}
+
+	if _, _, err := paseto.DecryptV2(key, modify(v2)); err != paseto.ErrOpen {
+		t.Errorf("Should have rejected a modified v2 footer, was %v", err)
+	}
+
+	if _, _, err := paseto.DecryptV4(key, modify(v4), []byte("implicit")); err != paseto.ErrOpen {
+		t.Errorf("Should have rejected a modified v4 footer, was %v", err)
+	}
+
+	if _, _, err := paseto.DecryptV4(key, v4, []byte("explicit")); err != paseto.ErrOpen {
+		t.Error("Should have rejected a different implicit assertion")
+	}
+
+	if _, _, err := paseto.DecryptV4(key, v2, nil); err != paseto.ErrInvalidToken {
+		t.Error("Should have rejected a v2 token")
+	}
+
+	if _, _, err := paseto.DecryptV2(key, "v2.local.AAAA"); err != paseto.ErrInvalidToken {
+		t.Error("Should have rejected a short token")
+	}
+
+	if _, err := paseto.EncryptV4(rand.Reader, key[1:], nil, nil, nil); err != paseto.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+}
+
+func TestFooter(t *testing.T) {
+	key := make([]byte, paseto.KeySize)
+	token, _ := paseto.EncryptV4(rand.Reader, key, []byte("payload"), []byte(`{"kid":"k1"}`), nil)
+
+	footer, err := paseto.Footer(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(footer) != `{"kid":"k1"}` {
+		t.Errorf("Bad footer: expected %s, was %s", `{"kid":"k1"}`, footer)
+	}
+
+	if _, err := paseto.Footer("v4.local.a.b.c"); err != paseto.ErrInvalidToken {
+		t.Error("Should have rejected a token with too many parts")
+	}
+}
+
+func ExampleEncryptV4() {
+	key, err := hex.DecodeString("707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f")
+	if err != nil {
+		panic(err)
+	}
+
+	token, err := paseto.EncryptV4(rand.Reader, key, []byte(`{"sub":"alice"}`), []byte(`{"kid":"k1"}`), nil)
+	if err != nil {
+		panic(err)
+	}
+
+	payload, footer, err := paseto.DecryptV4(key, token, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s %s\n", payload, footer)
+	// Output:
+	// {"sub":"alice"} {"kid":"k1"}
+}
@@ -0,0 +1,204 @@
+// Package poly1305 provides a pure Go implementation of Poly1305, a fast,
+// one-time message authenticator.
+//
+// From Bernstein, Daniel J. "The Poly1305-AES message-authentication code."
+// Fast Software Encryption. 2005. (http://cr.yp.to/mac/poly1305-20050329.pdf):
+//
+//	Poly1305-AES computes a 16-byte authenticator of a message of any length,
+//	using a 16-byte nonce (unique message number) and a 32-byte secret key.
+//	Attackers can't modify or forge messages if the message sender transmits
+//	an authenticator along with each message and the message receiver checks
+//	each authenticator.
+//
+// A Poly1305 key must only ever be used to authenticate a single message. In
+// this module the key is always taken from the first block of a ChaCha20 or
+// Salsa20 keystream, which guarantees that.
+package poly1305
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	// KeySize is the length of Poly1305 keys, in bytes.
+	KeySize = 32
+	// TagSize is the length of Poly1305 authenticators, in bytes.
+	TagSize = 16
+)
+
+// Sum generates an authenticator for msg using a one-time key and puts the
+// 16-byte result into out.
+func Sum(out *[TagSize]byte, msg []byte, key *[KeySize]byte) {
+	m := New(key)
+	m.Write(msg)
+	m.finish(out)
+}
+
+// Verify returns true if mac is a valid authenticator for msg with the given
+// key. The comparison is done in constant time.
+func Verify(mac *[TagSize]byte, msg []byte, key *[KeySize]byte) bool {
+	var tag [TagSize]byte
+	Sum(&tag, msg, key)
+	return subtle.ConstantTimeCompare(tag[:], mac[:]) == 1
+}
+
+// MAC is an incremental Poly1305 authenticator. Data is fed to it with Write,
+// and the authenticator is produced by Sum or checked by Verify.
+type MAC struct {
+	h      [3]uint64     // the accumulator, h[2] holds bits 128 and up
+	r      [2]uint64     // the clamped first half of the key
+	s      [2]uint64     // the second half of the key
+	buf    [TagSize]byte // buffered bytes of a partial block
+	offset int           // the number of bytes buffered in buf
+}
+
+// New returns a new MAC computing an authenticator with the given one-time
+// key.
+func New(key *[KeySize]byte) *MAC {
+	m := new(MAC)
+	m.r[0] = binary.LittleEndian.Uint64(key[0:]) & 0x0ffffffc0fffffff
+	m.r[1] = binary.LittleEndian.Uint64(key[8:]) & 0x0ffffffc0ffffffc
+	m.s[0] = binary.LittleEndian.Uint64(key[16:])
+	m.s[1] = binary.LittleEndian.Uint64(key[24:])
+	return m
+}
+
+// Size returns the length of the authenticator, in bytes.
+func (m *MAC) Size() int {
+	return TagSize
+}
+
+// Write adds more data to the running authenticator. It never returns an
+// error.
+func (m *MAC) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if m.offset > 0 {
+		k := copy(m.buf[m.offset:], p)
+		m.offset += k
+		p = p[k:]
+		if m.offset < TagSize {
+			return n, nil
+		}
+		m.blocks(m.buf[:], true)
+		m.offset = 0
+	}
+
+	if full := len(p) - len(p)%TagSize; full > 0 {
+		m.blocks(p[:full], true)
+		p = p[full:]
+	}
+
+	m.offset = copy(m.buf[:], p)
+	return n, nil
+}
+
+// Sum appends the current authenticator to b and returns the resulting slice.
+// It does not change the underlying state, so more data may be written
+// afterwards.
+func (m *MAC) Sum(b []byte) []byte {
+	var tag [TagSize]byte
+	mm := *m
+	mm.finish(&tag)
+	return append(b, tag[:]...)
+}
+
+// Verify returns whether the authenticator of all data written so far is
+// equal to expected. The comparison is done in constant time.
+func (m *MAC) Verify(expected []byte) bool {
+	var tag [TagSize]byte
+	mm := *m
+	mm.finish(&tag)
+	return subtle.ConstantTimeCompare(tag[:], expected) == 1
+}
+
+// finish pads and processes any buffered partial block, then writes the
+// authenticator to out. It leaves the MAC in an unusable state.
+func (m *MAC) finish(out *[TagSize]byte) {
+	if m.offset > 0 {
+		// A partial block is padded with a single 1 bit followed by zeros,
+		// instead of having the 2^128 bit added.
+		m.buf[m.offset] = 1
+		for i := m.offset + 1; i < TagSize; i++ {
+			m.buf[i] = 0
+		}
+		m.blocks(m.buf[:], false)
+	}
+
+	h0, h1, h2 := m.h[0], m.h[1], m.h[2]
+
+	// Fully reduce h modulo 2^130 - 5 by computing h + 5 and keeping it
+	// instead of h if that overflows 2^130.
+	t0, c := bits.Add64(h0, 5, 0)
+	t1, c := bits.Add64(h1, 0, c)
+	t2, _ := bits.Add64(h2, 0, c)
+	mask := uint64(0) - (t2 >> 2)
+	h0 = (h0 &^ mask) | (t0 & mask)
+	h1 = (h1 &^ mask) | (t1 & mask)
+
+	// The authenticator is (h + s) mod 2^128.
+	h0, c = bits.Add64(h0, m.s[0], 0)
+	h1, _ = bits.Add64(h1, m.s[1], c)
+
+	binary.LittleEndian.PutUint64(out[0:], h0)
+	binary.LittleEndian.PutUint64(out[8:], h1)
+}
+
+// blocks processes a multiple of 16 bytes of input. If full is true, each
+// block has the 2^128 bit set, as all blocks but a padded final one do.
+func (m *MAC) blocks(p []byte, full bool) {
+	var hibit uint64
+	if full {
+		hibit = 1
+	}
+
+	h0, h1, h2 := m.h[0], m.h[1], m.h[2]
+	r0, r1 := m.r[0], m.r[1]
+
+	for len(p) >= TagSize {
+		// h += m
+		var c uint64
+		h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(p[0:]), 0)
+		h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(p[8:]), c)
+		h2 += c + hibit
+
+		// h *= r, as a schoolbook multiplication of the 130-bit h by the
+		// 124-bit r. Because h2 is at most 7 and r is clamped, the products
+		// involving h2 fit in 64 bits.
+		h0r0hi, h0r0lo := bits.Mul64(h0, r0)
+		h1r0hi, h1r0lo := bits.Mul64(h1, r0)
+		h0r1hi, h0r1lo := bits.Mul64(h0, r1)
+		h1r1hi, h1r1lo := bits.Mul64(h1, r1)
+		h2r0 := h2 * r0
+		h2r1 := h2 * r1
+
+		t0 := h0r0lo
+		t1, c := bits.Add64(h0r0hi, h1r0lo, 0)
+		t2, _ := bits.Add64(h1r0hi, h2r0, c)
+		t1, c = bits.Add64(t1, h0r1lo, 0)
+		t2, c = bits.Add64(t2, h0r1hi, c)
+		t3 := h1r1hi + c
+		t2, c = bits.Add64(t2, h1r1lo, 0)
+		t3 += h2r1 + c
+
+		// Partially reduce modulo 2^130 - 5. Splitting the product at bit
+		// 130 into a low part and a high part, 2^130 is congruent to 5, so
+		// the result is low + 5*high = low + 4*high + high.
+		h0, h1, h2 = t0, t1, t2&3
+		c0, c1 := t2&^3, t3
+		h0, c = bits.Add64(h0, c0, 0)
+		h1, c = bits.Add64(h1, c1, c)
+		h2 += c
+		c0 = c0>>2 | c1<<62
+		c1 >>= 2
+		h0, c = bits.Add64(h0, c0, 0)
+		h1, c = bits.Add64(h1, c1, c)
+		h2 += c
+
+		p = p[TagSize:]
+	}
+
+	m.h[0], m.h[1], m.h[2] = h0, h1, h2
+}
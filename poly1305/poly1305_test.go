@@ -0,0 +1,145 @@
+package poly1305_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/codahale/chacha20/poly1305"
+)
+
+// stolen from https://tools.ietf.org/html/rfc8439#section-2.5.2 and
+// https://tools.ietf.org/html/rfc8439#appendix-A.3
+type testVector struct {
+	key string
+	msg string
+	tag string
+}
+
+var testVectors = []testVector{
+	testVector{
+		"85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b",
+		hex.EncodeToString([]byte("Cryptographic Forum Research Group")),
+		"a8061dc1305136c6c22b8baf0c0127a9",
+	},
+	testVector{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+			"0000000000000000000000000000000000000000000000000000000000000000",
+		"00000000000000000000000000000000",
+	},
+	testVector{
+		"0200000000000000000000000000000000000000000000000000000000000000",
+		"ffffffffffffffffffffffffffffffff",
+		"03000000000000000000000000000000",
+	},
+	testVector{
+		"02000000000000000000000000000000ffffffffffffffffffffffffffffffff",
+		"02000000000000000000000000000000",
+		"03000000000000000000000000000000",
+	},
+	testVector{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"ffffffffffffffffffffffffffffffff" +
+			"f0ffffffffffffffffffffffffffffff" +
+			"11000000000000000000000000000000",
+		"05000000000000000000000000000000",
+	},
+	testVector{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"ffffffffffffffffffffffffffffffff" +
+			"fbfefefefefefefefefefefefefefefe" +
+			"01010101010101010101010101010101",
+		"00000000000000000000000000000000",
+	},
+	testVector{
+		"0200000000000000000000000000000000000000000000000000000000000000",
+		"fdffffffffffffffffffffffffffffff",
+		"faffffffffffffffffffffffffffffff",
+	},
+	testVector{
+		"0100000000000000040000000000000000000000000000000000000000000000",
+		"e33594d7505e43b900000000000000003394d7505e4379cd0100000000000000" +
+			"0000000000000000000000000000000001000000000000000000000000000000",
+		"14000000000000005500000000000000",
+	},
+	testVector{
+		"0100000000000000040000000000000000000000000000000000000000000000",
+		"e33594d7505e43b900000000000000003394d7505e4379cd0100000000000000" +
+			"00000000000000000000000000000000",
+		"13000000000000000000000000000000",
+	},
+}
+
+func decode(t *testing.T, vector testVector) (*[poly1305.KeySize]byte, []byte, []byte) {
+	k, err := hex.DecodeString(vector.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := hex.DecodeString(vector.msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := hex.DecodeString(vector.tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var key [poly1305.KeySize]byte
+	copy(key[:], k)
+
+	return &key, msg, tag
+}
+
+func TestSum(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		key, msg, expected := decode(t, vector)
+
+		var tag [poly1305.TagSize]byte
+		poly1305.Sum(&tag, msg, key)
+
+		if !bytes.Equal(expected, tag[:]) {
+			t.Errorf("Bad tag: expected %x, was %x", expected, tag)
+		}
+
+		if !poly1305.Verify(&tag, msg, key) {
+			t.Error("Should have verified the tag")
+		}
+
+		tag[0] ^= 1
+		if poly1305.Verify(&tag, msg, key) {
+			t.Error("Should have rejected a modified tag")
+		}
+	}
+}
+
+func TestMACIncremental(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		key, msg, expected := decode(t, vector)
+
+		// Feed the message in uneven pieces to exercise the partial block
+		// buffering.
+		m := poly1305.New(key)
+		for size := 1; len(msg) > 0; size += 3 {
+			if size > len(msg) {
+				size = len(msg)
+			}
+			m.Write(msg[:size])
+			msg = msg[size:]
+		}
+
+		if tag := m.Sum(nil); !bytes.Equal(expected, tag) {
+			t.Errorf("Bad tag: expected %x, was %x", expected, tag)
+		}
+
+		if !m.Verify(expected) {
+			t.Error("Should have verified the tag")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package quic implements QUIC header protection with ChaCha20, as described
+// in RFC 9001.
+//
+// After a packet's payload has been encrypted, 16 bytes of ciphertext are
+// sampled starting 4 bytes after the start of the Packet Number field. The
+// first 4 bytes of the sample are used as a little-endian 32-bit block counter
+// and the remaining 12 as the nonce for ChaCha20 keyed with the header
+// protection key, and the first 5 bytes of keystream are the mask. The first
+// byte of the mask protects the low 4 bits of the first byte of a long header
+// or the low 5 bits of a short header, and the remaining bytes protect the
+// Packet Number field, whose length is encoded in the first byte.
+//
+// For more information, see https://www.rfc-editor.org/rfc/rfc9001#section-5.4
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/codahale/chacha20"
+)
+
+const (
+	// KeySize is the length of a header protection key, in bytes.
+	KeySize = chacha20.KeySize
+	// SampleSize is the length of the ciphertext sample, in bytes.
+	SampleSize = 16
+	// MaskSize is the length of the header protection mask, in bytes.
+	MaskSize = 5
+
+	// the offset of the sample from the start of the Packet Number field
+	sampleOffset = 4
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20.ErrInvalidKey
+	// ErrInvalidSample is returned when the provided sample is not 128 bits
+	// long.
+	ErrInvalidSample = errors.New("invalid sample length (must be 128 bits)")
+	// ErrShortPacket is returned when a packet is too short to be sampled
+	// at the given Packet Number offset.
+	ErrShortPacket = errors.New("packet too short to sample")
+)
+
+// HeaderProtection applies and removes QUIC header protection.
+type HeaderProtection struct {
+	key [KeySize]byte
+}
+
+// NewHeaderProtection returns a HeaderProtection for the given 256-bit header
+// protection key, as derived with the "quic hp" label.
+func NewHeaderProtection(key []byte) (*HeaderProtection, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	h := new(HeaderProtection)
+	copy(h.key[:], key)
+	return h, nil
+}
+
+// Mask returns the header protection mask for the given 16-byte ciphertext
+// sample.
+func (h *HeaderProtection) Mask(sample []byte) ([MaskSize]byte, error) {
+	var mask [MaskSize]byte
+	if len(sample) != SampleSize {
+		return mask, ErrInvalidSample
+	}
+
+	counter := binary.LittleEndian.Uint32(sample)
+	s, err := chacha20.NewIETFWithCounter(h.key[:], sample[4:], counter)
+	if err != nil {
+		return mask, err
+	}
+
+	s.XORKeyStream(mask[:], mask[:])
+	return mask, nil
+}
+
+// Protect applies header protection in place to a packet whose payload has
+// already been encrypted. The Packet Number field of the packet starts at
+// pnOffset.
+func (h *HeaderProtection) Protect(packet []byte, pnOffset int) error {
+	mask, err := h.packetMask(packet, pnOffset)
+	if err != nil {
+		return err
+	}
+
+	// read the Packet Number length before it is masked
+	pnLength := int(packet[0]&0x03) + 1
+	packet[0] ^= mask[0] & firstByteMask(packet[0])
+	xorPacketNumber(packet[pnOffset:pnOffset+pnLength], mask)
+	return nil
+}
+
+// Unprotect removes header protection in place from a packet whose Packet
+// Number field starts at pnOffset, and returns the length of the Packet
+// Number field.
+func (h *HeaderProtection) Unprotect(packet []byte, pnOffset int) (int, error) {
+	mask, err := h.packetMask(packet, pnOffset)
+	if err != nil {
+		return 0, err
+	}
+
+	// read the Packet Number length after it is unmasked
+	packet[0] ^= mask[0] & firstByteMask(packet[0])
+	pnLength := int(packet[0]&0x03) + 1
+	xorPacketNumber(packet[pnOffset:pnOffset+pnLength], mask)
+	return pnLength, nil
+}
+
+func (h *HeaderProtection) packetMask(packet []byte, pnOffset int) ([MaskSize]byte, error) {
+	if pnOffset < 1 || len(packet)-pnOffset < sampleOffset+SampleSize {
+		return [MaskSize]byte{}, ErrShortPacket
+	}
+
+	start := pnOffset + sampleOffset
+	return h.Mask(packet[start : start+SampleSize])
+}
+
+// firstByteMask returns the bits of the first byte which are protected. The
+// Header Form bit is never protected, so it can be read either way.
+func firstByteMask(b byte) byte {
+	if b&0x80 != 0 {
+		// long header
+		return 0x0f
+	}
+	// short header
+	return 0x1f
+}
+
+func xorPacketNumber(pn []byte, mask [MaskSize]byte) {
+	for i := range pn {
+		pn[i] ^= mask[i+1]
+	}
+}
@@ -0,0 +1,184 @@
+package quic_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+	"github.com/codahale/chacha20/quic"
+)
+
+type testVector struct {
+	hp        string
+	pnOffset  int
+	pnLength  int
+	mask      string
+	header    string // the unprotected packet
+	protected string
+}
+
+var testVectors = []testVector{
+	// stolen from https://www.rfc-editor.org/rfc/rfc9001#appendix-A.5
+	testVector{
+		"25a282b9e82f06f21f488917a4fc8f1b73573685608597d0efcb076b0ab7a7a4",
+		1,
+		3,
+		"aefefe7d03",
+		"4200bff4655e5cd55c41f69080575d7999c25a5bfb",
+		"4cfe4189655e5cd55c41f69080575d7999c25a5bfb",
+	},
+	// an Initial packet with a 4-byte packet number and an arbitrary payload,
+	// whose mask is libsodium's crypto_stream_chacha20_ietf_xor_ic of five
+	// zero bytes, with the first four bytes of the sample as the little-endian
+	// block counter and the remaining twelve as the nonce
+	testVector{
+		"404142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f",
+		18,
+		4,
+		"d71b03ea39",
+		"c300000001088394c8f03e51570800004018000000020724415e7b98b5d2ef0c2946" +
+			"63809dbad7f4112e4b6885a2",
+		"c400000001088394c8f03e515708000040181b03ea3b0724415e7b98b5d2ef0c2946" +
+			"63809dbad7f4112e4b6885a2",
+	},
+}
+
+func TestProtect(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		hp, _ := hex.DecodeString(vector.hp)
+		packet, _ := hex.DecodeString(vector.header)
+
+		h, err := quic.NewHeaderProtection(hp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sample := packet[vector.pnOffset+4 : vector.pnOffset+4+quic.SampleSize]
+		mask, err := h.Mask(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(mask[:]) != vector.mask {
+			t.Errorf("Bad mask: expected %s, was %x", vector.mask, mask)
+		}
+
+		if err := h.Protect(packet, vector.pnOffset); err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(packet) != vector.protected {
+			t.Errorf("Bad packet: expected %s, was %x", vector.protected, packet)
+		}
+	}
+}
+
+func TestUnprotect(t *testing.T) {
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		hp, _ := hex.DecodeString(vector.hp)
+		packet, _ := hex.DecodeString(vector.protected)
+
+		h, err := quic.NewHeaderProtection(hp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pnLength, err := h.Unprotect(packet, vector.pnOffset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pnLength != vector.pnLength {
+			t.Errorf("Bad packet number length: expected %d, was %d", vector.pnLength, pnLength)
+		}
+
+		if hex.EncodeToString(packet) != vector.header {
+			t.Errorf("Bad packet: expected %s, was %x", vector.header, packet)
+		}
+	}
+}
+
+func TestPacketProtection(t *testing.T) {
+	// stolen from https://www.rfc-editor.org/rfc/rfc9001#appendix-A.5, which
+	// protects the payload before the header
+	key, _ := hex.DecodeString("c6d98ff3441c3fe1b2182094f69caa2ed4b716b65488960a7a984979fb23e1c8")
+	nonce, _ := hex.DecodeString("e0459b3474bdd0e46d417eb0")
+	header, _ := hex.DecodeString("4200bff4")
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := aead.Seal(header, nonce, []byte{0x01}, header)
+
+	hp, _ := hex.DecodeString(testVectors[0].hp)
+	h, _ := quic.NewHeaderProtection(hp)
+	if err := h.Protect(packet, len(header)-3); err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(packet) != testVectors[0].protected {
+		t.Errorf("Bad packet: expected %s, was %x", testVectors[0].protected, packet)
+	}
+}
+
+func TestBadInputs(t *testing.T) {
+	if _, err := quic.NewHeaderProtection(make([]byte, 16)); err != quic.ErrInvalidKey {
+		t.Error("Should have rejected a 128-bit key")
+	}
+
+	h, _ := quic.NewHeaderProtection(make([]byte, quic.KeySize))
+
+	if _, err := h.Mask(make([]byte, 15)); err != quic.ErrInvalidSample {
+		t.Error("Should have rejected a short sample")
+	}
+
+	packet := make([]byte, 20)
+	if err := h.Protect(packet, 1); err != quic.ErrShortPacket {
+		t.Error("Should have rejected a short packet")
+	}
+
+	if _, err := h.Unprotect(packet, 0); err != quic.ErrShortPacket {
+		t.Error("Should have rejected a Packet Number offset of zero")
+	}
+
+	if !bytes.Equal(packet, make([]byte, 20)) {
+		t.Error("Should not have modified a rejected packet")
+	}
+}
+
+func ExampleHeaderProtection() {
+	hp, err := hex.DecodeString("25a282b9e82f06f21f488917a4fc8f1b73573685608597d0efcb076b0ab7a7a4")
+	if err != nil {
+		panic(err)
+	}
+
+	h, err := quic.NewHeaderProtection(hp)
+	if err != nil {
+		panic(err)
+	}
+
+	// a short header with a 1-byte Packet Number, followed by the encrypted
+	// payload
+	packet, _ := hex.DecodeString("40" + "2a" + "0102030405060708090a0b0c0d0e0f101112131415")
+
+	if err := h.Protect(packet, 1); err != nil {
+		panic(err)
+	}
+
+	pnLength, err := h.Unprotect(packet, 1)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(packet[1 : 1+pnLength])
+	// Output:
+	// [42]
+}
@@ -0,0 +1,180 @@
+package chacha20
+
+import (
+	"errors"
+	"io"
+)
+
+// the length of IETF ChaCha20's keystream, in bytes
+const ietfKeystreamSize = 1 << 32 * blockSize
+
+// ErrInvalidOffset is returned when an offset is negative, or is beyond the
+// end of the keystream.
+var ErrInvalidOffset = errors.New("invalid keystream offset")
+
+// newSeekable returns an unstarted ChaCha20 stream for the given key and a
+// nonce of any of the supported lengths, which must be positioned with seek
+// before use.
+func newSeekable(key []byte, nonce []byte) (*stream, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	s := new(stream)
+	switch len(nonce) {
+	case NonceSize, IETFNonceSize:
+		s.init(key, nonce, 20)
+	case XNonceSize:
+		s.initX(key, nonce, 20)
+	default:
+		return nil, ErrInvalidNonce
+	}
+
+	return s, nil
+}
+
+// seek positions the stream at a byte offset in its keystream, by setting the
+// block counter directly and discarding the start of the block. The stream
+// must have at least n more bytes of keystream; IETF ChaCha20's ends at
+// 256 GiB.
+func (s *stream) seek(offset int64, n int) error {
+	if offset < 0 || (s.ietf && uint64(offset)+uint64(n) > ietfKeystreamSize) {
+		return ErrInvalidOffset
+	}
+
+	block, within := uint64(offset)/blockSize, int(uint64(offset)%blockSize)
+	if s.ietf {
+		if block == 1<<32 {
+			// The end of the keystream is the end of its last block.
+			block, within = block-1, blockSize
+		}
+		s.done = false
+	} else {
+		s.state[13] = uint32(block >> 32)
+	}
+	s.state[12] = uint32(block)
+
+	s.advance()
+	s.offset = within
+	return nil
+}
+
+// NewReaderAt returns an io.ReaderAt which decrypts the ChaCha20 ciphertext
+// read from r, jumping directly to the keystream for each offset. The nonce
+// may be 64, 96 or 192 bits long, for ChaCha20, IETF ChaCha20 or XChaCha20.
+// Like any io.ReaderAt, it is safe for concurrent use if r is.
+//
+// The ciphertext is not authenticated, and can be modified undetectably.
+func NewReaderAt(r io.ReaderAt, key []byte, nonce []byte) (io.ReaderAt, error) {
+	s, err := newSeekable(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readerAt{r: r, s: *s}, nil
+}
+
+type readerAt struct {
+	r io.ReaderAt
+	s stream // never used directly, only copied
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	s := r.s
+	if err := s.seek(off, len(p)); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.ReadAt(p, off)
+	s.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// NewWriterAt returns an io.WriterAt which encrypts data with ChaCha20 before
+// writing it to w, jumping directly to the keystream for each offset. The
+// nonce may be 64, 96 or 192 bits long, for ChaCha20, IETF ChaCha20 or
+// XChaCha20. It is safe for concurrent use if w is.
+//
+// Rewriting part of a file reuses its keystream, which reveals the XOR of the
+// old and new plaintext to anyone who sees both ciphertexts.
+func NewWriterAt(w io.WriterAt, key []byte, nonce []byte) (io.WriterAt, error) {
+	s, err := newSeekable(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writerAt{w: w, s: *s}, nil
+}
+
+type writerAt struct {
+	w io.WriterAt
+	s stream // never used directly, only copied
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	s := w.s
+	if err := s.seek(off, len(p)); err != nil {
+		return 0, err
+	}
+
+	// p must not be modified
+	buf := make([]byte, len(p))
+	s.XORKeyStream(buf, p)
+	return w.w.WriteAt(buf, off)
+}
+
+// NewReadSeeker returns an io.ReadSeeker which decrypts the ChaCha20
+// ciphertext read from r, continuing the keystream for sequential reads and
+// jumping directly to the keystream for the new offset after a seek. The
+// nonce may be 64, 96 or 192 bits long, for ChaCha20, IETF ChaCha20 or
+// XChaCha20. The offset of r must be zero.
+func NewReadSeeker(r io.ReadSeeker, key []byte, nonce []byte) (io.ReadSeeker, error) {
+	s, err := newSeekable(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	return &readSeeker{r: r, s: s}, nil
+}
+
+type readSeeker struct {
+	r      io.ReadSeeker
+	s      *stream
+	offset int64
+	err    error // the error from positioning the stream, if any
+}
+
+func (r *readSeeker) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if r.s.ietf && uint64(r.offset)+uint64(len(p)) > ietfKeystreamSize {
+		p = p[:ietfKeystreamSize-r.offset]
+		if len(p) == 0 {
+			return 0, ErrInvalidOffset
+		}
+	}
+
+	n, err := r.r.Read(p)
+	r.s.XORKeyStream(p[:n], p[:n])
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.r.Seek(offset, whence)
+	if err != nil {
+		return n, err
+	}
+
+	if n != r.offset {
+		r.offset = n
+		r.err = r.s.seek(n, 0)
+	}
+	return n, nil
+}
@@ -0,0 +1,278 @@
+package chacha20_test
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/codahale/chacha20"
+)
+
+func sequentialKey() []byte {
+	key := make([]byte, chacha20.KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+// the keystreams of each variant, encrypting zeros from the start
+func testStreams(t *testing.T, n int) map[int][]byte {
+	key := sequentialKey()
+
+	streams := make(map[int][]byte)
+	for _, size := range []int{chacha20.NonceSize, chacha20.IETFNonceSize, chacha20.XNonceSize} {
+		nonce := bytes.Repeat([]byte{0xaa}, size)
+
+		var s cipher.Stream
+		var err error
+		switch size {
+		case chacha20.NonceSize:
+			s, err = chacha20.New(key, nonce)
+		case chacha20.IETFNonceSize:
+			s, err = chacha20.NewIETF(key, nonce)
+		default:
+			s, err = chacha20.NewXChaCha(key, nonce)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, n)
+		s.XORKeyStream(b, b)
+		streams[size] = b
+	}
+	return streams
+}
+
+func TestReaderAt(t *testing.T) {
+	key := sequentialKey()
+
+	for size, ciphertext := range testStreams(t, 1000) {
+		t.Logf("Running %d-byte nonce", size)
+
+		r, err := chacha20.NewReaderAt(bytes.NewReader(ciphertext), key, bytes.Repeat([]byte{0xaa}, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the plaintext is all zeros
+		for _, off := range []int64{0, 1, 63, 64, 65, 500, 990} {
+			p := make([]byte, 17)
+			n, err := r.ReadAt(p, off)
+			if off+17 > 1000 {
+				if err != io.EOF || n != int(1000-off) {
+					t.Errorf("Bad read at %d: %d, %v", off, n, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(p[:n], make([]byte, n)) {
+				t.Errorf("Bad plaintext at %d: %x", off, p[:n])
+			}
+		}
+
+		if _, err := r.ReadAt(make([]byte, 1), -1); err != chacha20.ErrInvalidOffset {
+			t.Error("Should have rejected a negative offset")
+		}
+	}
+}
+
+func TestReaderAtConcurrent(t *testing.T) {
+	key := sequentialKey()
+	nonce := bytes.Repeat([]byte{0xaa}, chacha20.XNonceSize)
+	ciphertext := testStreams(t, 1<<16)[chacha20.XNonceSize]
+
+	r, err := chacha20.NewReaderAt(bytes.NewReader(ciphertext), key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+
+			p := make([]byte, 1000)
+			for j := 0; j < 100; j++ {
+				if _, err := r.ReadAt(p, off); err != nil {
+					t.Error(err)
+					return
+				}
+
+				if !bytes.Equal(p, make([]byte, len(p))) {
+					t.Errorf("Bad plaintext at %d", off)
+					return
+				}
+			}
+		}(int64(i * 4001))
+	}
+	wg.Wait()
+}
+
+func TestWriterAt(t *testing.T) {
+	key := sequentialKey()
+	nonce := bytes.Repeat([]byte{0xaa}, chacha20.NonceSize)
+	keystream := testStreams(t, 300)[chacha20.NonceSize]
+
+	f, err := os.CreateTemp(t.TempDir(), "chacha20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := chacha20.NewWriterAt(f, key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// out of order
+	zeros := make([]byte, 100)
+	for _, off := range []int64{200, 0, 100} {
+		if _, err := w.WriteAt(zeros, off); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(zeros, make([]byte, 100)) {
+		t.Error("Should not have modified the written data")
+	}
+
+	ciphertext, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ciphertext, keystream) {
+		t.Errorf("Bad ciphertext: expected %x, was %x", keystream, ciphertext)
+	}
+}
+
+func TestReadSeeker(t *testing.T) {
+	key := sequentialKey()
+	nonce := bytes.Repeat([]byte{0xaa}, chacha20.IETFNonceSize)
+	ciphertext := testStreams(t, 1000)[chacha20.IETFNonceSize]
+
+	r, err := chacha20.NewReadSeeker(bytes.NewReader(ciphertext), key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, 100)
+	for _, seek := range []struct {
+		offset int64
+		whence int
+	}{
+		{0, io.SeekCurrent},
+		{0, io.SeekCurrent}, // sequential
+		{777, io.SeekStart},
+		{-500, io.SeekCurrent},
+		{-100, io.SeekEnd},
+	} {
+		off, err := r.Seek(seek.offset, seek.whence)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.ReadFull(r, p); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(p, make([]byte, len(p))) {
+			t.Errorf("Bad plaintext at %d: %x", off, p)
+		}
+	}
+}
+
+func TestIETFOffsetLimit(t *testing.T) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.IETFNonceSize)
+
+	r, err := chacha20.NewReaderAt(new(zeros), key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := make([]byte, 64)
+	if _, err := r.ReadAt(last, 1<<38-64); err != nil {
+		t.Fatalf("Should have read the last block, was %v", err)
+	}
+
+	c, err := chacha20.NewIETFWithCounter(key, nonce, 0xffffffff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]byte, 64)
+	c.XORKeyStream(expected, expected)
+
+	if !bytes.Equal(expected, last) {
+		t.Errorf("Bad last block: expected %x, was %x", expected, last)
+	}
+
+	if _, err := r.ReadAt(make([]byte, 65), 1<<38-64); err != chacha20.ErrInvalidOffset {
+		t.Error("Should have rejected a read past the end of the keystream")
+	}
+
+	if _, err := r.ReadAt(make([]byte, 1), 1<<38); err != chacha20.ErrInvalidOffset {
+		t.Error("Should have rejected a read at the end of the keystream")
+	}
+
+	rs, err := chacha20.NewReadSeeker(new(zeros), key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rs.Seek(1<<38-64, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, 100)
+	n, err := rs.Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, p[:n]) {
+		t.Errorf("Bad last block: expected %x, was %x", expected, p[:n])
+	}
+
+	if _, err := rs.Read(p); err != chacha20.ErrInvalidOffset {
+		t.Error("Should have rejected a read at the end of the keystream")
+	}
+
+	if _, err := chacha20.NewReaderAt(bytes.NewReader(nil), key, make([]byte, 16)); err != chacha20.ErrInvalidNonce {
+		t.Error("Should have rejected a 128-bit nonce")
+	}
+}
+
+// zeros is an unlimited stream of zeros.
+type zeros struct {
+	offset int64
+}
+
+func (z *zeros) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (z *zeros) Read(p []byte) (int, error) {
+	n, err := z.ReadAt(p, z.offset)
+	z.offset += int64(n)
+	return n, err
+}
+
+func (z *zeros) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		panic("unsupported")
+	}
+	z.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,158 @@
+// Package secretbox provides NaCl and libsodium compatible secret-key
+// authenticated encryption.
+//
+// Seal and Open implement NaCl's crypto_secretbox, which combines XSalsa20 and
+// Poly1305. SealXChaCha and OpenXChaCha implement libsodium's
+// crypto_secretbox_xchacha20poly1305, the same construction with XChaCha20 in
+// place of XSalsa20.
+//
+// In both, the first 32 bytes of keystream are used as a one-time Poly1305 key
+// and the rest encrypts the message. The sealed box is the 16-byte
+// authenticator followed by the ciphertext, exactly as produced by the
+// libsodium "easy" API.
+//
+// For more information, see http://nacl.cr.yp.to/secretbox.html
+package secretbox
+
+import (
+	"crypto/cipher"
+	"errors"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/internal/salsa"
+	"github.com/codahale/chacha20/poly1305"
+)
+
+const (
+	// KeySize is the length of secretbox keys, in bytes.
+	KeySize = 32
+	// NonceSize is the length of secretbox nonces, in bytes.
+	NonceSize = 24
+	// Overhead is the number of bytes a sealed box is longer than its
+	// message.
+	Overhead = poly1305.TagSize
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20.ErrInvalidKey
+	// ErrInvalidNonce is returned when the provided nonce is not 192 bits
+	// long.
+	ErrInvalidNonce = chacha20.ErrInvalidXNonce
+	// ErrOpen is returned when a box cannot be opened, either because it was
+	// not sealed with the given key and nonce or because it was modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// Seal encrypts and authenticates message with XSalsa20 and Poly1305 and
+// appends the result to out, which must not overlap message. The key must be
+// 256 bits long and the nonce must be 192 bits long; a nonce must never be
+// used twice with the same key.
+func Seal(out, message, nonce, key []byte) ([]byte, error) {
+	s, err := newXSalsa20(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(out, message, s), nil
+}
+
+// Open authenticates and decrypts a box produced by Seal and appends the
+// message to out, which must not overlap box.
+func Open(out, box, nonce, key []byte) ([]byte, error) {
+	s, err := newXSalsa20(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(out, box, s)
+}
+
+// SealXChaCha encrypts and authenticates message with XChaCha20 and Poly1305
+// and appends the result to out, which must not overlap message. The key must
+// be 256 bits long and the nonce must be 192 bits long; a nonce must never be
+// used twice with the same key.
+func SealXChaCha(out, message, nonce, key []byte) ([]byte, error) {
+	s, err := chacha20.NewXChaCha(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(out, message, s), nil
+}
+
+// OpenXChaCha authenticates and decrypts a box produced by SealXChaCha and
+// appends the message to out, which must not overlap box.
+func OpenXChaCha(out, box, nonce, key []byte) ([]byte, error) {
+	s, err := chacha20.NewXChaCha(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(out, box, s)
+}
+
+func newXSalsa20(key, nonce []byte) (cipher.Stream, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(nonce) != NonceSize {
+		return nil, ErrInvalidNonce
+	}
+
+	var k [KeySize]byte
+	var n [NonceSize]byte
+	copy(k[:], key)
+	copy(n[:], nonce)
+
+	return salsa.NewXSalsa20(&k, &n), nil
+}
+
+func seal(out, message []byte, s cipher.Stream) []byte {
+	var polyKey [poly1305.KeySize]byte
+	s.XORKeyStream(polyKey[:], polyKey[:])
+
+	ret, box := sliceForAppend(out, Overhead+len(message))
+	s.XORKeyStream(box[Overhead:], message)
+
+	var tag [poly1305.TagSize]byte
+	poly1305.Sum(&tag, box[Overhead:], &polyKey)
+	copy(box, tag[:])
+
+	return ret
+}
+
+func open(out, box []byte, s cipher.Stream) ([]byte, error) {
+	if len(box) < Overhead {
+		return nil, ErrOpen
+	}
+
+	var polyKey [poly1305.KeySize]byte
+	s.XORKeyStream(polyKey[:], polyKey[:])
+
+	var tag [poly1305.TagSize]byte
+	copy(tag[:], box)
+	if !poly1305.Verify(&tag, box[Overhead:], &polyKey) {
+		return nil, ErrOpen
+	}
+
+	ret, message := sliceForAppend(out, len(box)-Overhead)
+	s.XORKeyStream(message, box[Overhead:])
+
+	return ret, nil
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
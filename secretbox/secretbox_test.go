@@ -0,0 +1,156 @@
+package secretbox_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/secretbox"
+)
+
+// stolen from NaCl's tests/secretbox.c, with the XChaCha20 box produced by
+// libsodium's crypto_secretbox_xchacha20poly1305_easy for the same inputs
+const (
+	testKey     = "1b27556473e985d462cd51197a9a46c76009549eac6474f206c4ee0844f68389"
+	testNonce   = "69696ee955b62b73cd62bda875fc73d68219e0036b7a0b37"
+	testMessage = "be075fc53c81f2d5cf141316ebeb0c7b5228c52a4c62cbd44b66849b64244ffc" +
+		"e5ecbaaf33bd751a1ac728d45e6c61296cdc3c01233561f41db66cce314adb31" +
+		"0e3be8250c46f06dceea3a7fa1348057e2f6556ad6b1318a024a838f21af1fde" +
+		"048977eb48f59ffd4924ca1c60902e52f0a089bc76897040e082f93776384864" +
+		"5e0705"
+	testBox = "f3ffc7703f9400e52a7dfb4b3d3305d98e993b9f48681273c29650ba32fc76ce" +
+		"48332ea7164d96a4476fb8c531a1186ac0dfc17c98dce87b4da7f011ec48c972" +
+		"71d2c20f9b928fe2270d6fb863d51738b48eeee314a7cc8ab932164548e526ae" +
+		"90224368517acfeabd6bb3732bc0e9da99832b61ca01b6de56244a9e88d5f9b3" +
+		"7973f622a43d14a6599b1f654cb45a74e355a5"
+	testXChaChaBox = "0c61fcffbc3fc8d3aa7464b91ab35374bf8af3198585e55d9cb07edcd1e5a695" +
+		"26547fbd0f2c642e9ee96e19462031f1032f1cd862bb952900103c06ac16344d" +
+		"7f9c9df0feaaf5a733dea7ea2df70a619936fcc5501de75c5d112e8abd7573c4" +
+		"61ada29ec016d131aa557804320011ff6d94092581ceea1bad3cf0d651938802" +
+		"ca867cd52bbe50c2da1161cb09514407609920"
+)
+
+type sealFunc func(out, message, nonce, key []byte) ([]byte, error)
+
+func testSealOpen(t *testing.T, seal, open sealFunc, expectedHex string) {
+	key, _ := hex.DecodeString(testKey)
+	nonce, _ := hex.DecodeString(testNonce)
+	message, _ := hex.DecodeString(testMessage)
+	expected, _ := hex.DecodeString(expectedHex)
+
+	box, err := seal(nil, message, nonce, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, box) {
+		t.Errorf("Bad box: expected %x, was %x", expected, box)
+	}
+
+	opened, err := open(nil, box, nonce, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(message, opened) {
+		t.Errorf("Bad message: expected %x, was %x", message, opened)
+	}
+
+	for _, i := range []int{0, secretbox.Overhead, len(box) - 1} {
+		box[i] ^= 1
+		if _, err := open(nil, box, nonce, key); err != secretbox.ErrOpen {
+			t.Errorf("Should have rejected a box modified at offset %d", i)
+		}
+		box[i] ^= 1
+	}
+
+	if _, err := open(nil, box[:secretbox.Overhead-1], nonce, key); err != secretbox.ErrOpen {
+		t.Error("Should have rejected a truncated box")
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	testSealOpen(t, secretbox.Seal, secretbox.Open, testBox)
+}
+
+func TestSecretBoxXChaCha(t *testing.T) {
+	testSealOpen(t, secretbox.SealXChaCha, secretbox.OpenXChaCha, testXChaChaBox)
+}
+
+func TestSealAppends(t *testing.T) {
+	key := make([]byte, secretbox.KeySize)
+	nonce := make([]byte, secretbox.NonceSize)
+
+	prefix := []byte("prefix")
+	box, err := secretbox.Seal(prefix, []byte("message"), nonce, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(box, prefix) {
+		t.Errorf("Should have kept the prefix, was %x", box)
+	}
+
+	message, err := secretbox.Open(nil, box[len(prefix):], nonce, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(message) != "message" {
+		t.Errorf("Bad message: %q", message)
+	}
+}
+
+func TestBadKeySize(t *testing.T) {
+	key := make([]byte, 3)
+	nonce := make([]byte, secretbox.NonceSize)
+
+	if _, err := secretbox.Seal(nil, nil, nonce, key); err != secretbox.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+
+	if _, err := secretbox.SealXChaCha(nil, nil, nonce, key); err != secretbox.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+}
+
+func TestBadNonceSize(t *testing.T) {
+	key := make([]byte, secretbox.KeySize)
+	nonce := make([]byte, 3)
+
+	if _, err := secretbox.Seal(nil, nil, nonce, key); err != secretbox.ErrInvalidNonce {
+		t.Error("Should have rejected an invalid nonce")
+	}
+
+	if _, err := secretbox.SealXChaCha(nil, nil, nonce, key); err != secretbox.ErrInvalidNonce {
+		t.Error("Should have rejected an invalid nonce")
+	}
+}
+
+func ExampleSealXChaCha() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	// A nonce should only be used once. Generate it randomly.
+	nonce, err := hex.DecodeString("308c92676fa95973308c92676fa95973308c92676fa95973")
+	if err != nil {
+		panic(err)
+	}
+
+	box, err := secretbox.SealXChaCha(nil, []byte("hello I am a secret message"), nonce, key)
+	if err != nil {
+		panic(err)
+	}
+
+	message, err := secretbox.OpenXChaCha(nil, box, nonce, key)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", message)
+	// Output:
+	// hello I am a secret message
+}
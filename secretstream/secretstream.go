@@ -0,0 +1,327 @@
+// Package secretstream provides a libsodium compatible implementation of
+// crypto_secretstream_xchacha20poly1305, which encrypts a sequence of
+// messages under a single key.
+//
+// An Encryptor produces a 24-byte header which must be sent ahead of the
+// encrypted messages. Each message is pushed with a Tag, and the Decryptor
+// pulls messages in the same order, returning each message's tag. Reordered,
+// dropped, duplicated or modified messages fail to decrypt.
+//
+// The key for the stream is derived from the key and the header with
+// HChaCha20, and each message is encrypted with IETF ChaCha20 and
+// authenticated with Poly1305. After every message the nonce is mixed with
+// the message's authenticator, and the key is ratcheted forward whenever a
+// message is tagged with TagRekey or the message counter wraps around.
+//
+// For more information, see
+// https://doc.libsodium.org/secret-key_cryptography/secretstream
+package secretstream
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/poly1305"
+)
+
+const (
+	// KeySize is the length of secretstream keys, in bytes.
+	KeySize = chacha20.KeySize
+	// HeaderSize is the length of the stream header, in bytes.
+	HeaderSize = chacha20.XNonceSize
+	// Overhead is the number of bytes an encrypted message is longer than its
+	// plaintext: the encrypted tag followed by the authenticator.
+	Overhead = 1 + poly1305.TagSize
+
+	// MaxMessageSize is the length of the longest message which can be
+	// pushed, in bytes.
+	MaxMessageSize = 64 * (1<<32 - 2)
+
+	counterSize = 4
+	inonceSize  = 8
+)
+
+// A Tag is attached to each message and returned when it is decrypted.
+type Tag byte
+
+const (
+	// TagMessage is the most common tag, which adds no information about the
+	// nature of the message.
+	TagMessage Tag = 0
+	// TagPush marks the end of a set of messages, but not the end of the
+	// stream.
+	TagPush Tag = 1
+	// TagRekey ratchets the key forward after the message, so that previous
+	// messages cannot be decrypted with the new key.
+	TagRekey Tag = 2
+	// TagFinal marks the end of the stream. It also ratchets the key.
+	TagFinal = TagPush | TagRekey
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20.ErrInvalidKey
+	// ErrInvalidHeader is returned when the provided header is not 192 bits
+	// long.
+	ErrInvalidHeader = errors.New("invalid header length (must be 192 bits)")
+	// ErrMessageTooLarge is returned when a message is longer than
+	// MaxMessageSize.
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrOpen is returned when a message cannot be decrypted, either because
+	// it was modified or because it is not the next message in the stream.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// An Encryptor encrypts a stream of messages.
+type Encryptor struct {
+	header [HeaderSize]byte
+	state  state
+}
+
+// NewEncryptor creates and returns a new Encryptor with the given key and a
+// random header read from rand. The key argument must be 256 bits long.
+func NewEncryptor(key []byte, rand io.Reader) (*Encryptor, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	e := new(Encryptor)
+	if _, err := io.ReadFull(rand, e.header[:]); err != nil {
+		return nil, err
+	}
+
+	if err := e.state.init(key, e.header[:]); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Header returns the stream header, which must be passed to NewDecryptor.
+func (e *Encryptor) Header() []byte {
+	return append([]byte(nil), e.header[:]...)
+}
+
+// Push encrypts and authenticates message and the optional additionalData
+// with the given tag, and appends the result to out, which must not overlap
+// message.
+func (e *Encryptor) Push(out, message, additionalData []byte, tag Tag) ([]byte, error) {
+	if uint64(len(message)) > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	ret, c := sliceForAppend(out, len(message)+Overhead)
+	s, mac := e.state.begin(additionalData)
+
+	var block [64]byte
+	block[0] = byte(tag)
+	s.XORKeyStream(block[:], block[:])
+	mac.Write(block[:])
+	c[0] = block[0]
+
+	body := c[1 : 1+len(message)]
+	s.XORKeyStream(body, message)
+	e.state.end(mac, additionalData, body, c[1+len(message):])
+
+	e.state.ratchet(c[1+len(message):], tag)
+
+	return ret, nil
+}
+
+// Rekey explicitly ratchets the key forward, without adding information to
+// the stream. The Decryptor must call Rekey at the same point in the stream.
+func (e *Encryptor) Rekey() {
+	e.state.rekey()
+}
+
+// A Decryptor decrypts a stream of messages produced by an Encryptor.
+type Decryptor struct {
+	state state
+}
+
+// NewDecryptor creates and returns a new Decryptor with the given key and the
+// header produced by the Encryptor. The key argument must be 256 bits long,
+// and the header argument must be 192 bits long.
+func NewDecryptor(key, header []byte) (*Decryptor, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(header) != HeaderSize {
+		return nil, ErrInvalidHeader
+	}
+
+	d := new(Decryptor)
+	if err := d.state.init(key, header); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Pull authenticates and decrypts the next message of the stream with the
+// optional additionalData, appends it to out, which must not overlap
+// ciphertext, and returns the message's tag. If the message cannot be
+// authenticated, the Decryptor's state is left unchanged.
+func (d *Decryptor) Pull(out, ciphertext, additionalData []byte) ([]byte, Tag, error) {
+	if len(ciphertext) < Overhead {
+		return nil, 0, ErrOpen
+	}
+
+	n := len(ciphertext) - Overhead
+	if uint64(n) > MaxMessageSize {
+		return nil, 0, ErrMessageTooLarge
+	}
+
+	s, mac := d.state.begin(additionalData)
+
+	var block [64]byte
+	block[0] = ciphertext[0]
+	s.XORKeyStream(block[:], block[:])
+	tag := Tag(block[0])
+	block[0] = ciphertext[0]
+	mac.Write(block[:])
+
+	body := ciphertext[1 : 1+n]
+	var expected [poly1305.TagSize]byte
+	d.state.end(mac, additionalData, body, expected[:])
+
+	stored := ciphertext[1+n:]
+	if subtle.ConstantTimeCompare(expected[:], stored) != 1 {
+		return nil, 0, ErrOpen
+	}
+
+	ret, message := sliceForAppend(out, n)
+	s.XORKeyStream(message, body)
+
+	d.state.ratchet(stored, tag)
+
+	return ret, tag, nil
+}
+
+// Rekey explicitly ratchets the key forward, mirroring a call to the
+// Encryptor's Rekey at the same point in the stream.
+func (d *Decryptor) Rekey() {
+	d.state.rekey()
+}
+
+type state struct {
+	key   [KeySize]byte                // the current stream key
+	nonce [chacha20.IETFNonceSize]byte // the message counter and inonce
+}
+
+func (st *state) init(key, header []byte) error {
+	subkey, err := chacha20.HChaCha20(key, header[:chacha20.HNonceSize])
+	if err != nil {
+		return err
+	}
+
+	copy(st.key[:], subkey)
+	st.resetCounter()
+	copy(st.nonce[counterSize:], header[chacha20.HNonceSize:])
+
+	return nil
+}
+
+// begin starts processing a message, returning the keystream positioned at
+// block 1 and a MAC which has absorbed the padded additional data.
+func (st *state) begin(additionalData []byte) (cipher.Stream, *poly1305.MAC) {
+	s := newKeystream(st)
+
+	var polyKey [poly1305.KeySize]byte
+	var block [64]byte
+	s.XORKeyStream(block[:], block[:])
+	copy(polyKey[:], block[:])
+
+	mac := poly1305.New(&polyKey)
+	mac.Write(additionalData)
+	pad16(mac, len(additionalData))
+
+	return s, mac
+}
+
+// end finishes the MAC over the encrypted body and the lengths, and writes
+// the authenticator to out.
+func (st *state) end(mac *poly1305.MAC, additionalData, body, out []byte) {
+	mac.Write(body)
+
+	// libsodium computes this padding as (0x10 - 64 + len(body)) & 0xf, which
+	// is len(body) % 16 bytes rather than the number needed to reach a block
+	// boundary. It is reproduced here for compatibility.
+	mac.Write(zeros[:len(body)%16])
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], 64+uint64(len(body)))
+	mac.Write(lengths[:])
+
+	copy(out, mac.Sum(nil))
+}
+
+// ratchet updates the state after a message with the given authenticator and
+// tag.
+func (st *state) ratchet(authenticator []byte, tag Tag) {
+	inonce := st.nonce[counterSize:]
+	for i := range inonce {
+		inonce[i] ^= authenticator[i]
+	}
+
+	counter := binary.LittleEndian.Uint32(st.nonce[:counterSize]) + 1
+	binary.LittleEndian.PutUint32(st.nonce[:counterSize], counter)
+
+	if tag&TagRekey != 0 || counter == 0 {
+		st.rekey()
+	}
+}
+
+// rekey replaces the key and the inonce with keystream generated by
+// encrypting them, and resets the counter.
+func (st *state) rekey() {
+	var buf [KeySize + inonceSize]byte
+	copy(buf[:], st.key[:])
+	copy(buf[KeySize:], st.nonce[counterSize:])
+
+	s := newKeystream(st)
+	s.XORKeyStream(buf[:], buf[:])
+
+	copy(st.key[:], buf[:KeySize])
+	copy(st.nonce[counterSize:], buf[KeySize:])
+	st.resetCounter()
+}
+
+func (st *state) resetCounter() {
+	binary.LittleEndian.PutUint32(st.nonce[:counterSize], 1)
+}
+
+func newKeystream(st *state) cipher.Stream {
+	// The key and nonce are always the right sizes.
+	s, _ := chacha20.NewIETF(st.key[:], st.nonce[:])
+	return s
+}
+
+var zeros [16]byte
+
+// pad16 writes zeros to the MAC to pad n bytes of input to a multiple of 16.
+func pad16(mac *poly1305.MAC, n int) {
+	if n%16 != 0 {
+		mac.Write(zeros[:16-n%16])
+	}
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,217 @@
+package secretstream_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/secretstream"
+)
+
+// produced by libsodium: crypto_secretstream_xchacha20poly1305_init_push with
+// the key 000102...1f, which chose the header at random, then
+// crypto_secretstream_xchacha20poly1305_push for each message with its
+// additional data and tag, calling crypto_secretstream_xchacha20poly1305_rekey
+// before the last
+const (
+	testKey    = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testHeader = "7765c8612180deb9e4d3fe2a2668ade5023e70956f7fd788"
+)
+
+type testMessage struct {
+	message        string
+	additionalData string
+	tag            secretstream.Tag
+	rekeyBefore    bool
+	ciphertext     string
+}
+
+var testMessages = []testMessage{
+	testMessage{
+		"Arbitrary data to encrypt", "", secretstream.TagMessage, false,
+		"23b26836bfd92c5e026026ed18f39270c549be799421fda38661ca6c6284c98a" +
+			"e95cf19e7a95306bbf5d",
+	},
+	testMessage{
+		"split into", "", secretstream.TagPush, false,
+		"7b69553c2507a19f81daee126e6fc2bd20771b58bf9eb892f296e1",
+	},
+	testMessage{
+		"three messages", "with ad", secretstream.TagRekey, false,
+		"e2df8e5be4e32c1bc1bf4894dcbacd50028c9e57a8017aa322bfe438de170a",
+	},
+	testMessage{
+		"", "", secretstream.TagMessage, false,
+		"07825def16aa1f0590d1ae633e86bb328b",
+	},
+	testMessage{
+		"and one more after an explicit rekey, long enough to span a couple " +
+			"of ChaCha20 blocks of keystream.", "", secretstream.TagFinal, true,
+		"67c9108447651ca55aa9073bd3884056ae356fd44d64c642f39868bfea60f818" +
+			"bd3b0e8bb7665946452aafc11a474c754119018527178b547f6c03f5f6d4948f" +
+			"12f42b4d963c24a696caf972f5da0d7d759c84d347309e933ac8fd962ba23a60" +
+			"e130f3c926e4ac619453767f5ff4624ffa174466",
+	},
+}
+
+func TestPush(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+	header, _ := hex.DecodeString(testHeader)
+
+	e, err := secretstream.NewEncryptor(key, bytes.NewReader(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(header, e.Header()) {
+		t.Errorf("Bad header: expected %x, was %x", header, e.Header())
+	}
+
+	for i, m := range testMessages {
+		t.Logf("Running test message %d", i)
+
+		if m.rekeyBefore {
+			e.Rekey()
+		}
+
+		expected, _ := hex.DecodeString(m.ciphertext)
+		c, err := e.Push(nil, []byte(m.message), []byte(m.additionalData), m.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(expected, c) {
+			t.Errorf("Bad ciphertext: expected %x, was %x", expected, c)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+	header, _ := hex.DecodeString(testHeader)
+
+	d, err := secretstream.NewDecryptor(key, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range testMessages {
+		t.Logf("Running test message %d", i)
+
+		if m.rekeyBefore {
+			d.Rekey()
+		}
+
+		c, _ := hex.DecodeString(m.ciphertext)
+
+		// A modified message must fail without disturbing the state.
+		c[len(c)-1] ^= 1
+		if _, _, err := d.Pull(nil, c, []byte(m.additionalData)); err != secretstream.ErrOpen {
+			t.Error("Should have rejected a modified message")
+		}
+		c[len(c)-1] ^= 1
+
+		message, tag, err := d.Pull(nil, c, []byte(m.additionalData))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(message) != m.message {
+			t.Errorf("Bad message: expected %q, was %q", m.message, message)
+		}
+
+		if tag != m.tag {
+			t.Errorf("Bad tag: expected %d, was %d", m.tag, tag)
+		}
+	}
+}
+
+func TestReordered(t *testing.T) {
+	key := make([]byte, secretstream.KeySize)
+
+	e, err := secretstream.NewEncryptor(key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := e.Push(nil, []byte("first"), nil, secretstream.TagMessage)
+	second, _ := e.Push(nil, []byte("second"), nil, secretstream.TagMessage)
+
+	d, err := secretstream.NewDecryptor(key, e.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := d.Pull(nil, second, nil); err != secretstream.ErrOpen {
+		t.Error("Should have rejected an out of order message")
+	}
+
+	if _, _, err := d.Pull(nil, first, nil); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, err := d.Pull(nil, first, nil); err != secretstream.ErrOpen {
+		t.Error("Should have rejected a replayed message")
+	}
+}
+
+func TestBadKeySize(t *testing.T) {
+	key := make([]byte, 3)
+
+	if _, err := secretstream.NewEncryptor(key, rand.Reader); err != secretstream.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+
+	if _, err := secretstream.NewDecryptor(key, make([]byte, secretstream.HeaderSize)); err != secretstream.ErrInvalidKey {
+		t.Error("Should have rejected an invalid key")
+	}
+}
+
+func TestBadHeaderSize(t *testing.T) {
+	key := make([]byte, secretstream.KeySize)
+
+	if _, err := secretstream.NewDecryptor(key, make([]byte, 3)); err != secretstream.ErrInvalidHeader {
+		t.Error("Should have rejected an invalid header")
+	}
+}
+
+func ExampleEncryptor() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	e, err := secretstream.NewEncryptor(key, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	first, err := e.Push(nil, []byte("hello I am"), nil, secretstream.TagMessage)
+	if err != nil {
+		panic(err)
+	}
+
+	last, err := e.Push(nil, []byte("a secret stream"), nil, secretstream.TagFinal)
+	if err != nil {
+		panic(err)
+	}
+
+	d, err := secretstream.NewDecryptor(key, e.Header())
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range [][]byte{first, last} {
+		message, tag, err := d.Pull(nil, c, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("%s (final: %v)\n", message, tag == secretstream.TagFinal)
+	}
+	// Output:
+	// hello I am (final: false)
+	// a secret stream (final: true)
+}
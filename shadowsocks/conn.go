@@ -0,0 +1,205 @@
+package shadowsocks
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// Conn wraps a stream connection, such as TCP, so that everything written to
+// it is encrypted and everything read from it is decrypted and
+// authenticated. Like any net.Conn, the returned connection may be read from
+// and written to concurrently.
+func (c *Cipher) Conn(conn net.Conn) net.Conn {
+	return &streamConn{Conn: conn, c: c}
+}
+
+type streamConn struct {
+	net.Conn
+	c *Cipher
+
+	wmu    sync.Mutex
+	waead  cipher.AEAD
+	wnonce []byte
+	werr   error
+
+	rmu    sync.Mutex
+	raead  cipher.AEAD
+	rnonce []byte
+	rbuf   []byte // the unread part of the last payload
+	rerr   error
+}
+
+func (s *streamConn) Write(b []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if s.werr != nil {
+		return 0, s.werr
+	}
+
+	var out []byte
+	if s.waead == nil {
+		salt := make([]byte, SaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return 0, err
+		}
+
+		aead, err := s.c.aead(salt)
+		if err != nil {
+			return 0, err
+		}
+
+		s.waead, s.wnonce = aead, make([]byte, aead.NonceSize())
+		out = salt
+	}
+
+	n := 0
+	for n < len(b) {
+		payload := b[n:min(len(b), n+MaxPayloadSize)]
+
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(payload)))
+
+		out = s.waead.Seal(out, s.wnonce, length[:], nil)
+		increment(s.wnonce)
+		out = s.waead.Seal(out, s.wnonce, payload, nil)
+		increment(s.wnonce)
+
+		if _, err := s.Conn.Write(out); err != nil {
+			// a partial chunk leaves the stream unusable
+			s.werr = err
+			return n, err
+		}
+
+		n += len(payload)
+		out = out[:0]
+	}
+
+	// the salt is sent even when nothing else is
+	if len(out) > 0 {
+		if _, err := s.Conn.Write(out); err != nil {
+			s.werr = err
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
+func (s *streamConn) Read(b []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	for len(s.rbuf) == 0 {
+		if s.rerr != nil {
+			return 0, s.rerr
+		}
+
+		s.rerr = s.readChunk()
+	}
+
+	n := copy(b, s.rbuf)
+	s.rbuf = s.rbuf[n:]
+	return n, nil
+}
+
+// readChunk reads, authenticates and decrypts the next chunk into rbuf,
+// reading the salt first if needed. A stream which ends between chunks
+// returns io.EOF.
+func (s *streamConn) readChunk() error {
+	if s.raead == nil {
+		salt := make([]byte, SaltSize)
+		if _, err := io.ReadFull(s.Conn, salt); err != nil {
+			return err
+		}
+
+		aead, err := s.c.aead(salt)
+		if err != nil {
+			return err
+		}
+
+		s.raead, s.rnonce = aead, make([]byte, aead.NonceSize())
+	}
+
+	buf := make([]byte, 2+Overhead, MaxPayloadSize+Overhead)
+	if _, err := io.ReadFull(s.Conn, buf); err != nil {
+		return err
+	}
+
+	length, err := s.raead.Open(buf[:0], s.rnonce, buf, nil)
+	if err != nil {
+		return ErrOpen
+	}
+	increment(s.rnonce)
+
+	n := int(binary.BigEndian.Uint16(length))
+	if n > MaxPayloadSize {
+		return ErrInvalidChunk
+	}
+
+	buf = buf[:n+Overhead]
+	if _, err := io.ReadFull(s.Conn, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if s.rbuf, err = s.raead.Open(buf[:0], s.rnonce, buf, nil); err != nil {
+		return ErrOpen
+	}
+	increment(s.rnonce)
+
+	return nil
+}
+
+// PacketConn wraps a packet connection, such as UDP, so that every packet
+// written to it is encrypted and every packet read from it is decrypted and
+// authenticated. ReadFrom returns ErrOpen for a packet which cannot be
+// authenticated, and the connection can continue to be read from.
+func (c *Cipher) PacketConn(conn net.PacketConn) net.PacketConn {
+	return &packetConn{PacketConn: conn, c: c}
+}
+
+type packetConn struct {
+	net.PacketConn
+	c *Cipher
+}
+
+func (p *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	packet, err := p.c.SealPacket(nil, b)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := p.PacketConn.WriteTo(packet, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, maxPacketSize)
+	n, addr, err := p.PacketConn.ReadFrom(buf)
+	if err != nil {
+		return 0, addr, err
+	}
+
+	payload, err := p.c.OpenPacket(buf[SaltSize:SaltSize], buf[:n])
+	if err != nil {
+		return 0, addr, err
+	}
+
+	if len(payload) > len(b) {
+		return copy(b, payload), addr, io.ErrShortBuffer
+	}
+	return copy(b, payload), addr, nil
+}
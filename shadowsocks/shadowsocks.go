@@ -0,0 +1,166 @@
+// Package shadowsocks implements the AEAD ciphers of the Shadowsocks proxy
+// protocol, chacha20-ietf-poly1305 and xchacha20-ietf-poly1305, as net.Conn
+// and net.PacketConn wrappers.
+//
+// Both directions of a stream begin with a random salt as long as the key.
+// A session subkey is derived from the key and the salt with HKDF-SHA1 and
+// the info string "ss-subkey", and the stream is a series of chunks, each an
+// encrypted 2-byte big-endian payload length followed by the encrypted
+// payload. Every encryption uses the next nonce, starting at zero and
+// incremented as a little-endian integer, and payloads are at most 0x3FFF
+// bytes long.
+//
+// Each packet is a random salt followed by its payload, encrypted under the
+// derived subkey with an all-zero nonce.
+//
+// The wrappers only protect the data. The target address which a client
+// sends first, in both streams and packets, is left to the caller.
+//
+// For more information, see https://shadowsocks.org/doc/aead.html
+package shadowsocks
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"io"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// MethodChaCha20Poly1305 is the name of the ChaCha20-Poly1305 cipher.
+	MethodChaCha20Poly1305 = "chacha20-ietf-poly1305"
+	// MethodXChaCha20Poly1305 is the name of the XChaCha20-Poly1305 cipher.
+	MethodXChaCha20Poly1305 = "xchacha20-ietf-poly1305"
+
+	// KeySize is the length of a key, in bytes.
+	KeySize = chacha20poly1305.KeySize
+	// SaltSize is the length of the salt which begins each stream and
+	// packet, in bytes.
+	SaltSize = KeySize
+	// MaxPayloadSize is the largest payload of a stream chunk, in bytes.
+	MaxPayloadSize = 0x3FFF
+	// Overhead is the length of the tag which follows each encrypted length
+	// and payload, in bytes.
+	Overhead = chacha20poly1305.Overhead
+
+	// the largest packet which can be received
+	maxPacketSize = 64 * 1024
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20poly1305.ErrInvalidKey
+	// ErrUnsupportedMethod is returned when a cipher is not one of the
+	// ChaCha20-Poly1305 methods.
+	ErrUnsupportedMethod = errors.New("unsupported method")
+	// ErrInvalidChunk is returned when a chunk's length is larger than
+	// MaxPayloadSize.
+	ErrInvalidChunk = errors.New("invalid chunk length")
+	// ErrShortPacket is returned when a packet is too short to contain a salt
+	// and a tag.
+	ErrShortPacket = errors.New("packet too short")
+	// ErrOpen is returned when a chunk or packet cannot be authenticated,
+	// either because it was not sealed with the given key or because it was
+	// modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// KeyFromPassword derives a key from a password as Shadowsocks does, with
+// OpenSSL's EVP_BytesToKey, MD5 and no salt. Passwords are poor keys, and
+// random keys should be preferred where both ends allow it.
+func KeyFromPassword(password string) []byte {
+	var key, prev []byte
+	for len(key) < KeySize {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:KeySize]
+}
+
+// Cipher is a Shadowsocks AEAD cipher with a pre-shared key.
+type Cipher struct {
+	key     []byte
+	newAEAD func([]byte) (cipher.AEAD, error)
+}
+
+// New returns a Cipher for the given method and 256-bit key.
+func New(method string, key []byte) (*Cipher, error) {
+	c := &Cipher{key: append([]byte{}, key...)}
+
+	switch method {
+	case MethodChaCha20Poly1305:
+		c.newAEAD = chacha20poly1305.New
+	case MethodXChaCha20Poly1305:
+		c.newAEAD = chacha20poly1305.NewX
+	default:
+		return nil, ErrUnsupportedMethod
+	}
+
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	return c, nil
+}
+
+// aead returns the AEAD for the subkey derived from the given salt.
+func (c *Cipher) aead(salt []byte) (cipher.AEAD, error) {
+	subkey, err := hkdf.Key(sha1.New, c.key, salt, "ss-subkey", KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return c.newAEAD(subkey)
+}
+
+// SealPacket encrypts a packet's payload under a random salt, and appends
+// the packet to dst.
+func (c *Cipher) SealPacket(dst, payload []byte) ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := c.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(append(dst, salt...), make([]byte, aead.NonceSize()), payload, nil), nil
+}
+
+// OpenPacket authenticates and decrypts a packet, and appends its payload to
+// dst.
+func (c *Cipher) OpenPacket(dst, packet []byte) ([]byte, error) {
+	if len(packet) < SaltSize+Overhead {
+		return nil, ErrShortPacket
+	}
+
+	aead, err := c.aead(packet[:SaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := aead.Open(dst, make([]byte, aead.NonceSize()), packet[SaltSize:], nil)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return out, nil
+}
+
+// increment adds one to a little-endian nonce.
+func increment(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
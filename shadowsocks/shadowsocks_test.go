@@ -0,0 +1,258 @@
+package shadowsocks_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/codahale/chacha20/shadowsocks"
+)
+
+type testVector struct {
+	method string
+	stream string // "Hello, world!" and "Goodbye!" as two chunks
+	packet string // "Hello, world!"
+}
+
+const testKey = "b3adc47839e047eb228870526dc8fc30b347287ffca3045dcea06b3fdf090acb"
+
+// made with the key derived from the password "barfoo!", the salts 000102...1f
+// for the stream and 202122...3f for the packet, and subkeys derived with
+// HKDF-SHA1 using Python's hmac module. Each length and payload was sealed
+// with libsodium's crypto_aead_chacha20poly1305_ietf_encrypt or
+// crypto_aead_xchacha20poly1305_ietf_encrypt, with no additional data and a
+// little-endian counter as the nonce, starting from zero in each stream and
+// packet.
+var testVectors = []testVector{
+	testVector{
+		shadowsocks.MethodChaCha20Poly1305,
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1fa0222a75" +
+			"0cf40b493b59b331c7bd973c7f9e06d76ee13ab34b4df02faae75e79ee137ff9c08ab9ae" +
+			"3090156134633ded4794dba8dda9fdbcb211c344da4a05832354a6ec275f82f319386177" +
+			"36b884e9daf22f25065e034ab6",
+		"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3fa45fd387" +
+			"3eb70ccc7cc23eedc6deb04017d676bc9c531aef620fedb851",
+	},
+	testVector{
+		shadowsocks.MethodXChaCha20Poly1305,
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f19bd972a" +
+			"1f23a9a51daab510d93976e17b6deb1c82f04527f34964d6181f6045f6a6534f6b6bebdf" +
+			"09b5793a34a03787fbb80e1f9e128d5294dc1d7dc9e8671d0d28364d3dfd52241aafcf23" +
+			"2f523c8ba5498dc97bcc1038b8",
+		"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f256fa57d" +
+			"1b120d40a08a784d2a80188c8490abe249d191c535fecd99ea",
+	},
+}
+
+// readStream returns everything read from a wrapped connection whose peer
+// sends the given data and closes.
+func readStream(c *shadowsocks.Cipher, data []byte) ([]byte, error) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	return io.ReadAll(c.Conn(server))
+}
+
+func TestKeyFromPassword(t *testing.T) {
+	key := hex.EncodeToString(shadowsocks.KeyFromPassword("barfoo!"))
+	if key != testKey {
+		t.Errorf("Bad key: expected %s, was %s", testKey, key)
+	}
+}
+
+func TestStream(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		c, err := shadowsocks.New(vector.method, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := hex.DecodeString(vector.stream)
+
+		plaintext, err := readStream(c, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plaintext) != "Hello, world!Goodbye!" {
+			t.Errorf("Bad plaintext: expected %q, was %q", "Hello, world!Goodbye!", plaintext)
+		}
+
+		// the last byte is part of the second chunk's tag
+		data[len(data)-1] ^= 1
+		if _, err := readStream(c, data); err != shadowsocks.ErrOpen {
+			t.Error("Should have rejected a modified chunk")
+		}
+
+		if _, err := readStream(c, data[:len(data)-1]); err != io.ErrUnexpectedEOF {
+			t.Error("Should have rejected a truncated chunk")
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		c, err := shadowsocks.New(vector.method, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packet, _ := hex.DecodeString(vector.packet)
+
+		payload, err := c.OpenPacket(nil, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(payload) != "Hello, world!" {
+			t.Errorf("Bad payload: expected %q, was %q", "Hello, world!", payload)
+		}
+
+		packet[0] ^= 1
+		if _, err := c.OpenPacket(nil, packet); err != shadowsocks.ErrOpen {
+			t.Error("Should have rejected a modified salt")
+		}
+
+		if _, err := c.OpenPacket(nil, packet[:shadowsocks.SaltSize]); err != shadowsocks.ErrShortPacket {
+			t.Error("Should have rejected a short packet")
+		}
+	}
+}
+
+func TestConnLoopback(t *testing.T) {
+	c, err := shadowsocks.New(shadowsocks.MethodXChaCha20Poly1305, make([]byte, shadowsocks.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// an echo server
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		io.Copy(c.Conn(conn), c.Conn(conn))
+	}()
+
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	conn := c.Conn(raw)
+
+	// several chunks
+	sent := bytes.Repeat([]byte("0123456789"), 10000)
+	go conn.Write(sent)
+
+	received := make([]byte, len(sent))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sent, received) {
+		t.Error("Bad echo")
+	}
+}
+
+func TestPacketConnLoopback(t *testing.T) {
+	c, err := shadowsocks.New(shadowsocks.MethodChaCha20Poly1305, make([]byte, shadowsocks.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawServer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawServer.Close()
+	server := c.PacketConn(rawServer)
+
+	rawClient, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawClient.Close()
+	client := c.PacketConn(rawClient)
+
+	// an unauthenticated packet is rejected without closing the connection
+	if _, err := rawClient.WriteTo(make([]byte, 64), server.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	if _, _, err := server.ReadFrom(buf); err != shadowsocks.ErrOpen {
+		t.Error("Should have rejected an unauthenticated packet")
+	}
+
+	if _, err := client.WriteTo([]byte("ping"), server.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, addr, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != "ping" {
+		t.Errorf("Bad payload: expected %q, was %q", "ping", buf[:n])
+	}
+
+	if addr.String() != client.LocalAddr().String() {
+		t.Errorf("Bad address: expected %v, was %v", client.LocalAddr(), addr)
+	}
+}
+
+func TestBadInputs(t *testing.T) {
+	if _, err := shadowsocks.New("aes-256-gcm", make([]byte, 32)); err != shadowsocks.ErrUnsupportedMethod {
+		t.Error("Should have rejected an unsupported method")
+	}
+
+	if _, err := shadowsocks.New(shadowsocks.MethodChaCha20Poly1305, make([]byte, 16)); err != shadowsocks.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+}
+
+func Example() {
+	c, err := shadowsocks.New(shadowsocks.MethodChaCha20Poly1305, shadowsocks.KeyFromPassword("barfoo!"))
+	if err != nil {
+		panic(err)
+	}
+
+	client, server := net.Pipe()
+	go func() {
+		conn := c.Conn(client)
+		conn.Write([]byte("hello I am a proxied request"))
+		conn.Close()
+	}()
+
+	b, err := io.ReadAll(c.Conn(server))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s\n", b)
+	// Output:
+	// hello I am a proxied request
+}
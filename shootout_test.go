@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rc4"
+	"math/rand"
 	"testing"
 
 	"github.com/codahale/chacha20"
@@ -42,3 +43,89 @@ func BenchmarkRC4(b *testing.B) {
 	c, _ := rc4.NewCipher(key)
 	benchmarkStream(b, c)
 }
+
+// a batch of records between 100 and 500 bytes long, each with its own key
+// and nonce
+func batchJobs() ([]chacha20.Job, int64) {
+	r := rand.New(rand.NewSource(1))
+
+	var n int64
+	jobs := make([]chacha20.Job, 1000)
+	for i := range jobs {
+		size := 100 + r.Intn(401)
+		jobs[i] = chacha20.Job{
+			Key:   make([]byte, chacha20.KeySize),
+			Nonce: make([]byte, chacha20.IETFNonceSize),
+			Dst:   make([]byte, size),
+			Src:   make([]byte, size),
+		}
+		r.Read(jobs[i].Key)
+		r.Read(jobs[i].Nonce)
+		n += int64(size)
+	}
+	return jobs, n
+}
+
+func BenchmarkChaCha20Batch(b *testing.B) {
+	jobs, n := batchJobs()
+	b.SetBytes(n)
+	for i := 0; i < b.N; i++ {
+		if err := chacha20.XORKeyStreamBatch(jobs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkChaCha20BatchLoop(b *testing.B) {
+	jobs, n := batchJobs()
+	b.SetBytes(n)
+	for i := 0; i < b.N; i++ {
+		for _, job := range jobs {
+			c, err := chacha20.NewIETF(job.Key, job.Nonce)
+			if err != nil {
+				b.Fatal(err)
+			}
+			c.XORKeyStream(job.Dst, job.Src)
+		}
+	}
+}
+
+// packets of a 13-byte header, a 1400-byte body and a 16-byte trailer, so most
+// slices begin part way through a block
+func vecBuffers() ([][]byte, int64) {
+	var bufs [][]byte
+	var n int64
+	for n < benchSize {
+		for _, size := range []int{13, 1400, 16} {
+			bufs = append(bufs, make([]byte, size))
+			n += int64(size)
+		}
+	}
+	return bufs, n
+}
+
+func BenchmarkChaCha20Vec(b *testing.B) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.NonceSize)
+	c, _ := chacha20.New(key, nonce)
+
+	bufs, n := vecBuffers()
+	b.SetBytes(n)
+	for i := 0; i < b.N; i++ {
+		chacha20.XORKeyStreamVec(c, bufs, bufs)
+	}
+}
+
+func BenchmarkChaCha20VecLoop(b *testing.B) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.NonceSize)
+	c, _ := chacha20.New(key, nonce)
+
+	bufs, n := vecBuffers()
+	b.SetBytes(n)
+	for i := 0; i < b.N; i++ {
+		for _, buf := range bufs {
+			c.XORKeyStream(buf, buf)
+		}
+	}
+}
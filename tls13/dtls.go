@@ -0,0 +1,180 @@
+package tls13
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+
+	"github.com/codahale/chacha20"
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// the fixed bits of the unified header's first byte
+	unifiedHeaderFixed = 0x20
+	unifiedHeaderMask  = 0xe0
+	// the unified header's flags
+	flagConnectionID   = 0x10
+	flagSequenceNumber = 0x08 // the sequence number is 16 bits, not 8
+	flagLength         = 0x04
+	epochMask          = 0x03
+
+	// the length of the ciphertext sampled for record number encryption
+	sampleSize = 16
+)
+
+// DTLSProtector seals and opens the DTLS 1.3 records sent in one direction in
+// one epoch. Records are sealed with the unified header of RFC 9147, with a
+// 16-bit sequence number and a length, and their sequence numbers are
+// encrypted with a key derived from the traffic secret.
+//
+// Unlike Protector, records may be opened in any order. Each record's full
+// sequence number is reconstructed from its low bits as the one closest to
+// the sequence number following the highest one opened. DTLSProtector does
+// not detect replayed records.
+type DTLSProtector struct {
+	aead  cipher.AEAD
+	iv    []byte
+	snKey []byte
+	epoch uint64
+	seq   uint64 // the sequence number of the next record to seal
+	next  uint64 // the highest sequence number opened, plus one
+	done  bool   // whether the sequence number is exhausted
+}
+
+// NewDTLS returns a DTLSProtector for the given 256-bit traffic secret and
+// epoch, starting at sequence number zero.
+func NewDTLS(trafficSecret []byte, epoch uint64) (*DTLSProtector, error) {
+	aead, iv, err := trafficKeys(trafficSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DTLSProtector{
+		aead:  aead,
+		iv:    iv,
+		snKey: ExpandLabel(trafficSecret, "sn", nil, chacha20poly1305.KeySize),
+		epoch: epoch,
+	}, nil
+}
+
+// Seal protects content of the given type, followed by the given number of
+// bytes of zero padding, and appends the DTLSCiphertext record to dst.
+func (p *DTLSProtector) Seal(dst []byte, contentType ContentType, content []byte, padding int) ([]byte, error) {
+	if p.done {
+		return nil, ErrSequenceOverflow
+	}
+
+	plaintext, err := innerPlaintext(contentType, content, padding)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte{
+		unifiedHeaderFixed | flagSequenceNumber | flagLength | byte(p.epoch&epochMask),
+		0, 0, 0, 0,
+	}
+	binary.BigEndian.PutUint16(header[1:], uint16(p.seq))
+	binary.BigEndian.PutUint16(header[3:], uint16(len(plaintext)+p.aead.Overhead()))
+
+	out := append(dst, header...)
+	out = p.aead.Seal(out, nonce(p.iv, p.seq), plaintext, header)
+
+	// encrypt the sequence number in place
+	record := out[len(dst):]
+	mask := p.mask(record[len(header):])
+	record[1] ^= mask[0]
+	record[2] ^= mask[1]
+
+	p.seq++
+	p.done = p.seq == 0
+	return out, nil
+}
+
+// Open authenticates and decrypts a single DTLSCiphertext record, returning
+// its real content type and its content with the padding removed. Records
+// without a length field are assumed to extend to the end of record. Records
+// with a connection ID or from a different epoch are rejected.
+func (p *DTLSProtector) Open(record []byte) (ContentType, []byte, error) {
+	if len(record) < 1 || record[0]&unifiedHeaderMask != unifiedHeaderFixed ||
+		record[0]&flagConnectionID != 0 ||
+		uint64(record[0]&epochMask) != p.epoch&epochMask {
+		return 0, nil, ErrInvalidRecord
+	}
+
+	snLen := 1
+	if record[0]&flagSequenceNumber != 0 {
+		snLen = 2
+	}
+
+	headerLen := 1 + snLen
+	if record[0]&flagLength != 0 {
+		headerLen += 2
+	}
+
+	if len(record) < headerLen+sampleSize || len(record)-headerLen > maxCiphertextSize {
+		return 0, nil, ErrInvalidRecord
+	}
+
+	if record[0]&flagLength != 0 &&
+		int(binary.BigEndian.Uint16(record[1+snLen:])) != len(record)-headerLen {
+		return 0, nil, ErrInvalidRecord
+	}
+
+	// decrypt the sequence number into a copy of the header
+	header := make([]byte, headerLen)
+	copy(header, record)
+	mask := p.mask(record[headerLen:])
+
+	var low uint64
+	for i := 0; i < snLen; i++ {
+		header[1+i] ^= mask[i]
+		low = low<<8 | uint64(header[1+i])
+	}
+	seq := reconstruct(p.next, low, uint(8*snLen))
+
+	plaintext, err := p.aead.Open(nil, nonce(p.iv, seq), record[headerLen:], header)
+	if err != nil {
+		return 0, nil, ErrOpen
+	}
+
+	if len(plaintext) > maxPlaintextSize {
+		return 0, nil, ErrInvalidRecord
+	}
+
+	if seq >= p.next {
+		p.next = seq + 1
+	}
+
+	return parseInnerPlaintext(plaintext)
+}
+
+// mask returns the record number encryption mask for a record's ciphertext,
+// which is at least sampleSize bytes long.
+func (p *DTLSProtector) mask(ciphertext []byte) [2]byte {
+	counter := binary.LittleEndian.Uint32(ciphertext)
+	s, err := chacha20.NewIETFWithCounter(p.snKey, ciphertext[4:sampleSize], counter)
+	if err != nil {
+		// Never happens, the key and nonce are always the right length.
+		panic(err)
+	}
+
+	var mask [2]byte
+	s.XORKeyStream(mask[:], mask[:])
+	return mask
+}
+
+// reconstruct returns the sequence number with the given low bits which is
+// closest to expected.
+func reconstruct(expected, low uint64, bits uint) uint64 {
+	window := uint64(1) << bits
+	candidate := expected&^(window-1) | low
+
+	switch {
+	case candidate+window/2 < expected && candidate+window > candidate:
+		return candidate + window
+	case candidate > expected+window/2 && candidate >= window:
+		return candidate - window
+	default:
+		return candidate
+	}
+}
@@ -0,0 +1,226 @@
+// Package tls13 implements record protection for the TLS 1.3 (RFC 8446) and
+// DTLS 1.3 (RFC 9147) TLS_CHACHA20_POLY1305_SHA256 cipher suite, without the
+// rest of the protocol.
+//
+// A traffic secret, as produced by the handshake's key schedule, is expanded
+// into a write key and IV with HKDF-Expand-Label. Each record is sealed with
+// a nonce formed by XORing its 64-bit sequence number into the IV, and the
+// record header as additional data. The plaintext of a record is its content,
+// followed by its real content type and any zero padding, which hides the
+// type and length of the content from observers.
+//
+// For more information, see https://tools.ietf.org/html/rfc8446#section-5
+package tls13
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+// A ContentType is the type of a record's content.
+type ContentType uint8
+
+const (
+	// ContentTypeChangeCipherSpec is the type of the compatibility
+	// change_cipher_spec record, which is never protected.
+	ContentTypeChangeCipherSpec ContentType = 20
+	// ContentTypeAlert is the type of alert records.
+	ContentTypeAlert ContentType = 21
+	// ContentTypeHandshake is the type of handshake records.
+	ContentTypeHandshake ContentType = 22
+	// ContentTypeApplicationData is the type of application data records,
+	// and the outer type of all protected records.
+	ContentTypeApplicationData ContentType = 23
+)
+
+const (
+	// SecretSize is the length of a traffic secret, in bytes.
+	SecretSize = sha256.Size
+	// HeaderSize is the length of a TLSCiphertext record header, in bytes.
+	HeaderSize = 5
+	// MaxContentSize is the length of the longest content a record can
+	// contain, in bytes.
+	MaxContentSize = 1 << 14
+
+	// the longest TLSInnerPlaintext, including the content type and padding
+	maxPlaintextSize = MaxContentSize + 1
+	// the longest encrypted record, without its header
+	maxCiphertextSize = MaxContentSize + 256
+	// the legacy_record_version of every protected record
+	legacyVersion = 0x0303
+)
+
+var (
+	// ErrInvalidSecret is returned when the provided traffic secret is not 256
+	// bits long.
+	ErrInvalidSecret = errors.New("invalid secret length (must be 256 bits)")
+	// ErrInvalidRecord is returned when a record's header is not that of a
+	// protected record, its length does not match its contents, or it is too
+	// long.
+	ErrInvalidRecord = errors.New("invalid record")
+	// ErrRecordTooLong is returned when the content and padding of a record
+	// would be longer than a record can contain.
+	ErrRecordTooLong = errors.New("record too long")
+	// ErrSequenceOverflow is returned when the record sequence number is
+	// exhausted, and the traffic keys must be updated.
+	ErrSequenceOverflow = errors.New("record sequence number exhausted")
+	// ErrNoContentType is returned when an opened record contains only
+	// padding.
+	ErrNoContentType = errors.New("record has no content type")
+	// ErrOpen is returned when a record cannot be authenticated, either
+	// because it was not sealed with the given traffic secret and sequence
+	// number or because it was modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// ExpandLabel implements HKDF-Expand-Label with SHA-256, deriving a secret of
+// the given length from the given secret, label (without the "tls13 "
+// prefix) and context.
+func ExpandLabel(secret []byte, label string, context []byte, length int) []byte {
+	info := make([]byte, 0, 4+len("tls13 ")+len(label)+len(context))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len("tls13 ")+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+
+	out, err := hkdf.Expand(sha256.New, secret, string(info), length)
+	if err != nil {
+		// Never happens, the secret is always long enough and the output
+		// always short enough.
+		panic(err)
+	}
+	return out
+}
+
+// trafficKeys derives the write key and IV from a traffic secret.
+func trafficKeys(secret []byte) (cipher.AEAD, []byte, error) {
+	if len(secret) != SecretSize {
+		return nil, nil, ErrInvalidSecret
+	}
+
+	aead, err := chacha20poly1305.New(ExpandLabel(secret, "key", nil, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return aead, ExpandLabel(secret, "iv", nil, chacha20poly1305.NonceSize), nil
+}
+
+// nonce XORs a 64-bit sequence number into the IV.
+func nonce(iv []byte, seq uint64) []byte {
+	n := make([]byte, len(iv))
+	copy(n, iv)
+	for i := 0; i < 8; i++ {
+		n[len(n)-1-i] ^= byte(seq >> (8 * uint(i)))
+	}
+	return n
+}
+
+// innerPlaintext returns the TLSInnerPlaintext of a record.
+func innerPlaintext(contentType ContentType, content []byte, padding int) ([]byte, error) {
+	if padding < 0 || len(content)+1+padding > maxPlaintextSize {
+		return nil, ErrRecordTooLong
+	}
+
+	p := make([]byte, len(content)+1+padding)
+	copy(p, content)
+	p[len(content)] = byte(contentType)
+	return p, nil
+}
+
+// parseInnerPlaintext strips the padding from a TLSInnerPlaintext, returning
+// the content type and the content.
+func parseInnerPlaintext(p []byte) (ContentType, []byte, error) {
+	i := len(p) - 1
+	for i >= 0 && p[i] == 0 {
+		i--
+	}
+
+	if i < 0 {
+		return 0, nil, ErrNoContentType
+	}
+
+	return ContentType(p[i]), p[:i], nil
+}
+
+// Protector seals and opens the TLS 1.3 records sent in one direction with one
+// traffic secret. Records must be opened in the order in which they were
+// sealed.
+type Protector struct {
+	aead cipher.AEAD
+	iv   []byte
+	seq  uint64
+	done bool // whether the sequence number is exhausted
+}
+
+// New returns a Protector for the given 256-bit traffic secret, starting at
+// sequence number zero.
+func New(trafficSecret []byte) (*Protector, error) {
+	aead, iv, err := trafficKeys(trafficSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Protector{aead: aead, iv: iv}, nil
+}
+
+// Seal protects content of the given type, followed by the given number of
+// bytes of zero padding, and appends the TLSCiphertext record to dst.
+func (p *Protector) Seal(dst []byte, contentType ContentType, content []byte, padding int) ([]byte, error) {
+	if p.done {
+		return nil, ErrSequenceOverflow
+	}
+
+	plaintext, err := innerPlaintext(contentType, content, padding)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte{byte(ContentTypeApplicationData), legacyVersion >> 8, legacyVersion & 0xff, 0, 0}
+	binary.BigEndian.PutUint16(header[3:], uint16(len(plaintext)+p.aead.Overhead()))
+
+	out := append(dst, header...)
+	out = p.aead.Seal(out, nonce(p.iv, p.seq), plaintext, header)
+	p.increment()
+	return out, nil
+}
+
+// Open authenticates and decrypts a TLSCiphertext record, returning its real
+// content type and its content with the padding removed.
+func (p *Protector) Open(record []byte) (ContentType, []byte, error) {
+	if p.done {
+		return 0, nil, ErrSequenceOverflow
+	}
+
+	if len(record) < HeaderSize ||
+		record[0] != byte(ContentTypeApplicationData) ||
+		binary.BigEndian.Uint16(record[1:]) != legacyVersion ||
+		int(binary.BigEndian.Uint16(record[3:])) != len(record)-HeaderSize ||
+		len(record)-HeaderSize > maxCiphertextSize {
+		return 0, nil, ErrInvalidRecord
+	}
+
+	plaintext, err := p.aead.Open(nil, nonce(p.iv, p.seq), record[HeaderSize:], record[:HeaderSize])
+	if err != nil {
+		return 0, nil, ErrOpen
+	}
+
+	if len(plaintext) > maxPlaintextSize {
+		return 0, nil, ErrInvalidRecord
+	}
+
+	p.increment()
+	return parseInnerPlaintext(plaintext)
+}
+
+func (p *Protector) increment() {
+	p.seq++
+	p.done = p.seq == 0
+}
@@ -0,0 +1,330 @@
+package tls13_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/codahale/chacha20/tls13"
+)
+
+// the server handshake traffic secret from
+// https://tools.ietf.org/html/rfc8448#section-3
+const trafficSecret = "b67b7d690cc16c4e75e54213cb2d37b4e9c912bcded9105d42befd59d391ad38"
+
+// RFC 8448 only uses TLS_AES_128_GCM_SHA256, so these records were made from
+// its traffic secret: the key, iv and sn were derived with HKDF-Expand-Label
+// using Python's hmac module, and each record was sealed with libsodium's
+// crypto_aead_chacha20poly1305_ietf_encrypt, with the iv XORed with the
+// sequence number as the nonce and the record header as the additional data.
+// Each DTLS record's sequence number was then masked with libsodium's
+// crypto_stream_chacha20_ietf_xor_ic under sn, with the first 16 bytes of the
+// ciphertext as the block counter and nonce.
+type testVector struct {
+	epoch       uint64 // DTLS only
+	seq         uint64
+	contentType tls13.ContentType
+	content     string
+	padding     int
+	record      string
+}
+
+var tlsVectors = []testVector{
+	testVector{
+		0,
+		0,
+		tls13.ContentTypeHandshake,
+		"14000020000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		0,
+		"17030300354473757003ce958119233a5968bf58e7b191c8247e667e4c12130ca8f76e04" +
+			"68bd6cd92f173d2ca74d455eac9fd5d379e10aad8636",
+	},
+	testVector{
+		0,
+		1,
+		tls13.ContentTypeApplicationData,
+		"474554202f20485454502f312e310d0a0d0a",
+		0,
+		"17030300233fd70595516648bbe25fa888dc86642080d5e8dc7ac61da20fe29e51de8adc" +
+			"c7c83878",
+	},
+	testVector{
+		0,
+		2,
+		tls13.ContentTypeAlert,
+		"0100",
+		13,
+		"1703030020ae76dc15abf26b2eaafe280bd238ac8bac3762ffabc27933b248025754444b" +
+			"6c",
+	},
+}
+
+var dtlsVectors = []testVector{
+	testVector{
+		3,
+		0,
+		tls13.ContentTypeApplicationData,
+		"70696e67",
+		0,
+		"2f73f20015201a1b3714069f301c010b4279b65c6aa478875cc2",
+	},
+	testVector{
+		3,
+		1,
+		tls13.ContentTypeAlert,
+		"0100",
+		0,
+		"2f6ef90013799244852ad044dbbac1da002d5f2d42b0809c",
+	},
+	testVector{
+		3,
+		0x12345,
+		tls13.ContentTypeApplicationData,
+		"706f6e67",
+		7,
+		"2ff90d001c2e1a04edad1a60664563ac2edbc0980aeb906c616c3b7c8ea1637287",
+	},
+}
+
+func decode(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func TestExpandLabel(t *testing.T) {
+	// stolen from https://tools.ietf.org/html/rfc8448#section-3
+	secret := decode(trafficSecret)
+
+	key := tls13.ExpandLabel(secret, "key", nil, 16)
+	if hex.EncodeToString(key) != "3fce516009c21727d0f2e4e86ee403bc" {
+		t.Errorf("Bad key: expected %s, was %x", "3fce516009c21727d0f2e4e86ee403bc", key)
+	}
+
+	iv := tls13.ExpandLabel(secret, "iv", nil, 12)
+	if hex.EncodeToString(iv) != "5d313eb2671276ee13000b30" {
+		t.Errorf("Bad IV: expected %s, was %x", "5d313eb2671276ee13000b30", iv)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	p, err := tls13.New(decode(trafficSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, vector := range tlsVectors {
+		t.Logf("Running test vector %d", i)
+
+		record, err := p.Seal(nil, vector.contentType, decode(vector.content), vector.padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(record) != vector.record {
+			t.Errorf("Bad record: expected %s, was %x", vector.record, record)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	p, err := tls13.New(decode(trafficSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// records must be opened in order
+	if _, _, err := p.Open(decode(tlsVectors[1].record)); err != tls13.ErrOpen {
+		t.Error("Should have rejected an out of order record")
+	}
+
+	for i, vector := range tlsVectors {
+		t.Logf("Running test vector %d", i)
+
+		record := decode(vector.record)
+		record[len(record)-1] ^= 1
+		if _, _, err := p.Open(record); err != tls13.ErrOpen {
+			t.Error("Should have rejected a modified record")
+		}
+		record[len(record)-1] ^= 1
+
+		contentType, content, err := p.Open(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if contentType != vector.contentType {
+			t.Errorf("Bad content type: expected %d, was %d", vector.contentType, contentType)
+		}
+
+		if hex.EncodeToString(content) != vector.content {
+			t.Errorf("Bad content: expected %s, was %x", vector.content, content)
+		}
+	}
+}
+
+func TestBadRecords(t *testing.T) {
+	sender, _ := tls13.New(decode(trafficSecret))
+	record, _ := sender.Seal(nil, tls13.ContentTypeApplicationData, []byte("hello"), 0)
+
+	records := [][]byte{
+		record[:4],
+		// a handshake record
+		append([]byte{22}, record[1:]...),
+		// TLS 1.0
+		append([]byte{23, 3, 1}, record[3:]...),
+		// a length which doesn't match
+		append(append([]byte{}, record...), 0),
+	}
+
+	for i, r := range records {
+		t.Logf("Running record %d", i)
+
+		p, _ := tls13.New(decode(trafficSecret))
+		if _, _, err := p.Open(r); err != tls13.ErrInvalidRecord {
+			t.Errorf("Should have rejected an invalid record, was %v", err)
+		}
+	}
+
+	padding, _ := sender.Seal(nil, 0, nil, 10)
+	p, _ := tls13.New(decode(trafficSecret))
+	p.Open(record)
+	if _, _, err := p.Open(padding); err != tls13.ErrNoContentType {
+		t.Errorf("Should have rejected a record with no content type, was %v", err)
+	}
+
+	if _, err := sender.Seal(nil, tls13.ContentTypeApplicationData, make([]byte, tls13.MaxContentSize), 1); err != tls13.ErrRecordTooLong {
+		t.Error("Should have rejected a record with too much padding")
+	}
+
+	if _, err := tls13.New(make([]byte, 48)); err != tls13.ErrInvalidSecret {
+		t.Error("Should have rejected a SHA-384 secret")
+	}
+}
+
+func TestDTLSSeal(t *testing.T) {
+	for i, vector := range dtlsVectors {
+		t.Logf("Running test vector %d", i)
+
+		p, err := tls13.NewDTLS(decode(trafficSecret), vector.epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for seq := uint64(0); seq < vector.seq; seq++ {
+			if _, err := p.Seal(nil, tls13.ContentTypeApplicationData, nil, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		record, err := p.Seal(nil, vector.contentType, decode(vector.content), vector.padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(record) != vector.record {
+			t.Errorf("Bad record: expected %s, was %x", vector.record, record)
+		}
+	}
+}
+
+func TestDTLSOpen(t *testing.T) {
+	for i, vector := range dtlsVectors {
+		t.Logf("Running test vector %d", i)
+
+		sender, _ := tls13.NewDTLS(decode(trafficSecret), vector.epoch)
+		receiver, err := tls13.NewDTLS(decode(trafficSecret), vector.epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the receiver needs to have seen recent records to reconstruct the
+		// full sequence number
+		for seq := uint64(0); seq < vector.seq; seq++ {
+			record, _ := sender.Seal(nil, tls13.ContentTypeApplicationData, nil, 0)
+			if seq%0x4000 == 0x3fff {
+				if _, _, err := receiver.Open(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		contentType, content, err := receiver.Open(decode(vector.record))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if contentType != vector.contentType {
+			t.Errorf("Bad content type: expected %d, was %d", vector.contentType, contentType)
+		}
+
+		if hex.EncodeToString(content) != vector.content {
+			t.Errorf("Bad content: expected %s, was %x", vector.content, content)
+		}
+
+		other, _ := tls13.NewDTLS(decode(trafficSecret), vector.epoch+1)
+		if _, _, err := other.Open(decode(vector.record)); err != tls13.ErrInvalidRecord {
+			t.Error("Should have rejected a record from another epoch")
+		}
+	}
+}
+
+func TestDTLSReordering(t *testing.T) {
+	sender, _ := tls13.NewDTLS(decode(trafficSecret), 2)
+	receiver, _ := tls13.NewDTLS(decode(trafficSecret), 2)
+
+	var records [][]byte
+	for i := 0; i < 5; i++ {
+		record, _ := sender.Seal(nil, tls13.ContentTypeApplicationData, []byte{byte(i)}, 0)
+		records = append(records, record)
+	}
+
+	for _, i := range []int{3, 0, 4, 1, 2} {
+		_, content, err := receiver.Open(records[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(content, []byte{byte(i)}) {
+			t.Errorf("Bad content: expected %d, was %x", i, content)
+		}
+	}
+
+	// records without a length extend to the end of the datagram
+	short := append([]byte{records[0][0] &^ 0x04}, records[0][1:3]...)
+	short = append(short, records[0][5:]...)
+	if _, _, err := receiver.Open(short); err != tls13.ErrOpen {
+		t.Error("Should have authenticated the header without a length")
+	}
+}
+
+func Example() {
+	secret, err := hex.DecodeString("b67b7d690cc16c4e75e54213cb2d37b4e9c912bcded9105d42befd59d391ad38")
+	if err != nil {
+		panic(err)
+	}
+
+	sender, err := tls13.New(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	receiver, err := tls13.New(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	record, err := sender.Seal(nil, tls13.ContentTypeApplicationData, []byte("hello"), 32)
+	if err != nil {
+		panic(err)
+	}
+
+	contentType, content, err := receiver.Open(record)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%d %d %s\n", len(record), contentType, content)
+	// Output:
+	// 59 23 hello
+}
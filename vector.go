@@ -0,0 +1,104 @@
+package chacha20
+
+import "crypto/cipher"
+
+// XORKeyStreamVec XORs the concatenation of the src slices with one
+// contiguous run of the stream's keystream, writing the result to the dst
+// slices. The two lists may be split at different places, but dst must hold
+// at least as many bytes in total as src, and XORKeyStreamVec panics if it
+// does not. Like XORKeyStream, each dst slice may overlap its corresponding
+// bytes of src entirely or not at all.
+//
+// For streams from this package, keystream blocks continue across slice
+// boundaries, and runs of whole blocks are generated several at a time, as
+// XORKeyStreamBatch does. Other streams are called once per contiguous piece.
+func XORKeyStreamVec(s cipher.Stream, dst, src [][]byte) {
+	if length(dst) < length(src) {
+		panic("chacha20: output smaller than input")
+	}
+
+	xor := s.XORKeyStream
+	if cs, ok := s.(*stream); ok {
+		xor = cs.xorBlocks
+	}
+
+	d, out := 0, []byte(nil)
+	for _, in := range src {
+		for len(in) > 0 {
+			for len(out) == 0 {
+				out = dst[d]
+				d++
+			}
+
+			n := len(in)
+			if n > len(out) {
+				n = len(out)
+			}
+
+			xor(out[:n], in[:n])
+			in, out = in[n:], out[n:]
+		}
+	}
+}
+
+// length returns the total length of a list of slices.
+func length(b [][]byte) (n int) {
+	for _, p := range b {
+		n += len(p)
+	}
+	return
+}
+
+// xorBlocks is XORKeyStream with a fast path for runs of whole blocks, which
+// are generated lanes at a time with core4. A partly used block is finished
+// first, so the fast path applies wherever a slice begins.
+func (s *stream) xorBlocks(dst, src []byte) {
+	if s.offset != blockSize {
+		n := blockSize - s.offset
+		if n > len(src) {
+			n = len(src)
+		}
+		s.XORKeyStream(dst[:n], src[:n])
+		dst, src = dst[n:], src[n:]
+	}
+
+	var in, out [stateSize][lanes]uint32
+	for len(src) >= lanes*blockSize && s.offset == blockSize {
+		// the counter of the next block, which is 32 bits for IETF ChaCha20
+		ctr := uint64(s.state[13])<<32 | uint64(s.state[12])
+		if s.ietf {
+			ctr = uint64(s.state[12])
+			if s.done || ctr+lanes > 1<<32 {
+				// Near the end of the keystream, leave it to advance.
+				break
+			}
+		}
+
+		for l := 0; l < lanes; l++ {
+			for i, v := range s.state {
+				in[i][l] = v
+			}
+			in[12][l] = uint32(ctr + uint64(l))
+			if !s.ietf {
+				in[13][l] = uint32((ctr + uint64(l)) >> 32)
+			}
+		}
+
+		core4(&in, &out, s.rounds)
+
+		for l := 0; l < lanes; l++ {
+			xorLane(dst[l*blockSize:], src[l*blockSize:(l+1)*blockSize], &out, l)
+		}
+		dst, src = dst[lanes*blockSize:], src[lanes*blockSize:]
+
+		ctr += lanes
+		s.state[12] = uint32(ctr)
+		if s.ietf {
+			s.done = ctr == 1<<32
+		} else {
+			s.state[13] = uint32(ctr >> 32)
+		}
+	}
+
+	s.XORKeyStream(dst, src)
+}
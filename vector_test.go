@@ -0,0 +1,189 @@
+package chacha20_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/codahale/chacha20"
+)
+
+// split splits b at the given lengths, cycling through them.
+func split(b []byte, lengths ...int) [][]byte {
+	var v [][]byte
+	for i := 0; len(b) > 0; i++ {
+		n := lengths[i%len(lengths)]
+		if n > len(b) {
+			n = len(b)
+		}
+		v = append(v, b[:n])
+		b = b[n:]
+	}
+	return v
+}
+
+func TestXORKeyStreamVec(t *testing.T) {
+	const n = 1000
+	streams := testStreams(t, n+10)
+	key := sequentialKey()
+
+	for size, expected := range streams {
+		for _, c := range []struct {
+			src, dst []int
+		}{
+			{[]int{n}, []int{n}},
+			{[]int{20, 300, 680}, []int{20, 300, 680}},
+			{[]int{1, 0, 63, 64, 65}, []int{128, 7}},
+			{[]int{64, 0, 128}, []int{3, 0, 0, 200}},
+		} {
+			nonce := bytes.Repeat([]byte{0xaa}, size)
+
+			var s cipher.Stream
+			var err error
+			switch size {
+			case chacha20.NonceSize:
+				s, err = chacha20.New(key, nonce)
+			case chacha20.IETFNonceSize:
+				s, err = chacha20.NewIETF(key, nonce)
+			default:
+				s, err = chacha20.NewXChaCha(key, nonce)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			src := make([]byte, n)
+			dst := make([]byte, n)
+			chacha20.XORKeyStreamVec(s, split(dst, c.dst...), split(src, c.src...))
+
+			if !bytes.Equal(expected[:n], dst) {
+				t.Errorf("Bad keystream for %d-byte nonce split as %v and %v", size, c.src, c.dst)
+			}
+
+			// the stream continues where the vector left off
+			next := make([]byte, 10)
+			s.XORKeyStream(next, next)
+			if !bytes.Equal(expected[n:], next) {
+				t.Errorf("Bad keystream after vector for %d-byte nonce", size)
+			}
+		}
+	}
+}
+
+func TestXORKeyStreamVecInPlace(t *testing.T) {
+	key := sequentialKey()
+	nonce := bytes.Repeat([]byte{0xaa}, chacha20.XNonceSize)
+	expected := testStreams(t, 500)[chacha20.XNonceSize]
+
+	s, err := chacha20.NewXChaCha(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 500)
+	v := split(buf, 100, 33, 200)
+	chacha20.XORKeyStreamVec(s, v, v)
+
+	if !bytes.Equal(expected, buf) {
+		t.Errorf("Bad keystream: expected %x, was %x", expected, buf)
+	}
+}
+
+func TestXORKeyStreamVecOtherStream(t *testing.T) {
+	block, err := aes.NewCipher(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]byte, 300)
+	cipher.NewCTR(block, make([]byte, 16)).XORKeyStream(expected, expected)
+
+	actual := make([]byte, 300)
+	chacha20.XORKeyStreamVec(cipher.NewCTR(block, make([]byte, 16)), split(actual, 7, 90), split(make([]byte, 300), 64, 1))
+
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("Bad keystream: expected %x, was %x", expected, actual)
+	}
+}
+
+func TestXORKeyStreamVecShortOutput(t *testing.T) {
+	s, err := chacha20.New(sequentialKey(), make([]byte, chacha20.NonceSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Should have panicked on a short output")
+		}
+	}()
+
+	chacha20.XORKeyStreamVec(s, [][]byte{make([]byte, 10)}, [][]byte{make([]byte, 5), make([]byte, 6)})
+}
+
+func TestXORKeyStreamVecRounds(t *testing.T) {
+	key := sequentialKey()
+	nonce := bytes.Repeat([]byte{0xaa}, chacha20.NonceSize)
+
+	for _, rounds := range []uint8{8, 12, 20} {
+		s, err := chacha20.NewWithRounds(key, nonce, rounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := make([]byte, 1000)
+		s.XORKeyStream(expected, expected)
+
+		s, err = chacha20.NewWithRounds(key, nonce, rounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := make([]byte, 1000)
+		chacha20.XORKeyStreamVec(s, split(actual, 1000), split(make([]byte, 1000), 3, 600))
+
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("Bad keystream for ChaCha%d: expected %x, was %x", rounds, expected, actual)
+		}
+	}
+}
+
+func TestXORKeyStreamVecIETFEnd(t *testing.T) {
+	key := sequentialKey()
+	nonce := make([]byte, chacha20.IETFNonceSize)
+
+	for _, counter := range []uint32{0xfffffff0, 0xfffffffc, 0xfffffffd} {
+		n := int(1<<32-uint64(counter)) * 64
+
+		s, err := chacha20.NewIETFWithCounter(key, nonce, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := make([]byte, n)
+		s.XORKeyStream(expected, expected)
+
+		s, err = chacha20.NewIETFWithCounter(key, nonce, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the whole of the rest of the keystream
+		actual := make([]byte, n)
+		chacha20.XORKeyStreamVec(s, split(actual, n), split(make([]byte, n), 5, n))
+
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("Bad keystream from counter %x: expected %x, was %x", counter, expected, actual)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Should have panicked after the end of the keystream from counter %x", counter)
+				}
+			}()
+			chacha20.XORKeyStreamVec(s, [][]byte{make([]byte, 1)}, [][]byte{make([]byte, 1)})
+		}()
+	}
+}
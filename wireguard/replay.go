@@ -0,0 +1,88 @@
+// The replay filter is adapted from the replay package of wireguard-go
+// (https://git.zx2c4.com/wireguard-go), which carries this notice:
+//
+// Copyright (C) 2017-2023 WireGuard LLC. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+// of the Software, and to permit persons to whom the Software is furnished to do
+// so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wireguard
+
+const (
+	blockBitLog = 6                // the log2 of the bits in a block
+	blockBits   = 1 << blockBitLog // the bits in a block
+	ringBlocks  = 1 << 7           // the blocks in the ring
+	blockMask   = ringBlocks - 1
+	bitMask     = blockBits - 1
+
+	// WindowSize is the number of counters behind the highest one seen which
+	// are still accepted by a ReplayFilter.
+	WindowSize = (ringBlocks - 1) * blockBits
+)
+
+// ReplayFilter is the sliding window of RFC 6479 which WireGuard uses to
+// reject replayed and very old messages. It is a ring of blocks of bits,
+// where each bit records whether a counter has been seen. Sliding the window
+// forward only clears the blocks it passes over, so a jump of any size costs
+// at most a pass over the ring.
+//
+// The zero value is an empty filter.
+type ReplayFilter struct {
+	last uint64
+	ring [ringBlocks]uint64
+}
+
+// Reset empties the filter.
+func (f *ReplayFilter) Reset() {
+	f.last = 0
+	f.ring = [ringBlocks]uint64{}
+}
+
+// ValidateCounter records the given counter and returns true if it is less
+// than limit, has not been seen before, and is within WindowSize of the
+// highest counter seen. It must only be called with the counters of
+// authenticated messages.
+func (f *ReplayFilter) ValidateCounter(counter, limit uint64) bool {
+	if counter >= limit {
+		return false
+	}
+
+	indexBlock := counter >> blockBitLog
+	if counter > f.last {
+		// slide the window forward, clearing the blocks it passes over
+		current := f.last >> blockBitLog
+		diff := indexBlock - current
+		if diff > ringBlocks {
+			diff = ringBlocks
+		}
+
+		for i := current + 1; i <= current+diff; i++ {
+			f.ring[i&blockMask] = 0
+		}
+		f.last = counter
+	} else if f.last-counter > WindowSize {
+		// too far behind the window
+		return false
+	}
+
+	indexBlock &= blockMask
+	indexBit := counter & bitMask
+	old := f.ring[indexBlock]
+	f.ring[indexBlock] = old | 1<<indexBit
+	return f.ring[indexBlock] != old
+}
@@ -0,0 +1,192 @@
+// Package wireguard implements the transport data messages of WireGuard,
+// which carry encrypted IP packets between peers once a handshake has
+// established a pair of session keys.
+//
+// Each message has a 16-byte header containing the message type (4), the
+// receiver's session index and a 64-bit counter, all little-endian. The
+// packet is padded with zeros to a multiple of 16 bytes and encrypted with
+// ChaCha20-Poly1305, using the counter as a little-endian nonce and no
+// additional data. Receivers reject replayed messages with a sliding window,
+// and both sides stop using a session's keys after a fixed number of messages
+// or amount of time.
+//
+// Senders and Receivers are not safe for concurrent use.
+//
+// For more information, see https://www.wireguard.com/papers/wireguard.pdf
+package wireguard
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/codahale/chacha20/chacha20poly1305"
+)
+
+const (
+	// MessageTypeTransport is the type of transport data messages.
+	MessageTypeTransport = 4
+	// KeySize is the length of a session key, in bytes.
+	KeySize = chacha20poly1305.KeySize
+	// HeaderSize is the length of a transport message header, in bytes.
+	HeaderSize = 16
+	// Overhead is the number of bytes a transport message is longer than its
+	// padded packet.
+	Overhead = HeaderSize + chacha20poly1305.Overhead
+	// PaddingMultiple is the multiple to which packets are padded, in bytes.
+	PaddingMultiple = 16
+
+	// RekeyAfterMessages is the number of messages after which a sender
+	// should initiate a new handshake.
+	RekeyAfterMessages uint64 = 1 << 60
+	// RejectAfterMessages is the number of messages after which a session's
+	// keys must not be used.
+	RejectAfterMessages uint64 = 1<<64 - 1<<13 - 1
+	// RekeyAfterTime is the age after which a sender should initiate a new
+	// handshake.
+	RekeyAfterTime = 120 * time.Second
+	// RejectAfterTime is the age after which a session's keys must not be
+	// used.
+	RejectAfterTime = 180 * time.Second
+)
+
+var (
+	// ErrInvalidKey is returned when the provided key is not 256 bits long.
+	ErrInvalidKey = chacha20poly1305.ErrInvalidKey
+	// ErrInvalidMessage is returned when a message is not a transport message
+	// or is too short to be one.
+	ErrInvalidMessage = errors.New("invalid transport message")
+	// ErrWrongReceiver is returned when a message is addressed to a
+	// different session.
+	ErrWrongReceiver = errors.New("message for another session")
+	// ErrExpired is returned when a session has sent or received
+	// RejectAfterMessages messages or is older than RejectAfterTime.
+	ErrExpired = errors.New("session keys expired")
+	// ErrReplay is returned when a message's counter has been seen before or
+	// is too old to be checked.
+	ErrReplay = errors.New("replayed message")
+	// ErrOpen is returned when a message cannot be authenticated, either
+	// because it was not sealed with the session's key or because it was
+	// modified.
+	ErrOpen = errors.New("message authentication failed")
+)
+
+// ParseHeader returns the receiver index and counter of a transport message,
+// so that it can be routed to the Receiver for its session.
+func ParseHeader(msg []byte) (receiverIndex uint32, counter uint64, err error) {
+	if len(msg) < HeaderSize+chacha20poly1305.Overhead ||
+		binary.LittleEndian.Uint32(msg) != MessageTypeTransport {
+		return 0, 0, ErrInvalidMessage
+	}
+
+	return binary.LittleEndian.Uint32(msg[4:]), binary.LittleEndian.Uint64(msg[8:]), nil
+}
+
+// nonce returns the nonce for a counter: four zero bytes followed by the
+// counter, little-endian.
+func nonce(counter uint64) []byte {
+	n := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(n[4:], counter)
+	return n
+}
+
+// Sender seals packets into transport messages with one session's sending
+// key.
+type Sender struct {
+	aead          cipher.AEAD
+	receiverIndex uint32
+	created       time.Time
+	counter       uint64
+}
+
+// NewSender returns a Sender for the given 256-bit sending key, created at
+// the given time, whose messages are addressed to the peer's session index.
+func NewSender(key []byte, receiverIndex uint32, created time.Time) (*Sender, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sender{aead: aead, receiverIndex: receiverIndex, created: created}, nil
+}
+
+// Seal pads and encrypts a packet, and appends the transport message to dst.
+// An empty packet is a keepalive message. It returns ErrExpired once the
+// session is too old at the given time or has sealed too many messages.
+func (s *Sender) Seal(dst, packet []byte, now time.Time) ([]byte, error) {
+	if s.counter >= RejectAfterMessages || now.Sub(s.created) >= RejectAfterTime {
+		return nil, ErrExpired
+	}
+
+	padded := make([]byte, (len(packet)+PaddingMultiple-1)/PaddingMultiple*PaddingMultiple)
+	copy(padded, packet)
+
+	var header [HeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], MessageTypeTransport)
+	binary.LittleEndian.PutUint32(header[4:], s.receiverIndex)
+	binary.LittleEndian.PutUint64(header[8:], s.counter)
+
+	out := s.aead.Seal(append(dst, header[:]...), nonce(s.counter), padded, nil)
+	s.counter++
+	return out, nil
+}
+
+// NeedsRekey returns true if the session has sealed RekeyAfterMessages
+// messages or is older than RekeyAfterTime at the given time, and a new
+// handshake should be initiated. In WireGuard, only the handshake's
+// initiator rekeys because of the session's age.
+func (s *Sender) NeedsRekey(now time.Time) bool {
+	return s.counter >= RekeyAfterMessages || now.Sub(s.created) >= RekeyAfterTime
+}
+
+// Receiver opens transport messages with one session's receiving key.
+type Receiver struct {
+	aead    cipher.AEAD
+	index   uint32
+	created time.Time
+	filter  ReplayFilter
+}
+
+// NewReceiver returns a Receiver for the given 256-bit receiving key, created
+// at the given time, which accepts messages addressed to its own session
+// index.
+func NewReceiver(key []byte, index uint32, created time.Time) (*Receiver, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Receiver{aead: aead, index: index, created: created}, nil
+}
+
+// Open authenticates and decrypts a transport message and appends its padded
+// packet to dst. The padding is not removed, as the packet's own header
+// records its length. It returns ErrReplay if the message has been opened
+// before, and ErrExpired once the session is too old at the given time.
+func (r *Receiver) Open(dst, msg []byte, now time.Time) ([]byte, error) {
+	index, counter, err := ParseHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if index != r.index {
+		return nil, ErrWrongReceiver
+	}
+
+	if counter >= RejectAfterMessages || now.Sub(r.created) >= RejectAfterTime {
+		return nil, ErrExpired
+	}
+
+	out, err := r.aead.Open(dst, nonce(counter), msg[HeaderSize:], nil)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	// only authenticated counters can move the window
+	if !r.filter.ValidateCounter(counter, RejectAfterMessages) {
+		return nil, ErrReplay
+	}
+
+	return out, nil
+}
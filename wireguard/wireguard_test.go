@@ -0,0 +1,265 @@
+package wireguard_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/codahale/chacha20/wireguard"
+)
+
+// WireGuard publishes no transport message vectors, so these were produced
+// with libsodium following the whitepaper.
+type testVector struct {
+	receiverIndex uint32
+	counter       uint64
+	packet        string
+	message       string
+}
+
+const testKey = "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"
+
+var testVectors = []testVector{
+	// a keepalive
+	testVector{
+		0x12345678,
+		0,
+		"",
+		"040000007856341200000000000000003ae5d3f2a376d317eaea5aef0215ba54",
+	},
+	// an ICMP echo request
+	testVector{
+		0x12345678,
+		1,
+		"4500001c000040004001b7a20a0000010a0000020800f7ff00000000",
+		"04000000785634120100000000000000a61174117e7f67e622046db4807486eb134f2cdc" +
+			"1729f9f9c09411cf12b48fcfe1fbca9511534dbb8865aa9557b1745c",
+	},
+	testVector{
+		0xdeadbeef,
+		3,
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		"04000000efbeadde0300000000000000e768c784f6ec2679a1c7e976ae6d6139956c7f40" +
+			"fcb6f30521c898eec6e6badccb5affccbb7e2099c5c6ec23cb80f1526148fc71f0f84177" +
+			"4842cc932cb31b6a",
+	},
+}
+
+var epoch = time.Unix(1700000000, 0)
+
+func padded(packet []byte) []byte {
+	n := (len(packet) + 15) / 16 * 16
+	return append(packet, make([]byte, n-len(packet))...)
+}
+
+func TestSeal(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		s, err := wireguard.NewSender(key, vector.receiverIndex, epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for c := uint64(0); c < vector.counter; c++ {
+			s.Seal(nil, nil, epoch)
+		}
+
+		packet, _ := hex.DecodeString(vector.packet)
+		msg, err := s.Seal(nil, packet, epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(msg) != vector.message {
+			t.Errorf("Bad message: expected %s, was %x", vector.message, msg)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	key, _ := hex.DecodeString(testKey)
+
+	for i, vector := range testVectors {
+		t.Logf("Running test vector %d", i)
+
+		msg, _ := hex.DecodeString(vector.message)
+		packet, _ := hex.DecodeString(vector.packet)
+
+		index, counter, err := wireguard.ParseHeader(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if index != vector.receiverIndex || counter != vector.counter {
+			t.Errorf("Bad header: expected %x/%d, was %x/%d", vector.receiverIndex, vector.counter, index, counter)
+		}
+
+		r, err := wireguard.NewReceiver(key, vector.receiverIndex, epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg[len(msg)-1] ^= 1
+		if _, err := r.Open(nil, msg, epoch); err != wireguard.ErrOpen {
+			t.Error("Should have rejected a modified message")
+		}
+		msg[len(msg)-1] ^= 1
+
+		actual, err := r.Open(nil, msg, epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(padded(packet), actual) {
+			t.Errorf("Bad packet: expected %x, was %x", padded(packet), actual)
+		}
+
+		if _, err := r.Open(nil, msg, epoch); err != wireguard.ErrReplay {
+			t.Error("Should have rejected a replayed message")
+		}
+
+		other, _ := wireguard.NewReceiver(key, vector.receiverIndex+1, epoch)
+		if _, err := other.Open(nil, msg, epoch); err != wireguard.ErrWrongReceiver {
+			t.Error("Should have rejected a message for another session")
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	key := make([]byte, wireguard.KeySize)
+	s, _ := wireguard.NewSender(key, 1, epoch)
+	r, _ := wireguard.NewReceiver(key, 1, epoch)
+
+	if s.NeedsRekey(epoch) {
+		t.Error("Should not have needed a rekey")
+	}
+
+	msg, _ := s.Seal(nil, []byte("hello"), epoch.Add(wireguard.RekeyAfterTime))
+	if !s.NeedsRekey(epoch.Add(wireguard.RekeyAfterTime)) {
+		t.Error("Should have needed a rekey")
+	}
+
+	if _, err := r.Open(nil, msg, epoch.Add(wireguard.RejectAfterTime)); err != wireguard.ErrExpired {
+		t.Error("Should have rejected a message after the session expired")
+	}
+
+	if _, err := s.Seal(nil, []byte("hello"), epoch.Add(wireguard.RejectAfterTime)); err != wireguard.ErrExpired {
+		t.Error("Should not have sealed a message after the session expired")
+	}
+}
+
+func TestBadMessages(t *testing.T) {
+	key := make([]byte, wireguard.KeySize)
+	r, _ := wireguard.NewReceiver(key, 1, epoch)
+
+	messages := []string{
+		"",
+		// a handshake initiation
+		"0100000001000000000000000000000000000000000000000000000000000000",
+		// too short for a tag
+		"04000000010000000000000000000000000000000000000000000000000000",
+	}
+
+	for i, v := range messages {
+		t.Logf("Running message %d", i)
+
+		msg, _ := hex.DecodeString(v)
+		if _, err := r.Open(nil, msg, epoch); err != wireguard.ErrInvalidMessage {
+			t.Errorf("Should have rejected an invalid message, was %v", err)
+		}
+	}
+
+	if _, err := wireguard.NewSender(key[1:], 1, epoch); err != wireguard.ErrInvalidKey {
+		t.Error("Should have rejected a short key")
+	}
+}
+
+func TestReplayFilter(t *testing.T) {
+	const limit = 1 << 20
+
+	var f wireguard.ReplayFilter
+	steps := []struct {
+		counter uint64
+		valid   bool
+	}{
+		{0, true},
+		{1, true},
+		{1, false},
+		{9, true},
+		{8, true},
+		{7, true},
+		{7, false},
+		{wireguard.WindowSize + 9, true},
+		{10, true}, // just within the window
+		{8, false},
+		{2*wireguard.WindowSize + 9, true},
+		{wireguard.WindowSize + 9, false},
+		{wireguard.WindowSize + 10, true},
+		// a jump of more than the whole window, which clears every block
+		{1 << 19, true},
+		{1<<19 - 1, true},
+		{2*wireguard.WindowSize + 9, false},
+		{limit - 1, true},
+		{limit, false},
+		{limit - 1, false},
+	}
+
+	for i, s := range steps {
+		if v := f.ValidateCounter(s.counter, limit); v != s.valid {
+			t.Errorf("Bad result for step %d (%d): expected %v, was %v", i, s.counter, s.valid, v)
+		}
+	}
+
+	f.Reset()
+	if !f.ValidateCounter(0, limit) {
+		t.Error("Should have accepted a counter after a reset")
+	}
+}
+
+func TestReplayFilterWindow(t *testing.T) {
+	var f wireguard.ReplayFilter
+
+	for c := uint64(1); c <= 3*wireguard.WindowSize; c += 3 {
+		if !f.ValidateCounter(c, wireguard.RejectAfterMessages) {
+			t.Fatalf("Should have accepted %d", c)
+		}
+	}
+
+	last := uint64(3*wireguard.WindowSize - 2)
+	for c := last - wireguard.WindowSize; c <= last; c++ {
+		seen := c%3 == 1
+		if v := f.ValidateCounter(c, wireguard.RejectAfterMessages); v == seen {
+			t.Errorf("Bad result for %d: expected %v, was %v", c, !seen, v)
+		}
+	}
+}
+
+func Example() {
+	key, err := hex.DecodeString("60143a3d7c7137c3622d490e7dbb85859138d198d9c648960e186412a6250722")
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+	s, _ := wireguard.NewSender(key, 42, now)
+	r, _ := wireguard.NewReceiver(key, 42, now)
+
+	msg, err := s.Seal(nil, []byte("an IP packet"), now)
+	if err != nil {
+		panic(err)
+	}
+
+	packet, err := r.Open(nil, msg, now)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(len(msg), len(packet))
+	// Output:
+	// 48 16
+}